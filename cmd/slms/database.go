@@ -0,0 +1,102 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/zekroTJA/slms/internal/config"
+	"github.com/zekroTJA/slms/internal/database"
+	"github.com/zekroTJA/slms/internal/database/cache"
+	"github.com/zekroTJA/slms/internal/database/instrument"
+	"github.com/zekroTJA/slms/internal/database/memory"
+	"github.com/zekroTJA/slms/internal/database/mysql"
+	"github.com/zekroTJA/slms/internal/database/postgres"
+	"github.com/zekroTJA/slms/internal/database/sqlite"
+	"github.com/zekroTJA/slms/internal/database/timeout"
+	"github.com/zekroTJA/slms/pkg/metrics"
+)
+
+// openDatabase creates the database middleware
+// specified by the type in the passed database
+// config and opens it with the corresponding
+// backend configuration.
+// If reg is not nil, the query durations of the
+// database backend are recorded in it.
+// If a query timeout is set, each call to the
+// opened database middleware is bounded by it.
+// If enabled, the database middleware is
+// wrapped by a lookup cache afterwards.
+func openDatabase(cfg *config.Database, reg *metrics.Registry) (database.Middleware, error) {
+	db, dbCfg, err := newDatabase(cfg)
+	if err != nil {
+		return nil, err
+	}
+
+	if err = db.Open(dbCfg); err != nil {
+		return nil, err
+	}
+
+	if reg != nil {
+		db = instrument.New(db, reg)
+	}
+
+	if cfg.QueryTimeout > 0 {
+		db = timeout.New(db, time.Duration(cfg.QueryTimeout)*time.Second)
+	}
+
+	if cfg.Cache != nil && cfg.Cache.Enabled {
+		db = cache.New(db, cfg.Cache)
+	}
+
+	return db, nil
+}
+
+// newDatabase creates the database middleware
+// specified by the type in the passed database
+// config and returns it with the corresponding
+// backend configuration without opening it.
+// If no type is specified, MySQL is used.
+func newDatabase(cfg *config.Database) (database.Middleware, interface{}, error) {
+	if cfg == nil {
+		return nil, nil, errors.New("database config must be specified")
+	}
+
+	var db database.Middleware
+	var dbCfg interface{}
+
+	// The backend configs are only assigned to dbCfg
+	// when not nil to prevent passing typed nil values.
+	switch cfg.Type {
+	case "", "mysql":
+		db = new(mysql.MySQL)
+		if cfg.MySQL != nil {
+			dbCfg = cfg.MySQL
+		}
+	case "postgres":
+		db = new(postgres.Postgres)
+		if cfg.Postgres != nil {
+			dbCfg = cfg.Postgres
+		}
+	case "sqlite":
+		db = new(sqlite.SQLite)
+		if cfg.SQLite != nil {
+			dbCfg = cfg.SQLite
+		}
+	case "memory":
+		// The in-memory database works without any
+		// configuration, so it falls back to defaults.
+		db, dbCfg = new(memory.Memory), new(memory.Config)
+		if cfg.Memory != nil {
+			dbCfg = cfg.Memory
+		}
+	default:
+		return nil, nil, fmt.Errorf("unsupported database type '%s'", cfg.Type)
+	}
+
+	if dbCfg == nil {
+		return nil, nil, fmt.Errorf("no config specified for database type '%s'", cfg.Type)
+	}
+
+	return db, dbCfg, nil
+}
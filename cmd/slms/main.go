@@ -4,17 +4,22 @@ import (
 	"flag"
 	"fmt"
 	"os"
+	"os/signal"
+	"syscall"
 	"time"
 
 	"github.com/zekroTJA/slms/internal/static"
 
 	"github.com/zekroTJA/slms/internal/auth"
+	"github.com/zekroTJA/slms/internal/counter"
+	"github.com/zekroTJA/slms/internal/rollup"
+	"github.com/zekroTJA/slms/internal/trash"
 
-	"github.com/zekroTJA/slms/internal/database/mysql"
 	"github.com/zekroTJA/slms/internal/webserver"
 
 	"github.com/zekroTJA/slms/internal/config"
 	"github.com/zekroTJA/slms/internal/logger"
+	"github.com/zekroTJA/slms/pkg/metrics"
 )
 
 var (
@@ -78,16 +83,60 @@ func main() {
 		cfg.WebServer.Address = *flagAddr
 	}
 
+	/////////////////
+	// SUBCOMMANDS //
+	/////////////////
+
+	switch flag.Arg(0) {
+	case "migrate":
+		if err = runMigrate(cfg.Database, flag.Args()[1:]); err != nil {
+			logger.Fatal("MIGRATE :: %s", err.Error())
+		}
+		return
+	case "export":
+		if err = runExport(cfg.Database, flag.Args()[1:]); err != nil {
+			logger.Fatal("EXPORT :: %s", err.Error())
+		}
+		return
+	case "import":
+		if err = runImport(cfg, flag.Args()[1:]); err != nil {
+			logger.Fatal("IMPORT :: %s", err.Error())
+		}
+		return
+	}
+
 	//////////////
 	// DATABASE //
 	//////////////
 
-	db := new(mysql.MySQL)
-	if err = db.Open(cfg.Database); err != nil {
+	// The metrics registry is only created if the
+	// metrics endpoint is enabled to not record
+	// database query durations needlessly.
+	var registry *metrics.Registry
+	if cfg.WebServer.Metrics != nil && cfg.WebServer.Metrics.Enabled {
+		registry = metrics.NewRegistry()
+	}
+
+	db, err := openDatabase(cfg.Database, registry)
+	if err != nil {
 		logger.Fatal("DATABASE :: failed connecting: %s", err.Error())
 	}
 	logger.Info("DATABASE :: initialized")
 
+	accessCounter := counter.New(db,
+		time.Duration(cfg.Database.AccessFlushInterval)*time.Second)
+
+	var trashPurger *trash.Purger
+	if cfg.Database.TrashRetention > 0 {
+		trashPurger = trash.New(db,
+			time.Duration(cfg.Database.TrashRetention)*24*time.Hour, 0)
+	}
+
+	var accessRoller *rollup.Roller
+	if cfg.Database.AccessRetention != nil {
+		accessRoller = rollup.New(db, *cfg.Database.AccessRetention, 0)
+	}
+
 	////////////////
 	// WEB SERVER //
 	////////////////
@@ -99,11 +148,59 @@ func main() {
 		logger.Warning("WEBSERVER :: ATTENTION! WEB SERVER IS CONFIGURED IN NON TLS MODE")
 	}
 
-	ws, err := webserver.NewWebServer(cfg.WebServer, db, authProvider)
+	ws, err := webserver.NewWebServer(cfg.WebServer, db, accessCounter, authProvider, registry)
 	if err != nil {
 		logger.Fatal("WEBSERVER :: init failed: %s", err.Error())
 	}
-	if err = ws.ListenAndServeBlocking(); err != nil {
-		logger.Fatal("WEBSERVER :: failed startup: %s", err.Error())
+
+	go func() {
+		if err := ws.ListenAndServeBlocking(); err != nil {
+			logger.Fatal("WEBSERVER :: failed startup: %s", err.Error())
+		}
+	}()
+
+	// SIGHUP reloads the user agent patterns
+	// without restarting the web server.
+	hc := make(chan os.Signal, 1)
+	signal.Notify(hc, syscall.SIGHUP)
+	go func() {
+		for range hc {
+			if err := ws.ReloadUserAgentPatterns(); err != nil {
+				logger.Error("WEBSERVER :: failed reloading user agent patterns: %s", err.Error())
+				continue
+			}
+			logger.Info("WEBSERVER :: reloaded user agent patterns")
+		}
+	}()
+
+	//////////////
+	// SHUTDOWN //
+	//////////////
+
+	sc := make(chan os.Signal, 1)
+	signal.Notify(sc, syscall.SIGINT, syscall.SIGTERM)
+	<-sc
+
+	// The web server is closed first, so that no
+	// accesses are recorded after the final flush
+	// and no requests reach the closed database.
+	logger.Info("SHUTDOWN :: closing web server")
+	if err = ws.Close(); err != nil {
+		logger.Error("SHUTDOWN :: failed closing web server: %s", err.Error())
+	}
+
+	if trashPurger != nil {
+		trashPurger.Close()
+	}
+	if accessRoller != nil {
+		accessRoller.Close()
 	}
+
+	logger.Info("SHUTDOWN :: flushing access counts")
+	if err = accessCounter.Close(); err != nil {
+		logger.Error("SHUTDOWN :: failed flushing access counts: %s", err.Error())
+	}
+
+	logger.Info("SHUTDOWN :: closing database connection")
+	db.Close()
 }
@@ -0,0 +1,81 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/zekroTJA/slms/internal/config"
+	"github.com/zekroTJA/slms/internal/database"
+)
+
+const migrateUsage = "usage: slms [flags] migrate up|down|status"
+
+// runMigrate executes the 'migrate' subcommand
+// with the passed arguments against the database
+// specified in the passed config.
+//
+//   up     : applies all pending migrations
+//   down   : reverts the latest applied migration
+//   status : lists all migrations and their state
+func runMigrate(cfg *config.Database, args []string) error {
+	if len(args) != 1 {
+		return errors.New(migrateUsage)
+	}
+
+	db, dbCfg, err := newDatabase(cfg)
+	if err != nil {
+		return err
+	}
+
+	mdb, ok := db.(database.Migratable)
+	if !ok {
+		return fmt.Errorf("database type '%s' does not support migrations", cfg.Type)
+	}
+
+	if err = mdb.Connect(dbCfg); err != nil {
+		return err
+	}
+	defer mdb.Close()
+
+	migrator := mdb.Migrator()
+
+	switch args[0] {
+
+	case "up":
+		n, err := migrator.Up()
+		if err != nil {
+			return err
+		}
+		fmt.Printf("Applied %d migration(s). Schema is at version %d.\n", n, migrator.Latest())
+
+	case "down":
+		mig, err := migrator.Down()
+		if err != nil {
+			return err
+		}
+		fmt.Printf("Reverted migration %d (%s).\n", mig.Version, mig.Name)
+
+	case "status":
+		v, err := migrator.Version()
+		if err != nil {
+			return err
+		}
+		status, err := migrator.Status()
+		if err != nil {
+			return err
+		}
+		fmt.Printf("Schema version: %d (latest known: %d)\n\n", v, migrator.Latest())
+		for _, s := range status {
+			state := "pending"
+			if s.Applied {
+				state = "applied"
+			}
+			fmt.Printf("  %04d  %-8s %s\n", s.Version, state, s.Name)
+		}
+
+	default:
+		return errors.New(migrateUsage)
+	}
+
+	return nil
+}
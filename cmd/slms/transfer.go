@@ -0,0 +1,131 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/zekroTJA/slms/internal/config"
+	"github.com/zekroTJA/slms/internal/database"
+	"github.com/zekroTJA/slms/internal/shortlink"
+	"github.com/zekroTJA/slms/internal/transfer"
+	"github.com/zekroTJA/slms/internal/webserver"
+)
+
+const (
+	exportUsage = "usage: slms [flags] export [-format json|csv] [file]"
+	importUsage = "usage: slms [flags] import [-format json|csv] [-strategy skip|overwrite|rename] file"
+)
+
+// runExport executes the 'export' subcommand
+// which writes all short links of the database
+// specified in the passed config to the passed
+// file or to stdout if no file is passed.
+func runExport(cfg *config.Database, args []string) error {
+	fs := flag.NewFlagSet("export", flag.ContinueOnError)
+	format := fs.String("format", "", "export format (json or csv, defaults to file extension)")
+	if err := fs.Parse(args); err != nil || fs.NArg() > 1 {
+		return errors.New(exportUsage)
+	}
+
+	file := fs.Arg(0)
+	if *format == "" {
+		*format = formatFromFileName(file)
+	}
+	if err := transfer.CheckFormat(*format); err != nil {
+		return err
+	}
+
+	db, err := openDatabase(cfg, nil)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	var w io.Writer = os.Stdout
+	if file != "" {
+		f, err := os.Create(file)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		w = f
+	}
+
+	return transfer.Export(context.Background(), db, w, *format)
+}
+
+// runImport executes the 'import' subcommand
+// which imports the short links of the passed
+// file into the database specified in the
+// passed config.
+// Imported short links are validated against
+// the passed web server config.
+func runImport(cfg *config.Main, args []string) error {
+	fs := flag.NewFlagSet("import", flag.ContinueOnError)
+	format := fs.String("format", "", "import format (json or csv, defaults to file extension)")
+	strategyName := fs.String("strategy", "skip", "handling of existing short links (skip, overwrite or rename)")
+	if err := fs.Parse(args); err != nil || fs.NArg() != 1 {
+		return errors.New(importUsage)
+	}
+
+	file := fs.Arg(0)
+	if *format == "" {
+		*format = formatFromFileName(file)
+	}
+
+	strategy, err := transfer.ParseStrategy(*strategyName)
+	if err != nil {
+		return err
+	}
+
+	f, err := os.Open(file)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	sls, err := transfer.Decode(f, *format)
+	if err != nil {
+		return err
+	}
+
+	db, err := openDatabase(cfg.Database, nil)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	res, err := transfer.Import(database.WithActor(context.Background(), "cli"), db, sls, strategy,
+		func(sl *shortlink.ShortLink) error {
+			return webserver.ValidateShortLink(sl, cfg.WebServer.OnlyHTTPSRootLink)
+		})
+
+	if res != nil {
+		fmt.Printf("Created: %d, overwritten: %d, skipped: %d, renamed: %d, failed: %d\n",
+			res.Created, res.Overwritten, res.Skipped, len(res.Renamed), len(res.Failed))
+		for _, r := range res.Renamed {
+			fmt.Printf("  renamed  %s -> %s\n", r.From, r.To)
+		}
+		for _, f := range res.Failed {
+			fmt.Printf("  failed   %s (%s): %s\n", f.ShortLink, f.RootLink, f.Error)
+		}
+	}
+
+	return err
+}
+
+// formatFromFileName returns the transfer format
+// matching the extension of the passed file name.
+// JSON is used as default.
+func formatFromFileName(name string) string {
+	if strings.ToLower(filepath.Ext(name)) == ".csv" {
+		return transfer.FormatCSV
+	}
+	return transfer.FormatJSON
+}
@@ -11,6 +11,13 @@ func CreateHash(s string, rounds int) (string, error) {
 	return string(bHash), err
 }
 
+// IsHash returns if the passed string
+// is a bcrypt generated hash.
+func IsHash(s string) bool {
+	_, err := bcrypt.Cost([]byte(s))
+	return err == nil
+}
+
 // CheckHash returns if the passed, bcrypt
 // generated hash matches the passed string.
 func CheckHash(s, hash string) bool {
@@ -7,7 +7,12 @@ import (
 	"github.com/zekroTJA/slms/internal/util"
 
 	"github.com/ghodss/yaml"
+	"github.com/zekroTJA/slms/internal/database/cache"
+	"github.com/zekroTJA/slms/internal/database/memory"
 	"github.com/zekroTJA/slms/internal/database/mysql"
+	"github.com/zekroTJA/slms/internal/database/postgres"
+	"github.com/zekroTJA/slms/internal/database/sqlite"
+	"github.com/zekroTJA/slms/internal/rollup"
 	"github.com/zekroTJA/slms/internal/webserver"
 )
 
@@ -26,6 +31,8 @@ var defConf = &Main{
 		Address:           ":443",
 		RootRedirect:      "/manage",
 		PermanentRedirect: true,
+		RedirectMaxAge:    86400,
+		RequestTimeout:    10,
 		OnlyHTTPSRootLink: true,
 		APITokenHash:      "",
 		SessionStoreKey:   util.GetRandString(64),
@@ -35,10 +42,26 @@ var defConf = &Main{
 			KeyFile:  "/var/cert/example.com.key",
 		},
 	},
-	Database: &mysql.Config{
-		Host:     "localhost",
-		Username: "slms",
-		Database: "slms",
+	Database: &Database{
+		Type:                "mysql",
+		AccessFlushInterval: 10,
+		QueryTimeout:        5,
+		TrashRetention:      30,
+		AccessRetention: &rollup.Config{
+			Raw:    30,
+			Hourly: 365,
+			Daily:  0,
+		},
+		Cache: &cache.Config{
+			Enabled: true,
+			TTL:     60,
+			Size:    10000,
+		},
+		MySQL: &mysql.Config{
+			Host:     "localhost",
+			Username: "slms",
+			Database: "slms",
+		},
 	},
 }
 
@@ -46,7 +69,63 @@ var defConf = &Main{
 // for this application.
 type Main struct {
 	WebServer *webserver.Config `json:"web_server"`
-	Database  *mysql.Config     `json:"database"`
+	Database  *Database         `json:"database"`
+}
+
+// Database contains the type of the database
+// backend which will be used ('mysql', 'postgres',
+// 'sqlite' or 'memory') and the configuration for
+// each of the database backends. Only the configuration
+// of the selected type needs to be specified.
+// Cache configures the optional lookup cache
+// in front of the database and
+// AccessFlushInterval the interval in seconds
+// in which collected access counts are written
+// to the database.
+// QueryTimeout is the time in seconds after
+// which a single database call is aborted.
+// TrashRetention is the time in days after
+// which deleted short links are removed
+// permanently. 0 keeps them forever.
+// AccessRetention configures after which time
+// recorded accesses are aggregated into hourly
+// and daily rollups.
+// Host, Username, Password and Name are the
+// flat MySQL keys of configs written before
+// the database type selection. They are only
+// used if no MySQL config is specified.
+type Database struct {
+	Type                string           `json:"type"`
+	AccessFlushInterval int              `json:"access_flush_interval"`
+	QueryTimeout        int              `json:"query_timeout"`
+	TrashRetention      int              `json:"trash_retention"`
+	AccessRetention     *rollup.Config   `json:"access_retention,omitempty"`
+	Cache               *cache.Config    `json:"cache,omitempty"`
+	MySQL               *mysql.Config    `json:"mysql,omitempty"`
+	Postgres            *postgres.Config `json:"postgres,omitempty"`
+	SQLite              *sqlite.Config   `json:"sqlite,omitempty"`
+	Memory              *memory.Config   `json:"memory,omitempty"`
+
+	Host     string `json:"host,omitempty"`
+	Username string `json:"username,omitempty"`
+	Password string `json:"password,omitempty"`
+	Name     string `json:"database,omitempty"`
+}
+
+// applyLegacy sets the MySQL config from the flat
+// legacy MySQL keys if they are specified and no
+// MySQL config is set.
+func (d *Database) applyLegacy() {
+	if d.MySQL != nil || (d.Host == "" && d.Username == "" && d.Name == "") {
+		return
+	}
+
+	d.MySQL = &mysql.Config{
+		Host:     d.Host,
+		Username: d.Username,
+		Password: d.Password,
+		Database: d.Name,
+	}
 }
 
 // OpenAndParse tries to open a confic file from
@@ -75,9 +154,15 @@ func OpenAndParse(loc string) (*Main, bool, error) {
 	}
 
 	conf := new(Main)
-	err = unmarshal(data, conf)
+	if err = unmarshal(data, conf); err != nil {
+		return conf, false, err
+	}
+
+	if conf.Database != nil {
+		conf.Database.applyLegacy()
+	}
 
-	return conf, false, err
+	return conf, false, nil
 }
 
 // createFile attempts to create a new config file
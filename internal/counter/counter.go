@@ -0,0 +1,255 @@
+// Package counter provides an in-process aggregator
+// for short link access counts and access events
+// which writes them to the database in batches.
+package counter
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/zekroTJA/slms/internal/database"
+	"github.com/zekroTJA/slms/internal/logger"
+	"github.com/zekroTJA/slms/internal/shortlink"
+	"github.com/zekroTJA/slms/pkg/multierror"
+)
+
+// DefaultInterval is the flush interval used
+// when no interval is specified.
+const DefaultInterval = 10 * time.Second
+
+// flushTimeout is the time after which a
+// flush of the flush loop or on Close is
+// aborted.
+const flushTimeout = 30 * time.Second
+
+// maxAccesses is the maximum number of access
+// events kept after failed flushes. If it is
+// exceeded, the oldest events are dropped.
+const maxAccesses = 100000
+
+// Counter collects access increments per short
+// link and access events in memory and flushes
+// the merged increments and the events to the
+// database in the specified interval.
+// Increments and events which could not be
+// written to the database are kept for the
+// next flush, up to maxAccesses events.
+// Increments being written by a flush are
+// still reported as pending until the write
+// returned.
+// Unique visitors are counted once per short
+// link and UTC day by the visitor hashes of
+// the recorded events.
+type Counter struct {
+	mtx      sync.Mutex
+	db       database.Middleware
+	pending  map[int]int
+	inflight map[int]int
+	uniques  map[int]int
+	accesses []*shortlink.Access
+	day      time.Time
+	seen     map[visit]struct{}
+
+	ticker *time.Ticker
+	stop   chan struct{}
+	done   chan struct{}
+}
+
+// New creates a new Counter which flushes
+// collected increments to the passed database
+// in the passed interval. If interval is <= 0,
+// DefaultInterval is used.
+func New(db database.Middleware, interval time.Duration) *Counter {
+	if interval <= 0 {
+		interval = DefaultInterval
+	}
+
+	c := &Counter{
+		db:       db,
+		pending:  make(map[int]int),
+		inflight: make(map[int]int),
+		uniques:  make(map[int]int),
+		seen:     make(map[visit]struct{}),
+		ticker:   time.NewTicker(interval),
+		stop:     make(chan struct{}),
+		done:     make(chan struct{}),
+	}
+
+	go c.loop()
+
+	return c
+}
+
+// Add records one access to the short
+// link with the passed ID.
+func (c *Counter) Add(id int) {
+	c.AddN(id, 1)
+}
+
+// AddN records n accesses to the short
+// link with the passed ID.
+func (c *Counter) AddN(id, n int) {
+	c.mtx.Lock()
+	c.pending[id] += n
+	c.mtx.Unlock()
+}
+
+// Pending returns the number of accesses to
+// the short link with the passed ID which are
+// not yet written to the database, including
+// the ones currently being written.
+func (c *Counter) Pending(id int) int {
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+	return c.pending[id] + c.inflight[id]
+}
+
+// Record records the passed access event and,
+// if it was not made by a bot, one access and
+// possibly one unique visitor to its short link.
+func (c *Counter) Record(a *shortlink.Access) {
+	c.mtx.Lock()
+	if !a.Bot {
+		c.pending[a.ShortLinkID]++
+		if a.Visitor != "" && c.firstVisit(a) {
+			c.uniques[a.ShortLinkID]++
+		}
+	}
+	c.accesses = append(c.accesses, a)
+	c.mtx.Unlock()
+}
+
+// Flush writes all collected increments and access
+// events to the database. Increments and events
+// which failed to be written are kept and the errors
+// are returned.
+func (c *Counter) Flush(ctx context.Context) error {
+	c.mtx.Lock()
+	pending := c.pending
+	uniques := c.uniques
+	accesses := c.accesses
+	c.pending = make(map[int]int)
+	c.uniques = make(map[int]int)
+	c.accesses = nil
+	for id, n := range pending {
+		c.inflight[id] += n
+	}
+	c.mtx.Unlock()
+
+	mErr := multierror.New(nil)
+
+	for id, n := range pending {
+		err := c.db.IncrementAccesses(ctx, id, n)
+
+		c.mtx.Lock()
+		if c.inflight[id] -= n; c.inflight[id] == 0 {
+			delete(c.inflight, id)
+		}
+		if err != nil {
+			mErr.Append(err)
+			c.pending[id] += n
+		}
+		c.mtx.Unlock()
+	}
+
+	for id, n := range uniques {
+		if err := c.db.IncrementUniques(ctx, id, n); err != nil {
+			mErr.Append(err)
+			c.mtx.Lock()
+			c.uniques[id] += n
+			c.mtx.Unlock()
+		}
+	}
+
+	if len(accesses) > 0 {
+		if err := c.db.AddAccesses(ctx, accesses); err != nil {
+			mErr.Append(err)
+			c.requeue(accesses)
+		}
+	}
+
+	return mErr.Concat()
+}
+
+// Close stops the flush loop and writes all
+// remaining increments and events to the database.
+func (c *Counter) Close() error {
+	c.ticker.Stop()
+	close(c.stop)
+	<-c.done
+
+	return c.flush()
+}
+
+// flush flushes the collected increments and
+// events with a timeout of flushTimeout.
+func (c *Counter) flush() error {
+	ctx, cancel := context.WithTimeout(context.Background(), flushTimeout)
+	defer cancel()
+	return c.Flush(ctx)
+}
+
+// requeue prepends the passed access events, which
+// failed to be written, to the collected events.
+// If more than maxAccesses events are collected,
+// the oldest ones are dropped.
+func (c *Counter) requeue(accesses []*shortlink.Access) {
+	c.mtx.Lock()
+	c.accesses = append(accesses, c.accesses...)
+	dropped := len(c.accesses) - maxAccesses
+	if dropped > 0 {
+		c.accesses = append([]*shortlink.Access(nil), c.accesses[dropped:]...)
+	}
+	c.mtx.Unlock()
+
+	if dropped > 0 {
+		logger.Warning("COUNTER :: dropped %d access events which could not be written", dropped)
+	}
+}
+
+// loop flushes the collected increments on
+// each tick until the counter is closed.
+func (c *Counter) loop() {
+	defer close(c.done)
+
+	for {
+		select {
+		case <-c.ticker.C:
+			if err := c.flush(); err != nil {
+				logger.Error("COUNTER :: failed flushing accesses: %s", err.Error())
+			}
+		case <-c.stop:
+			return
+		}
+	}
+}
+
+// visit identifies the visits of a
+// visitor to a short link.
+type visit struct {
+	id      int
+	visitor string
+}
+
+// firstVisit returns true if the visitor of the
+// passed access was not seen before on the same
+// UTC day at its short link. Visits of former
+// days are forgotten. The caller must hold the
+// lock.
+func (c *Counter) firstVisit(a *shortlink.Access) bool {
+	t := a.Time.UTC()
+	day := time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, time.UTC)
+	if !day.Equal(c.day) {
+		c.day = day
+		c.seen = make(map[visit]struct{})
+	}
+
+	v := visit{a.ShortLinkID, a.Visitor}
+	if _, ok := c.seen[v]; ok {
+		return false
+	}
+	c.seen[v] = struct{}{}
+
+	return true
+}
@@ -0,0 +1,254 @@
+package counter
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/zekroTJA/slms/internal/database"
+	"github.com/zekroTJA/slms/internal/shortlink"
+)
+
+var errWrite = errors.New("write failed")
+
+// fakeDB records the increments and access events
+// written to it. If fail is set, all writes fail.
+// If set, onIncrement is called on each increment
+// of accesses before it is written. Writes with a
+// deadline are counted in deadlines.
+type fakeDB struct {
+	database.Middleware
+
+	onIncrement func(id int)
+
+	mtx       sync.Mutex
+	fail      bool
+	deadlines int
+	calls     map[int]int
+	accesses  map[int]int
+	uniques   map[int]int
+	events    []*shortlink.Access
+}
+
+func newFakeDB() *fakeDB {
+	return &fakeDB{
+		calls:    make(map[int]int),
+		accesses: make(map[int]int),
+		uniques:  make(map[int]int),
+	}
+}
+
+func (db *fakeDB) setFail(fail bool) {
+	db.mtx.Lock()
+	db.fail = fail
+	db.mtx.Unlock()
+}
+
+func (db *fakeDB) IncrementAccesses(ctx context.Context, id, n int) error {
+	if db.onIncrement != nil {
+		db.onIncrement(id)
+	}
+
+	db.mtx.Lock()
+	defer db.mtx.Unlock()
+	if _, ok := ctx.Deadline(); ok {
+		db.deadlines++
+	}
+	if db.fail {
+		return errWrite
+	}
+	db.calls[id]++
+	db.accesses[id] += n
+	return nil
+}
+
+func (db *fakeDB) IncrementUniques(ctx context.Context, id, n int) error {
+	db.mtx.Lock()
+	defer db.mtx.Unlock()
+	if db.fail {
+		return errWrite
+	}
+	db.uniques[id] += n
+	return nil
+}
+
+func (db *fakeDB) AddAccesses(ctx context.Context, accesses []*shortlink.Access) error {
+	db.mtx.Lock()
+	defer db.mtx.Unlock()
+	if db.fail {
+		return errWrite
+	}
+	db.events = append(db.events, accesses...)
+	return nil
+}
+
+func date(s string) time.Time {
+	t, err := time.Parse(time.RFC3339, s)
+	if err != nil {
+		panic(err)
+	}
+	return t
+}
+
+func TestMerge(t *testing.T) {
+	db := newFakeDB()
+	c := New(db, time.Hour)
+	defer c.Close()
+
+	c.AddN(1, 2)
+	c.Add(1)
+	c.Add(2)
+
+	if n := c.Pending(1); n != 3 {
+		t.Errorf("pending accesses should be 3 but were %d", n)
+	}
+
+	if err := c.Flush(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	if db.accesses[1] != 3 || db.accesses[2] != 1 {
+		t.Errorf("accesses should be 3 and 1 but were %d and %d", db.accesses[1], db.accesses[2])
+	}
+	if db.calls[1] != 1 || db.calls[2] != 1 {
+		t.Errorf("increments should be merged into one call per ID but were %v", db.calls)
+	}
+	if n := c.Pending(1); n != 0 {
+		t.Errorf("pending accesses should be 0 after flush but were %d", n)
+	}
+}
+
+func TestPendingDuringFlush(t *testing.T) {
+	db := newFakeDB()
+	c := New(db, time.Hour)
+	defer c.Close()
+
+	c.AddN(1, 2)
+
+	var during int
+	db.onIncrement = func(id int) {
+		c.Add(id)
+		during = c.Pending(id)
+	}
+	if err := c.Flush(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	db.onIncrement = nil
+
+	if during != 3 {
+		t.Errorf("pending accesses should be 3 while flushing but were %d", during)
+	}
+	if n := c.Pending(1); n != 1 {
+		t.Errorf("pending accesses should be 1 after flush but were %d", n)
+	}
+}
+
+func TestFailedFlush(t *testing.T) {
+	db := newFakeDB()
+	c := New(db, time.Hour)
+	defer c.Close()
+
+	now := time.Now()
+	c.Record(&shortlink.Access{ShortLinkID: 1, Time: now, Visitor: "v"})
+	c.Record(&shortlink.Access{ShortLinkID: 1, Time: now, Bot: true})
+
+	db.setFail(true)
+	if err := c.Flush(context.Background()); err == nil {
+		t.Fatal("flush should fail")
+	}
+
+	if n := c.Pending(1); n != 1 {
+		t.Errorf("failed accesses should be kept but pending were %d", n)
+	}
+
+	c.Add(1)
+
+	db.setFail(false)
+	if err := c.Flush(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	if db.accesses[1] != 2 {
+		t.Errorf("accesses should be 2 but were %d", db.accesses[1])
+	}
+	if db.uniques[1] != 1 {
+		t.Errorf("uniques should be 1 but were %d", db.uniques[1])
+	}
+	if len(db.events) != 2 {
+		t.Errorf("2 access events should be written but were %d", len(db.events))
+	}
+}
+
+func TestDropOldestAccesses(t *testing.T) {
+	db := newFakeDB()
+	c := New(db, time.Hour)
+	defer c.Close()
+
+	db.setFail(true)
+	for i := 0; i < maxAccesses+5; i++ {
+		c.Record(&shortlink.Access{ShortLinkID: i, Bot: true})
+	}
+	if err := c.Flush(context.Background()); err == nil {
+		t.Fatal("flush should fail")
+	}
+	db.setFail(false)
+
+	if len(c.accesses) != maxAccesses {
+		t.Fatalf("%d access events should be kept but were %d", maxAccesses, len(c.accesses))
+	}
+	if id := c.accesses[0].ShortLinkID; id != 5 {
+		t.Errorf("oldest kept event should be of short link 5 but was of %d", id)
+	}
+}
+
+func TestClose(t *testing.T) {
+	db := newFakeDB()
+	c := New(db, time.Hour)
+
+	c.AddN(1, 5)
+	c.Record(&shortlink.Access{ShortLinkID: 1, Time: time.Now()})
+
+	if err := c.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	if db.accesses[1] != 6 {
+		t.Errorf("accesses should be 6 but were %d", db.accesses[1])
+	}
+	if db.deadlines != 1 {
+		t.Error("flush on close should have a deadline")
+	}
+	if len(db.events) != 1 {
+		t.Errorf("1 access event should be written but were %d", len(db.events))
+	}
+}
+
+func TestFirstVisit(t *testing.T) {
+	db := newFakeDB()
+	c := New(db, time.Hour)
+	defer c.Close()
+
+	for _, a := range []*shortlink.Access{
+		{ShortLinkID: 1, Time: date("2019-04-03T22:00:00Z"), Visitor: "a"},
+		{ShortLinkID: 1, Time: date("2019-04-03T23:59:59Z"), Visitor: "a"},
+		{ShortLinkID: 2, Time: date("2019-04-03T23:59:59Z"), Visitor: "a"},
+		{ShortLinkID: 1, Time: date("2019-04-03T23:59:59Z"), Visitor: "b"},
+		// Times in other zones count by their UTC day.
+		{ShortLinkID: 1, Time: date("2019-04-04T01:00:00+02:00"), Visitor: "a"},
+		{ShortLinkID: 1, Time: date("2019-04-04T00:00:00Z"), Visitor: "a"},
+		{ShortLinkID: 1, Time: date("2019-04-04T10:00:00Z"), Visitor: "a"},
+		{ShortLinkID: 1, Time: date("2019-04-04T10:00:00Z"), Visitor: "c", Bot: true},
+	} {
+		c.Record(a)
+	}
+
+	if err := c.Flush(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	if db.uniques[1] != 3 || db.uniques[2] != 1 {
+		t.Errorf("uniques should be 3 and 1 but were %d and %d", db.uniques[1], db.uniques[2])
+	}
+}
@@ -0,0 +1,20 @@
+package database
+
+import "context"
+
+// actorKey is the context key of the actor.
+type actorKey struct{}
+
+// WithActor returns a copy of ctx carrying the
+// passed actor which is recorded in the revisions
+// of changes made with the returned context.
+func WithActor(ctx context.Context, actor string) context.Context {
+	return context.WithValue(ctx, actorKey{}, actor)
+}
+
+// Actor returns the actor carried by ctx or
+// an empty string if ctx carries no actor.
+func Actor(ctx context.Context) string {
+	actor, _ := ctx.Value(actorKey{}).(string)
+	return actor
+}
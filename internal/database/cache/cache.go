@@ -0,0 +1,331 @@
+// Package cache provides a database middleware
+// which caches short link lookups of another
+// database middleware.
+package cache
+
+import (
+	"container/list"
+	"context"
+	"sync"
+	"time"
+
+	"github.com/zekroTJA/slms/internal/database"
+	"github.com/zekroTJA/slms/internal/shortlink"
+)
+
+const (
+	defaultTTL        = 60
+	defaultMaxEntries = 10000
+)
+
+// Config contains the configuration
+// for the short link lookup cache.
+// TTL is the lifetime of a cached entry
+// in seconds and Size the maximum number
+// of cached entries.
+type Config struct {
+	Enabled bool `json:"enabled"`
+	TTL     int  `json:"ttl"`
+	Size    int  `json:"size"`
+}
+
+// entry contains the result of a lookup by
+// short identifier, which is the found short
+// link or nil, if no short link was found.
+type entry struct {
+	short   string
+	sl      *shortlink.ShortLink
+	expires time.Time
+}
+
+// lookup is a lookup of a short identifier in the
+// wrapped middleware which has not returned yet.
+// Invalidations during the lookup mark it stale,
+// so that its possibly outdated result is not
+// cached. As the ID of the short link is unknown
+// until the lookup returns, invalidated IDs are
+// collected and checked afterwards.
+type lookup struct {
+	short string
+	ids   []int
+	stale bool
+}
+
+// Cache wraps a database middleware and caches
+// the results of short link lookups by short
+// identifier, which are executed on each short
+// link redirect. Also negative lookups are cached.
+// Cached entries are invalidated on
+// UpdateShortLink, IncrementAccesses,
+// IncrementUniques, CreateShortLink,
+// DeleteShortLink and
+// RestoreShortLink, so that changes take
+// effect immediately. Results of lookups which
+// were running while their short link was
+// invalidated are not cached.
+// If the maximum number of entries is reached,
+// the least recently used entry is evicted.
+//
+// All other calls are passed directly to the
+// wrapped database middleware.
+type Cache struct {
+	database.Middleware
+
+	ttl        time.Duration
+	maxEntries int
+
+	mtx     sync.Mutex
+	lru     *list.List
+	entries map[string]*list.Element
+	shorts  map[int]string
+	lookups map[*lookup]struct{}
+}
+
+// New creates a new Cache wrapping the passed,
+// already opened database middleware.
+func New(db database.Middleware, cfg *Config) *Cache {
+	c := &Cache{
+		Middleware: db,
+		ttl:        defaultTTL * time.Second,
+		maxEntries: defaultMaxEntries,
+		lru:        list.New(),
+		entries:    make(map[string]*list.Element),
+		shorts:     make(map[int]string),
+		lookups:    make(map[*lookup]struct{}),
+	}
+
+	if cfg.TTL > 0 {
+		c.ttl = time.Duration(cfg.TTL) * time.Second
+	}
+	if cfg.Size > 0 {
+		c.maxEntries = cfg.Size
+	}
+
+	return c
+}
+
+// GetShortLink gets a short link by id, root link or
+// short link like the wrapped database middleware.
+// Lookups by short link are served from the cache,
+// if available.
+func (c *Cache) GetShortLink(ctx context.Context, id, root, short string) (*shortlink.ShortLink, error) {
+	if id != "" || root != "" || short == "" {
+		return c.Middleware.GetShortLink(ctx, id, root, short)
+	}
+
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	if sl, ok := c.get(short); ok {
+		return sl, nil
+	}
+
+	l := c.startLookup(short)
+	sl, err := c.Middleware.GetShortLink(ctx, id, root, short)
+	c.finishLookup(l, sl, err == nil)
+	if err != nil {
+		return nil, err
+	}
+
+	return copyOf(sl), nil
+}
+
+// UpdateShortLink updates the short link in the
+// wrapped database middleware and removes it from
+// the cache, so that the stored short link is
+// fetched on next lookup.
+func (c *Cache) UpdateShortLink(ctx context.Context, id int, updated *shortlink.ShortLink) error {
+	err := c.Middleware.UpdateShortLink(ctx, id, updated)
+	c.invalidate(id, updated.ShortLink)
+	return err
+}
+
+// IncrementAccesses increments the access count in
+// the wrapped database middleware and removes the
+// short link from the cache, so that the new count
+// is fetched on next lookup.
+func (c *Cache) IncrementAccesses(ctx context.Context, id, n int) error {
+	err := c.Middleware.IncrementAccesses(ctx, id, n)
+	c.invalidate(id, "")
+	return err
+}
+
+// IncrementUniques increments the unique visitor
+// count in the wrapped database middleware and
+// removes the short link from the cache, so that
+// the new count is fetched on next lookup.
+func (c *Cache) IncrementUniques(ctx context.Context, id, n int) error {
+	err := c.Middleware.IncrementUniques(ctx, id, n)
+	c.invalidate(id, "")
+	return err
+}
+
+// CreateShortLink creates the short link in the
+// wrapped database middleware and removes a
+// cached negative lookup of its short identifier.
+func (c *Cache) CreateShortLink(ctx context.Context, sl *shortlink.ShortLink) (*shortlink.ShortLink, error) {
+	newSl, err := c.Middleware.CreateShortLink(ctx, sl)
+	c.invalidate(0, sl.ShortLink)
+	return newSl, err
+}
+
+// DeleteShortLink deletes the short link in the
+// wrapped database middleware and removes it
+// from the cache.
+func (c *Cache) DeleteShortLink(ctx context.Context, id int) error {
+	err := c.Middleware.DeleteShortLink(ctx, id)
+	c.invalidate(id, "")
+	return err
+}
+
+// RestoreShortLink restores the short link in the
+// wrapped database middleware and removes a
+// cached negative lookup of its short identifier.
+func (c *Cache) RestoreShortLink(ctx context.Context, id int) error {
+	sl, err := c.Middleware.GetTrashedShortLink(ctx, id)
+	if err != nil {
+		return err
+	}
+
+	err = c.Middleware.RestoreShortLink(ctx, id)
+	if sl != nil {
+		c.invalidate(id, sl.ShortLink.ShortLink)
+	}
+	return err
+}
+
+// get returns a copy of the cached lookup result
+// of the passed short identifier and true, if it
+// is cached and not expired.
+func (c *Cache) get(short string) (*shortlink.ShortLink, bool) {
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+
+	elem, ok := c.entries[short]
+	if !ok {
+		return nil, false
+	}
+
+	e := elem.Value.(*entry)
+	if time.Now().After(e.expires) {
+		c.remove(elem)
+		return nil, false
+	}
+
+	c.lru.MoveToFront(elem)
+
+	return copyOf(e.sl), true
+}
+
+// startLookup registers a lookup of the passed
+// short identifier, which must be finished with
+// finishLookup.
+func (c *Cache) startLookup(short string) *lookup {
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+
+	l := &lookup{short: short}
+	c.lookups[l] = struct{}{}
+
+	return l
+}
+
+// finishLookup unregisters the passed lookup and
+// caches its result sl, if ok is set and the short
+// link was not invalidated during the lookup.
+func (c *Cache) finishLookup(l *lookup, sl *shortlink.ShortLink, ok bool) {
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+
+	delete(c.lookups, l)
+
+	if !ok || l.stale {
+		return
+	}
+	if sl != nil {
+		for _, id := range l.ids {
+			if id == sl.ID {
+				return
+			}
+		}
+	}
+
+	c.set(l.short, sl)
+}
+
+// set caches the passed short link, which may be
+// nil, by the passed short identifier. If the
+// maximum number of entries is reached, the least
+// recently used entry is evicted. The caller must
+// hold the lock.
+func (c *Cache) set(short string, sl *shortlink.ShortLink) {
+	if elem, ok := c.entries[short]; ok {
+		c.remove(elem)
+	}
+
+	for c.lru.Len() >= c.maxEntries {
+		c.remove(c.lru.Back())
+	}
+
+	c.entries[short] = c.lru.PushFront(&entry{
+		short:   short,
+		sl:      copyOf(sl),
+		expires: time.Now().Add(c.ttl),
+	})
+	if sl != nil {
+		c.shorts[sl.ID] = short
+	}
+}
+
+// invalidate removes the cached entries of the
+// short link with the passed ID, if not 0, and of
+// the passed short identifier, if not empty, and
+// marks running lookups of them stale.
+func (c *Cache) invalidate(id int, short string) {
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+
+	cached := c.shorts[id]
+	c.removeShort(cached)
+	c.removeShort(short)
+
+	for l := range c.lookups {
+		switch {
+		case short != "" && l.short == short, cached != "" && l.short == cached:
+			l.stale = true
+		case id != 0:
+			l.ids = append(l.ids, id)
+		}
+	}
+}
+
+// removeShort removes the cached entry of the
+// passed short identifier, if existent. The
+// caller must hold the lock.
+func (c *Cache) removeShort(short string) {
+	if elem, ok := c.entries[short]; ok {
+		c.remove(elem)
+	}
+}
+
+// remove removes the passed element from the
+// cache. The caller must hold the lock.
+func (c *Cache) remove(elem *list.Element) {
+	e := c.lru.Remove(elem).(*entry)
+	delete(c.entries, e.short)
+	if e.sl != nil && c.shorts[e.sl.ID] == e.short {
+		delete(c.shorts, e.sl.ID)
+	}
+}
+
+// copyOf returns a copy of the passed short link
+// so that cached objects can not be modified by
+// the caller. If sl is nil, nil is returned.
+func copyOf(sl *shortlink.ShortLink) *shortlink.ShortLink {
+	if sl == nil {
+		return nil
+	}
+	c := *sl
+	return &c
+}
@@ -0,0 +1,163 @@
+package cache
+
+import (
+	"context"
+	"testing"
+
+	"github.com/zekroTJA/slms/internal/database"
+	"github.com/zekroTJA/slms/internal/database/dbtest"
+	"github.com/zekroTJA/slms/internal/database/memory"
+	"github.com/zekroTJA/slms/internal/shortlink"
+)
+
+func TestMiddleware(t *testing.T) {
+	dbtest.Run(t, func(t *testing.T) database.Middleware {
+		db := new(memory.Memory)
+		if err := db.Open(new(memory.Config)); err != nil {
+			t.Fatal(err)
+		}
+		return New(db, &Config{Enabled: true})
+	})
+}
+
+// counting wraps a database middleware and counts
+// the lookups by short identifier passed to it.
+// UpdateShortLink succeeds without updating,
+// like the SQL backends when no row matches.
+// If set, afterLookup is called after each lookup
+// before its result is returned.
+type counting struct {
+	database.Middleware
+	lookups     map[string]int
+	afterLookup func()
+}
+
+func (c *counting) GetShortLink(ctx context.Context, id, root, short string) (*shortlink.ShortLink, error) {
+	c.lookups[short]++
+	sl, err := c.Middleware.GetShortLink(ctx, id, root, short)
+	if c.afterLookup != nil {
+		c.afterLookup()
+	}
+	return sl, err
+}
+
+func (c *counting) UpdateShortLink(ctx context.Context, id int, updated *shortlink.ShortLink) error {
+	return nil
+}
+
+func newCounting(t *testing.T) *counting {
+	db := new(memory.Memory)
+	if err := db.Open(new(memory.Config)); err != nil {
+		t.Fatal(err)
+	}
+	return &counting{Middleware: db, lookups: make(map[string]int)}
+}
+
+func TestEviction(t *testing.T) {
+	ctx := context.Background()
+	db := newCounting(t)
+	c := New(db, &Config{Enabled: true, Size: 2})
+
+	for _, short := range []string{"a", "b", "a", "c", "a", "b"} {
+		if _, err := c.GetShortLink(ctx, "", "", short); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// "b" is evicted by "c" as least recently used,
+	// "a" stays cached as it was used in between.
+	exp := map[string]int{"a": 1, "b": 2, "c": 1}
+	for short, n := range exp {
+		if db.lookups[short] != n {
+			t.Errorf("'%s' should be looked up %d times but was %d times", short, n, db.lookups[short])
+		}
+	}
+	if len(c.entries) != 2 || c.lru.Len() != 2 {
+		t.Errorf("cache should contain 2 entries but contained %d", c.lru.Len())
+	}
+}
+
+func TestUpdateInvalidates(t *testing.T) {
+	ctx := context.Background()
+	db := newCounting(t)
+	c := New(db, &Config{Enabled: true})
+
+	sl, err := c.CreateShortLink(ctx, &shortlink.ShortLink{RootLink: "https://example.com", ShortLink: "a"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = c.GetShortLink(ctx, "", "", "a"); err != nil {
+		t.Fatal(err)
+	}
+	if err = c.DeleteShortLink(ctx, sl.ID); err != nil {
+		t.Fatal(err)
+	}
+
+	// The update of the deleted short link matches no
+	// row, so it must not be cached afterwards.
+	if err = c.UpdateShortLink(ctx, sl.ID, sl); err != nil {
+		t.Fatal(err)
+	}
+	got, err := c.GetShortLink(ctx, "", "", "a")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got != nil {
+		t.Error("deleted short link should not be found after update")
+	}
+}
+
+func TestInvalidateDuringLookup(t *testing.T) {
+	ctx := context.Background()
+	db := newCounting(t)
+	c := New(db, &Config{Enabled: true})
+
+	sl, err := c.CreateShortLink(ctx, &shortlink.ShortLink{RootLink: "https://example.com", ShortLink: "a"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The accesses are incremented after the short link
+	// was read, so the result of the lookup is outdated.
+	db.afterLookup = func() {
+		if err := c.IncrementAccesses(ctx, sl.ID, 1); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if _, err = c.GetShortLink(ctx, "", "", "a"); err != nil {
+		t.Fatal(err)
+	}
+
+	// The short link is created after the negative
+	// lookup, which must not be cached either.
+	db.afterLookup = func() {
+		if _, err := c.CreateShortLink(ctx, &shortlink.ShortLink{RootLink: "https://example.com", ShortLink: "b"}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if _, err = c.GetShortLink(ctx, "", "", "b"); err != nil {
+		t.Fatal(err)
+	}
+
+	db.afterLookup = nil
+
+	got, err := c.GetShortLink(ctx, "", "", "a")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.Accesses != 1 {
+		t.Errorf("accesses should be 1 but were %d", got.Accesses)
+	}
+	if got, err = c.GetShortLink(ctx, "", "", "b"); err != nil {
+		t.Fatal(err)
+	}
+	if got == nil {
+		t.Error("created short link should be found")
+	}
+	if db.lookups["a"] != 2 || db.lookups["b"] != 2 {
+		t.Errorf("outdated lookups should not be cached, lookups were %v", db.lookups)
+	}
+	if len(c.lookups) != 0 {
+		t.Errorf("%d lookups should be finished", len(c.lookups))
+	}
+}
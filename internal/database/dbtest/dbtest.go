@@ -0,0 +1,1724 @@
+// Package dbtest provides a conformance test suite
+// which checks implementations of database.Middleware
+// against the contract described by the interface.
+//
+// A backend test only needs to pass a Factory which
+// returns an opened and empty database instance:
+//
+//   func TestMiddleware(t *testing.T) {
+//       dbtest.Run(t, func(t *testing.T) database.Middleware {
+//           db := new(MyBackend)
+//           if err := db.Open(cfg); err != nil {
+//               t.Fatal(err)
+//           }
+//           return db
+//       })
+//   }
+package dbtest
+
+import (
+	"context"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/zekroTJA/slms/internal/database"
+	"github.com/zekroTJA/slms/internal/shortlink"
+)
+
+// ctx is the context passed to all
+// database calls of the test cases.
+var ctx = context.Background()
+
+// A Factory returns a new, opened database
+// middleware instance which contains no
+// short link entries. The instance will be
+// closed after each test case.
+type Factory func(t *testing.T) database.Middleware
+
+// Run executes all conformance test cases against
+// database middleware instances created by newDB.
+func Run(t *testing.T, newDB Factory) {
+	cases := []struct {
+		name string
+		test func(t *testing.T, db database.Middleware)
+	}{
+		{"CreateShortLink", testCreateShortLink},
+		{"GetShortLink", testGetShortLink},
+		{"GetShortLinkNotFound", testGetShortLinkNotFound},
+		{"GetShortLinks", testGetShortLinks},
+		{"GetShortLinksPaging", testGetShortLinksPaging},
+		{"QueryShortLinks", testQueryShortLinks},
+		{"QueryShortLinksCursor", testQueryShortLinksCursor},
+		{"GetShortLinkCount", testGetShortLinkCount},
+		{"UpdateShortLink", testUpdateShortLink},
+		{"Limits", testLimits},
+		{"RedirectType", testRedirectType},
+		{"Password", testPassword},
+		{"IncrementAccesses", testIncrementAccesses},
+		{"DeleteShortLink", testDeleteShortLink},
+		{"ReuseDeletedShort", testReuseDeletedShort},
+		{"ShortLinkConflict", testShortLinkConflict},
+		{"CaseSensitiveShort", testCaseSensitiveShort},
+		{"GetTrashedShortLinks", testGetTrashedShortLinks},
+		{"RestoreShortLink", testRestoreShortLink},
+		{"PurgeShortLink", testPurgeShortLink},
+		{"PurgeTrash", testPurgeTrash},
+		{"Revisions", testRevisions},
+		{"PurgeRevisions", testPurgeRevisions},
+		{"Accesses", testAccesses},
+		{"AccessBreakdowns", testAccessBreakdowns},
+		{"Countries", testCountries},
+		{"BotAccesses", testBotAccesses},
+		{"Uniques", testUniques},
+		{"Rollups", testRollups},
+		{"Summary", testSummary},
+		{"CancelledContext", testCancelledContext},
+	}
+
+	for _, c := range cases {
+		c := c
+		t.Run(c.name, func(t *testing.T) {
+			db := newDB(t)
+			defer db.Close()
+			c.test(t, db)
+		})
+	}
+}
+
+// --- HELPERS -----------------------------------------------------------
+
+// mustCreate creates a short link with the passed
+// root and short link and fails the test on error.
+func mustCreate(t *testing.T, db database.Middleware, root, short string) *shortlink.ShortLink {
+	t.Helper()
+
+	sl, err := db.CreateShortLink(ctx, &shortlink.ShortLink{
+		RootLink:  root,
+		ShortLink: short,
+	})
+	if err != nil {
+		t.Fatalf("CreateShortLink(%s, %s) failed: %s", root, short, err.Error())
+	}
+	if sl == nil {
+		t.Fatalf("CreateShortLink(%s, %s) returned nil", root, short)
+	}
+
+	return sl
+}
+
+// mustGet gets a short link by id, root or short
+// link and fails the test on error.
+func mustGet(t *testing.T, db database.Middleware, id, root, short string) *shortlink.ShortLink {
+	t.Helper()
+
+	sl, err := db.GetShortLink(ctx, id, root, short)
+	if err != nil {
+		t.Fatalf("GetShortLink(%q, %q, %q) failed: %s", id, root, short, err.Error())
+	}
+
+	return sl
+}
+
+// mustCount returns the short link count
+// and fails the test on error.
+func mustCount(t *testing.T, db database.Middleware) int {
+	t.Helper()
+
+	n, err := db.GetShortLinkCount(ctx)
+	if err != nil {
+		t.Fatalf("GetShortLinkCount() failed: %s", err.Error())
+	}
+
+	return n
+}
+
+// mustDelete deletes the short link with the
+// passed ID and fails the test on error.
+func mustDelete(t *testing.T, db database.Middleware, id int) {
+	t.Helper()
+
+	if err := db.DeleteShortLink(ctx, id); err != nil {
+		t.Fatalf("DeleteShortLink(%d) failed: %s", id, err.Error())
+	}
+}
+
+// idOf returns the ID of the short
+// link as string.
+func idOf(sl *shortlink.ShortLink) string {
+	return strconv.Itoa(sl.ID)
+}
+
+// --- TEST CASES --------------------------------------------------------
+
+func testCreateShortLink(t *testing.T, db database.Middleware) {
+	sl := mustCreate(t, db, "https://example.com/a", "a")
+
+	if sl.ID <= 0 {
+		t.Errorf("ID should be > 0 but was %d", sl.ID)
+	}
+	if sl.RootLink != "https://example.com/a" {
+		t.Errorf("RootLink should be '%s' but was '%s'", "https://example.com/a", sl.RootLink)
+	}
+	if sl.ShortLink != "a" {
+		t.Errorf("ShortLink should be '%s' but was '%s'", "a", sl.ShortLink)
+	}
+	if sl.Accesses != 0 {
+		t.Errorf("Accesses should be 0 but was %d", sl.Accesses)
+	}
+	if sl.Created.IsZero() {
+		t.Error("Created should be set but was zero")
+	}
+
+	sl2 := mustCreate(t, db, "https://example.com/b", "b")
+	if sl2.ID == sl.ID {
+		t.Errorf("IDs of different short links should differ but were both %d", sl.ID)
+	}
+}
+
+func testGetShortLink(t *testing.T, db database.Middleware) {
+	sl := mustCreate(t, db, "https://example.com/a", "a")
+	mustCreate(t, db, "https://example.com/b", "b")
+
+	byID := mustGet(t, db, idOf(sl), "", "")
+	byRoot := mustGet(t, db, "", sl.RootLink, "")
+	byShort := mustGet(t, db, "", "", sl.ShortLink)
+
+	for name, got := range map[string]*shortlink.ShortLink{
+		"id": byID, "root": byRoot, "short": byShort,
+	} {
+		if got == nil {
+			t.Errorf("GetShortLink by %s returned nil", name)
+			continue
+		}
+		if got.ID != sl.ID || got.RootLink != sl.RootLink || got.ShortLink != sl.ShortLink {
+			t.Errorf("GetShortLink by %s returned %+v but should return %+v", name, got, sl)
+		}
+	}
+
+	// id takes precedence over root and short
+	if got := mustGet(t, db, idOf(sl), "https://example.com/b", "b"); got == nil || got.ID != sl.ID {
+		t.Errorf("GetShortLink should prefer id over root and short but returned %+v", got)
+	}
+}
+
+func testGetShortLinkNotFound(t *testing.T, db database.Middleware) {
+	sl := mustCreate(t, db, "https://example.com/a", "a")
+
+	for _, args := range [][3]string{
+		{"", "", ""},
+		{strconv.Itoa(sl.ID + 1000), "", ""},
+		{"notanid", "", ""},
+		{"", "https://example.com/none", ""},
+		{"", "", "none"},
+	} {
+		got, err := db.GetShortLink(ctx, args[0], args[1], args[2])
+		if err != nil {
+			t.Errorf("GetShortLink(%q, %q, %q) should not fail but returned: %s",
+				args[0], args[1], args[2], err.Error())
+		}
+		if got != nil {
+			t.Errorf("GetShortLink(%q, %q, %q) should return nil but returned %+v",
+				args[0], args[1], args[2], got)
+		}
+	}
+}
+
+func testGetShortLinks(t *testing.T, db database.Middleware) {
+	sls, err := db.GetShortLinks(ctx, 0, 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(sls) != 0 {
+		t.Errorf("empty database should return 0 entries but returned %d", len(sls))
+	}
+
+	first := mustCreate(t, db, "https://example.com/a", "a")
+	// Databases store timestamps with second
+	// precision, so wait to get a later date.
+	time.Sleep(1100 * time.Millisecond)
+	mustCreate(t, db, "https://example.com/b", "b")
+	last := mustCreate(t, db, "https://example.com/c", "c")
+
+	sls, err = db.GetShortLinks(ctx, 0, 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(sls) != 3 {
+		t.Fatalf("should return 3 entries but returned %d", len(sls))
+	}
+
+	for i := 1; i < len(sls); i++ {
+		if sls[i].Created.After(sls[i-1].Created) {
+			t.Errorf("entries should be ordered by created descending but %d (%s) is after %d (%s)",
+				sls[i].ID, sls[i].Created, sls[i-1].ID, sls[i-1].Created)
+		}
+	}
+
+	if sls[2].ID != first.ID {
+		t.Errorf("oldest entry %d should be last but was %d", first.ID, sls[2].ID)
+	}
+	if sls[0].ID != last.ID && sls[1].ID != last.ID {
+		t.Errorf("newest entry %d should be in the first two entries", last.ID)
+	}
+}
+
+func testGetShortLinksPaging(t *testing.T, db database.Middleware) {
+	const n = 7
+
+	for i := 0; i < n; i++ {
+		mustCreate(t, db, "https://example.com/"+strconv.Itoa(i), "s"+strconv.Itoa(i))
+	}
+
+	seen := make(map[int]bool)
+	for from := 0; from < n; from += 3 {
+		sls, err := db.GetShortLinks(ctx, from, 3)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		exp := 3
+		if n-from < exp {
+			exp = n - from
+		}
+		if len(sls) != exp {
+			t.Errorf("GetShortLinks(%d, 3) should return %d entries but returned %d", from, exp, len(sls))
+		}
+
+		for _, sl := range sls {
+			if seen[sl.ID] {
+				t.Errorf("entry %d was returned on multiple pages", sl.ID)
+			}
+			seen[sl.ID] = true
+		}
+	}
+
+	if len(seen) != n {
+		t.Errorf("all pages should contain %d entries but contained %d", n, len(seen))
+	}
+
+	sls, err := db.GetShortLinks(ctx, n, 3)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(sls) != 0 {
+		t.Errorf("page after last entry should be empty but contained %d entries", len(sls))
+	}
+}
+
+func testQueryShortLinks(t *testing.T, db database.Middleware) {
+	query := func(q database.Query) ([]string, int) {
+		if q.Limit == 0 {
+			q.Limit = 100
+		}
+		sls, total, err := db.QueryShortLinks(ctx, &q)
+		if err != nil {
+			t.Fatal(err)
+		}
+		shorts := make([]string, len(sls))
+		for i, sl := range sls {
+			shorts[i] = sl.ShortLink
+		}
+		return shorts, total
+	}
+	expect := func(name string, q database.Query, exp ...string) {
+		t.Helper()
+		shorts, total := query(q)
+		if strings.Join(shorts, ",") != strings.Join(exp, ",") || total != len(exp) {
+			t.Errorf("%s should return %v (total %d) but returned %v (total %d)",
+				name, exp, len(exp), shorts, total)
+		}
+	}
+
+	mustCreate(t, db, "https://example.com/docs", "a")
+	mustCreate(t, db, "http://EXAMPLE.com:8080", "b")
+	mustCreate(t, db, "https://sub.example.com/Docs?q=1", "c")
+	mustCreate(t, db, "https://example.org/100%_sure", "docs")
+	deleted := mustCreate(t, db, "https://example.com/deleted", "docs-deleted")
+	mustDelete(t, db, deleted.ID)
+
+	sls, err := db.GetShortLinks(ctx, 0, 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i, sl := range sls {
+		if err = db.IncrementAccesses(ctx, sl.ID, len(sls)-i); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	expect("no filter sorted by short", database.Query{Sort: database.SortShortLink},
+		"a", "b", "c", "docs")
+	expect("search", database.Query{Search: "DOCS", Sort: database.SortShortLink},
+		"a", "c", "docs")
+	expect("search with wildcard", database.Query{Search: "%_"}, "docs")
+	expect("search with underscore", database.Query{Search: "_"}, "docs")
+	expect("domain", database.Query{Domain: "Example.com", Sort: database.SortShortLink},
+		"a", "b")
+	expect("unknown domain", database.Query{Domain: "example"})
+	expect("min accesses", database.Query{MinAccesses: 3, Sort: database.SortAccesses},
+		"c", "docs")
+	expect("sort by accesses descending",
+		database.Query{Sort: database.SortAccesses, Descending: true},
+		"docs", "c", "b", "a")
+
+	past := time.Now().Add(-time.Hour)
+	future := time.Now().Add(time.Hour)
+	expect("created range", database.Query{CreatedFrom: past, CreatedTo: future, Search: "8080"}, "b")
+	expect("created in future", database.Query{CreatedFrom: future})
+	expect("created in past", database.Query{CreatedTo: past})
+	expect("edited range", database.Query{EditedFrom: past, EditedTo: future, Search: "8080"}, "b")
+
+	shorts, total := query(database.Query{Sort: database.SortShortLink, From: 1, Limit: 2})
+	if strings.Join(shorts, ",") != "b,c" || total != 4 {
+		t.Errorf("page should return [b c] (total 4) but returned %v (total %d)", shorts, total)
+	}
+	shorts, total = query(database.Query{From: 4, Limit: 2})
+	if len(shorts) != 0 || total != 4 {
+		t.Errorf("page after last entry should be empty (total 4) but returned %v (total %d)", shorts, total)
+	}
+}
+
+func testQueryShortLinksCursor(t *testing.T, db database.Middleware) {
+	const n = 5
+
+	for i := 0; i < n; i++ {
+		mustCreate(t, db, "https://example.com/"+strconv.Itoa(i), "s"+strconv.Itoa(i))
+	}
+
+	all, _, err := db.QueryShortLinks(ctx, &database.Query{Descending: true, Limit: n})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(all) != n {
+		t.Fatalf("should return %d entries but returned %d", n, len(all))
+	}
+
+	cursorOf := func(sl *shortlink.ShortLink, backward bool) *database.Cursor {
+		return &database.Cursor{Created: sl.Created, ID: sl.ID, Backward: backward}
+	}
+
+	var got []*shortlink.ShortLink
+	var created *shortlink.ShortLink
+	q := &database.Query{Descending: true, Limit: 2}
+	for {
+		sls, total, err := db.QueryShortLinks(ctx, q)
+		if err != nil {
+			t.Fatal(err)
+		}
+		exp := n
+		if created != nil {
+			exp++
+		}
+		if total != exp {
+			t.Errorf("total should be %d but was %d", exp, total)
+		}
+		if len(sls) == 0 {
+			break
+		}
+		if created == nil {
+			// Entries created while paging must
+			// not shift the following pages.
+			created = mustCreate(t, db, "https://example.com/new", "new")
+		}
+		got = append(got, sls...)
+		q.Cursor = cursorOf(sls[len(sls)-1], false)
+		q.Sort = database.SortAccesses
+	}
+
+	if len(got) != n {
+		t.Fatalf("pages should contain %d entries but contained %d", n, len(got))
+	}
+	for i := range got {
+		if got[i].ID != all[i].ID {
+			t.Errorf("entry %d should be %d but was %d", i, all[i].ID, got[i].ID)
+		}
+	}
+
+	sls, _, err := db.QueryShortLinks(ctx, &database.Query{
+		Descending: true, Limit: 2, Cursor: cursorOf(all[3], true)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(sls) != 2 || sls[0].ID != all[1].ID || sls[1].ID != all[2].ID {
+		t.Errorf("backward page should contain %d and %d but was %+v", all[1].ID, all[2].ID, sls)
+	}
+
+	sls, _, err = db.QueryShortLinks(ctx, &database.Query{
+		Limit: 10, Cursor: cursorOf(all[2], false)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(sls) != 3 || sls[0].ID != all[1].ID || sls[1].ID != all[0].ID || sls[2].ID != created.ID {
+		t.Errorf("ascending page should contain %d, %d and %d but was %+v",
+			all[1].ID, all[0].ID, created.ID, sls)
+	}
+}
+
+func testGetShortLinkCount(t *testing.T, db database.Middleware) {
+	if n := mustCount(t, db); n != 0 {
+		t.Errorf("count of empty database should be 0 but was %d", n)
+	}
+
+	mustCreate(t, db, "https://example.com/a", "a")
+	mustCreate(t, db, "https://example.com/b", "b")
+
+	if n := mustCount(t, db); n != 2 {
+		t.Errorf("count should be 2 but was %d", n)
+	}
+}
+
+func testUpdateShortLink(t *testing.T, db database.Middleware) {
+	sl := mustCreate(t, db, "https://example.com/a", "a")
+
+	sl.RootLink = "https://example.com/updated"
+	sl.ShortLink = "updated"
+	sl.Accesses = 5
+
+	if err := db.UpdateShortLink(ctx, sl.ID, sl); err != nil {
+		t.Fatal(err)
+	}
+
+	got := mustGet(t, db, idOf(sl), "", "")
+	if got == nil {
+		t.Fatal("updated entry was not found")
+	}
+	if got.RootLink != sl.RootLink || got.ShortLink != sl.ShortLink {
+		t.Errorf("updated entry should be %+v but was %+v", sl, got)
+	}
+	if got.Accesses != 0 {
+		t.Errorf("UpdateShortLink should not modify accesses but they were %d", got.Accesses)
+	}
+	if got.Edited.Before(got.Created) {
+		t.Errorf("edited (%s) should not be before created (%s)", got.Edited, got.Created)
+	}
+
+	if old := mustGet(t, db, "", "", "a"); old != nil {
+		t.Errorf("old short should not be found anymore but returned %+v", old)
+	}
+}
+
+func testLimits(t *testing.T, db database.Middleware) {
+	expires := time.Date(2030, 1, 2, 3, 4, 5, 0, time.UTC)
+
+	sl, err := db.CreateShortLink(ctx, &shortlink.ShortLink{
+		RootLink:    "https://example.com/a",
+		ShortLink:   "a",
+		ExpiresAt:   &expires,
+		MaxAccesses: 3,
+		FallbackURL: "https://example.com/fallback",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	plain := mustCreate(t, db, "https://example.com/b", "b")
+
+	check := func(what string, got *shortlink.ShortLink, expires *time.Time, max int, fallback string) {
+		t.Helper()
+		if got == nil {
+			t.Fatalf("%s: short link was not found", what)
+		}
+		if (got.ExpiresAt == nil) != (expires == nil) ||
+			got.ExpiresAt != nil && !got.ExpiresAt.Equal(*expires) {
+			t.Errorf("%s: expires at should be %v but was %v", what, expires, got.ExpiresAt)
+		}
+		if got.MaxAccesses != max || got.FallbackURL != fallback {
+			t.Errorf("%s: limits should be %d and '%s' but were %d and '%s'",
+				what, max, fallback, got.MaxAccesses, got.FallbackURL)
+		}
+	}
+
+	check("created", sl, &expires, 3, "https://example.com/fallback")
+	check("get", mustGet(t, db, "", "", "a"), &expires, 3, "https://example.com/fallback")
+	check("plain", mustGet(t, db, idOf(plain), "", ""), nil, 0, "")
+
+	sls, err := db.GetShortLinks(ctx, 0, 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(sls) != 2 {
+		t.Fatalf("GetShortLinks should return 2 entries but returned %d", len(sls))
+	}
+	check("list", sls[1], &expires, 3, "https://example.com/fallback")
+
+	later := expires.Add(time.Hour)
+	sl.ExpiresAt = &later
+	sl.MaxAccesses = 10
+	if err = db.UpdateShortLink(ctx, sl.ID, sl); err != nil {
+		t.Fatal(err)
+	}
+	check("updated", mustGet(t, db, idOf(sl), "", ""), &later, 10, "https://example.com/fallback")
+
+	sl.ExpiresAt = nil
+	sl.MaxAccesses = 0
+	sl.FallbackURL = ""
+	if err = db.UpdateShortLink(ctx, sl.ID, sl); err != nil {
+		t.Fatal(err)
+	}
+	check("removed", mustGet(t, db, idOf(sl), "", ""), nil, 0, "")
+}
+
+func testRedirectType(t *testing.T, db database.Middleware) {
+	sl, err := db.CreateShortLink(ctx, &shortlink.ShortLink{
+		RootLink:     "https://example.com/a",
+		ShortLink:    "a",
+		RedirectType: shortlink.RedirectFound,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if sl.RedirectType != shortlink.RedirectFound {
+		t.Errorf("created redirect type should be '%s' but was '%s'", shortlink.RedirectFound, sl.RedirectType)
+	}
+
+	if got := mustGet(t, db, "", "", "a"); got.RedirectType != shortlink.RedirectFound {
+		t.Errorf("redirect type should be '%s' but was '%s'", shortlink.RedirectFound, got.RedirectType)
+	}
+
+	sl.RedirectType = shortlink.RedirectMetaRefresh
+	if err = db.UpdateShortLink(ctx, sl.ID, sl); err != nil {
+		t.Fatal(err)
+	}
+	if got := mustGet(t, db, idOf(sl), "", ""); got.RedirectType != shortlink.RedirectMetaRefresh {
+		t.Errorf("updated redirect type should be '%s' but was '%s'", shortlink.RedirectMetaRefresh, got.RedirectType)
+	}
+
+	if got := mustCreate(t, db, "https://example.com/b", "b"); got.RedirectType != "" {
+		t.Errorf("redirect type should be empty by default but was '%s'", got.RedirectType)
+	}
+}
+
+func testPassword(t *testing.T, db database.Middleware) {
+	const hash = "$2a$04$hash"
+
+	sl, err := db.CreateShortLink(ctx, &shortlink.ShortLink{
+		RootLink:     "https://example.com/a",
+		ShortLink:    "a",
+		PasswordHash: hash,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if got := mustGet(t, db, "", "", "a"); got.PasswordHash != hash {
+		t.Errorf("password hash should be '%s' but was '%s'", hash, got.PasswordHash)
+	}
+
+	sl.PasswordHash = ""
+	if err = db.UpdateShortLink(ctx, sl.ID, sl); err != nil {
+		t.Fatal(err)
+	}
+	if got := mustGet(t, db, idOf(sl), "", ""); got.PasswordHash != "" {
+		t.Errorf("password hash should be removed but was '%s'", got.PasswordHash)
+	}
+
+	if got := mustCreate(t, db, "https://example.com/b", "b"); got.PasswordHash != "" {
+		t.Errorf("password hash should be empty by default but was '%s'", got.PasswordHash)
+	}
+}
+
+func testIncrementAccesses(t *testing.T, db database.Middleware) {
+	const n = 20
+
+	sl := mustCreate(t, db, "https://example.com/a", "a")
+	other := mustCreate(t, db, "https://example.com/b", "b")
+
+	var wg sync.WaitGroup
+	errs := make(chan error, n)
+	for i := 0; i < n; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			errs <- db.IncrementAccesses(ctx, sl.ID, 2)
+		}()
+	}
+	wg.Wait()
+	close(errs)
+
+	for err := range errs {
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if got := mustGet(t, db, idOf(sl), "", ""); got == nil || got.Accesses != 2*n {
+		t.Errorf("accesses should be %d after concurrent increments but entry was %+v", 2*n, got)
+	}
+	if got := mustGet(t, db, idOf(other), "", ""); got == nil || got.Accesses != 0 {
+		t.Errorf("accesses of other entry should be 0 but entry was %+v", got)
+	}
+}
+
+func testDeleteShortLink(t *testing.T, db database.Middleware) {
+	sl := mustCreate(t, db, "https://example.com/a", "a")
+	keep := mustCreate(t, db, "https://example.com/b", "b")
+
+	if err := db.DeleteShortLink(ctx, sl.ID); err != nil {
+		t.Fatal(err)
+	}
+
+	if got := mustGet(t, db, idOf(sl), "", ""); got != nil {
+		t.Errorf("deleted entry was found by id: %+v", got)
+	}
+	if got := mustGet(t, db, "", sl.RootLink, ""); got != nil {
+		t.Errorf("deleted entry was found by root: %+v", got)
+	}
+	if got := mustGet(t, db, "", "", sl.ShortLink); got != nil {
+		t.Errorf("deleted entry was found by short: %+v", got)
+	}
+
+	sls, err := db.GetShortLinks(ctx, 0, 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(sls) != 1 || sls[0].ID != keep.ID {
+		t.Errorf("list should only contain entry %d but contained %d entries", keep.ID, len(sls))
+	}
+
+	if n := mustCount(t, db); n != 1 {
+		t.Errorf("count should be 1 after deletion but was %d", n)
+	}
+}
+
+func testReuseDeletedShort(t *testing.T, db database.Middleware) {
+	sl := mustCreate(t, db, "https://example.com/a", "a")
+
+	if err := db.DeleteShortLink(ctx, sl.ID); err != nil {
+		t.Fatal(err)
+	}
+
+	sl2 := mustCreate(t, db, "https://example.com/new", "a")
+	if sl2.ID == sl.ID {
+		t.Errorf("new entry should not reuse ID %d of deleted entry", sl.ID)
+	}
+	if sl2.RootLink != "https://example.com/new" {
+		t.Errorf("created entry should have root '%s' but had '%s'", "https://example.com/new", sl2.RootLink)
+	}
+
+	got := mustGet(t, db, "", "", "a")
+	if got == nil || got.ID != sl2.ID {
+		t.Errorf("short should resolve to new entry %d but resolved to %+v", sl2.ID, got)
+	}
+}
+
+func testShortLinkConflict(t *testing.T, db database.Middleware) {
+	a := mustCreate(t, db, "https://example.com/a", "a")
+	b := mustCreate(t, db, "https://example.com/b", "b")
+
+	_, err := db.CreateShortLink(ctx, &shortlink.ShortLink{RootLink: "https://example.com/other", ShortLink: "a"})
+	if e, ok := err.(*database.ConflictError); !ok || e.ShortLink != "a" {
+		t.Errorf("creating used short should fail with conflict but failed with %v", err)
+	}
+	if n := mustCount(t, db); n != 2 {
+		t.Errorf("count should be 2 after conflict but was %d", n)
+	}
+
+	err = db.UpdateShortLink(ctx, b.ID, &shortlink.ShortLink{RootLink: b.RootLink, ShortLink: "a"})
+	if !database.IsConflict(err) {
+		t.Errorf("updating to used short should fail with conflict but failed with %v", err)
+	}
+	if got := mustGet(t, db, idOf(b), "", ""); got == nil || got.ShortLink != "b" {
+		t.Errorf("entry should be unchanged after conflict but was %+v", got)
+	}
+
+	err = db.UpdateShortLink(ctx, a.ID, &shortlink.ShortLink{RootLink: "https://example.com/new", ShortLink: "a"})
+	if err != nil {
+		t.Errorf("updating root link should not conflict with itself but failed with %v", err)
+	}
+
+	mustDelete(t, db, a.ID)
+	mustCreate(t, db, "https://example.com/other", "a")
+
+	if err = db.RestoreShortLink(ctx, a.ID); !database.IsConflict(err) {
+		t.Errorf("restoring used short should fail with conflict but failed with %v", err)
+	}
+	if sl, err := db.GetTrashedShortLink(ctx, a.ID); err != nil || sl == nil {
+		t.Errorf("entry should stay in trash after conflict but was %+v (%v)", sl, err)
+	}
+
+	const n = 10
+	var wg sync.WaitGroup
+	errs := make(chan error, n)
+	for i := 0; i < n; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			_, err := db.CreateShortLink(ctx, &shortlink.ShortLink{RootLink: "https://example.com/race", ShortLink: "race"})
+			errs <- err
+		}()
+	}
+	wg.Wait()
+	close(errs)
+
+	created := 0
+	for err := range errs {
+		if err == nil {
+			created++
+		} else if !database.IsConflict(err) {
+			t.Errorf("concurrent create should fail with conflict but failed with %v", err)
+		}
+	}
+	if created != 1 {
+		t.Errorf("exactly one concurrent create should succeed but %d did", created)
+	}
+}
+
+func testCaseSensitiveShort(t *testing.T, db database.Middleware) {
+	lower := mustCreate(t, db, "https://example.com/lower", "abc")
+	upper, err := db.CreateShortLink(ctx, &shortlink.ShortLink{RootLink: "https://example.com/upper", ShortLink: "Abc"})
+	if err != nil {
+		t.Fatalf("short identifiers differing in case should not conflict but failed with %v", err)
+	}
+
+	if got := mustGet(t, db, "", "", "abc"); got == nil || got.ID != lower.ID {
+		t.Errorf("'abc' should resolve to entry %d but resolved to %+v", lower.ID, got)
+	}
+	if got := mustGet(t, db, "", "", "Abc"); got == nil || got.ID != upper.ID {
+		t.Errorf("'Abc' should resolve to entry %d but resolved to %+v", upper.ID, got)
+	}
+	if got := mustGet(t, db, "", "", "ABC"); got != nil {
+		t.Errorf("'ABC' should not be found but resolved to %+v", got)
+	}
+
+	// The search stays case-insensitive.
+	sls, _, err := db.QueryShortLinks(ctx, &database.Query{
+		Search: "ABC",
+		Sort:   database.SortCreated,
+		Limit:  10,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(sls) != 2 {
+		t.Errorf("search should find 2 entries but found %d", len(sls))
+	}
+}
+
+func testAccesses(t *testing.T, db database.Middleware) {
+	a := mustCreate(t, db, "https://example.com/a", "a")
+	b := mustCreate(t, db, "https://example.com/b", "b")
+
+	base := time.Now().UTC().Truncate(time.Hour).Add(-10 * time.Hour)
+	access := func(sl *shortlink.ShortLink, offset time.Duration) *shortlink.Access {
+		return &shortlink.Access{
+			ShortLinkID: sl.ID,
+			Time:        base.Add(offset),
+			Referrer:    "example.org",
+			Agent:       shortlink.AgentBrowser,
+		}
+	}
+
+	err := db.AddAccesses(ctx, []*shortlink.Access{
+		access(a, 10*time.Minute),
+		access(a, 20*time.Minute),
+		access(b, 15*time.Minute),
+		access(a, time.Hour+5*time.Minute),
+		access(a, 3*time.Hour),
+		access(a, -time.Second),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	counts, err := db.GetAccessCounts(ctx, a.ID, base, base.Add(3*time.Hour), database.TrafficHuman)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(counts) != 2 {
+		t.Fatalf("should return 2 hours but returned %d", len(counts))
+	}
+	for i, exp := range []*shortlink.AccessCount{
+		{Time: base, Count: 2},
+		{Time: base.Add(time.Hour), Count: 1},
+	} {
+		if !counts[i].Time.Equal(exp.Time) || counts[i].Count != exp.Count {
+			t.Errorf("hour %d should be %+v but was %+v", i, exp, counts[i])
+		}
+	}
+
+	counts, err = db.GetAccessCounts(ctx, b.ID, base, base.Add(3*time.Hour), database.TrafficHuman)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(counts) != 1 || counts[0].Count != 1 {
+		t.Errorf("other short link should have 1 access but had %+v", counts)
+	}
+
+	mustDelete(t, db, a.ID)
+	if err = db.PurgeShortLink(ctx, a.ID); err != nil {
+		t.Fatal(err)
+	}
+
+	counts, err = db.GetAccessCounts(ctx, a.ID, base.Add(-time.Hour), base.Add(4*time.Hour), database.TrafficHuman)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(counts) != 0 {
+		t.Errorf("accesses should be purged with short link but were %+v", counts)
+	}
+}
+
+func testAccessBreakdowns(t *testing.T, db database.Middleware) {
+	sl := mustCreate(t, db, "https://example.com/a", "a")
+	other := mustCreate(t, db, "https://example.com/b", "b")
+
+	base := time.Now().UTC().Truncate(time.Hour).Add(-10 * time.Hour)
+	access := func(sl *shortlink.ShortLink, offset time.Duration, ref, source, medium string) *shortlink.Access {
+		return &shortlink.Access{
+			ShortLinkID: sl.ID,
+			Time:        base.Add(offset),
+			Referrer:    ref,
+			Agent:       shortlink.AgentBrowser,
+			Campaign:    shortlink.Campaign{Source: source, Medium: medium, Name: "launch"},
+		}
+	}
+
+	err := db.AddAccesses(ctx, []*shortlink.Access{
+		access(sl, 0, "twitter.com", "twitter", "social"),
+		access(sl, time.Minute, "twitter.com", "twitter", "social"),
+		access(sl, 2*time.Minute, "twitter.com", "twitter", "social"),
+		access(sl, 3*time.Minute, "example.org", "newsletter", "email"),
+		access(sl, 4*time.Minute, "example.org", "newsletter", "email"),
+		{ShortLinkID: sl.ID, Time: base.Add(5 * time.Minute)},
+		access(sl, 2*time.Hour, "late.example", "late", "social"),
+		access(other, time.Minute, "twitter.com", "twitter", "social"),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	refs, err := db.GetReferrerCounts(ctx, sl.ID, base, base.Add(time.Hour), database.TrafficHuman, 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expRefs := []shortlink.ReferrerCount{
+		{Referrer: "twitter.com", Count: 3},
+		{Referrer: "example.org", Count: 2},
+		{Referrer: "", Count: 1},
+	}
+	if len(refs) != len(expRefs) {
+		t.Fatalf("should return %d referrers but returned %d", len(expRefs), len(refs))
+	}
+	for i, exp := range expRefs {
+		if *refs[i] != exp {
+			t.Errorf("referrer %d should be %+v but was %+v", i, exp, *refs[i])
+		}
+	}
+
+	refs, err = db.GetReferrerCounts(ctx, sl.ID, base, base.Add(time.Hour), database.TrafficHuman, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(refs) != 1 || refs[0].Referrer != "twitter.com" {
+		t.Errorf("limit 1 should return top referrer but returned %+v", refs)
+	}
+
+	campaigns, err := db.GetCampaignCounts(ctx, sl.ID, base, base.Add(time.Hour), database.TrafficHuman, 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expCampaigns := []shortlink.CampaignCount{
+		{Source: "twitter", Medium: "social", Name: "launch", Count: 3},
+		{Source: "newsletter", Medium: "email", Name: "launch", Count: 2},
+	}
+	if len(campaigns) != len(expCampaigns) {
+		t.Fatalf("should return %d campaigns but returned %d", len(expCampaigns), len(campaigns))
+	}
+	for i, exp := range expCampaigns {
+		if *campaigns[i] != exp {
+			t.Errorf("campaign %d should be %+v but was %+v", i, exp, *campaigns[i])
+		}
+	}
+}
+
+func testCountries(t *testing.T, db database.Middleware) {
+	sl := mustCreate(t, db, "https://example.com/a", "a")
+	other := mustCreate(t, db, "https://example.com/b", "b")
+
+	base := time.Now().UTC().Truncate(24 * time.Hour).Add(-48 * time.Hour)
+	access := func(sl *shortlink.ShortLink, offset time.Duration, country string, bot bool) *shortlink.Access {
+		return &shortlink.Access{
+			ShortLinkID: sl.ID,
+			Time:        base.Add(offset),
+			Agent:       shortlink.AgentBrowser,
+			Bot:         bot,
+			Country:     country,
+		}
+	}
+
+	err := db.AddAccesses(ctx, []*shortlink.Access{
+		access(sl, 0, "DE", false),
+		access(sl, time.Minute, "DE", false),
+		access(sl, 2*time.Hour, "US", false),
+		access(sl, 3*time.Hour, "", false),
+		access(sl, 4*time.Hour, "SE", false),
+		access(sl, 5*time.Hour, "SE", false),
+		access(sl, 6*time.Hour, "US", true),
+		access(sl, 30*time.Hour, "FR", false),
+		access(other, time.Minute, "DE", false),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	check := func(stage string) {
+		t.Helper()
+
+		countries, err := db.GetCountryCounts(ctx, sl.ID, base, base.Add(24*time.Hour), database.TrafficHuman, 10)
+		if err != nil {
+			t.Fatal(err)
+		}
+		exp := []shortlink.CountryCount{
+			{Country: "DE", Count: 2},
+			{Country: "SE", Count: 2},
+			{Country: "", Count: 1},
+			{Country: "US", Count: 1},
+		}
+		if len(countries) != len(exp) {
+			t.Fatalf("%s: should return %d countries but returned %+v", stage, len(exp), countries)
+		}
+		for i, e := range exp {
+			if *countries[i] != e {
+				t.Errorf("%s: country %d should be %+v but was %+v", stage, i, e, *countries[i])
+			}
+		}
+
+		countries, err = db.GetCountryCounts(ctx, sl.ID, base, base.Add(24*time.Hour), database.TrafficBot, 10)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(countries) != 1 || *countries[0] != (shortlink.CountryCount{Country: "US", Count: 1}) {
+			t.Errorf("%s: should count 1 bot access from US but counted %+v", stage, countries)
+		}
+
+		countries, err = db.GetCountryCounts(ctx, sl.ID, base, base.Add(24*time.Hour), database.TrafficHuman, 1)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(countries) != 1 || countries[0].Country != "DE" {
+			t.Errorf("%s: limit 1 should return top country but returned %+v", stage, countries)
+		}
+	}
+
+	check("raw")
+
+	if _, err = db.RollUpAccesses(ctx, base.Add(24*time.Hour)); err != nil {
+		t.Fatal(err)
+	}
+	check("hourly")
+
+	if _, err = db.RollUpHourlyAccesses(ctx, base.Add(24*time.Hour)); err != nil {
+		t.Fatal(err)
+	}
+	check("daily")
+}
+
+func testBotAccesses(t *testing.T, db database.Middleware) {
+	sl := mustCreate(t, db, "https://example.com/a", "a")
+
+	base := time.Now().UTC().Truncate(time.Hour).Add(-10 * time.Hour)
+	access := func(ref string, bot bool) *shortlink.Access {
+		return &shortlink.Access{
+			ShortLinkID: sl.ID,
+			Time:        base,
+			Referrer:    ref,
+			Agent:       shortlink.AgentBrowser,
+			Bot:         bot,
+			Campaign:    shortlink.Campaign{Source: ref},
+		}
+	}
+
+	err := db.AddAccesses(ctx, []*shortlink.Access{
+		access("twitter.com", false),
+		access("twitter.com", false),
+		access("slack.com", true),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for traffic, exp := range map[database.Traffic]int{
+		database.TrafficHuman: 2,
+		database.TrafficBot:   1,
+		database.TrafficAll:   3,
+	} {
+		counts, err := db.GetAccessCounts(ctx, sl.ID, base, base.Add(time.Hour), traffic)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(counts) != 1 || counts[0].Count != exp {
+			t.Errorf("%s traffic should count %d accesses but counted %+v", traffic, exp, counts)
+		}
+
+		refs, err := db.GetReferrerCounts(ctx, sl.ID, base, base.Add(time.Hour), traffic, 10)
+		if err != nil {
+			t.Fatal(err)
+		}
+		campaigns, err := db.GetCampaignCounts(ctx, sl.ID, base, base.Add(time.Hour), traffic, 10)
+		if err != nil {
+			t.Fatal(err)
+		}
+		n, m := 0, 0
+		for _, r := range refs {
+			n += r.Count
+		}
+		for _, c := range campaigns {
+			m += c.Count
+		}
+		if n != exp || m != exp {
+			t.Errorf("%s traffic breakdowns should count %d accesses but counted %d and %d", traffic, exp, n, m)
+		}
+	}
+
+	refs, err := db.GetReferrerCounts(ctx, sl.ID, base, base.Add(time.Hour), database.TrafficBot, 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(refs) != 1 || refs[0].Referrer != "slack.com" {
+		t.Errorf("bot traffic should only be referred by slack.com but was %+v", refs)
+	}
+}
+
+func testUniques(t *testing.T, db database.Middleware) {
+	sl := mustCreate(t, db, "https://example.com/a", "a")
+	other := mustCreate(t, db, "https://example.com/b", "b")
+
+	if err := db.IncrementUniques(ctx, sl.ID, 3); err != nil {
+		t.Fatal(err)
+	}
+	if got := mustGet(t, db, idOf(sl), "", ""); got == nil || got.Uniques != 3 || got.Accesses != 0 {
+		t.Errorf("uniques should be 3 and accesses 0 but entry was %+v", got)
+	}
+	sls, _, err := db.QueryShortLinks(ctx, &database.Query{Sort: database.SortID, Limit: 10})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(sls) != 2 || sls[0].Uniques != 3 || sls[1].Uniques != 0 {
+		t.Errorf("list should contain the unique counts but was %+v", sls)
+	}
+
+	base := time.Now().UTC().Truncate(24 * time.Hour).Add(-48 * time.Hour)
+	access := func(sl *shortlink.ShortLink, offset time.Duration, visitor string, bot bool) *shortlink.Access {
+		return &shortlink.Access{
+			ShortLinkID: sl.ID,
+			Time:        base.Add(offset),
+			Agent:       shortlink.AgentBrowser,
+			Bot:         bot,
+			Visitor:     visitor,
+		}
+	}
+
+	err = db.AddAccesses(ctx, []*shortlink.Access{
+		access(sl, time.Hour, "v1", false),
+		access(sl, 2*time.Hour, "v1", false),
+		access(sl, 3*time.Hour, "v2", false),
+		access(sl, 4*time.Hour, "v3", true),
+		access(sl, 5*time.Hour, "", false),
+		access(sl, 25*time.Hour, "v1", false),
+		access(other, time.Hour, "v4", false),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	counts, err := db.GetUniqueCounts(ctx, sl.ID, base, base.Add(48*time.Hour))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(counts) != 2 {
+		t.Fatalf("should return 2 days but returned %d", len(counts))
+	}
+	for i, exp := range []*shortlink.AccessCount{
+		{Time: base, Count: 2},
+		{Time: base.Add(24 * time.Hour), Count: 1},
+	} {
+		if !counts[i].Time.Equal(exp.Time) || counts[i].Count != exp.Count {
+			t.Errorf("day %d should be %+v but was %+v", i, exp, counts[i])
+		}
+	}
+}
+
+func testRollups(t *testing.T, db database.Middleware) {
+	sl := mustCreate(t, db, "https://example.com/a", "a")
+	other := mustCreate(t, db, "https://example.com/b", "b")
+
+	base := time.Now().UTC().Truncate(24 * time.Hour).Add(-72 * time.Hour)
+	end := base.Add(96 * time.Hour)
+	access := func(sl *shortlink.ShortLink, offset time.Duration, ref, visitor string, bot bool) *shortlink.Access {
+		return &shortlink.Access{
+			ShortLinkID: sl.ID,
+			Time:        base.Add(offset),
+			Referrer:    ref,
+			Agent:       shortlink.AgentBrowser,
+			Bot:         bot,
+			Visitor:     visitor,
+			Campaign:    shortlink.Campaign{Source: ref},
+		}
+	}
+
+	err := db.AddAccesses(ctx, []*shortlink.Access{
+		access(sl, time.Hour+10*time.Minute, "twitter.com", "v1", false),
+		access(sl, time.Hour+20*time.Minute, "twitter.com", "v1", false),
+		access(sl, 2*time.Hour, "example.org", "v2", false),
+		access(sl, 3*time.Hour, "twitter.com", "v3", true),
+		access(sl, 25*time.Hour, "twitter.com", "v1", false),
+		access(sl, 49*time.Hour, "example.org", "v1", false),
+		access(other, time.Hour, "twitter.com", "v4", false),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// check compares the stats of sl in the whole range,
+	// which must not change by rolling up accesses, and
+	// the hourly access counts of human traffic.
+	check := func(stage string, hours []*shortlink.AccessCount, human, bot, twitter int, uniques []*shortlink.AccessCount) {
+		counts, err := db.GetAccessCounts(ctx, sl.ID, base, end, database.TrafficHuman)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(counts) != len(hours) {
+			t.Fatalf("%s: should return %d hours but returned %d", stage, len(hours), len(counts))
+		}
+		for i, exp := range hours {
+			if !counts[i].Time.Equal(exp.Time) || counts[i].Count != exp.Count {
+				t.Errorf("%s: hour %d should be %+v but was %+v", stage, i, exp, counts[i])
+			}
+		}
+
+		bots, err := db.GetAccessCounts(ctx, sl.ID, base, end, database.TrafficBot)
+		if err != nil {
+			t.Fatal(err)
+		}
+		n := 0
+		for _, c := range bots {
+			n += c.Count
+		}
+		if n != bot {
+			t.Errorf("%s: should count %d bot accesses but counted %d", stage, bot, n)
+		}
+
+		refs, err := db.GetReferrerCounts(ctx, sl.ID, base, end, database.TrafficHuman, 10)
+		if err != nil {
+			t.Fatal(err)
+		}
+		campaigns, err := db.GetCampaignCounts(ctx, sl.ID, base, end, database.TrafficHuman, 10)
+		if err != nil {
+			t.Fatal(err)
+		}
+		n, m := 0, 0
+		for _, r := range refs {
+			n += r.Count
+		}
+		for _, c := range campaigns {
+			if c.Source == "twitter.com" {
+				m += c.Count
+			}
+		}
+		if n != human || m != twitter {
+			t.Errorf("%s: should count %d referred and %d twitter accesses but counted %+v and %+v",
+				stage, human, twitter, refs, campaigns)
+		}
+
+		counts, err = db.GetUniqueCounts(ctx, sl.ID, base, end)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(counts) != len(uniques) {
+			t.Fatalf("%s: should return %d days but returned %d", stage, len(uniques), len(counts))
+		}
+		for i, exp := range uniques {
+			if !counts[i].Time.Equal(exp.Time) || counts[i].Count != exp.Count {
+				t.Errorf("%s: day %d should be %+v but was %+v", stage, i, exp, counts[i])
+			}
+		}
+	}
+
+	uniques := []*shortlink.AccessCount{
+		{Time: base, Count: 2},
+		{Time: base.Add(24 * time.Hour), Count: 1},
+		{Time: base.Add(48 * time.Hour), Count: 1},
+	}
+
+	check("raw", []*shortlink.AccessCount{
+		{Time: base.Add(time.Hour), Count: 2},
+		{Time: base.Add(2 * time.Hour), Count: 1},
+		{Time: base.Add(25 * time.Hour), Count: 1},
+		{Time: base.Add(49 * time.Hour), Count: 1},
+	}, 5, 1, 3, uniques)
+
+	n, err := db.RollUpAccesses(ctx, base.Add(48*time.Hour))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != 6 {
+		t.Errorf("6 accesses should be rolled up but %d were", n)
+	}
+	check("hourly", []*shortlink.AccessCount{
+		{Time: base.Add(time.Hour), Count: 2},
+		{Time: base.Add(2 * time.Hour), Count: 1},
+		{Time: base.Add(25 * time.Hour), Count: 1},
+		{Time: base.Add(49 * time.Hour), Count: 1},
+	}, 5, 1, 3, uniques)
+
+	n, err = db.RollUpHourlyAccesses(ctx, base.Add(48*time.Hour))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != 5 {
+		t.Errorf("5 hourly rollups should be rolled up but %d were", n)
+	}
+	check("daily", []*shortlink.AccessCount{
+		{Time: base, Count: 3},
+		{Time: base.Add(24 * time.Hour), Count: 1},
+		{Time: base.Add(49 * time.Hour), Count: 1},
+	}, 5, 1, 3, uniques)
+
+	n, err = db.PurgeDailyAccesses(ctx, base.Add(24*time.Hour))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != 4 {
+		t.Errorf("4 daily rollups should be purged but %d were", n)
+	}
+	check("purged", []*shortlink.AccessCount{
+		{Time: base.Add(24 * time.Hour), Count: 1},
+		{Time: base.Add(49 * time.Hour), Count: 1},
+	}, 2, 0, 1, uniques[1:])
+
+	mustDelete(t, db, sl.ID)
+	if err = db.PurgeShortLink(ctx, sl.ID); err != nil {
+		t.Fatal(err)
+	}
+	counts, err := db.GetAccessCounts(ctx, sl.ID, base, end, database.TrafficAll)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(counts) != 0 {
+		t.Errorf("rollups should be purged with short link but were %+v", counts)
+	}
+	counts, err = db.GetUniqueCounts(ctx, sl.ID, base, end)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(counts) != 0 {
+		t.Errorf("unique rollups should be purged with short link but were %+v", counts)
+	}
+}
+
+func testSummary(t *testing.T, db database.Middleware) {
+	a := mustCreate(t, db, "https://example.com/a", "a")
+	b := mustCreate(t, db, "https://example.com/b", "b")
+	c := mustCreate(t, db, "https://example.com/c", "c")
+	d := mustCreate(t, db, "https://example.com/d", "d")
+	e := mustCreate(t, db, "https://example.com/e", "e")
+
+	base := time.Now().UTC().Truncate(24 * time.Hour).Add(-72 * time.Hour)
+	access := func(sl *shortlink.ShortLink, offset time.Duration, bot bool) *shortlink.Access {
+		return &shortlink.Access{
+			ShortLinkID: sl.ID,
+			Time:        base.Add(offset),
+			Agent:       shortlink.AgentBrowser,
+			Bot:         bot,
+		}
+	}
+
+	err := db.AddAccesses(ctx, []*shortlink.Access{
+		access(a, time.Hour, false),
+		access(a, 2*time.Hour, false),
+		access(a, 3*time.Hour, false),
+		access(a, 4*time.Hour, true),
+		access(b, 5*time.Hour, false),
+		access(c, -48*time.Hour, false),
+		access(d, time.Hour, false),
+		access(d, 2*time.Hour, false),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	mustDelete(t, db, d.ID)
+
+	end := base.Add(24 * time.Hour)
+	check := func(stage string) {
+		t.Helper()
+
+		for traffic, exp := range map[database.Traffic]int{
+			database.TrafficHuman: 4,
+			database.TrafficBot:   1,
+			database.TrafficAll:   5,
+		} {
+			total, err := db.GetAccessTotal(ctx, base, end, traffic)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if total != exp {
+				t.Errorf("%s: should count %d %s accesses but counted %d", stage, exp, traffic, total)
+			}
+		}
+
+		top, err := db.GetTopShortLinks(ctx, base, end, database.TrafficHuman, 10)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(top) != 2 || top[0].ID != a.ID || top[0].Count != 3 || top[1].ID != b.ID || top[1].Count != 1 {
+			t.Errorf("%s: top short links should be a with 3 and b with 1 accesses but were %+v", stage, top)
+		} else if top[0].ShortLink.ShortLink != "a" || top[0].RootLink != a.RootLink {
+			t.Errorf("%s: top short link should be %+v but was %+v", stage, *a, top[0].ShortLink)
+		}
+
+		top, err = db.GetTopShortLinks(ctx, base, end, database.TrafficHuman, 1)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(top) != 1 || top[0].ID != a.ID {
+			t.Errorf("%s: limit 1 should return top short link but returned %+v", stage, top)
+		}
+
+		idle, total, err := db.GetIdleShortLinks(ctx, base, database.TrafficHuman, 10)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if total != 2 || len(idle) != 2 || idle[0].ID != c.ID || idle[1].ID != e.ID {
+			t.Errorf("%s: idle short links should be c and e but were %+v (total %d)", stage, idle, total)
+		}
+
+		idle, total, err = db.GetIdleShortLinks(ctx, base, database.TrafficBot, 1)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if total != 3 || len(idle) != 1 || idle[0].ID != b.ID {
+			t.Errorf("%s: limit 1 should return b of 3 idle short links but returned %+v (total %d)", stage, idle, total)
+		}
+	}
+
+	check("raw")
+
+	if _, err = db.RollUpAccesses(ctx, end); err != nil {
+		t.Fatal(err)
+	}
+	check("hourly")
+
+	if _, err = db.RollUpHourlyAccesses(ctx, end); err != nil {
+		t.Fatal(err)
+	}
+	check("daily")
+}
+
+func testCancelledContext(t *testing.T, db database.Middleware) {
+	sl := mustCreate(t, db, "https://example.com/a", "a")
+
+	cctx, cancel := context.WithCancel(ctx)
+	cancel()
+
+	if _, err := db.GetShortLinkCount(cctx); err == nil {
+		t.Error("GetShortLinkCount should fail with cancelled context")
+	}
+	if _, err := db.GetShortLink(cctx, "", "", sl.ShortLink); err == nil {
+		t.Error("GetShortLink should fail with cancelled context")
+	}
+	if _, err := db.GetShortLinks(cctx, 0, 10); err == nil {
+		t.Error("GetShortLinks should fail with cancelled context")
+	}
+	if _, _, err := db.QueryShortLinks(cctx, &database.Query{Limit: 10}); err == nil {
+		t.Error("QueryShortLinks should fail with cancelled context")
+	}
+	if err := db.UpdateShortLink(cctx, sl.ID, sl); err == nil {
+		t.Error("UpdateShortLink should fail with cancelled context")
+	}
+	if err := db.IncrementAccesses(cctx, sl.ID, 1); err == nil {
+		t.Error("IncrementAccesses should fail with cancelled context")
+	}
+	if err := db.IncrementUniques(cctx, sl.ID, 1); err == nil {
+		t.Error("IncrementUniques should fail with cancelled context")
+	}
+	if _, err := db.CreateShortLink(cctx, &shortlink.ShortLink{RootLink: "https://example.com/b", ShortLink: "b"}); err == nil {
+		t.Error("CreateShortLink should fail with cancelled context")
+	}
+	if err := db.DeleteShortLink(cctx, sl.ID); err == nil {
+		t.Error("DeleteShortLink should fail with cancelled context")
+	}
+	if _, err := db.GetTrashedShortLinks(cctx, 0, 10); err == nil {
+		t.Error("GetTrashedShortLinks should fail with cancelled context")
+	}
+	if _, err := db.GetTrashedShortLink(cctx, sl.ID); err == nil {
+		t.Error("GetTrashedShortLink should fail with cancelled context")
+	}
+	if err := db.RestoreShortLink(cctx, sl.ID); err == nil {
+		t.Error("RestoreShortLink should fail with cancelled context")
+	}
+	if err := db.PurgeShortLink(cctx, sl.ID); err == nil {
+		t.Error("PurgeShortLink should fail with cancelled context")
+	}
+	if _, err := db.PurgeTrash(cctx, 0); err == nil {
+		t.Error("PurgeTrash should fail with cancelled context")
+	}
+	if _, err := db.GetRevisions(cctx, sl.ID); err == nil {
+		t.Error("GetRevisions should fail with cancelled context")
+	}
+	if _, err := db.GetRevision(cctx, sl.ID, 1); err == nil {
+		t.Error("GetRevision should fail with cancelled context")
+	}
+	if err := db.AddAccesses(cctx, []*shortlink.Access{{ShortLinkID: sl.ID, Time: time.Now()}}); err == nil {
+		t.Error("AddAccesses should fail with cancelled context")
+	}
+	if _, err := db.GetAccessCounts(cctx, sl.ID, time.Time{}, time.Now(), database.TrafficHuman); err == nil {
+		t.Error("GetAccessCounts should fail with cancelled context")
+	}
+	if _, err := db.GetReferrerCounts(cctx, sl.ID, time.Time{}, time.Now(), database.TrafficHuman, 10); err == nil {
+		t.Error("GetReferrerCounts should fail with cancelled context")
+	}
+	if _, err := db.GetCampaignCounts(cctx, sl.ID, time.Time{}, time.Now(), database.TrafficHuman, 10); err == nil {
+		t.Error("GetCampaignCounts should fail with cancelled context")
+	}
+	if _, err := db.GetCountryCounts(cctx, sl.ID, time.Time{}, time.Now(), database.TrafficHuman, 10); err == nil {
+		t.Error("GetCountryCounts should fail with cancelled context")
+	}
+	if _, err := db.GetAccessTotal(cctx, time.Time{}, time.Now(), database.TrafficHuman); err == nil {
+		t.Error("GetAccessTotal should fail with cancelled context")
+	}
+	if _, err := db.GetTopShortLinks(cctx, time.Time{}, time.Now(), database.TrafficHuman, 10); err == nil {
+		t.Error("GetTopShortLinks should fail with cancelled context")
+	}
+	if _, _, err := db.GetIdleShortLinks(cctx, time.Time{}, database.TrafficHuman, 10); err == nil {
+		t.Error("GetIdleShortLinks should fail with cancelled context")
+	}
+	if _, err := db.GetUniqueCounts(cctx, sl.ID, time.Time{}, time.Now()); err == nil {
+		t.Error("GetUniqueCounts should fail with cancelled context")
+	}
+	if _, err := db.RollUpAccesses(cctx, time.Now()); err == nil {
+		t.Error("RollUpAccesses should fail with cancelled context")
+	}
+	if _, err := db.RollUpHourlyAccesses(cctx, time.Now()); err == nil {
+		t.Error("RollUpHourlyAccesses should fail with cancelled context")
+	}
+	if _, err := db.PurgeDailyAccesses(cctx, time.Now()); err == nil {
+		t.Error("PurgeDailyAccesses should fail with cancelled context")
+	}
+
+	if got := mustGet(t, db, idOf(sl), "", ""); got == nil || got.Accesses != 0 || got.Uniques != 0 {
+		t.Errorf("entry should be unchanged after cancelled calls but was %+v", got)
+	}
+}
+
+func testGetTrashedShortLinks(t *testing.T, db database.Middleware) {
+	a := mustCreate(t, db, "https://example.com/a", "a")
+	b := mustCreate(t, db, "https://example.com/b", "b")
+	mustCreate(t, db, "https://example.com/c", "c")
+
+	mustDelete(t, db, a.ID)
+	// Deletion dates have second precision
+	// in some database backends.
+	time.Sleep(1100 * time.Millisecond)
+	mustDelete(t, db, b.ID)
+
+	sls, err := db.GetTrashedShortLinks(ctx, 0, 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(sls) != 2 {
+		t.Fatalf("trash should contain 2 entries but contained %d", len(sls))
+	}
+	if sls[0].ID != b.ID || sls[1].ID != a.ID {
+		t.Errorf("trash should be ordered [%d, %d] but was [%d, %d]", b.ID, a.ID, sls[0].ID, sls[1].ID)
+	}
+	if sls[0].ShortLink.ShortLink != "b" || sls[0].RootLink != b.RootLink {
+		t.Errorf("trashed entry should equal %+v but was %+v", b, sls[0].ShortLink)
+	}
+	if !sls[0].Deleted.After(sls[1].Deleted) {
+		t.Errorf("deletion date %s should be after %s", sls[0].Deleted, sls[1].Deleted)
+	}
+
+	sls, err = db.GetTrashedShortLinks(ctx, 1, 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(sls) != 1 || sls[0].ID != a.ID {
+		t.Errorf("second page should only contain entry %d", a.ID)
+	}
+
+	got, err := db.GetTrashedShortLink(ctx, a.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got == nil || got.ID != a.ID || got.Deleted.IsZero() {
+		t.Errorf("trashed entry %d should be found with deletion date but was %+v", a.ID, got)
+	}
+}
+
+func testRestoreShortLink(t *testing.T, db database.Middleware) {
+	sl := mustCreate(t, db, "https://example.com/a", "a")
+	mustDelete(t, db, sl.ID)
+
+	if err := db.RestoreShortLink(ctx, sl.ID); err != nil {
+		t.Fatal(err)
+	}
+
+	if got := mustGet(t, db, "", "", "a"); got == nil || got.ID != sl.ID {
+		t.Errorf("restored entry %d should be found by short but was %+v", sl.ID, got)
+	}
+	if got, err := db.GetTrashedShortLink(ctx, sl.ID); err != nil || got != nil {
+		t.Errorf("restored entry should not be in trash but was %+v (%v)", got, err)
+	}
+	if n := mustCount(t, db); n != 1 {
+		t.Errorf("count should be 1 after restore but was %d", n)
+	}
+}
+
+func testPurgeShortLink(t *testing.T, db database.Middleware) {
+	sl := mustCreate(t, db, "https://example.com/a", "a")
+	active := mustCreate(t, db, "https://example.com/b", "b")
+	mustDelete(t, db, sl.ID)
+
+	if err := db.PurgeShortLink(ctx, sl.ID); err != nil {
+		t.Fatal(err)
+	}
+	if got, err := db.GetTrashedShortLink(ctx, sl.ID); err != nil || got != nil {
+		t.Errorf("purged entry should not be in trash but was %+v (%v)", got, err)
+	}
+
+	// Only deleted entries can be purged.
+	if err := db.PurgeShortLink(ctx, active.ID); err != nil {
+		t.Fatal(err)
+	}
+	if got := mustGet(t, db, idOf(active), "", ""); got == nil {
+		t.Error("active entry must not be purged")
+	}
+	if revs, err := db.GetRevisions(ctx, active.ID); err != nil || len(revs) == 0 {
+		t.Errorf("active entry should keep its revisions but had %d (%v)", len(revs), err)
+	}
+}
+
+func testPurgeTrash(t *testing.T, db database.Middleware) {
+	a := mustCreate(t, db, "https://example.com/a", "a")
+	b := mustCreate(t, db, "https://example.com/b", "b")
+	mustDelete(t, db, a.ID)
+	mustDelete(t, db, b.ID)
+
+	time.Sleep(1100 * time.Millisecond)
+
+	n, err := db.PurgeTrash(ctx, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != 0 {
+		t.Errorf("no entries should be purged with age of 1h but %d were", n)
+	}
+
+	n, err = db.PurgeTrash(ctx, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != 2 {
+		t.Errorf("2 entries should be purged but %d were", n)
+	}
+
+	sls, err := db.GetTrashedShortLinks(ctx, 0, 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(sls) != 0 {
+		t.Errorf("trash should be empty but contained %d entries", len(sls))
+	}
+}
+
+func testRevisions(t *testing.T, db database.Middleware) {
+	actx := database.WithActor(ctx, "tester")
+
+	sl, err := db.CreateShortLink(actx, &shortlink.ShortLink{RootLink: "https://example.com/a", ShortLink: "a"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	other := mustCreate(t, db, "https://example.com/other", "other")
+
+	updated := &shortlink.ShortLink{RootLink: "https://example.com/b", ShortLink: "a"}
+	if err = db.UpdateShortLink(actx, sl.ID, updated); err != nil {
+		t.Fatal(err)
+	}
+	if err = db.DeleteShortLink(actx, sl.ID); err != nil {
+		t.Fatal(err)
+	}
+	if err = db.RestoreShortLink(actx, sl.ID); err != nil {
+		t.Fatal(err)
+	}
+
+	revs, err := db.GetRevisions(ctx, sl.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	exp := []shortlink.Revision{
+		{Action: shortlink.ActionRestore, OldRootLink: "https://example.com/b", OldShortLink: "a",
+			NewRootLink: "https://example.com/b", NewShortLink: "a"},
+		{Action: shortlink.ActionDelete, OldRootLink: "https://example.com/b", OldShortLink: "a",
+			NewRootLink: "https://example.com/b", NewShortLink: "a"},
+		{Action: shortlink.ActionEdit, OldRootLink: "https://example.com/a", OldShortLink: "a",
+			NewRootLink: "https://example.com/b", NewShortLink: "a"},
+		{Action: shortlink.ActionCreate, NewRootLink: "https://example.com/a", NewShortLink: "a"},
+	}
+
+	if len(revs) != len(exp) {
+		t.Fatalf("short link should have %d revisions but had %d", len(exp), len(revs))
+	}
+	for i, r := range revs {
+		e := exp[i]
+		if r.ShortLinkID != sl.ID || r.Action != e.Action ||
+			r.OldRootLink != e.OldRootLink || r.OldShortLink != e.OldShortLink ||
+			r.NewRootLink != e.NewRootLink || r.NewShortLink != e.NewShortLink {
+			t.Errorf("revision %d should match %+v but was %+v", i, e, r)
+		}
+		if r.Actor != "tester" {
+			t.Errorf("revision %d should have actor 'tester' but had '%s'", i, r.Actor)
+		}
+		if r.Created.IsZero() {
+			t.Errorf("revision %d has no creation date", i)
+		}
+		if i > 0 && r.ID >= revs[i-1].ID {
+			t.Errorf("revisions should be ordered descending by ID")
+		}
+	}
+
+	got, err := db.GetRevision(ctx, sl.ID, revs[2].ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got == nil || got.ID != revs[2].ID || got.Action != shortlink.ActionEdit {
+		t.Errorf("revision %d should be found but was %+v", revs[2].ID, got)
+	}
+
+	if got, err = db.GetRevision(ctx, other.ID, revs[2].ID); err != nil || got != nil {
+		t.Errorf("revision of other short link should not be found but was %+v (%v)", got, err)
+	}
+
+	if revs, err = db.GetRevisions(ctx, other.ID); err != nil || len(revs) != 1 || revs[0].Actor != "" {
+		t.Errorf("other short link should have 1 revision without actor but had %+v (%v)", revs, err)
+	}
+}
+
+func testPurgeRevisions(t *testing.T, db database.Middleware) {
+	a := mustCreate(t, db, "https://example.com/a", "a")
+	b := mustCreate(t, db, "https://example.com/b", "b")
+	active := mustCreate(t, db, "https://example.com/c", "c")
+	mustDelete(t, db, a.ID)
+	mustDelete(t, db, b.ID)
+
+	if err := db.PurgeShortLink(ctx, a.ID); err != nil {
+		t.Fatal(err)
+	}
+	if revs, err := db.GetRevisions(ctx, a.ID); err != nil || len(revs) != 0 {
+		t.Errorf("purged short link should have no revisions but had %d (%v)", len(revs), err)
+	}
+
+	time.Sleep(1100 * time.Millisecond)
+
+	if _, err := db.PurgeTrash(ctx, 0); err != nil {
+		t.Fatal(err)
+	}
+	if revs, err := db.GetRevisions(ctx, b.ID); err != nil || len(revs) != 0 {
+		t.Errorf("purged short link should have no revisions but had %d (%v)", len(revs), err)
+	}
+	if revs, err := db.GetRevisions(ctx, active.ID); err != nil || len(revs) == 0 {
+		t.Errorf("active short link should keep its revisions but had %d (%v)", len(revs), err)
+	}
+}
@@ -0,0 +1,22 @@
+package database
+
+import "fmt"
+
+// ConflictError is returned by database middlewares
+// when a short link could not be created, updated or
+// restored because its short identifier is already
+// used by another non-deleted short link.
+type ConflictError struct {
+	ShortLink string
+}
+
+func (e *ConflictError) Error() string {
+	return fmt.Sprintf("the short identifyer '%s' is used by another short link", e.ShortLink)
+}
+
+// IsConflict returns true if err is a
+// *ConflictError.
+func IsConflict(err error) bool {
+	_, ok := err.(*ConflictError)
+	return ok
+}
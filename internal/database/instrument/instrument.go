@@ -0,0 +1,235 @@
+// Package instrument provides a database middleware
+// which records the duration of each call to another
+// database middleware.
+package instrument
+
+import (
+	"context"
+	"time"
+
+	"github.com/zekroTJA/slms/internal/database"
+	"github.com/zekroTJA/slms/internal/shortlink"
+	"github.com/zekroTJA/slms/pkg/metrics"
+)
+
+// Instrument wraps a database middleware and
+// observes the duration of each call in a
+// histogram labeled by the called method.
+type Instrument struct {
+	database.Middleware
+
+	h *metrics.Histogram
+}
+
+// New creates a new Instrument wrapping the passed,
+// already opened database middleware which registers
+// its query duration histogram in reg.
+func New(db database.Middleware, reg *metrics.Registry) *Instrument {
+	return &Instrument{
+		Middleware: db,
+		h: reg.Histogram("slms_database_query_duration_seconds",
+			"Duration of database queries in seconds.", nil, "method"),
+	}
+}
+
+// observe records the time elapsed since start
+// for the passed method.
+func (i *Instrument) observe(method string, start time.Time) {
+	i.h.Observe(time.Since(start).Seconds(), method)
+}
+
+// GetShortLinkCount calls GetShortLinkCount of the wrapped
+// database middleware and observes its duration.
+func (i *Instrument) GetShortLinkCount(ctx context.Context) (int, error) {
+	defer i.observe("GetShortLinkCount", time.Now())
+	return i.Middleware.GetShortLinkCount(ctx)
+}
+
+// GetShortLink calls GetShortLink of the wrapped
+// database middleware and observes its duration.
+func (i *Instrument) GetShortLink(ctx context.Context, id, root, short string) (*shortlink.ShortLink, error) {
+	defer i.observe("GetShortLink", time.Now())
+	return i.Middleware.GetShortLink(ctx, id, root, short)
+}
+
+// GetShortLinks calls GetShortLinks of the wrapped
+// database middleware and observes its duration.
+func (i *Instrument) GetShortLinks(ctx context.Context, from, limit int) ([]*shortlink.ShortLink, error) {
+	defer i.observe("GetShortLinks", time.Now())
+	return i.Middleware.GetShortLinks(ctx, from, limit)
+}
+
+// QueryShortLinks calls QueryShortLinks of the wrapped
+// database middleware and observes its duration.
+func (i *Instrument) QueryShortLinks(ctx context.Context, q *database.Query) ([]*shortlink.ShortLink, int, error) {
+	defer i.observe("QueryShortLinks", time.Now())
+	return i.Middleware.QueryShortLinks(ctx, q)
+}
+
+// UpdateShortLink calls UpdateShortLink of the wrapped
+// database middleware and observes its duration.
+func (i *Instrument) UpdateShortLink(ctx context.Context, id int, updated *shortlink.ShortLink) error {
+	defer i.observe("UpdateShortLink", time.Now())
+	return i.Middleware.UpdateShortLink(ctx, id, updated)
+}
+
+// IncrementAccesses calls IncrementAccesses of the wrapped
+// database middleware and observes its duration.
+func (i *Instrument) IncrementAccesses(ctx context.Context, id, n int) error {
+	defer i.observe("IncrementAccesses", time.Now())
+	return i.Middleware.IncrementAccesses(ctx, id, n)
+}
+
+// IncrementUniques calls IncrementUniques of the wrapped
+// database middleware and observes its duration.
+func (i *Instrument) IncrementUniques(ctx context.Context, id, n int) error {
+	defer i.observe("IncrementUniques", time.Now())
+	return i.Middleware.IncrementUniques(ctx, id, n)
+}
+
+// CreateShortLink calls CreateShortLink of the wrapped
+// database middleware and observes its duration.
+func (i *Instrument) CreateShortLink(ctx context.Context, sl *shortlink.ShortLink) (*shortlink.ShortLink, error) {
+	defer i.observe("CreateShortLink", time.Now())
+	return i.Middleware.CreateShortLink(ctx, sl)
+}
+
+// DeleteShortLink calls DeleteShortLink of the wrapped
+// database middleware and observes its duration.
+func (i *Instrument) DeleteShortLink(ctx context.Context, id int) error {
+	defer i.observe("DeleteShortLink", time.Now())
+	return i.Middleware.DeleteShortLink(ctx, id)
+}
+
+// GetTrashedShortLinks calls GetTrashedShortLinks of the wrapped
+// database middleware and observes its duration.
+func (i *Instrument) GetTrashedShortLinks(ctx context.Context, from, limit int) ([]*shortlink.Trashed, error) {
+	defer i.observe("GetTrashedShortLinks", time.Now())
+	return i.Middleware.GetTrashedShortLinks(ctx, from, limit)
+}
+
+// GetTrashedShortLink calls GetTrashedShortLink of the wrapped
+// database middleware and observes its duration.
+func (i *Instrument) GetTrashedShortLink(ctx context.Context, id int) (*shortlink.Trashed, error) {
+	defer i.observe("GetTrashedShortLink", time.Now())
+	return i.Middleware.GetTrashedShortLink(ctx, id)
+}
+
+// RestoreShortLink calls RestoreShortLink of the wrapped
+// database middleware and observes its duration.
+func (i *Instrument) RestoreShortLink(ctx context.Context, id int) error {
+	defer i.observe("RestoreShortLink", time.Now())
+	return i.Middleware.RestoreShortLink(ctx, id)
+}
+
+// PurgeShortLink calls PurgeShortLink of the wrapped
+// database middleware and observes its duration.
+func (i *Instrument) PurgeShortLink(ctx context.Context, id int) error {
+	defer i.observe("PurgeShortLink", time.Now())
+	return i.Middleware.PurgeShortLink(ctx, id)
+}
+
+// PurgeTrash calls PurgeTrash of the wrapped
+// database middleware and observes its duration.
+func (i *Instrument) PurgeTrash(ctx context.Context, age time.Duration) (int, error) {
+	defer i.observe("PurgeTrash", time.Now())
+	return i.Middleware.PurgeTrash(ctx, age)
+}
+
+// GetRevisions calls GetRevisions of the wrapped
+// database middleware and observes its duration.
+func (i *Instrument) GetRevisions(ctx context.Context, id int) ([]*shortlink.Revision, error) {
+	defer i.observe("GetRevisions", time.Now())
+	return i.Middleware.GetRevisions(ctx, id)
+}
+
+// GetRevision calls GetRevision of the wrapped
+// database middleware and observes its duration.
+func (i *Instrument) GetRevision(ctx context.Context, id, rev int) (*shortlink.Revision, error) {
+	defer i.observe("GetRevision", time.Now())
+	return i.Middleware.GetRevision(ctx, id, rev)
+}
+
+// AddAccesses calls AddAccesses of the wrapped
+// database middleware and observes its duration.
+func (i *Instrument) AddAccesses(ctx context.Context, accesses []*shortlink.Access) error {
+	defer i.observe("AddAccesses", time.Now())
+	return i.Middleware.AddAccesses(ctx, accesses)
+}
+
+// GetAccessCounts calls GetAccessCounts of the wrapped
+// database middleware and observes its duration.
+func (i *Instrument) GetAccessCounts(ctx context.Context, id int, from, to time.Time, traffic database.Traffic) ([]*shortlink.AccessCount, error) {
+	defer i.observe("GetAccessCounts", time.Now())
+	return i.Middleware.GetAccessCounts(ctx, id, from, to, traffic)
+}
+
+// GetReferrerCounts calls GetReferrerCounts of the wrapped
+// database middleware and observes its duration.
+func (i *Instrument) GetReferrerCounts(ctx context.Context, id int, from, to time.Time, traffic database.Traffic, limit int) ([]*shortlink.ReferrerCount, error) {
+	defer i.observe("GetReferrerCounts", time.Now())
+	return i.Middleware.GetReferrerCounts(ctx, id, from, to, traffic, limit)
+}
+
+// GetCampaignCounts calls GetCampaignCounts of the wrapped
+// database middleware and observes its duration.
+func (i *Instrument) GetCampaignCounts(ctx context.Context, id int, from, to time.Time, traffic database.Traffic, limit int) ([]*shortlink.CampaignCount, error) {
+	defer i.observe("GetCampaignCounts", time.Now())
+	return i.Middleware.GetCampaignCounts(ctx, id, from, to, traffic, limit)
+}
+
+// GetCountryCounts calls GetCountryCounts of the wrapped
+// database middleware and observes its duration.
+func (i *Instrument) GetCountryCounts(ctx context.Context, id int, from, to time.Time, traffic database.Traffic, limit int) ([]*shortlink.CountryCount, error) {
+	defer i.observe("GetCountryCounts", time.Now())
+	return i.Middleware.GetCountryCounts(ctx, id, from, to, traffic, limit)
+}
+
+// GetUniqueCounts calls GetUniqueCounts of the wrapped
+// database middleware and observes its duration.
+func (i *Instrument) GetUniqueCounts(ctx context.Context, id int, from, to time.Time) ([]*shortlink.AccessCount, error) {
+	defer i.observe("GetUniqueCounts", time.Now())
+	return i.Middleware.GetUniqueCounts(ctx, id, from, to)
+}
+
+// GetAccessTotal calls GetAccessTotal of the wrapped
+// database middleware and observes its duration.
+func (i *Instrument) GetAccessTotal(ctx context.Context, from, to time.Time, traffic database.Traffic) (int, error) {
+	defer i.observe("GetAccessTotal", time.Now())
+	return i.Middleware.GetAccessTotal(ctx, from, to, traffic)
+}
+
+// GetTopShortLinks calls GetTopShortLinks of the wrapped
+// database middleware and observes its duration.
+func (i *Instrument) GetTopShortLinks(ctx context.Context, from, to time.Time, traffic database.Traffic, limit int) ([]*shortlink.ShortLinkCount, error) {
+	defer i.observe("GetTopShortLinks", time.Now())
+	return i.Middleware.GetTopShortLinks(ctx, from, to, traffic, limit)
+}
+
+// GetIdleShortLinks calls GetIdleShortLinks of the wrapped
+// database middleware and observes its duration.
+func (i *Instrument) GetIdleShortLinks(ctx context.Context, since time.Time, traffic database.Traffic, limit int) ([]*shortlink.ShortLink, int, error) {
+	defer i.observe("GetIdleShortLinks", time.Now())
+	return i.Middleware.GetIdleShortLinks(ctx, since, traffic, limit)
+}
+
+// RollUpAccesses calls RollUpAccesses of the wrapped
+// database middleware and observes its duration.
+func (i *Instrument) RollUpAccesses(ctx context.Context, before time.Time) (int, error) {
+	defer i.observe("RollUpAccesses", time.Now())
+	return i.Middleware.RollUpAccesses(ctx, before)
+}
+
+// RollUpHourlyAccesses calls RollUpHourlyAccesses of the wrapped
+// database middleware and observes its duration.
+func (i *Instrument) RollUpHourlyAccesses(ctx context.Context, before time.Time) (int, error) {
+	defer i.observe("RollUpHourlyAccesses", time.Now())
+	return i.Middleware.RollUpHourlyAccesses(ctx, before)
+}
+
+// PurgeDailyAccesses calls PurgeDailyAccesses of the wrapped
+// database middleware and observes its duration.
+func (i *Instrument) PurgeDailyAccesses(ctx context.Context, before time.Time) (int, error) {
+	defer i.observe("PurgeDailyAccesses", time.Now())
+	return i.Middleware.PurgeDailyAccesses(ctx, before)
+}
@@ -0,0 +1,1394 @@
+package memory
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"net/url"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/zekroTJA/slms/internal/database"
+	"github.com/zekroTJA/slms/internal/logger"
+	"github.com/zekroTJA/slms/internal/shortlink"
+)
+
+// Memory is a thread safe database middleware
+// which holds all short links in memory.
+// Optionally, the state can be saved to a JSON
+// snapshot file on close which will be loaded
+// again on next open.
+type Memory struct {
+	mtx       sync.RWMutex
+	cfg       *Config
+	lastID    int
+	entries   map[int]*entry
+	lastRevID int
+	revisions []*shortlink.Revision
+	accesses  []*shortlink.Access
+	rollups   []*rollup
+	uniques   []*uniqueRollup
+}
+
+// Config contains the configuration
+// for the in-memory database.
+type Config struct {
+	SnapshotFile string `json:"snapshot_file"`
+}
+
+// entry wraps a short link with its
+// soft deletion state and date.
+type entry struct {
+	shortlink.ShortLink
+	Deleted   bool      `json:"deleted"`
+	DeletedAt time.Time `json:"deleted_at"`
+}
+
+// rollupKey contains the properties by which
+// rolled up accesses are aggregated. Rollups are
+// dated to the start of their hour or day.
+type rollupKey struct {
+	ShortLinkID int       `json:"short_link_id"`
+	Daily       bool      `json:"daily"`
+	Time        time.Time `json:"time"`
+	Bot         bool      `json:"bot"`
+	Referrer    string    `json:"referrer"`
+	Country     string    `json:"country"`
+	Source      string    `json:"utm_source"`
+	Medium      string    `json:"utm_medium"`
+	Name        string    `json:"utm_campaign"`
+}
+
+// rollup is the number of accesses
+// aggregated by its key.
+type rollup struct {
+	rollupKey
+	Count int `json:"count"`
+}
+
+// uniqueRollup is the number of unique visitors
+// of a short link on the day starting at Time.
+type uniqueRollup struct {
+	ShortLinkID int       `json:"short_link_id"`
+	Time        time.Time `json:"time"`
+	Count       int       `json:"count"`
+}
+
+// snapshot is the structure of the
+// JSON snapshot file.
+type snapshot struct {
+	LastID         int                   `json:"last_id"`
+	Entries        []*entry              `json:"entries"`
+	LastRevisionID int                   `json:"last_revision_id"`
+	Revisions      []*shortlink.Revision `json:"revisions"`
+	Accesses       []*shortlink.Access   `json:"accesses"`
+	Rollups        []*rollup             `json:"rollups"`
+	UniqueRollups  []*uniqueRollup       `json:"unique_rollups"`
+}
+
+// Open initializes the in-memory storage and
+// loads the snapshot file, if specified and
+// existent.
+func (m *Memory) Open(cfg interface{}) error {
+	conf, ok := cfg.(*Config)
+	if !ok {
+		return errors.New("cfg is not type of memory.Config")
+	}
+
+	m.mtx.Lock()
+	defer m.mtx.Unlock()
+
+	m.cfg = conf
+	m.lastID = 0
+	m.entries = make(map[int]*entry)
+	m.lastRevID = 0
+	m.revisions = make([]*shortlink.Revision, 0)
+	m.accesses = make([]*shortlink.Access, 0)
+	m.rollups = make([]*rollup, 0)
+	m.uniques = make([]*uniqueRollup, 0)
+
+	if conf.SnapshotFile == "" {
+		return nil
+	}
+
+	return m.loadSnapshot()
+}
+
+// Close writes the current state to the
+// snapshot file, if specified.
+func (m *Memory) Close() {
+	m.mtx.RLock()
+	defer m.mtx.RUnlock()
+
+	if m.cfg == nil || m.cfg.SnapshotFile == "" {
+		return
+	}
+
+	if err := m.saveSnapshot(); err != nil {
+		logger.Error("DATABASE :: failed writing snapshot: %s", err.Error())
+	}
+}
+
+// loadSnapshot reads the snapshot file and
+// restores the entries from it. If the file
+// does not exist, this is a no-op.
+func (m *Memory) loadSnapshot() error {
+	data, err := ioutil.ReadFile(m.cfg.SnapshotFile)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	snap := new(snapshot)
+	if err = json.Unmarshal(data, snap); err != nil {
+		return err
+	}
+
+	m.lastID = snap.LastID
+	for _, e := range snap.Entries {
+		// Short links deleted before deletion dates
+		// were recorded are dated to their last
+		// modification.
+		if e.Deleted && e.DeletedAt.IsZero() {
+			e.DeletedAt = e.Edited
+		}
+		m.entries[e.ID] = e
+		if e.ID > m.lastID {
+			m.lastID = e.ID
+		}
+	}
+
+	m.lastRevID = snap.LastRevisionID
+	for _, r := range snap.Revisions {
+		m.revisions = append(m.revisions, r)
+		if r.ID > m.lastRevID {
+			m.lastRevID = r.ID
+		}
+	}
+
+	for _, a := range snap.Accesses {
+		// Accesses recorded before bots were
+		// flagged are flagged by their agent.
+		if a.Agent == shortlink.AgentBot {
+			a.Bot = true
+		}
+		m.accesses = append(m.accesses, a)
+	}
+
+	m.rollups = append(m.rollups, snap.Rollups...)
+	m.uniques = append(m.uniques, snap.UniqueRollups...)
+
+	return nil
+}
+
+// saveSnapshot writes all entries to
+// the snapshot file.
+func (m *Memory) saveSnapshot() error {
+	snap := &snapshot{
+		LastID:         m.lastID,
+		Entries:        make([]*entry, 0, len(m.entries)),
+		LastRevisionID: m.lastRevID,
+		Revisions:      m.revisions,
+		Accesses:       m.accesses,
+		Rollups:        m.rollups,
+		UniqueRollups:  m.uniques,
+	}
+	for _, e := range m.entries {
+		snap.Entries = append(snap.Entries, e)
+	}
+	sort.Slice(snap.Entries, func(i, j int) bool {
+		return snap.Entries[i].ID < snap.Entries[j].ID
+	})
+
+	data, err := json.MarshalIndent(snap, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	return ioutil.WriteFile(m.cfg.SnapshotFile, data, 0600)
+}
+
+// GetShortLinkCount returns the number of short
+// link entries in the database.
+func (m *Memory) GetShortLinkCount(ctx context.Context) (int, error) {
+	if err := ctx.Err(); err != nil {
+		return 0, err
+	}
+
+	m.mtx.RLock()
+	defer m.mtx.RUnlock()
+
+	var i int
+	for _, e := range m.entries {
+		if !e.Deleted {
+			i++
+		}
+	}
+
+	return i, nil
+}
+
+// GetShortLink gets a short link object by id, root
+// link or short link, depending on which was passed
+// first (in this order).
+// If no short link was found, no error will be returned and
+// the returned short link object will be nil.
+func (m *Memory) GetShortLink(ctx context.Context, id, root, short string) (*shortlink.ShortLink, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	m.mtx.RLock()
+	defer m.mtx.RUnlock()
+
+	switch {
+	case id != "":
+		iid, err := strconv.Atoi(id)
+		if err != nil {
+			return nil, nil
+		}
+		if e, ok := m.entries[iid]; ok && !e.Deleted {
+			return copyOf(e), nil
+		}
+	case root != "":
+		// Root links are not unique, so the newest
+		// short link is returned like by the other
+		// database middlewares.
+		for _, e := range m.sorted() {
+			if e.RootLink == root {
+				return copyOf(e), nil
+			}
+		}
+	case short != "":
+		// Short identifiers of active short links are
+		// unique, so the entries need not be sorted
+		// on the lookups of each redirect.
+		for _, e := range m.entries {
+			if !e.Deleted && e.ShortLink.ShortLink == short {
+				return copyOf(e), nil
+			}
+		}
+	}
+
+	return nil, nil
+}
+
+// GetShortLinks returns a list of short links which
+// is ordered by created date descending between
+// from index and limit ammount.
+func (m *Memory) GetShortLinks(ctx context.Context, from, limit int) ([]*shortlink.ShortLink, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	m.mtx.RLock()
+	defer m.mtx.RUnlock()
+
+	entries := m.sorted()
+
+	if from >= len(entries) {
+		return make([]*shortlink.ShortLink, 0), nil
+	}
+	entries = entries[from:]
+	if limit < len(entries) {
+		entries = entries[:limit]
+	}
+
+	sls := make([]*shortlink.ShortLink, len(entries))
+	for i, e := range entries {
+		sls[i] = copyOf(e)
+	}
+
+	return sls, nil
+}
+
+// QueryShortLinks returns the list of short links
+// described by the passed query and the total
+// number of short links matching its filters.
+func (m *Memory) QueryShortLinks(ctx context.Context, q *database.Query) ([]*shortlink.ShortLink, int, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, 0, err
+	}
+
+	m.mtx.RLock()
+	defer m.mtx.RUnlock()
+
+	search := strings.ToLower(q.Search)
+	entries := make([]*entry, 0, len(m.entries))
+	for _, e := range m.entries {
+		if e.Deleted ||
+			search != "" &&
+				!strings.Contains(strings.ToLower(e.ShortLink.ShortLink), search) &&
+				!strings.Contains(strings.ToLower(e.RootLink), search) ||
+			q.Domain != "" && !hasDomain(e.RootLink, q.Domain) ||
+			!inRange(e.Created, q.CreatedFrom, q.CreatedTo) ||
+			!inRange(e.Edited, q.EditedFrom, q.EditedTo) ||
+			e.Accesses < q.MinAccesses {
+			continue
+		}
+		entries = append(entries, e)
+	}
+
+	total := len(entries)
+	field, desc := q.Sort, q.Descending
+
+	if c := q.Cursor; c != nil {
+		field = database.SortCreated
+		if c.Backward {
+			desc = !desc
+		}
+		behind := entries[:0]
+		for _, e := range entries {
+			after := e.Created.After(c.Created) ||
+				e.Created.Equal(c.Created) && e.ID > c.ID
+			before := e.Created.Before(c.Created) ||
+				e.Created.Equal(c.Created) && e.ID < c.ID
+			if desc && before || !desc && after {
+				behind = append(behind, e)
+			}
+		}
+		entries = behind
+	}
+
+	less := lessFunc(field)
+	sort.Slice(entries, func(i, j int) bool {
+		a, b := entries[i], entries[j]
+		if desc {
+			a, b = b, a
+		}
+		if less(a, b) {
+			return true
+		}
+		if less(b, a) {
+			return false
+		}
+		return a.ID < b.ID
+	})
+
+	if q.From >= len(entries) {
+		return make([]*shortlink.ShortLink, 0), total, nil
+	}
+	entries = entries[q.From:]
+	if q.Limit < len(entries) {
+		entries = entries[:q.Limit]
+	}
+
+	if q.Cursor != nil && q.Cursor.Backward {
+		for i, j := 0, len(entries)-1; i < j; i, j = i+1, j-1 {
+			entries[i], entries[j] = entries[j], entries[i]
+		}
+	}
+
+	sls := make([]*shortlink.ShortLink, len(entries))
+	for i, e := range entries {
+		sls[i] = copyOf(e)
+	}
+
+	return sls, total, nil
+}
+
+// UpdateShortLink updates the root and short
+// link and the settings of a short link by the
+// values contained in updated.
+func (m *Memory) UpdateShortLink(ctx context.Context, id int, updated *shortlink.ShortLink) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	m.mtx.Lock()
+	defer m.mtx.Unlock()
+
+	e, ok := m.entries[id]
+	if !ok || e.Deleted {
+		return nil
+	}
+
+	if m.isUsed(updated.ShortLink, id) {
+		return &database.ConflictError{ShortLink: updated.ShortLink}
+	}
+
+	old := e.ShortLink
+
+	e.ShortLink.ShortLink = updated.ShortLink
+	e.RootLink = updated.RootLink
+	e.ExpiresAt = copyTime(updated.ExpiresAt)
+	e.MaxAccesses = updated.MaxAccesses
+	e.FallbackURL = updated.FallbackURL
+	e.RedirectType = updated.RedirectType
+	e.PasswordHash = updated.PasswordHash
+	e.Edited = now()
+
+	m.addRevision(ctx, shortlink.ActionEdit, &old, &e.ShortLink)
+
+	return nil
+}
+
+// IncrementAccesses atomically increases the
+// access count of a short link by n.
+func (m *Memory) IncrementAccesses(ctx context.Context, id, n int) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	m.mtx.Lock()
+	defer m.mtx.Unlock()
+
+	if e, ok := m.entries[id]; ok {
+		e.Accesses += n
+	}
+
+	return nil
+}
+
+// IncrementUniques atomically increases the
+// unique visitor count of a short link by n.
+func (m *Memory) IncrementUniques(ctx context.Context, id, n int) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	m.mtx.Lock()
+	defer m.mtx.Unlock()
+
+	if e, ok := m.entries[id]; ok {
+		e.Uniques += n
+	}
+
+	return nil
+}
+
+// CreateShortLink creates a new shortlink
+// entry and returnes the new shortlink
+// object whis was created.
+func (m *Memory) CreateShortLink(ctx context.Context, sl *shortlink.ShortLink) (*shortlink.ShortLink, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	m.mtx.Lock()
+	defer m.mtx.Unlock()
+
+	if m.isUsed(sl.ShortLink, 0) {
+		return nil, &database.ConflictError{ShortLink: sl.ShortLink}
+	}
+
+	m.lastID++
+	t := now()
+
+	e := &entry{
+		ShortLink: shortlink.ShortLink{
+			ID:           m.lastID,
+			RootLink:     sl.RootLink,
+			ShortLink:    sl.ShortLink,
+			Created:      t,
+			Edited:       t,
+			ExpiresAt:    copyTime(sl.ExpiresAt),
+			MaxAccesses:  sl.MaxAccesses,
+			FallbackURL:  sl.FallbackURL,
+			RedirectType: sl.RedirectType,
+			PasswordHash: sl.PasswordHash,
+		},
+	}
+	m.entries[e.ID] = e
+
+	m.addRevision(ctx, shortlink.ActionCreate, &shortlink.ShortLink{ID: e.ID}, &e.ShortLink)
+
+	return copyOf(e), nil
+}
+
+// DeleteShortLink marks a short link as
+// deleted so that it will not be returned
+// by any getter anymore.
+func (m *Memory) DeleteShortLink(ctx context.Context, id int) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	m.mtx.Lock()
+	defer m.mtx.Unlock()
+
+	if e, ok := m.entries[id]; ok && !e.Deleted {
+		e.Deleted = true
+		e.DeletedAt = now()
+		m.addRevision(ctx, shortlink.ActionDelete, &e.ShortLink, &e.ShortLink)
+	}
+
+	return nil
+}
+
+// GetTrashedShortLinks returns a list of deleted
+// short links which is ordered by deletion date
+// descending between from index and limit ammount.
+func (m *Memory) GetTrashedShortLinks(ctx context.Context, from, limit int) ([]*shortlink.Trashed, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	m.mtx.RLock()
+	defer m.mtx.RUnlock()
+
+	entries := m.trashed()
+
+	if from >= len(entries) {
+		return make([]*shortlink.Trashed, 0), nil
+	}
+	entries = entries[from:]
+	if limit < len(entries) {
+		entries = entries[:limit]
+	}
+
+	sls := make([]*shortlink.Trashed, len(entries))
+	for i, e := range entries {
+		sls[i] = trashedCopyOf(e)
+	}
+
+	return sls, nil
+}
+
+// GetTrashedShortLink returns the deleted short
+// link with the passed ID. If no deleted short
+// link was found, nil is returned.
+func (m *Memory) GetTrashedShortLink(ctx context.Context, id int) (*shortlink.Trashed, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	m.mtx.RLock()
+	defer m.mtx.RUnlock()
+
+	if e, ok := m.entries[id]; ok && e.Deleted {
+		return trashedCopyOf(e), nil
+	}
+
+	return nil, nil
+}
+
+// RestoreShortLink makes the deleted short
+// link with the passed ID available again.
+func (m *Memory) RestoreShortLink(ctx context.Context, id int) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	m.mtx.Lock()
+	defer m.mtx.Unlock()
+
+	e, ok := m.entries[id]
+	if !ok || !e.Deleted {
+		return nil
+	}
+
+	if m.isUsed(e.ShortLink.ShortLink, id) {
+		return &database.ConflictError{ShortLink: e.ShortLink.ShortLink}
+	}
+
+	e.Deleted = false
+	e.DeletedAt = time.Time{}
+	m.addRevision(ctx, shortlink.ActionRestore, &e.ShortLink, &e.ShortLink)
+
+	return nil
+}
+
+// PurgeShortLink permanently removes the deleted
+// short link with the passed ID, its revisions
+// and its accesses.
+func (m *Memory) PurgeShortLink(ctx context.Context, id int) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	m.mtx.Lock()
+	defer m.mtx.Unlock()
+
+	if e, ok := m.entries[id]; ok && e.Deleted {
+		delete(m.entries, id)
+		m.purgeData(map[int]struct{}{id: {}})
+	}
+
+	return nil
+}
+
+// PurgeTrash permanently removes all short links
+// which were deleted longer than age ago, their
+// revisions and their accesses and returns the
+// number of removed short links.
+func (m *Memory) PurgeTrash(ctx context.Context, age time.Duration) (int, error) {
+	if err := ctx.Err(); err != nil {
+		return 0, err
+	}
+
+	m.mtx.Lock()
+	defer m.mtx.Unlock()
+
+	before := now().Add(-age)
+
+	ids := make(map[int]struct{})
+	for id, e := range m.entries {
+		if e.Deleted && e.DeletedAt.Before(before) {
+			delete(m.entries, id)
+			ids[id] = struct{}{}
+		}
+	}
+
+	if len(ids) > 0 {
+		m.purgeData(ids)
+	}
+
+	return len(ids), nil
+}
+
+// sorted returns all non-deleted entries ordered
+// by created date descending. Entries with equal
+// created dates are ordered by ID descending.
+// The caller must hold at least a read lock.
+func (m *Memory) sorted() []*entry {
+	entries := make([]*entry, 0, len(m.entries))
+	for _, e := range m.entries {
+		if !e.Deleted {
+			entries = append(entries, e)
+		}
+	}
+
+	sort.Slice(entries, func(i, j int) bool {
+		if entries[i].Created.Equal(entries[j].Created) {
+			return entries[i].ID > entries[j].ID
+		}
+		return entries[i].Created.After(entries[j].Created)
+	})
+
+	return entries
+}
+
+// GetRevisions returns all revisions of the short
+// link with the passed ID ordered descending.
+func (m *Memory) GetRevisions(ctx context.Context, id int) ([]*shortlink.Revision, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	m.mtx.RLock()
+	defer m.mtx.RUnlock()
+
+	revs := make([]*shortlink.Revision, 0)
+	for i := len(m.revisions) - 1; i >= 0; i-- {
+		if r := m.revisions[i]; r.ShortLinkID == id {
+			rc := *r
+			revs = append(revs, &rc)
+		}
+	}
+
+	return revs, nil
+}
+
+// GetRevision returns the revision with the ID rev
+// of the short link with the passed ID. If no such
+// revision was found, nil is returned.
+func (m *Memory) GetRevision(ctx context.Context, id, rev int) (*shortlink.Revision, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	m.mtx.RLock()
+	defer m.mtx.RUnlock()
+
+	for _, r := range m.revisions {
+		if r.ID == rev && r.ShortLinkID == id {
+			rc := *r
+			return &rc, nil
+		}
+	}
+
+	return nil, nil
+}
+
+// addRevision records a revision of the passed
+// action changing the short link from before to
+// after. The actor is taken from ctx.
+// The caller must hold the write lock.
+func (m *Memory) addRevision(ctx context.Context, action string, before, after *shortlink.ShortLink) {
+	m.lastRevID++
+	m.revisions = append(m.revisions, &shortlink.Revision{
+		ID:           m.lastRevID,
+		ShortLinkID:  before.ID,
+		Action:       action,
+		OldRootLink:  before.RootLink,
+		OldShortLink: before.ShortLink,
+		NewRootLink:  after.RootLink,
+		NewShortLink: after.ShortLink,
+		Actor:        database.Actor(ctx),
+		Created:      now(),
+	})
+}
+
+// purgeData removes all revisions, accesses and
+// rollups of the short links with the passed IDs.
+// The caller must hold the write lock.
+func (m *Memory) purgeData(ids map[int]struct{}) {
+	purged := func(id int) bool {
+		_, ok := ids[id]
+		return ok
+	}
+
+	revs := m.revisions[:0]
+	for _, r := range m.revisions {
+		if !purged(r.ShortLinkID) {
+			revs = append(revs, r)
+		}
+	}
+	m.revisions = revs
+
+	accesses := m.accesses[:0]
+	for _, a := range m.accesses {
+		if !purged(a.ShortLinkID) {
+			accesses = append(accesses, a)
+		}
+	}
+	m.accesses = accesses
+
+	rollups := m.rollups[:0]
+	for _, r := range m.rollups {
+		if !purged(r.ShortLinkID) {
+			rollups = append(rollups, r)
+		}
+	}
+	m.rollups = rollups
+
+	uniques := m.uniques[:0]
+	for _, u := range m.uniques {
+		if !purged(u.ShortLinkID) {
+			uniques = append(uniques, u)
+		}
+	}
+	m.uniques = uniques
+}
+
+// AddAccesses records the passed accesses
+// of short links.
+func (m *Memory) AddAccesses(ctx context.Context, accesses []*shortlink.Access) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	m.mtx.Lock()
+	defer m.mtx.Unlock()
+
+	for _, a := range accesses {
+		c := *a
+		c.Time = c.Time.UTC().Truncate(time.Second)
+		m.accesses = append(m.accesses, &c)
+	}
+
+	return nil
+}
+
+// GetAccessCounts returns the hourly counts of
+// the accesses of the short link with the passed
+// ID selected by traffic in the range [from, to)
+// ordered ascending. Hours without accesses are
+// omitted.
+func (m *Memory) GetAccessCounts(ctx context.Context, id int, from, to time.Time, traffic database.Traffic) ([]*shortlink.AccessCount, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	m.mtx.RLock()
+	defer m.mtx.RUnlock()
+
+	hours := make(map[time.Time]*shortlink.AccessCount)
+	counts := make([]*shortlink.AccessCount, 0)
+	m.eachCount(id, from, to, traffic, func(r *rollup) {
+		hour := r.Time.Truncate(time.Hour)
+		c, ok := hours[hour]
+		if !ok {
+			c = &shortlink.AccessCount{Time: hour}
+			hours[hour] = c
+			counts = append(counts, c)
+		}
+		c.Count += r.Count
+	})
+
+	sort.Slice(counts, func(i, j int) bool {
+		return counts[i].Time.Before(counts[j].Time)
+	})
+
+	return counts, nil
+}
+
+// GetReferrerCounts returns the counts of the
+// accesses of the short link with the passed ID
+// selected by traffic in the range [from, to)
+// per referrer host. The limit referrers
+// with the most accesses are returned ordered by
+// count descending and referrer ascending.
+func (m *Memory) GetReferrerCounts(ctx context.Context, id int, from, to time.Time, traffic database.Traffic, limit int) ([]*shortlink.ReferrerCount, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	m.mtx.RLock()
+	defer m.mtx.RUnlock()
+
+	index := make(map[string]*shortlink.ReferrerCount)
+	counts := make([]*shortlink.ReferrerCount, 0)
+	m.eachCount(id, from, to, traffic, func(r *rollup) {
+		c, ok := index[r.Referrer]
+		if !ok {
+			c = &shortlink.ReferrerCount{Referrer: r.Referrer}
+			index[r.Referrer] = c
+			counts = append(counts, c)
+		}
+		c.Count += r.Count
+	})
+
+	sort.Slice(counts, func(i, j int) bool {
+		if counts[i].Count != counts[j].Count {
+			return counts[i].Count > counts[j].Count
+		}
+		return counts[i].Referrer < counts[j].Referrer
+	})
+
+	if limit < len(counts) {
+		counts = counts[:limit]
+	}
+
+	return counts, nil
+}
+
+// GetCampaignCounts returns the counts of the
+// accesses of the short link with the passed ID
+// selected by traffic in the range [from, to) per
+// UTM source, medium and campaign.
+// Accesses without these parameters are not counted.
+// The limit campaigns with the most accesses are
+// returned ordered by count descending and source,
+// medium and campaign ascending.
+func (m *Memory) GetCampaignCounts(ctx context.Context, id int, from, to time.Time, traffic database.Traffic, limit int) ([]*shortlink.CampaignCount, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	m.mtx.RLock()
+	defer m.mtx.RUnlock()
+
+	index := make(map[shortlink.CampaignCount]*shortlink.CampaignCount)
+	counts := make([]*shortlink.CampaignCount, 0)
+	m.eachCount(id, from, to, traffic, func(r *rollup) {
+		if r.Source == "" && r.Medium == "" && r.Name == "" {
+			return
+		}
+		key := shortlink.CampaignCount{Source: r.Source, Medium: r.Medium, Name: r.Name}
+		c, ok := index[key]
+		if !ok {
+			c = &key
+			index[key] = c
+			counts = append(counts, c)
+		}
+		c.Count += r.Count
+	})
+
+	sort.Slice(counts, func(i, j int) bool {
+		a, b := counts[i], counts[j]
+		switch {
+		case a.Count != b.Count:
+			return a.Count > b.Count
+		case a.Source != b.Source:
+			return a.Source < b.Source
+		case a.Medium != b.Medium:
+			return a.Medium < b.Medium
+		default:
+			return a.Name < b.Name
+		}
+	})
+
+	if limit < len(counts) {
+		counts = counts[:limit]
+	}
+
+	return counts, nil
+}
+
+// GetCountryCounts returns the counts of the
+// accesses of the short link with the passed ID
+// selected by traffic in the range [from, to)
+// per country code. Accesses with unresolved
+// country are counted with an empty code. The
+// limit countries with the most accesses are
+// returned ordered by count descending and
+// country ascending.
+func (m *Memory) GetCountryCounts(ctx context.Context, id int, from, to time.Time, traffic database.Traffic, limit int) ([]*shortlink.CountryCount, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	m.mtx.RLock()
+	defer m.mtx.RUnlock()
+
+	index := make(map[string]*shortlink.CountryCount)
+	counts := make([]*shortlink.CountryCount, 0)
+	m.eachCount(id, from, to, traffic, func(r *rollup) {
+		c, ok := index[r.Country]
+		if !ok {
+			c = &shortlink.CountryCount{Country: r.Country}
+			index[r.Country] = c
+			counts = append(counts, c)
+		}
+		c.Count += r.Count
+	})
+
+	sort.Slice(counts, func(i, j int) bool {
+		if counts[i].Count != counts[j].Count {
+			return counts[i].Count > counts[j].Count
+		}
+		return counts[i].Country < counts[j].Country
+	})
+
+	if limit < len(counts) {
+		counts = counts[:limit]
+	}
+
+	return counts, nil
+}
+
+// GetUniqueCounts returns the daily counts of the
+// unique human visitors of the short link with the
+// passed ID in the range [from, to) ordered
+// ascending. Days without visitors are omitted.
+func (m *Memory) GetUniqueCounts(ctx context.Context, id int, from, to time.Time) ([]*shortlink.AccessCount, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	m.mtx.RLock()
+	defer m.mtx.RUnlock()
+
+	visitors := make(map[time.Time]map[string]struct{})
+	for _, a := range m.accesses {
+		if a.ShortLinkID != id || !inRange(a.Time, from, to) || a.Bot || a.Visitor == "" {
+			continue
+		}
+		day := dayOf(a.Time)
+		seen, ok := visitors[day]
+		if !ok {
+			seen = make(map[string]struct{})
+			visitors[day] = seen
+		}
+		seen[a.Visitor] = struct{}{}
+	}
+
+	days := make(map[time.Time]*shortlink.AccessCount)
+	counts := make([]*shortlink.AccessCount, 0)
+	count := func(day time.Time, n int) {
+		c, ok := days[day]
+		if !ok {
+			c = &shortlink.AccessCount{Time: day}
+			days[day] = c
+			counts = append(counts, c)
+		}
+		c.Count += n
+	}
+
+	for day, seen := range visitors {
+		count(day, len(seen))
+	}
+	for _, u := range m.uniques {
+		if u.ShortLinkID == id && inRange(u.Time, from, to) {
+			count(u.Time, u.Count)
+		}
+	}
+
+	sort.Slice(counts, func(i, j int) bool {
+		return counts[i].Time.Before(counts[j].Time)
+	})
+
+	return counts, nil
+}
+
+// GetAccessTotal returns the number of accesses
+// of all short links selected by traffic in the
+// range [from, to). Accesses of deleted short
+// links are not counted.
+func (m *Memory) GetAccessTotal(ctx context.Context, from, to time.Time, traffic database.Traffic) (int, error) {
+	if err := ctx.Err(); err != nil {
+		return 0, err
+	}
+
+	m.mtx.RLock()
+	defer m.mtx.RUnlock()
+
+	var total int
+	for _, n := range m.linkCounts(from, to, traffic) {
+		total += n
+	}
+
+	return total, nil
+}
+
+// GetTopShortLinks returns the limit short links
+// with the most accesses selected by traffic in
+// the range [from, to) together with their number
+// ordered by count descending and ID ascending.
+// Short links without accesses are omitted.
+func (m *Memory) GetTopShortLinks(ctx context.Context, from, to time.Time, traffic database.Traffic, limit int) ([]*shortlink.ShortLinkCount, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	m.mtx.RLock()
+	defer m.mtx.RUnlock()
+
+	counts := make([]*shortlink.ShortLinkCount, 0)
+	for id, n := range m.linkCounts(from, to, traffic) {
+		counts = append(counts, &shortlink.ShortLinkCount{
+			ShortLink: *copyOf(m.entries[id]),
+			Count:     n,
+		})
+	}
+
+	sort.Slice(counts, func(i, j int) bool {
+		if counts[i].Count != counts[j].Count {
+			return counts[i].Count > counts[j].Count
+		}
+		return counts[i].ID < counts[j].ID
+	})
+
+	if limit < len(counts) {
+		counts = counts[:limit]
+	}
+
+	return counts, nil
+}
+
+// GetIdleShortLinks returns the limit short links
+// without accesses selected by traffic since the
+// passed time ordered by created date ascending
+// and the total number of such short links.
+func (m *Memory) GetIdleShortLinks(ctx context.Context, since time.Time, traffic database.Traffic, limit int) ([]*shortlink.ShortLink, int, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, 0, err
+	}
+
+	m.mtx.RLock()
+	defer m.mtx.RUnlock()
+
+	counts := m.linkCounts(since, time.Time{}, traffic)
+	entries := m.sorted()
+
+	sls := make([]*shortlink.ShortLink, 0)
+	var total int
+	for i := len(entries) - 1; i >= 0; i-- {
+		if counts[entries[i].ID] > 0 {
+			continue
+		}
+		total++
+		if len(sls) < limit {
+			sls = append(sls, copyOf(entries[i]))
+		}
+	}
+
+	return sls, total, nil
+}
+
+// RollUpAccesses aggregates the accesses recorded
+// before the passed time into hourly rollups and
+// the unique visitors into daily rollups, removes
+// the aggregated accesses and returns their number.
+// before should be the start of a day, so that the
+// visitors of a day are not split.
+func (m *Memory) RollUpAccesses(ctx context.Context, before time.Time) (int, error) {
+	if err := ctx.Err(); err != nil {
+		return 0, err
+	}
+
+	m.mtx.Lock()
+	defer m.mtx.Unlock()
+
+	hours := make(map[rollupKey]*rollup)
+	visitors := make(map[uniqueRollup]map[string]struct{})
+	accesses := m.accesses[:0]
+	var n int
+	for _, a := range m.accesses {
+		if !a.Time.Before(before) {
+			accesses = append(accesses, a)
+			continue
+		}
+		n++
+
+		key := rollupKey{
+			ShortLinkID: a.ShortLinkID,
+			Time:        a.Time.Truncate(time.Hour),
+			Bot:         a.Bot,
+			Referrer:    a.Referrer,
+			Country:     a.Country,
+			Source:      a.Source,
+			Medium:      a.Medium,
+			Name:        a.Name,
+		}
+		r, ok := hours[key]
+		if !ok {
+			r = &rollup{rollupKey: key}
+			hours[key] = r
+			m.rollups = append(m.rollups, r)
+		}
+		r.Count++
+
+		if a.Bot || a.Visitor == "" {
+			continue
+		}
+		day := uniqueRollup{ShortLinkID: a.ShortLinkID, Time: dayOf(a.Time)}
+		seen, ok := visitors[day]
+		if !ok {
+			seen = make(map[string]struct{})
+			visitors[day] = seen
+		}
+		seen[a.Visitor] = struct{}{}
+	}
+	m.accesses = accesses
+
+	for day, seen := range visitors {
+		u := day
+		u.Count = len(seen)
+		m.uniques = append(m.uniques, &u)
+	}
+
+	return n, nil
+}
+
+// RollUpHourlyAccesses aggregates the hourly rollups
+// before the passed time into daily rollups, removes
+// the aggregated hourly rollups and returns their
+// number.
+func (m *Memory) RollUpHourlyAccesses(ctx context.Context, before time.Time) (int, error) {
+	if err := ctx.Err(); err != nil {
+		return 0, err
+	}
+
+	m.mtx.Lock()
+	defer m.mtx.Unlock()
+
+	days := make(map[rollupKey]*rollup)
+	rollups := make([]*rollup, 0, len(m.rollups))
+	var n int
+	for _, r := range m.rollups {
+		if r.Daily || !r.Time.Before(before) {
+			rollups = append(rollups, r)
+			continue
+		}
+		n++
+
+		key := r.rollupKey
+		key.Daily = true
+		key.Time = dayOf(r.Time)
+		d, ok := days[key]
+		if !ok {
+			d = &rollup{rollupKey: key}
+			days[key] = d
+			rollups = append(rollups, d)
+		}
+		d.Count += r.Count
+	}
+	m.rollups = rollups
+
+	return n, nil
+}
+
+// PurgeDailyAccesses removes the daily rollups
+// before the passed time and returns their number.
+func (m *Memory) PurgeDailyAccesses(ctx context.Context, before time.Time) (int, error) {
+	if err := ctx.Err(); err != nil {
+		return 0, err
+	}
+
+	m.mtx.Lock()
+	defer m.mtx.Unlock()
+
+	rollups := m.rollups[:0]
+	for _, r := range m.rollups {
+		if !r.Daily || !r.Time.Before(before) {
+			rollups = append(rollups, r)
+		}
+	}
+	n := len(m.rollups) - len(rollups)
+	m.rollups = rollups
+
+	uniques := m.uniques[:0]
+	for _, u := range m.uniques {
+		if !u.Time.Before(before) {
+			uniques = append(uniques, u)
+		}
+	}
+	m.uniques = uniques
+
+	return n, nil
+}
+
+// eachCount calls fn for each access and rollup of
+// the short link with the passed ID selected by
+// traffic in the range [from, to). Accesses are
+// passed as rollups with a count of 1.
+// The caller must hold at least a read lock.
+func (m *Memory) eachCount(id int, from, to time.Time, traffic database.Traffic, fn func(r *rollup)) {
+	for _, a := range m.accesses {
+		if a.ShortLinkID != id || !inRange(a.Time, from, to) || !traffic.Matches(a.Bot) {
+			continue
+		}
+		fn(&rollup{
+			rollupKey: rollupKey{
+				ShortLinkID: a.ShortLinkID,
+				Time:        a.Time,
+				Bot:         a.Bot,
+				Referrer:    a.Referrer,
+				Country:     a.Country,
+				Source:      a.Source,
+				Medium:      a.Medium,
+				Name:        a.Name,
+			},
+			Count: 1,
+		})
+	}
+
+	for _, r := range m.rollups {
+		if r.ShortLinkID == id && inRange(r.Time, from, to) && traffic.Matches(r.Bot) {
+			fn(r)
+		}
+	}
+}
+
+// linkCounts returns the numbers of accesses and
+// rolled up accesses selected by traffic in the
+// range [from, to) by ID of non-deleted short links.
+// Short links without accesses are omitted.
+// The caller must hold at least a read lock.
+func (m *Memory) linkCounts(from, to time.Time, traffic database.Traffic) map[int]int {
+	counts := make(map[int]int)
+	add := func(id int, t time.Time, bot bool, n int) {
+		if e, ok := m.entries[id]; ok && !e.Deleted && inRange(t, from, to) && traffic.Matches(bot) {
+			counts[id] += n
+		}
+	}
+
+	for _, a := range m.accesses {
+		add(a.ShortLinkID, a.Time, a.Bot, 1)
+	}
+	for _, r := range m.rollups {
+		add(r.ShortLinkID, r.Time, r.Bot, r.Count)
+	}
+
+	return counts
+}
+
+// isUsed returns true if a non-deleted entry
+// other than the one with the passed ID has
+// the passed short identifier.
+// The caller must hold at least a read lock.
+func (m *Memory) isUsed(short string, id int) bool {
+	for _, e := range m.entries {
+		if !e.Deleted && e.ID != id && e.ShortLink.ShortLink == short {
+			return true
+		}
+	}
+	return false
+}
+
+// trashed returns all deleted entries ordered
+// by deletion date descending. Entries with equal
+// deletion dates are ordered by ID descending.
+// The caller must hold at least a read lock.
+func (m *Memory) trashed() []*entry {
+	entries := make([]*entry, 0)
+	for _, e := range m.entries {
+		if e.Deleted {
+			entries = append(entries, e)
+		}
+	}
+
+	sort.Slice(entries, func(i, j int) bool {
+		if entries[i].DeletedAt.Equal(entries[j].DeletedAt) {
+			return entries[i].ID > entries[j].ID
+		}
+		return entries[i].DeletedAt.After(entries[j].DeletedAt)
+	})
+
+	return entries
+}
+
+// copyOf returns a copy of the short link
+// of the passed entry so that the stored
+// entry can not be modified from outside.
+func copyOf(e *entry) *shortlink.ShortLink {
+	sl := e.ShortLink
+	sl.ExpiresAt = copyTime(e.ExpiresAt)
+	return &sl
+}
+
+// copyTime returns a copy of the passed time in
+// UTC with second precision or nil, if t is nil.
+func copyTime(t *time.Time) *time.Time {
+	if t == nil {
+		return nil
+	}
+	c := t.UTC().Truncate(time.Second)
+	return &c
+}
+
+// now returns the current time in UTC
+// with second precision, as same as
+// it would be stored in a SQL database.
+func now() time.Time {
+	return time.Now().UTC().Truncate(time.Second)
+}
+
+// trashedCopyOf returns a copy of the short
+// link of the passed entry with its deletion
+// date.
+func trashedCopyOf(e *entry) *shortlink.Trashed {
+	return &shortlink.Trashed{
+		ShortLink: *copyOf(e),
+		Deleted:   e.DeletedAt,
+	}
+}
+
+// lessFunc returns the function comparing
+// entries by the passed sort field.
+func lessFunc(field string) func(a, b *entry) bool {
+	switch field {
+	case database.SortID:
+		return func(a, b *entry) bool { return a.ID < b.ID }
+	case database.SortRootLink:
+		return func(a, b *entry) bool { return a.RootLink < b.RootLink }
+	case database.SortShortLink:
+		return func(a, b *entry) bool { return a.ShortLink.ShortLink < b.ShortLink.ShortLink }
+	case database.SortAccesses:
+		return func(a, b *entry) bool { return a.Accesses < b.Accesses }
+	case database.SortEdited:
+		return func(a, b *entry) bool { return a.Edited.Before(b.Edited) }
+	}
+	return func(a, b *entry) bool { return a.Created.Before(b.Created) }
+}
+
+// hasDomain returns true if the host of the
+// passed root link equals domain.
+func hasDomain(rootLink, domain string) bool {
+	u, err := url.Parse(rootLink)
+	return err == nil && strings.EqualFold(u.Hostname(), domain)
+}
+
+// dayOf returns the start of
+// the UTC day containing t.
+func dayOf(t time.Time) time.Time {
+	t = t.UTC()
+	return time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, time.UTC)
+}
+
+// inRange returns true if t is in the range
+// [from, to). Zero bounds are ignored.
+func inRange(t, from, to time.Time) bool {
+	return (from.IsZero() || !t.Before(from)) &&
+		(to.IsZero() || t.Before(to))
+}
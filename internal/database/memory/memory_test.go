@@ -0,0 +1,18 @@
+package memory
+
+import (
+	"testing"
+
+	"github.com/zekroTJA/slms/internal/database"
+	"github.com/zekroTJA/slms/internal/database/dbtest"
+)
+
+func TestMiddleware(t *testing.T) {
+	dbtest.Run(t, func(t *testing.T) database.Middleware {
+		db := new(Memory)
+		if err := db.Open(new(Config)); err != nil {
+			t.Fatal(err)
+		}
+		return db
+	})
+}
@@ -1,9 +1,11 @@
 package database
 
 import (
+	"context"
 	"strings"
 	"time"
 
+	"github.com/zekroTJA/slms/internal/database/migration"
 	"github.com/zekroTJA/slms/internal/shortlink"
 )
 
@@ -24,6 +26,17 @@ func (t Timestamp) ToTime(format string) (time.Time, error) {
 // The Middleware interface describes
 // the functions a database middleware
 // must provide.
+// All functions taking a context must
+// abort and return the context's error
+// when the context is done.
+// Creating, updating, deleting and restoring
+// short links records a revision with the
+// actor carried by the context.
+// Purging short links also removes their
+// revisions and accesses.
+// Access stats include the rolled up accesses,
+// which are dated to the start of their hour
+// or day.
 type Middleware interface {
 	// Open initializes the database
 	// connection with the passed
@@ -35,23 +48,155 @@ type Middleware interface {
 
 	// GetShortLinkCount returns the number of short
 	// link entries in the database.
-	GetShortLinkCount() (int, error)
+	GetShortLinkCount(ctx context.Context) (int, error)
 	// GetShortLink gets a shortlink entry from
 	// database wether by id, root or short link
 	// (excatly in this order).
-	GetShortLink(id, root, short string) (*shortlink.ShortLink, error)
+	GetShortLink(ctx context.Context, id, root, short string) (*shortlink.ShortLink, error)
 	// GetShortLinks returns a list of short links which
 	// is ordered by created date descending between
 	// from index and limit ammount.
-	GetShortLinks(from, limit int) ([]*shortlink.ShortLink, error)
-	// UpdateShortLink updates a short link by
-	// all values contained in updated.
-	UpdateShortLink(id int, updated *shortlink.ShortLink) error
+	GetShortLinks(ctx context.Context, from, limit int) ([]*shortlink.ShortLink, error)
+	// QueryShortLinks returns the list of short links
+	// described by the passed query and the total
+	// number of short links matching its filters.
+	QueryShortLinks(ctx context.Context, q *Query) ([]*shortlink.ShortLink, int, error)
+	// UpdateShortLink updates the root and short
+	// link and the settings of a short link, like
+	// its limits, redirect type and password hash,
+	// by the values contained in updated. The
+	// access count is not modified.
+	UpdateShortLink(ctx context.Context, id int, updated *shortlink.ShortLink) error
+	// IncrementAccesses atomically increases the
+	// access count of a short link by n.
+	IncrementAccesses(ctx context.Context, id, n int) error
+	// IncrementUniques atomically increases the
+	// unique visitor count of a short link by n.
+	IncrementUniques(ctx context.Context, id, n int) error
 	// CreateShortLink creates a new shortlink
 	// entry in the database and returnes the
 	// new shortlink object whis was created.
-	CreateShortLink(sl *shortlink.ShortLink) (*shortlink.ShortLink, error)
+	CreateShortLink(ctx context.Context, sl *shortlink.ShortLink) (*shortlink.ShortLink, error)
 	// Deletes a shortlink from the database
 	// or marks it at least as unavailable.
-	DeleteShortLink(id int) error
+	DeleteShortLink(ctx context.Context, id int) error
+
+	// GetTrashedShortLinks returns a list of deleted
+	// short links which is ordered by deletion date
+	// descending between from index and limit ammount.
+	GetTrashedShortLinks(ctx context.Context, from, limit int) ([]*shortlink.Trashed, error)
+	// GetTrashedShortLink returns the deleted short
+	// link with the passed ID. If no deleted short
+	// link was found, nil is returned.
+	GetTrashedShortLink(ctx context.Context, id int) (*shortlink.Trashed, error)
+	// RestoreShortLink makes the deleted short
+	// link with the passed ID available again.
+	RestoreShortLink(ctx context.Context, id int) error
+	// PurgeShortLink permanently removes the deleted
+	// short link with the passed ID from the database.
+	PurgeShortLink(ctx context.Context, id int) error
+	// PurgeTrash permanently removes all short links
+	// which were deleted longer than age ago and
+	// returns the number of removed short links.
+	PurgeTrash(ctx context.Context, age time.Duration) (int, error)
+
+	// GetRevisions returns all revisions of the short
+	// link with the passed ID ordered descending.
+	GetRevisions(ctx context.Context, id int) ([]*shortlink.Revision, error)
+	// GetRevision returns the revision with the ID rev
+	// of the short link with the passed ID. If no such
+	// revision was found, nil is returned.
+	GetRevision(ctx context.Context, id, rev int) (*shortlink.Revision, error)
+
+	// AddAccesses records the passed accesses
+	// of short links.
+	AddAccesses(ctx context.Context, accesses []*shortlink.Access) error
+	// GetAccessCounts returns the hourly counts of
+	// the accesses of the short link with the passed
+	// ID selected by traffic in the range [from, to)
+	// ordered ascending. Hours without accesses are
+	// omitted.
+	GetAccessCounts(ctx context.Context, id int, from, to time.Time, traffic Traffic) ([]*shortlink.AccessCount, error)
+	// GetReferrerCounts returns the counts of the
+	// accesses of the short link with the passed ID
+	// selected by traffic in the range [from, to)
+	// per referrer host. The limit referrers
+	// with the most accesses are returned ordered by
+	// count descending and referrer ascending.
+	GetReferrerCounts(ctx context.Context, id int, from, to time.Time, traffic Traffic, limit int) ([]*shortlink.ReferrerCount, error)
+	// GetCampaignCounts returns the counts of the
+	// accesses of the short link with the passed ID
+	// selected by traffic in the range [from, to) per
+	// UTM source, medium and campaign.
+	// Accesses without these parameters are not counted.
+	// The limit campaigns with the most accesses are
+	// returned ordered by count descending and source,
+	// medium and campaign ascending.
+	GetCampaignCounts(ctx context.Context, id int, from, to time.Time, traffic Traffic, limit int) ([]*shortlink.CampaignCount, error)
+	// GetCountryCounts returns the counts of the
+	// accesses of the short link with the passed ID
+	// selected by traffic in the range [from, to)
+	// per country code. Accesses with unresolved
+	// country are counted with an empty code. The
+	// limit countries with the most accesses are
+	// returned ordered by count descending and
+	// country ascending.
+	GetCountryCounts(ctx context.Context, id int, from, to time.Time, traffic Traffic, limit int) ([]*shortlink.CountryCount, error)
+	// GetUniqueCounts returns the daily counts of the
+	// unique human visitors of the short link with the
+	// passed ID in the range [from, to) ordered
+	// ascending. Accesses without visitor are not
+	// counted and days without visitors are omitted.
+	GetUniqueCounts(ctx context.Context, id int, from, to time.Time) ([]*shortlink.AccessCount, error)
+	// GetAccessTotal returns the number of accesses
+	// of all short links selected by traffic in the
+	// range [from, to). Accesses of deleted short
+	// links are not counted.
+	GetAccessTotal(ctx context.Context, from, to time.Time, traffic Traffic) (int, error)
+	// GetTopShortLinks returns the limit short links
+	// with the most accesses selected by traffic in
+	// the range [from, to) together with their number
+	// ordered by count descending and ID ascending.
+	// Short links without accesses are omitted.
+	GetTopShortLinks(ctx context.Context, from, to time.Time, traffic Traffic, limit int) ([]*shortlink.ShortLinkCount, error)
+	// GetIdleShortLinks returns the limit short links
+	// without accesses selected by traffic since the
+	// passed time ordered by created date ascending
+	// and the total number of such short links.
+	GetIdleShortLinks(ctx context.Context, since time.Time, traffic Traffic, limit int) ([]*shortlink.ShortLink, int, error)
+
+	// RollUpAccesses aggregates the accesses recorded
+	// before the passed time into hourly rollups and
+	// the unique visitors into daily rollups, removes
+	// the aggregated accesses and returns their number.
+	// before should be the start of a day, so that the
+	// visitors of a day are not split.
+	RollUpAccesses(ctx context.Context, before time.Time) (int, error)
+	// RollUpHourlyAccesses aggregates the hourly rollups
+	// before the passed time into daily rollups, removes
+	// the aggregated hourly rollups and returns their
+	// number.
+	RollUpHourlyAccesses(ctx context.Context, before time.Time) (int, error)
+	// PurgeDailyAccesses removes the daily rollups
+	// before the passed time and returns their number.
+	PurgeDailyAccesses(ctx context.Context, before time.Time) (int, error)
+}
+
+// The Migratable interface describes the
+// functions a database middleware must
+// provide additionally when its schema is
+// maintained by versioned migrations.
+// Open applies pending migrations before
+// preparing the schema dependent statements.
+type Migratable interface {
+	// Connect only initializes the database
+	// connection with the passed parameters
+	// without touching the schema.
+	Connect(cfg interface{}) error
+	// Close closes an existing
+	// connection.
+	Close()
+	// Migrator returns the schema migrator
+	// of the connected database.
+	Migrator() *migration.Migrator
 }
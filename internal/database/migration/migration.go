@@ -0,0 +1,246 @@
+// Package migration provides versioned schema
+// migrations for SQL databases.
+package migration
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// Bind variable styles used in the
+// schema version table statements.
+const (
+	PlaceholderQuestion = "?"
+	PlaceholderDollar   = "$1"
+)
+
+var (
+	// ErrSchemaNewer is returned when the version of
+	// the database schema is higher than the latest
+	// migration known to this binary.
+	ErrSchemaNewer = errors.New("database schema is newer than supported by this binary")
+	// ErrNothingToRollback is returned by Down when
+	// no migration has been applied yet.
+	ErrNothingToRollback = errors.New("no applied migration to roll back")
+)
+
+// A Migration describes one versioned step of
+// the database schema. Up contains the statements
+// to apply the step and Down the statements to
+// revert it. Each statement is executed separately.
+type Migration struct {
+	Version int
+	Name    string
+	Up      []string
+	Down    []string
+}
+
+// Status contains the version and name of a
+// migration and if it is applied to the
+// database schema.
+type Status struct {
+	Version int
+	Name    string
+	Applied bool
+}
+
+// Migrator applies and reverts migrations
+// on a SQL database and keeps track of the
+// current schema version in the table
+// 'schema_migrations'.
+type Migrator struct {
+	db          *sql.DB
+	placeholder string
+	migrations  []*Migration
+}
+
+// New creates a new Migrator for the passed database
+// connection using the passed bind variable style and
+// migrations. The migrations are sorted by version.
+func New(db *sql.DB, placeholder string, migrations []*Migration) *Migrator {
+	sorted := make([]*Migration, len(migrations))
+	copy(sorted, migrations)
+	sort.Slice(sorted, func(i, j int) bool {
+		return sorted[i].Version < sorted[j].Version
+	})
+
+	return &Migrator{
+		db:          db,
+		placeholder: placeholder,
+		migrations:  sorted,
+	}
+}
+
+// Latest returns the version of the latest
+// migration known to the Migrator.
+func (m *Migrator) Latest() int {
+	if len(m.migrations) == 0 {
+		return 0
+	}
+	return m.migrations[len(m.migrations)-1].Version
+}
+
+// Version returns the current version of the
+// database schema. If no migration was applied
+// yet, 0 is returned.
+func (m *Migrator) Version() (int, error) {
+	if err := m.ensureTable(); err != nil {
+		return 0, err
+	}
+
+	var v sql.NullInt64
+	err := m.db.QueryRow("SELECT MAX(version) FROM schema_migrations;").Scan(&v)
+
+	return int(v.Int64), err
+}
+
+// Check returns ErrSchemaNewer if the database
+// schema is newer than the latest migration.
+func (m *Migrator) Check() error {
+	v, err := m.Version()
+	if err != nil {
+		return err
+	}
+	if v > m.Latest() {
+		return ErrSchemaNewer
+	}
+	return nil
+}
+
+// Up applies all pending migrations in ascending
+// order and returns the number of applied migrations.
+func (m *Migrator) Up() (int, error) {
+	v, err := m.Version()
+	if err != nil {
+		return 0, err
+	}
+	if v > m.Latest() {
+		return 0, ErrSchemaNewer
+	}
+
+	var n int
+	for _, mig := range m.migrations {
+		if mig.Version <= v {
+			continue
+		}
+		err = m.exec(mig.Up,
+			"INSERT INTO schema_migrations (version) VALUES ("+m.placeholder+");",
+			mig.Version)
+		if err != nil {
+			return n, fmt.Errorf("migration %d (%s) failed: %s", mig.Version, mig.Name, err.Error())
+		}
+		n++
+	}
+
+	return n, nil
+}
+
+// Down reverts the latest applied migration
+// and returns it.
+func (m *Migrator) Down() (*Migration, error) {
+	v, err := m.Version()
+	if err != nil {
+		return nil, err
+	}
+	if v == 0 {
+		return nil, ErrNothingToRollback
+	}
+	if v > m.Latest() {
+		return nil, ErrSchemaNewer
+	}
+
+	for i := len(m.migrations) - 1; i >= 0; i-- {
+		mig := m.migrations[i]
+		if mig.Version != v {
+			continue
+		}
+		err = m.exec(mig.Down,
+			"DELETE FROM schema_migrations WHERE version = "+m.placeholder+";",
+			mig.Version)
+		if err != nil {
+			return nil, fmt.Errorf("rollback of migration %d (%s) failed: %s", mig.Version, mig.Name, err.Error())
+		}
+		return mig, nil
+	}
+
+	return nil, fmt.Errorf("applied migration %d is unknown", v)
+}
+
+// Status returns the status of all known
+// migrations in ascending order.
+func (m *Migrator) Status() ([]*Status, error) {
+	if err := m.ensureTable(); err != nil {
+		return nil, err
+	}
+
+	rows, err := m.db.Query("SELECT version FROM schema_migrations;")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	applied := make(map[int]bool)
+	for rows.Next() {
+		var v int
+		if err = rows.Scan(&v); err != nil {
+			return nil, err
+		}
+		applied[v] = true
+	}
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	status := make([]*Status, len(m.migrations))
+	for i, mig := range m.migrations {
+		status[i] = &Status{
+			Version: mig.Version,
+			Name:    mig.Name,
+			Applied: applied[mig.Version],
+		}
+	}
+
+	return status, nil
+}
+
+// ensureTable creates the schema version
+// table if it does not exist yet.
+func (m *Migrator) ensureTable() error {
+	_, err := m.db.Exec(
+		"CREATE TABLE IF NOT EXISTS schema_migrations (" +
+			"version INTEGER NOT NULL PRIMARY KEY, " +
+			"applied TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP);")
+	return err
+}
+
+// exec executes the passed statements followed
+// by the version statement with the passed version
+// in one transaction.
+// Keep in mind that some databases like MySQL
+// implicitly commit DDL statements.
+func (m *Migrator) exec(stmts []string, versionStmt string, version int) error {
+	tx, err := m.db.Begin()
+	if err != nil {
+		return err
+	}
+
+	for _, stmt := range stmts {
+		if strings.TrimSpace(stmt) == "" {
+			continue
+		}
+		if _, err = tx.Exec(stmt); err != nil {
+			tx.Rollback()
+			return err
+		}
+	}
+
+	if _, err = tx.Exec(versionStmt, version); err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	return tx.Commit()
+}
@@ -0,0 +1,253 @@
+package mysql
+
+import "github.com/zekroTJA/slms/internal/database/migration"
+
+// migrations contains all schema migrations
+// of the MySQL database in ascending order.
+var migrations = []*migration.Migration{
+	{
+		Version: 1,
+		Name:    "create shortlinks table",
+		Up: []string{
+			"CREATE TABLE IF NOT EXISTS `shortlinks` (" +
+				"`id` INT NOT NULL AUTO_INCREMENT, " +
+				"`rootlink` TEXT NOT NULL, " +
+				"`shortlink` VARCHAR(255) NOT NULL, " +
+				"`created` TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP, " +
+				"`accesses` INT NOT NULL DEFAULT 0, " +
+				"`edited` TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP ON UPDATE CURRENT_TIMESTAMP, " +
+				"`deleted` TINYINT(1) NOT NULL DEFAULT 0, " +
+				"PRIMARY KEY (`id`), " +
+				"INDEX `idx_shortlink` (`shortlink`)" +
+				") ENGINE=InnoDB DEFAULT CHARSET=utf8mb4;",
+		},
+		Down: []string{
+			"DROP TABLE `shortlinks`;",
+		},
+	},
+	{
+		Version: 2,
+		Name:    "add deletion date",
+		Up: []string{
+			"ALTER TABLE `shortlinks` ADD `deleted_at` TIMESTAMP NULL DEFAULT NULL;",
+			// Short links deleted before are dated
+			// to their last modification.
+			"UPDATE `shortlinks` SET `deleted_at` = `edited`, `edited` = `edited` " +
+				"WHERE `deleted` = 1;",
+		},
+		Down: []string{
+			"ALTER TABLE `shortlinks` DROP COLUMN `deleted_at`;",
+		},
+	},
+	{
+		Version: 3,
+		Name:    "create revisions table",
+		Up: []string{
+			"CREATE TABLE IF NOT EXISTS `revisions` (" +
+				"`id` INT NOT NULL AUTO_INCREMENT, " +
+				"`shortlink_id` INT NOT NULL, " +
+				"`action` VARCHAR(16) NOT NULL, " +
+				"`old_rootlink` TEXT NOT NULL, " +
+				"`old_shortlink` VARCHAR(255) NOT NULL, " +
+				"`new_rootlink` TEXT NOT NULL, " +
+				"`new_shortlink` VARCHAR(255) NOT NULL, " +
+				"`actor` VARCHAR(255) NOT NULL, " +
+				"`created` TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP, " +
+				"PRIMARY KEY (`id`), " +
+				"INDEX `idx_shortlink_id` (`shortlink_id`)" +
+				") ENGINE=InnoDB DEFAULT CHARSET=utf8mb4;",
+			// Existing short links get a create revision
+			// so that they can be reverted to their
+			// current state.
+			"INSERT INTO `revisions` " +
+				"(`shortlink_id`, `action`, `old_rootlink`, `old_shortlink`, `new_rootlink`, `new_shortlink`, `actor`, `created`) " +
+				"SELECT `id`, 'create', '', '', `rootlink`, `shortlink`, '', `created` FROM `shortlinks`;",
+		},
+		Down: []string{
+			"DROP TABLE `revisions`;",
+		},
+	},
+	{
+		Version: 4,
+		Name:    "unique short links",
+		Up: []string{
+			// Duplicate short links created by concurrent
+			// requests are moved to the trash, so only the
+			// oldest one stays available.
+			"UPDATE `shortlinks` AS `a` JOIN `shortlinks` AS `b` " +
+				"ON `b`.`deleted` = 0 AND `b`.`shortlink` = `a`.`shortlink` AND `b`.`id` < `a`.`id` " +
+				"SET `a`.`deleted` = 1, `a`.`deleted_at` = CURRENT_TIMESTAMP, `a`.`edited` = `a`.`edited` " +
+				"WHERE `a`.`deleted` = 0;",
+			// MySQL has no partial indexes, so the unique
+			// index covers a column which is only set for
+			// non-deleted short links.
+			"ALTER TABLE `shortlinks` " +
+				"ADD `active_shortlink` VARCHAR(255) AS (IF(`deleted` = 0, `shortlink`, NULL)) STORED, " +
+				"ADD UNIQUE INDEX `idx_active_shortlink` (`active_shortlink`);",
+		},
+		Down: []string{
+			"ALTER TABLE `shortlinks` DROP INDEX `idx_active_shortlink`, DROP COLUMN `active_shortlink`;",
+		},
+	},
+	{
+		Version: 5,
+		Name:    "create accesses table",
+		Up: []string{
+			"CREATE TABLE IF NOT EXISTS `accesses` (" +
+				"`id` BIGINT NOT NULL AUTO_INCREMENT, " +
+				"`shortlink_id` INT NOT NULL, " +
+				"`created` TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP, " +
+				"`referrer` VARCHAR(255) NOT NULL, " +
+				"`agent` VARCHAR(16) NOT NULL, " +
+				"PRIMARY KEY (`id`), " +
+				"INDEX `idx_accesses_shortlink_created` (`shortlink_id`, `created`)" +
+				") ENGINE=InnoDB DEFAULT CHARSET=utf8mb4;",
+		},
+		Down: []string{
+			"DROP TABLE `accesses`;",
+		},
+	},
+	{
+		Version: 6,
+		Name:    "add campaign parameters to accesses",
+		Up: []string{
+			"ALTER TABLE `accesses` " +
+				"ADD `utm_source` VARCHAR(255) NOT NULL DEFAULT '', " +
+				"ADD `utm_medium` VARCHAR(255) NOT NULL DEFAULT '', " +
+				"ADD `utm_campaign` VARCHAR(255) NOT NULL DEFAULT '', " +
+				"ADD `utm_term` VARCHAR(255) NOT NULL DEFAULT '', " +
+				"ADD `utm_content` VARCHAR(255) NOT NULL DEFAULT '';",
+		},
+		Down: []string{
+			"ALTER TABLE `accesses` " +
+				"DROP COLUMN `utm_source`, " +
+				"DROP COLUMN `utm_medium`, " +
+				"DROP COLUMN `utm_campaign`, " +
+				"DROP COLUMN `utm_term`, " +
+				"DROP COLUMN `utm_content`;",
+		},
+	},
+	{
+		Version: 7,
+		Name:    "flag bot accesses",
+		Up: []string{
+			"ALTER TABLE `accesses` ADD `bot` TINYINT(1) NOT NULL DEFAULT 0;",
+			"UPDATE `accesses` SET `bot` = 1 WHERE `agent` = 'bot';",
+		},
+		Down: []string{
+			"ALTER TABLE `accesses` DROP COLUMN `bot`;",
+		},
+	},
+	{
+		Version: 8,
+		Name:    "count unique visitors",
+		Up: []string{
+			"ALTER TABLE `accesses` ADD `visitor` VARCHAR(32) NOT NULL DEFAULT '';",
+			"ALTER TABLE `shortlinks` ADD `uniques` INT NOT NULL DEFAULT 0;",
+		},
+		Down: []string{
+			"ALTER TABLE `shortlinks` DROP COLUMN `uniques`;",
+			"ALTER TABLE `accesses` DROP COLUMN `visitor`;",
+		},
+	},
+	{
+		Version: 9,
+		Name:    "create rollup tables",
+		Up: []string{
+			"CREATE TABLE IF NOT EXISTS `access_rollups` (" +
+				"`id` BIGINT NOT NULL AUTO_INCREMENT, " +
+				"`shortlink_id` INT NOT NULL, " +
+				"`granularity` VARCHAR(4) NOT NULL, " +
+				"`created` TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP, " +
+				"`bot` TINYINT(1) NOT NULL, " +
+				"`referrer` VARCHAR(255) NOT NULL, " +
+				"`utm_source` VARCHAR(255) NOT NULL, " +
+				"`utm_medium` VARCHAR(255) NOT NULL, " +
+				"`utm_campaign` VARCHAR(255) NOT NULL, " +
+				"`accesses` INT NOT NULL, " +
+				"PRIMARY KEY (`id`), " +
+				"INDEX `idx_access_rollups_shortlink_created` (`shortlink_id`, `created`), " +
+				"INDEX `idx_access_rollups_granularity_created` (`granularity`, `created`)" +
+				") ENGINE=InnoDB DEFAULT CHARSET=utf8mb4;",
+			"CREATE TABLE IF NOT EXISTS `unique_rollups` (" +
+				"`id` BIGINT NOT NULL AUTO_INCREMENT, " +
+				"`shortlink_id` INT NOT NULL, " +
+				"`created` TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP, " +
+				"`uniques` INT NOT NULL, " +
+				"PRIMARY KEY (`id`), " +
+				"INDEX `idx_unique_rollups_shortlink_created` (`shortlink_id`, `created`)" +
+				") ENGINE=InnoDB DEFAULT CHARSET=utf8mb4;",
+			"ALTER TABLE `accesses` ADD INDEX `idx_accesses_created` (`created`);",
+		},
+		Down: []string{
+			"ALTER TABLE `accesses` DROP INDEX `idx_accesses_created`;",
+			"DROP TABLE `unique_rollups`;",
+			"DROP TABLE `access_rollups`;",
+		},
+	},
+	{
+		Version: 10,
+		Name:    "add country to accesses",
+		Up: []string{
+			"ALTER TABLE `accesses` ADD `country` VARCHAR(2) NOT NULL DEFAULT '';",
+			"ALTER TABLE `access_rollups` ADD `country` VARCHAR(2) NOT NULL DEFAULT '';",
+		},
+		Down: []string{
+			"ALTER TABLE `access_rollups` DROP COLUMN `country`;",
+			"ALTER TABLE `accesses` DROP COLUMN `country`;",
+		},
+	},
+	{
+		Version: 11,
+		Name:    "add limits to shortlinks",
+		Up: []string{
+			"ALTER TABLE `shortlinks` ADD `expires_at` TIMESTAMP NULL DEFAULT NULL;",
+			"ALTER TABLE `shortlinks` ADD `max_accesses` INT NOT NULL DEFAULT 0;",
+			"ALTER TABLE `shortlinks` ADD `fallback_url` TEXT NOT NULL;",
+		},
+		Down: []string{
+			"ALTER TABLE `shortlinks` DROP COLUMN `fallback_url`;",
+			"ALTER TABLE `shortlinks` DROP COLUMN `max_accesses`;",
+			"ALTER TABLE `shortlinks` DROP COLUMN `expires_at`;",
+		},
+	},
+	{
+		Version: 12,
+		Name:    "add redirect type to shortlinks",
+		Up: []string{
+			"ALTER TABLE `shortlinks` ADD `redirect_type` VARCHAR(4) NOT NULL DEFAULT '';",
+		},
+		Down: []string{
+			"ALTER TABLE `shortlinks` DROP COLUMN `redirect_type`;",
+		},
+	},
+	{
+		Version: 13,
+		Name:    "add password hash to shortlinks",
+		Up: []string{
+			"ALTER TABLE `shortlinks` ADD `password_hash` TEXT NOT NULL;",
+		},
+		Down: []string{
+			"ALTER TABLE `shortlinks` DROP COLUMN `password_hash`;",
+		},
+	},
+	{
+		Version: 14,
+		Name:    "case-sensitive short links",
+		Up: []string{
+			// Short identifiers are compared case-sensitively
+			// like by the other database backends, so that
+			// 'Abc' and 'abc' are different short links.
+			"ALTER TABLE `shortlinks` " +
+				"MODIFY `shortlink` VARCHAR(255) CHARACTER SET utf8mb4 COLLATE utf8mb4_bin NOT NULL, " +
+				"MODIFY `active_shortlink` VARCHAR(255) CHARACTER SET utf8mb4 COLLATE utf8mb4_bin " +
+				"AS (IF(`deleted` = 0, `shortlink`, NULL)) STORED;",
+		},
+		Down: []string{
+			"ALTER TABLE `shortlinks` " +
+				"MODIFY `shortlink` VARCHAR(255) CHARACTER SET utf8mb4 NOT NULL, " +
+				"MODIFY `active_shortlink` VARCHAR(255) CHARACTER SET utf8mb4 " +
+				"AS (IF(`deleted` = 0, `shortlink`, NULL)) STORED;",
+		},
+	},
+}
@@ -1,15 +1,19 @@
 package mysql
 
 import (
+	"context"
 	"database/sql"
 	"errors"
 	"fmt"
+	"time"
 
 	"github.com/zekroTJA/slms/pkg/multierror"
 
 	// MySQL driver import
-	_ "github.com/go-sql-driver/mysql"
+	"github.com/go-sql-driver/mysql"
 	"github.com/zekroTJA/slms/internal/database"
+	"github.com/zekroTJA/slms/internal/database/migration"
+	"github.com/zekroTJA/slms/internal/database/sqlquery"
 	"github.com/zekroTJA/slms/internal/shortlink"
 )
 
@@ -18,8 +22,25 @@ const timeFormat = "2006-01-02 15:04:05"
 // MySQL maintains the connection
 // to a MySQL database.
 type MySQL struct {
-	db    *sql.DB
-	stmts *prepStmts
+	db       *sql.DB
+	stmts    *prepStmts
+	migrator *migration.Migrator
+}
+
+// errDupEntry is the MySQL error number of
+// duplicate entries in unique indexes.
+const errDupEntry = 1062
+
+// dialect is the SQL dialect of MySQL for
+// dynamically built queries.
+var dialect = sqlquery.Dialect{
+	// Short links are stored with a binary
+	// collation, so the search must specify
+	// a case-insensitive one.
+	Like: "COLLATE utf8mb4_general_ci LIKE",
+	FormatTime: func(t time.Time) interface{} {
+		return t.UTC().Format(timeFormat)
+	},
 }
 
 type prepStmts struct {
@@ -29,8 +50,39 @@ type prepStmts struct {
 	getSLByRoot  *sql.Stmt
 	getSLByShort *sql.Stmt
 	updateSLByID *sql.Stmt
+	incrAccesses *sql.Stmt
+	incrUniques  *sql.Stmt
 	insertSL     *sql.Stmt
 	deleteSLByID *sql.Stmt
+
+	getTrashed     *sql.Stmt
+	getTrashedByID *sql.Stmt
+	restoreSLByID  *sql.Stmt
+	purgeSLByID    *sql.Stmt
+	getTrashAfter  *sql.Stmt
+
+	insertRevision *sql.Stmt
+	getRevisions   *sql.Stmt
+	getRevision    *sql.Stmt
+
+	insertAccess      *sql.Stmt
+	getAccessCounts   *sql.Stmt
+	getReferrerCounts *sql.Stmt
+	getCampaignCounts *sql.Stmt
+	getCountryCounts  *sql.Stmt
+	getUniqueCounts   *sql.Stmt
+	getAccessTotal    *sql.Stmt
+	getTopSLs         *sql.Stmt
+	getIdleSLs        *sql.Stmt
+	countIdleSLs      *sql.Stmt
+
+	rollUpAccesses *sql.Stmt
+	rollUpUniques  *sql.Stmt
+	rollUpHourly   *sql.Stmt
+	deleteAccesses *sql.Stmt
+	deleteHourly   *sql.Stmt
+	deleteDaily    *sql.Stmt
+	deleteUniques  *sql.Stmt
 }
 
 // Config contains the configuration
@@ -43,8 +95,25 @@ type Config struct {
 }
 
 // Open attempts to stablishes a
-// connection to a MySQL database.
+// connection to a MySQL database,
+// applies pending schema migrations
+// and prepares all statements.
 func (m *MySQL) Open(cfg interface{}) error {
+	if err := m.Connect(cfg); err != nil {
+		return err
+	}
+
+	if _, err := m.migrator.Up(); err != nil {
+		return err
+	}
+
+	return m.prepStatements()
+}
+
+// Connect attempts to stablishes a
+// connection to a MySQL database
+// without touching the schema.
+func (m *MySQL) Connect(cfg interface{}) error {
 	var err error
 
 	conf, ok := cfg.(*Config)
@@ -59,7 +128,15 @@ func (m *MySQL) Open(cfg interface{}) error {
 		return err
 	}
 
-	return m.prepStatements()
+	m.migrator = migration.New(m.db, migration.PlaceholderQuestion, migrations)
+
+	return nil
+}
+
+// Migrator returns the schema migrator
+// of the database connection.
+func (m *MySQL) Migrator() *migration.Migrator {
+	return m.migrator
 }
 
 // Close cleanly closes the
@@ -79,39 +156,255 @@ func (m *MySQL) prepStatements() error {
 	mErr.Append(err)
 
 	m.stmts.getSLByID, err = m.db.Prepare(
-		"SELECT `id`, `rootlink`, `shortlink`, `created`, `accesses`, `edited` FROM `shortlinks` " +
+		"SELECT `id`, `rootlink`, `shortlink`, `created`, `accesses`, `uniques`, `edited`, `expires_at`, `max_accesses`, `fallback_url`, `redirect_type`, `password_hash` FROM `shortlinks` " +
 			"WHERE `deleted` = 0 AND `id` = ?;")
 	mErr.Append(err)
 
 	m.stmts.getSLs, err = m.db.Prepare(
-		"SELECT `id`, `rootlink`, `shortlink`, `created`, `accesses`, `edited` FROM `shortlinks` " +
+		"SELECT `id`, `rootlink`, `shortlink`, `created`, `accesses`, `uniques`, `edited`, `expires_at`, `max_accesses`, `fallback_url`, `redirect_type`, `password_hash` FROM `shortlinks` " +
 			"WHERE `deleted` = 0 " +
-			"ORDER BY `created` DESC " +
+			"ORDER BY `created` DESC, `id` DESC " +
 			"LIMIT ?, ?;")
 	mErr.Append(err)
 
 	m.stmts.getSLByRoot, err = m.db.Prepare(
-		"SELECT `id`, `rootlink`, `shortlink`, `created`, `accesses`, `edited` FROM `shortlinks` " +
+		"SELECT `id`, `rootlink`, `shortlink`, `created`, `accesses`, `uniques`, `edited`, `expires_at`, `max_accesses`, `fallback_url`, `redirect_type`, `password_hash` FROM `shortlinks` " +
 			"WHERE `deleted` = 0 AND `rootlink` = ?;")
 	mErr.Append(err)
 
 	m.stmts.getSLByShort, err = m.db.Prepare(
-		"SELECT `id`, `rootlink`, `shortlink`, `created`, `accesses`, `edited` FROM `shortlinks` " +
+		"SELECT `id`, `rootlink`, `shortlink`, `created`, `accesses`, `uniques`, `edited`, `expires_at`, `max_accesses`, `fallback_url`, `redirect_type`, `password_hash` FROM `shortlinks` " +
 			"WHERE `deleted` = 0 AND `shortlink` = ?;")
 	mErr.Append(err)
 
 	m.stmts.updateSLByID, err = m.db.Prepare(
-		"UPDATE `shortlinks` SET `shortlink` = ?, `rootlink` = ?, `accesses` = ? " +
+		"UPDATE `shortlinks` SET `shortlink` = ?, `rootlink` = ?, " +
+			"`expires_at` = ?, `max_accesses` = ?, `fallback_url` = ?, `redirect_type` = ?, `password_hash` = ? " +
+			"WHERE `id` = ?;")
+	mErr.Append(err)
+
+	// Setting `edited` to its own value prevents
+	// the 'ON UPDATE' attribute from changing it.
+	m.stmts.incrAccesses, err = m.db.Prepare(
+		"UPDATE `shortlinks` SET `accesses` = `accesses` + ?, `edited` = `edited` " +
+			"WHERE `id` = ?;")
+	mErr.Append(err)
+
+	m.stmts.incrUniques, err = m.db.Prepare(
+		"UPDATE `shortlinks` SET `uniques` = `uniques` + ?, `edited` = `edited` " +
 			"WHERE `id` = ?;")
 	mErr.Append(err)
 
 	m.stmts.insertSL, err = m.db.Prepare(
-		"INSERT INTO `shortlinks` (`rootlink`, `shortlink`) " +
-			"VALUES (?, ?);")
+		"INSERT INTO `shortlinks` (`rootlink`, `shortlink`, `expires_at`, `max_accesses`, `fallback_url`, `redirect_type`, `password_hash`) " +
+			"VALUES (?, ?, ?, ?, ?, ?, ?);")
 	mErr.Append(err)
 
 	m.stmts.deleteSLByID, err = m.db.Prepare(
-		"UPDATE `shortlinks` SET `deleted` = 1 WHERE `id` = ?;")
+		"UPDATE `shortlinks` SET `deleted` = 1, `deleted_at` = CURRENT_TIMESTAMP " +
+			"WHERE `deleted` = 0 AND `id` = ?;")
+	mErr.Append(err)
+
+	m.stmts.getTrashed, err = m.db.Prepare(
+		"SELECT `id`, `rootlink`, `shortlink`, `created`, `accesses`, `uniques`, `edited`, `expires_at`, `max_accesses`, `fallback_url`, `redirect_type`, `password_hash`, `deleted_at` FROM `shortlinks` " +
+			"WHERE `deleted` = 1 " +
+			"ORDER BY `deleted_at` DESC, `id` DESC " +
+			"LIMIT ?, ?;")
+	mErr.Append(err)
+
+	m.stmts.getTrashedByID, err = m.db.Prepare(
+		"SELECT `id`, `rootlink`, `shortlink`, `created`, `accesses`, `uniques`, `edited`, `expires_at`, `max_accesses`, `fallback_url`, `redirect_type`, `password_hash`, `deleted_at` FROM `shortlinks` " +
+			"WHERE `deleted` = 1 AND `id` = ?;")
+	mErr.Append(err)
+
+	m.stmts.restoreSLByID, err = m.db.Prepare(
+		"UPDATE `shortlinks` SET `deleted` = 0, `deleted_at` = NULL, `edited` = `edited` " +
+			"WHERE `deleted` = 1 AND `id` = ?;")
+	mErr.Append(err)
+
+	m.stmts.purgeSLByID, err = m.db.Prepare(
+		"DELETE FROM `shortlinks` WHERE `deleted` = 1 AND `id` = ?;")
+	mErr.Append(err)
+
+	// The selected rows are locked, so that they
+	// can not be restored until they are purged.
+	m.stmts.getTrashAfter, err = m.db.Prepare(
+		"SELECT `id` FROM `shortlinks` " +
+			"WHERE `deleted` = 1 AND `deleted_at` < CURRENT_TIMESTAMP - INTERVAL ? SECOND FOR UPDATE;")
+	mErr.Append(err)
+
+	m.stmts.insertRevision, err = m.db.Prepare(
+		"INSERT INTO `revisions` " +
+			"(`shortlink_id`, `action`, `old_rootlink`, `old_shortlink`, `new_rootlink`, `new_shortlink`, `actor`) " +
+			"VALUES (?, ?, ?, ?, ?, ?, ?);")
+	mErr.Append(err)
+
+	m.stmts.getRevisions, err = m.db.Prepare(
+		"SELECT `id`, `shortlink_id`, `action`, `old_rootlink`, `old_shortlink`, " +
+			"`new_rootlink`, `new_shortlink`, `actor`, `created` FROM `revisions` " +
+			"WHERE `shortlink_id` = ? " +
+			"ORDER BY `id` DESC;")
+	mErr.Append(err)
+
+	m.stmts.getRevision, err = m.db.Prepare(
+		"SELECT `id`, `shortlink_id`, `action`, `old_rootlink`, `old_shortlink`, " +
+			"`new_rootlink`, `new_shortlink`, `actor`, `created` FROM `revisions` " +
+			"WHERE `shortlink_id` = ? AND `id` = ?;")
+	mErr.Append(err)
+
+	m.stmts.insertAccess, err = m.db.Prepare(
+		"INSERT INTO `accesses` " +
+			"(`shortlink_id`, `created`, `referrer`, `agent`, `bot`, `country`, `visitor`, `utm_source`, `utm_medium`, `utm_campaign`, `utm_term`, `utm_content`) " +
+			"VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?);")
+	mErr.Append(err)
+
+	// The stats queries count the accesses and add
+	// the rollups of the same range, which are dated
+	// to the start of their hour or day.
+	m.stmts.getAccessCounts, err = m.db.Prepare(
+		"SELECT `hour`, SUM(`n`) FROM (" +
+			"SELECT DATE_FORMAT(`created`, '%Y-%m-%d %H:00:00') AS `hour`, COUNT(`id`) AS `n` FROM `accesses` " +
+			"WHERE `shortlink_id` = ? AND `created` >= ? AND `created` < ? AND `bot` BETWEEN ? AND ? " +
+			"GROUP BY `hour` " +
+			"UNION ALL " +
+			"SELECT DATE_FORMAT(`created`, '%Y-%m-%d %H:00:00'), SUM(`accesses`) FROM `access_rollups` " +
+			"WHERE `shortlink_id` = ? AND `created` >= ? AND `created` < ? AND `bot` BETWEEN ? AND ? " +
+			"GROUP BY `created`" +
+			") AS `counts` GROUP BY `hour` ORDER BY `hour`;")
+	mErr.Append(err)
+
+	m.stmts.getReferrerCounts, err = m.db.Prepare(
+		"SELECT `referrer`, SUM(`n`) AS `total` FROM (" +
+			"SELECT `referrer`, COUNT(`id`) AS `n` FROM `accesses` " +
+			"WHERE `shortlink_id` = ? AND `created` >= ? AND `created` < ? AND `bot` BETWEEN ? AND ? " +
+			"GROUP BY `referrer` " +
+			"UNION ALL " +
+			"SELECT `referrer`, SUM(`accesses`) FROM `access_rollups` " +
+			"WHERE `shortlink_id` = ? AND `created` >= ? AND `created` < ? AND `bot` BETWEEN ? AND ? " +
+			"GROUP BY `referrer`" +
+			") AS `counts` GROUP BY `referrer` ORDER BY `total` DESC, `referrer` ASC LIMIT ?;")
+	mErr.Append(err)
+
+	m.stmts.getCampaignCounts, err = m.db.Prepare(
+		"SELECT `utm_source`, `utm_medium`, `utm_campaign`, SUM(`n`) AS `total` FROM (" +
+			"SELECT `utm_source`, `utm_medium`, `utm_campaign`, COUNT(`id`) AS `n` FROM `accesses` " +
+			"WHERE `shortlink_id` = ? AND `created` >= ? AND `created` < ? AND `bot` BETWEEN ? AND ? " +
+			"AND (`utm_source` <> '' OR `utm_medium` <> '' OR `utm_campaign` <> '') " +
+			"GROUP BY `utm_source`, `utm_medium`, `utm_campaign` " +
+			"UNION ALL " +
+			"SELECT `utm_source`, `utm_medium`, `utm_campaign`, SUM(`accesses`) FROM `access_rollups` " +
+			"WHERE `shortlink_id` = ? AND `created` >= ? AND `created` < ? AND `bot` BETWEEN ? AND ? " +
+			"AND (`utm_source` <> '' OR `utm_medium` <> '' OR `utm_campaign` <> '') " +
+			"GROUP BY `utm_source`, `utm_medium`, `utm_campaign`" +
+			") AS `counts` GROUP BY `utm_source`, `utm_medium`, `utm_campaign` " +
+			"ORDER BY `total` DESC, `utm_source`, `utm_medium`, `utm_campaign` LIMIT ?;")
+	mErr.Append(err)
+
+	m.stmts.getCountryCounts, err = m.db.Prepare(
+		"SELECT `country`, SUM(`n`) AS `total` FROM (" +
+			"SELECT `country`, COUNT(`id`) AS `n` FROM `accesses` " +
+			"WHERE `shortlink_id` = ? AND `created` >= ? AND `created` < ? AND `bot` BETWEEN ? AND ? " +
+			"GROUP BY `country` " +
+			"UNION ALL " +
+			"SELECT `country`, SUM(`accesses`) FROM `access_rollups` " +
+			"WHERE `shortlink_id` = ? AND `created` >= ? AND `created` < ? AND `bot` BETWEEN ? AND ? " +
+			"GROUP BY `country`" +
+			") AS `counts` GROUP BY `country` ORDER BY `total` DESC, `country` ASC LIMIT ?;")
+	mErr.Append(err)
+
+	m.stmts.getUniqueCounts, err = m.db.Prepare(
+		"SELECT `day`, SUM(`n`) FROM (" +
+			"SELECT DATE_FORMAT(`created`, '%Y-%m-%d 00:00:00') AS `day`, COUNT(DISTINCT `visitor`) AS `n` FROM `accesses` " +
+			"WHERE `shortlink_id` = ? AND `created` >= ? AND `created` < ? AND `bot` = 0 AND `visitor` <> '' " +
+			"GROUP BY `day` " +
+			"UNION ALL " +
+			"SELECT DATE_FORMAT(`created`, '%Y-%m-%d 00:00:00'), `uniques` FROM `unique_rollups` " +
+			"WHERE `shortlink_id` = ? AND `created` >= ? AND `created` < ?" +
+			") AS `counts` GROUP BY `day` ORDER BY `day`;")
+	mErr.Append(err)
+
+	m.stmts.getAccessTotal, err = m.db.Prepare(
+		"SELECT COALESCE(SUM(`n`), 0) FROM (" +
+			"SELECT COUNT(`id`) AS `n` FROM `accesses` " +
+			"WHERE `created` >= ? AND `created` < ? AND `bot` BETWEEN ? AND ? " +
+			"AND `shortlink_id` IN (SELECT `id` FROM `shortlinks` WHERE `deleted` = 0) " +
+			"UNION ALL " +
+			"SELECT SUM(`accesses`) FROM `access_rollups` " +
+			"WHERE `created` >= ? AND `created` < ? AND `bot` BETWEEN ? AND ? " +
+			"AND `shortlink_id` IN (SELECT `id` FROM `shortlinks` WHERE `deleted` = 0)" +
+			") AS `counts`;")
+	mErr.Append(err)
+
+	m.stmts.getTopSLs, err = m.db.Prepare(
+		"SELECT `s`.`id`, `s`.`rootlink`, `s`.`shortlink`, `s`.`created`, `s`.`accesses`, `s`.`uniques`, `s`.`edited`, `s`.`expires_at`, `s`.`max_accesses`, `s`.`fallback_url`, `s`.`redirect_type`, `s`.`password_hash`, `c`.`total` FROM (" +
+			"SELECT `shortlink_id`, SUM(`n`) AS `total` FROM (" +
+			"SELECT `shortlink_id`, COUNT(`id`) AS `n` FROM `accesses` " +
+			"WHERE `created` >= ? AND `created` < ? AND `bot` BETWEEN ? AND ? " +
+			"GROUP BY `shortlink_id` " +
+			"UNION ALL " +
+			"SELECT `shortlink_id`, SUM(`accesses`) FROM `access_rollups` " +
+			"WHERE `created` >= ? AND `created` < ? AND `bot` BETWEEN ? AND ? " +
+			"GROUP BY `shortlink_id`" +
+			") AS `counts` GROUP BY `shortlink_id`" +
+			") AS `c` JOIN `shortlinks` AS `s` ON `s`.`id` = `c`.`shortlink_id` " +
+			"WHERE `s`.`deleted` = 0 " +
+			"ORDER BY `c`.`total` DESC, `s`.`id` ASC LIMIT ?;")
+	mErr.Append(err)
+
+	// Short links are idle if neither accesses
+	// nor rollups were recorded since the time.
+	idle := "FROM `shortlinks` WHERE `deleted` = 0 " +
+		"AND `id` NOT IN (SELECT `shortlink_id` FROM `accesses` WHERE `created` >= ? AND `bot` BETWEEN ? AND ?) " +
+		"AND `id` NOT IN (SELECT `shortlink_id` FROM `access_rollups` WHERE `created` >= ? AND `bot` BETWEEN ? AND ?) "
+
+	m.stmts.getIdleSLs, err = m.db.Prepare(
+		"SELECT `id`, `rootlink`, `shortlink`, `created`, `accesses`, `uniques`, `edited`, `expires_at`, `max_accesses`, `fallback_url`, `redirect_type`, `password_hash` " + idle +
+			"ORDER BY `created` ASC, `id` ASC LIMIT ?;")
+	mErr.Append(err)
+
+	m.stmts.countIdleSLs, err = m.db.Prepare(
+		"SELECT COUNT(`id`) " + idle + ";")
+	mErr.Append(err)
+
+	m.stmts.rollUpAccesses, err = m.db.Prepare(
+		"INSERT INTO `access_rollups` " +
+			"(`shortlink_id`, `granularity`, `created`, `bot`, `referrer`, `country`, `utm_source`, `utm_medium`, `utm_campaign`, `accesses`) " +
+			"SELECT `shortlink_id`, 'hour', DATE_FORMAT(`created`, '%Y-%m-%d %H:00:00') AS `hour`, " +
+			"`bot`, `referrer`, `country`, `utm_source`, `utm_medium`, `utm_campaign`, COUNT(`id`) FROM `accesses` " +
+			"WHERE `created` < ? " +
+			"GROUP BY `shortlink_id`, `hour`, `bot`, `referrer`, `country`, `utm_source`, `utm_medium`, `utm_campaign`;")
+	mErr.Append(err)
+
+	m.stmts.rollUpUniques, err = m.db.Prepare(
+		"INSERT INTO `unique_rollups` (`shortlink_id`, `created`, `uniques`) " +
+			"SELECT `shortlink_id`, DATE_FORMAT(`created`, '%Y-%m-%d 00:00:00') AS `day`, COUNT(DISTINCT `visitor`) FROM `accesses` " +
+			"WHERE `created` < ? AND `bot` = 0 AND `visitor` <> '' " +
+			"GROUP BY `shortlink_id`, `day`;")
+	mErr.Append(err)
+
+	m.stmts.rollUpHourly, err = m.db.Prepare(
+		"INSERT INTO `access_rollups` " +
+			"(`shortlink_id`, `granularity`, `created`, `bot`, `referrer`, `country`, `utm_source`, `utm_medium`, `utm_campaign`, `accesses`) " +
+			"SELECT `shortlink_id`, 'day', DATE_FORMAT(`created`, '%Y-%m-%d 00:00:00') AS `day`, " +
+			"`bot`, `referrer`, `country`, `utm_source`, `utm_medium`, `utm_campaign`, SUM(`accesses`) FROM `access_rollups` " +
+			"WHERE `granularity` = 'hour' AND `created` < ? " +
+			"GROUP BY `shortlink_id`, `day`, `bot`, `referrer`, `country`, `utm_source`, `utm_medium`, `utm_campaign`;")
+	mErr.Append(err)
+
+	m.stmts.deleteAccesses, err = m.db.Prepare(
+		"DELETE FROM `accesses` WHERE `created` < ?;")
+	mErr.Append(err)
+
+	m.stmts.deleteHourly, err = m.db.Prepare(
+		"DELETE FROM `access_rollups` WHERE `granularity` = 'hour' AND `created` < ?;")
+	mErr.Append(err)
+
+	m.stmts.deleteDaily, err = m.db.Prepare(
+		"DELETE FROM `access_rollups` WHERE `granularity` = 'day' AND `created` < ?;")
+	mErr.Append(err)
+
+	m.stmts.deleteUniques, err = m.db.Prepare(
+		"DELETE FROM `unique_rollups` WHERE `created` < ?;")
 	mErr.Append(err)
 
 	return mErr.Concat()
@@ -119,9 +412,9 @@ func (m *MySQL) prepStatements() error {
 
 // GetShortLinkCount returns the number of short
 // link entries in the database.
-func (m *MySQL) GetShortLinkCount() (int, error) {
+func (m *MySQL) GetShortLinkCount(ctx context.Context) (int, error) {
 	var i int
-	err := m.stmts.getSLCount.QueryRow().Scan(&i)
+	err := m.stmts.getSLCount.QueryRowContext(ctx).Scan(&i)
 	return i, err
 }
 
@@ -130,14 +423,14 @@ func (m *MySQL) GetShortLinkCount() (int, error) {
 // first (in this order).
 // If no short link was found, no error will be returned and
 // the returned short link object will be nil.
-func (m *MySQL) GetShortLink(id, root, short string) (*shortlink.ShortLink, error) {
+func (m *MySQL) GetShortLink(ctx context.Context, id, root, short string) (*shortlink.ShortLink, error) {
 	switch {
 	case id != "":
-		return m.getShortLinkWithStrategy(id, m.stmts.getSLByID)
+		return m.getShortLinkWithStrategy(ctx, id, m.stmts.getSLByID)
 	case root != "":
-		return m.getShortLinkWithStrategy(root, m.stmts.getSLByRoot)
+		return m.getShortLinkWithStrategy(ctx, root, m.stmts.getSLByRoot)
 	case short != "":
-		return m.getShortLinkWithStrategy(short, m.stmts.getSLByShort)
+		return m.getShortLinkWithStrategy(ctx, short, m.stmts.getSLByShort)
 	default:
 		return nil, nil
 	}
@@ -146,13 +439,8 @@ func (m *MySQL) GetShortLink(id, root, short string) (*shortlink.ShortLink, erro
 // getShortLinkWithStrategy attempts to find a short link object
 // in the database by a given ident which will be passed to a
 // strategy (SQL prepared statement) defined in the arguments.
-func (m *MySQL) getShortLinkWithStrategy(ident string, strategy *sql.Stmt) (*shortlink.ShortLink, error) {
-	var created, edited database.Timestamp
-	sl := new(shortlink.ShortLink)
-
-	err := strategy.QueryRow(ident).Scan(
-		&sl.ID, &sl.RootLink, &sl.ShortLink, &created, &sl.Accesses, &edited)
-
+func (m *MySQL) getShortLinkWithStrategy(ctx context.Context, ident string, strategy *sql.Stmt) (*shortlink.ShortLink, error) {
+	sl, err := scanShortLink(strategy.QueryRowContext(ctx, ident))
 	if err == sql.ErrNoRows {
 		return nil, nil
 	}
@@ -160,74 +448,678 @@ func (m *MySQL) getShortLinkWithStrategy(ident string, strategy *sql.Stmt) (*sho
 		return nil, err
 	}
 
-	mErr := multierror.New(nil)
+	return sl, nil
+}
 
-	sl.Created, err = created.ToTime(timeFormat)
-	mErr.Append(err)
+func (m *MySQL) GetShortLinks(ctx context.Context, from, limit int) ([]*shortlink.ShortLink, error) {
+	rows, err := m.stmts.getSLs.QueryContext(ctx, from, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
 
-	sl.Edited, err = edited.ToTime(timeFormat)
-	mErr.Append(err)
+	sls := make([]*shortlink.ShortLink, 0, limit)
+	for rows.Next() {
+		sl, err := scanShortLink(rows)
+		if err != nil {
+			return nil, err
+		}
+		sls = append(sls, sl)
+	}
 
-	return sl, mErr.Concat()
+	return sls, rows.Err()
 }
 
-func (m *MySQL) GetShortLinks(from, limit int) ([]*shortlink.ShortLink, error) {
-	rows, err := m.stmts.getSLs.Query(from, limit)
-	if err == sql.ErrNoRows {
-		return make([]*shortlink.ShortLink, 0), nil
+// QueryShortLinks returns the list of short links
+// described by the passed query and the total
+// number of short links matching its filters.
+func (m *MySQL) QueryShortLinks(ctx context.Context, q *database.Query) ([]*shortlink.ShortLink, int, error) {
+	b := sqlquery.New(q, dialect)
+
+	var total int
+	err := m.db.QueryRowContext(ctx,
+		"SELECT COUNT(id) FROM shortlinks "+b.Where()+";", b.Args()...).Scan(&total)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	rows, err := m.db.QueryContext(ctx,
+		"SELECT id, rootlink, shortlink, created, accesses, uniques, edited, expires_at, max_accesses, fallback_url, redirect_type, password_hash FROM shortlinks "+
+			b.Page(q)+";", b.Args()...)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer rows.Close()
+
+	sls := make([]*shortlink.ShortLink, 0, q.Limit)
+	for rows.Next() {
+		sl, err := scanShortLink(rows)
+		if err != nil {
+			return nil, 0, err
+		}
+		sls = append(sls, sl)
+	}
+
+	if q.Cursor != nil && q.Cursor.Backward {
+		for i, j := 0, len(sls)-1; i < j; i, j = i+1, j-1 {
+			sls[i], sls[j] = sls[j], sls[i]
+		}
+	}
+
+	return sls, total, rows.Err()
+}
+
+func (m *MySQL) UpdateShortLink(ctx context.Context, id int, updated *shortlink.ShortLink) error {
+	return database.Transaction(ctx, m.db, func(tx *sql.Tx) error {
+		old, err := scanShortLink(tx.StmtContext(ctx, m.stmts.getSLByID).QueryRowContext(ctx, id))
+		if err == sql.ErrNoRows {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+
+		_, err = tx.StmtContext(ctx, m.stmts.updateSLByID).ExecContext(ctx,
+			updated.ShortLink, updated.RootLink, nullTime(updated.ExpiresAt),
+			updated.MaxAccesses, updated.FallbackURL, updated.RedirectType, updated.PasswordHash, id)
+		if err != nil {
+			return conflictError(err, updated.ShortLink)
+		}
+
+		return m.addRevision(ctx, tx, shortlink.ActionEdit, old, updated)
+	})
+}
+
+// IncrementAccesses atomically increases the
+// access count of a short link by n.
+func (m *MySQL) IncrementAccesses(ctx context.Context, id, n int) error {
+	_, err := m.stmts.incrAccesses.ExecContext(ctx, n, id)
+	return err
+}
+
+// IncrementUniques atomically increases the
+// unique visitor count of a short link by n.
+func (m *MySQL) IncrementUniques(ctx context.Context, id, n int) error {
+	_, err := m.stmts.incrUniques.ExecContext(ctx, n, id)
+	return err
+}
+
+func (m *MySQL) CreateShortLink(ctx context.Context, sl *shortlink.ShortLink) (*shortlink.ShortLink, error) {
+	var newSl *shortlink.ShortLink
+
+	err := database.Transaction(ctx, m.db, func(tx *sql.Tx) error {
+		res, err := tx.StmtContext(ctx, m.stmts.insertSL).ExecContext(ctx,
+			sl.RootLink, sl.ShortLink, nullTime(sl.ExpiresAt), sl.MaxAccesses, sl.FallbackURL, sl.RedirectType, sl.PasswordHash)
+		if err != nil {
+			return conflictError(err, sl.ShortLink)
+		}
+
+		id, err := res.LastInsertId()
+		if err != nil {
+			return err
+		}
+
+		newSl, err = scanShortLink(tx.StmtContext(ctx, m.stmts.getSLByID).QueryRowContext(ctx, id))
+		if err != nil {
+			return err
+		}
+
+		return m.addRevision(ctx, tx, shortlink.ActionCreate, &shortlink.ShortLink{ID: newSl.ID}, newSl)
+	})
+	if err != nil {
+		return nil, err
 	}
+
+	return newSl, nil
+}
+
+func (m *MySQL) DeleteShortLink(ctx context.Context, id int) error {
+	return database.Transaction(ctx, m.db, func(tx *sql.Tx) error {
+		old, err := scanShortLink(tx.StmtContext(ctx, m.stmts.getSLByID).QueryRowContext(ctx, id))
+		if err == sql.ErrNoRows {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+
+		if _, err = tx.StmtContext(ctx, m.stmts.deleteSLByID).ExecContext(ctx, id); err != nil {
+			return err
+		}
+
+		return m.addRevision(ctx, tx, shortlink.ActionDelete, old, old)
+	})
+}
+
+// GetTrashedShortLinks returns a list of deleted
+// short links which is ordered by deletion date
+// descending between from index and limit ammount.
+func (m *MySQL) GetTrashedShortLinks(ctx context.Context, from, limit int) ([]*shortlink.Trashed, error) {
+	rows, err := m.stmts.getTrashed.QueryContext(ctx, from, limit)
 	if err != nil {
 		return nil, err
 	}
+	defer rows.Close()
 
-	sls := make([]*shortlink.ShortLink, limit)
-	i := 0
+	sls := make([]*shortlink.Trashed, 0, limit)
 	for rows.Next() {
-		var created, edited database.Timestamp
-		sl := new(shortlink.ShortLink)
-		err = rows.Scan(
-			&sl.ID, &sl.RootLink, &sl.ShortLink, &created, &sl.Accesses, &edited)
+		sl, err := scanTrashed(rows)
 		if err != nil {
 			return nil, err
 		}
+		sls = append(sls, sl)
+	}
+
+	return sls, rows.Err()
+}
+
+// GetTrashedShortLink returns the deleted short
+// link with the passed ID. If no deleted short
+// link was found, nil is returned.
+func (m *MySQL) GetTrashedShortLink(ctx context.Context, id int) (*shortlink.Trashed, error) {
+	sl, err := scanTrashed(m.stmts.getTrashedByID.QueryRowContext(ctx, id))
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	return sl, err
+}
+
+// RestoreShortLink makes the deleted short
+// link with the passed ID available again.
+func (m *MySQL) RestoreShortLink(ctx context.Context, id int) error {
+	return database.Transaction(ctx, m.db, func(tx *sql.Tx) error {
+		old, err := scanTrashed(tx.StmtContext(ctx, m.stmts.getTrashedByID).QueryRowContext(ctx, id))
+		if err == sql.ErrNoRows {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+
+		if _, err = tx.StmtContext(ctx, m.stmts.restoreSLByID).ExecContext(ctx, id); err != nil {
+			return conflictError(err, old.ShortLink.ShortLink)
+		}
+
+		return m.addRevision(ctx, tx, shortlink.ActionRestore, &old.ShortLink, &old.ShortLink)
+	})
+}
+
+// PurgeShortLink permanently removes the deleted
+// short link with the passed ID, its revisions
+// and its accesses from the database.
+func (m *MySQL) PurgeShortLink(ctx context.Context, id int) error {
+	return database.Transaction(ctx, m.db, func(tx *sql.Tx) error {
+		res, err := tx.StmtContext(ctx, m.stmts.purgeSLByID).ExecContext(ctx, id)
+		if err != nil {
+			return err
+		}
+		if n, err := res.RowsAffected(); err != nil || n == 0 {
+			return err
+		}
+		return sqlquery.PurgeData(ctx, tx, dialect, []int{id})
+	})
+}
+
+// PurgeTrash permanently removes all short links
+// which were deleted longer than age ago, their
+// revisions and their accesses and returns the
+// number of removed short links.
+func (m *MySQL) PurgeTrash(ctx context.Context, age time.Duration) (int, error) {
+	var ids []int
+
+	err := database.Transaction(ctx, m.db, func(tx *sql.Tx) error {
+		var err error
+		ids, err = sqlquery.QueryIDs(ctx, tx.StmtContext(ctx, m.stmts.getTrashAfter), int64(age/time.Second))
+		if err != nil || len(ids) == 0 {
+			return err
+		}
+		return sqlquery.Purge(ctx, tx, dialect, ids)
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	return len(ids), nil
+}
+
+// GetRevisions returns all revisions of the short
+// link with the passed ID ordered descending.
+func (m *MySQL) GetRevisions(ctx context.Context, id int) ([]*shortlink.Revision, error) {
+	rows, err := m.stmts.getRevisions.QueryContext(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
 
-		sl.Created, err = created.ToTime(timeFormat)
+	revs := make([]*shortlink.Revision, 0)
+	for rows.Next() {
+		rev, err := scanRevision(rows)
 		if err != nil {
 			return nil, err
 		}
+		revs = append(revs, rev)
+	}
+
+	return revs, rows.Err()
+}
 
-		sl.Edited, err = edited.ToTime(timeFormat)
+// GetRevision returns the revision with the ID rev
+// of the short link with the passed ID. If no such
+// revision was found, nil is returned.
+func (m *MySQL) GetRevision(ctx context.Context, id, rev int) (*shortlink.Revision, error) {
+	r, err := scanRevision(m.stmts.getRevision.QueryRowContext(ctx, id, rev))
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	return r, err
+}
+
+// addRevision records a revision of the passed
+// action changing the short link from before to
+// after in the passed transaction. The actor is
+// taken from ctx.
+func (m *MySQL) addRevision(ctx context.Context, tx *sql.Tx, action string, before, after *shortlink.ShortLink) error {
+	_, err := tx.StmtContext(ctx, m.stmts.insertRevision).ExecContext(ctx,
+		before.ID, action, before.RootLink, before.ShortLink, after.RootLink, after.ShortLink, database.Actor(ctx))
+	return err
+}
+
+// AddAccesses records the passed accesses
+// of short links.
+func (m *MySQL) AddAccesses(ctx context.Context, accesses []*shortlink.Access) error {
+	return database.Transaction(ctx, m.db, func(tx *sql.Tx) error {
+		stmt := tx.StmtContext(ctx, m.stmts.insertAccess)
+		for _, a := range accesses {
+			bot := 0
+			if a.Bot {
+				bot = 1
+			}
+			_, err := stmt.ExecContext(ctx, a.ShortLinkID, a.Time.UTC().Format(timeFormat), a.Referrer, a.Agent, bot, a.Country, a.Visitor,
+				a.Source, a.Medium, a.Name, a.Term, a.Content)
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// GetAccessCounts returns the hourly counts of
+// the accesses of the short link with the passed
+// ID selected by traffic in the range [from, to)
+// ordered ascending. Hours without accesses are
+// omitted.
+func (m *MySQL) GetAccessCounts(ctx context.Context, id int, from, to time.Time, traffic database.Traffic) ([]*shortlink.AccessCount, error) {
+	minBot, maxBot := traffic.BotRange()
+	args := []interface{}{id, from.UTC().Format(timeFormat), to.UTC().Format(timeFormat), minBot, maxBot}
+	rows, err := m.stmts.getAccessCounts.QueryContext(ctx, append(args, args...)...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	counts := make([]*shortlink.AccessCount, 0)
+	for rows.Next() {
+		var hour database.Timestamp
+		c := new(shortlink.AccessCount)
+		if err = rows.Scan(&hour, &c.Count); err != nil {
+			return nil, err
+		}
+		if c.Time, err = hour.ToTime(timeFormat); err != nil {
+			return nil, err
+		}
+		counts = append(counts, c)
+	}
+
+	return counts, rows.Err()
+}
+
+// GetReferrerCounts returns the counts of the
+// accesses of the short link with the passed ID
+// selected by traffic in the range [from, to)
+// per referrer host. The limit referrers
+// with the most accesses are returned ordered by
+// count descending and referrer ascending.
+func (m *MySQL) GetReferrerCounts(ctx context.Context, id int, from, to time.Time, traffic database.Traffic, limit int) ([]*shortlink.ReferrerCount, error) {
+	minBot, maxBot := traffic.BotRange()
+	args := []interface{}{id, from.UTC().Format(timeFormat), to.UTC().Format(timeFormat), minBot, maxBot}
+	rows, err := m.stmts.getReferrerCounts.QueryContext(ctx, append(append(args, args...), limit)...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	counts := make([]*shortlink.ReferrerCount, 0, limit)
+	for rows.Next() {
+		c := new(shortlink.ReferrerCount)
+		if err = rows.Scan(&c.Referrer, &c.Count); err != nil {
+			return nil, err
+		}
+		counts = append(counts, c)
+	}
+
+	return counts, rows.Err()
+}
+
+// GetCampaignCounts returns the counts of the
+// accesses of the short link with the passed ID
+// selected by traffic in the range [from, to) per
+// UTM source, medium and campaign.
+// Accesses without these parameters are not counted.
+// The limit campaigns with the most accesses are
+// returned ordered by count descending and source,
+// medium and campaign ascending.
+func (m *MySQL) GetCampaignCounts(ctx context.Context, id int, from, to time.Time, traffic database.Traffic, limit int) ([]*shortlink.CampaignCount, error) {
+	minBot, maxBot := traffic.BotRange()
+	args := []interface{}{id, from.UTC().Format(timeFormat), to.UTC().Format(timeFormat), minBot, maxBot}
+	rows, err := m.stmts.getCampaignCounts.QueryContext(ctx, append(append(args, args...), limit)...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	counts := make([]*shortlink.CampaignCount, 0, limit)
+	for rows.Next() {
+		c := new(shortlink.CampaignCount)
+		if err = rows.Scan(&c.Source, &c.Medium, &c.Name, &c.Count); err != nil {
+			return nil, err
+		}
+		counts = append(counts, c)
+	}
+
+	return counts, rows.Err()
+}
+
+// GetCountryCounts returns the counts of the
+// accesses of the short link with the passed ID
+// selected by traffic in the range [from, to)
+// per country code. Accesses with unresolved
+// country are counted with an empty code. The
+// limit countries with the most accesses are
+// returned ordered by count descending and
+// country ascending.
+func (m *MySQL) GetCountryCounts(ctx context.Context, id int, from, to time.Time, traffic database.Traffic, limit int) ([]*shortlink.CountryCount, error) {
+	minBot, maxBot := traffic.BotRange()
+	args := []interface{}{id, from.UTC().Format(timeFormat), to.UTC().Format(timeFormat), minBot, maxBot}
+	rows, err := m.stmts.getCountryCounts.QueryContext(ctx, append(append(args, args...), limit)...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	counts := make([]*shortlink.CountryCount, 0, limit)
+	for rows.Next() {
+		c := new(shortlink.CountryCount)
+		if err = rows.Scan(&c.Country, &c.Count); err != nil {
+			return nil, err
+		}
+		counts = append(counts, c)
+	}
+
+	return counts, rows.Err()
+}
+
+// GetUniqueCounts returns the daily counts of the
+// unique human visitors of the short link with the
+// passed ID in the range [from, to) ordered
+// ascending. Days without visitors are omitted.
+func (m *MySQL) GetUniqueCounts(ctx context.Context, id int, from, to time.Time) ([]*shortlink.AccessCount, error) {
+	args := []interface{}{id, from.UTC().Format(timeFormat), to.UTC().Format(timeFormat)}
+	rows, err := m.stmts.getUniqueCounts.QueryContext(ctx, append(args, args...)...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	counts := make([]*shortlink.AccessCount, 0)
+	for rows.Next() {
+		var day database.Timestamp
+		c := new(shortlink.AccessCount)
+		if err = rows.Scan(&day, &c.Count); err != nil {
+			return nil, err
+		}
+		if c.Time, err = day.ToTime(timeFormat); err != nil {
+			return nil, err
+		}
+		counts = append(counts, c)
+	}
+
+	return counts, rows.Err()
+}
+
+// GetAccessTotal returns the number of accesses
+// of all short links selected by traffic in the
+// range [from, to). Accesses of deleted short
+// links are not counted.
+func (m *MySQL) GetAccessTotal(ctx context.Context, from, to time.Time, traffic database.Traffic) (int, error) {
+	minBot, maxBot := traffic.BotRange()
+	args := []interface{}{from.UTC().Format(timeFormat), to.UTC().Format(timeFormat), minBot, maxBot}
+
+	var total int
+	err := m.stmts.getAccessTotal.QueryRowContext(ctx, append(args, args...)...).Scan(&total)
+	return total, err
+}
+
+// GetTopShortLinks returns the limit short links
+// with the most accesses selected by traffic in
+// the range [from, to) together with their number
+// ordered by count descending and ID ascending.
+// Short links without accesses are omitted.
+func (m *MySQL) GetTopShortLinks(ctx context.Context, from, to time.Time, traffic database.Traffic, limit int) ([]*shortlink.ShortLinkCount, error) {
+	minBot, maxBot := traffic.BotRange()
+	args := []interface{}{from.UTC().Format(timeFormat), to.UTC().Format(timeFormat), minBot, maxBot}
+	rows, err := m.stmts.getTopSLs.QueryContext(ctx, append(append(args, args...), limit)...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	counts := make([]*shortlink.ShortLinkCount, 0, limit)
+	for rows.Next() {
+		var n int
+		sl, err := scanShortLink(rows, &n)
 		if err != nil {
 			return nil, err
 		}
+		counts = append(counts, &shortlink.ShortLinkCount{ShortLink: *sl, Count: n})
+	}
+
+	return counts, rows.Err()
+}
 
-		sls[i] = sl
-		i++
+// GetIdleShortLinks returns the limit short links
+// without accesses selected by traffic since the
+// passed time ordered by created date ascending
+// and the total number of such short links.
+func (m *MySQL) GetIdleShortLinks(ctx context.Context, since time.Time, traffic database.Traffic, limit int) ([]*shortlink.ShortLink, int, error) {
+	minBot, maxBot := traffic.BotRange()
+	args := []interface{}{since.UTC().Format(timeFormat), minBot, maxBot}
+	args = append(args, args...)
+
+	var total int
+	if err := m.stmts.countIdleSLs.QueryRowContext(ctx, args...).Scan(&total); err != nil {
+		return nil, 0, err
 	}
 
-	if i < limit {
-		sls = sls[:i]
+	rows, err := m.stmts.getIdleSLs.QueryContext(ctx, append(args, limit)...)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer rows.Close()
+
+	sls := make([]*shortlink.ShortLink, 0, limit)
+	for rows.Next() {
+		sl, err := scanShortLink(rows)
+		if err != nil {
+			return nil, 0, err
+		}
+		sls = append(sls, sl)
 	}
 
-	return sls, nil
+	return sls, total, rows.Err()
 }
 
-func (m *MySQL) UpdateShortLink(id int, updated *shortlink.ShortLink) error {
-	_, err := m.stmts.updateSLByID.Exec(updated.ShortLink, updated.RootLink, updated.Accesses, id)
-	return err
+// RollUpAccesses aggregates the accesses recorded
+// before the passed time into hourly rollups and
+// the unique visitors into daily rollups, removes
+// the aggregated accesses and returns their number.
+// before should be the start of a day, so that the
+// visitors of a day are not split.
+func (m *MySQL) RollUpAccesses(ctx context.Context, before time.Time) (int, error) {
+	var n int64
+
+	err := database.Transaction(ctx, m.db, func(tx *sql.Tx) error {
+		b := before.UTC().Format(timeFormat)
+		if _, err := tx.StmtContext(ctx, m.stmts.rollUpAccesses).ExecContext(ctx, b); err != nil {
+			return err
+		}
+		if _, err := tx.StmtContext(ctx, m.stmts.rollUpUniques).ExecContext(ctx, b); err != nil {
+			return err
+		}
+
+		res, err := tx.StmtContext(ctx, m.stmts.deleteAccesses).ExecContext(ctx, b)
+		if err != nil {
+			return err
+		}
+		n, err = res.RowsAffected()
+		return err
+	})
+
+	return int(n), err
+}
+
+// RollUpHourlyAccesses aggregates the hourly rollups
+// before the passed time into daily rollups, removes
+// the aggregated hourly rollups and returns their
+// number.
+func (m *MySQL) RollUpHourlyAccesses(ctx context.Context, before time.Time) (int, error) {
+	var n int64
+
+	err := database.Transaction(ctx, m.db, func(tx *sql.Tx) error {
+		b := before.UTC().Format(timeFormat)
+		if _, err := tx.StmtContext(ctx, m.stmts.rollUpHourly).ExecContext(ctx, b); err != nil {
+			return err
+		}
+
+		res, err := tx.StmtContext(ctx, m.stmts.deleteHourly).ExecContext(ctx, b)
+		if err != nil {
+			return err
+		}
+		n, err = res.RowsAffected()
+		return err
+	})
+
+	return int(n), err
+}
+
+// PurgeDailyAccesses removes the daily rollups
+// before the passed time and returns their number.
+func (m *MySQL) PurgeDailyAccesses(ctx context.Context, before time.Time) (int, error) {
+	var n int64
+
+	err := database.Transaction(ctx, m.db, func(tx *sql.Tx) error {
+		b := before.UTC().Format(timeFormat)
+		res, err := tx.StmtContext(ctx, m.stmts.deleteDaily).ExecContext(ctx, b)
+		if err != nil {
+			return err
+		}
+		if n, err = res.RowsAffected(); err != nil {
+			return err
+		}
+
+		_, err = tx.StmtContext(ctx, m.stmts.deleteUniques).ExecContext(ctx, b)
+		return err
+	})
+
+	return int(n), err
+}
+
+// scanner is implemented by *sql.Row
+// and *sql.Rows.
+type scanner interface {
+	Scan(dest ...interface{}) error
+}
+
+// scanTrashed scans a deleted short link
+// including its deletion date from s.
+func scanTrashed(s scanner) (*shortlink.Trashed, error) {
+	var deleted database.Timestamp
+
+	sl, err := scanShortLink(s, &deleted)
+	if err != nil {
+		return nil, err
+	}
+
+	t := &shortlink.Trashed{ShortLink: *sl}
+	t.Deleted, err = deleted.ToTime(timeFormat)
+
+	return t, err
+}
+
+// scanShortLink scans a short link from s
+// and the following columns into extra.
+func scanShortLink(s scanner, extra ...interface{}) (*shortlink.ShortLink, error) {
+	var created, edited, expires database.Timestamp
+	sl := new(shortlink.ShortLink)
+
+	err := s.Scan(append([]interface{}{
+		&sl.ID, &sl.RootLink, &sl.ShortLink, &created, &sl.Accesses, &sl.Uniques, &edited,
+		&expires, &sl.MaxAccesses, &sl.FallbackURL, &sl.RedirectType, &sl.PasswordHash}, extra...)...)
+	if err != nil {
+		return nil, err
+	}
+
+	mErr := multierror.New(nil)
+
+	sl.Created, err = created.ToTime(timeFormat)
+	mErr.Append(err)
+
+	sl.Edited, err = edited.ToTime(timeFormat)
+	mErr.Append(err)
+
+	// The expiration date is NULL
+	// if it is not set.
+	if expires != nil {
+		t, err := expires.ToTime(timeFormat)
+		mErr.Append(err)
+		sl.ExpiresAt = &t
+	}
+
+	return sl, mErr.Concat()
 }
 
-func (m *MySQL) CreateShortLink(sl *shortlink.ShortLink) (*shortlink.ShortLink, error) {
-	_, err := m.stmts.insertSL.Exec(sl.RootLink, sl.ShortLink)
+// nullTime returns the passed time as text in
+// UTC or nil, if t is nil.
+func nullTime(t *time.Time) interface{} {
+	if t == nil {
+		return nil
+	}
+	return t.UTC().Format(timeFormat)
+}
+
+// scanRevision scans a revision from s.
+func scanRevision(s scanner) (*shortlink.Revision, error) {
+	var created database.Timestamp
+	r := new(shortlink.Revision)
+
+	err := s.Scan(
+		&r.ID, &r.ShortLinkID, &r.Action, &r.OldRootLink, &r.OldShortLink,
+		&r.NewRootLink, &r.NewShortLink, &r.Actor, &created)
 	if err != nil {
 		return nil, err
 	}
 
-	newSl, err := m.GetShortLink("", "", sl.ShortLink)
-	return newSl, err
+	r.Created, err = created.ToTime(timeFormat)
+	return r, err
 }
 
-func (m *MySQL) DeleteShortLink(id int) error {
-	_, err := m.stmts.deleteSLByID.Exec(id)
+// conflictError returns a database.ConflictError
+// for the passed short identifier if err is a
+// violation of the unique short link index.
+// Otherwise, err is returned.
+func conflictError(err error, short string) error {
+	if e, ok := err.(*mysql.MySQLError); ok && e.Number == errDupEntry {
+		return &database.ConflictError{ShortLink: short}
+	}
 	return err
 }
@@ -0,0 +1,1093 @@
+package postgres
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"net/url"
+	"strconv"
+	"time"
+
+	// PostgreSQL driver import
+	"github.com/lib/pq"
+	"github.com/zekroTJA/slms/internal/database"
+	"github.com/zekroTJA/slms/internal/database/migration"
+	"github.com/zekroTJA/slms/internal/database/sqlquery"
+	"github.com/zekroTJA/slms/internal/shortlink"
+	"github.com/zekroTJA/slms/pkg/multierror"
+)
+
+// Postgres maintains the connection
+// to a PostgreSQL database.
+type Postgres struct {
+	db       *sql.DB
+	stmts    *prepStmts
+	migrator *migration.Migrator
+}
+
+// uniqueViolation is the PostgreSQL error code
+// of unique constraint violations.
+const uniqueViolation = "23505"
+
+// dialect is the SQL dialect of PostgreSQL
+// for dynamically built queries.
+var dialect = sqlquery.Dialect{
+	Dollar: true,
+	Like:   "ILIKE",
+}
+
+type prepStmts struct {
+	getSLCount   *sql.Stmt
+	getSLByID    *sql.Stmt
+	getSLs       *sql.Stmt
+	getSLByRoot  *sql.Stmt
+	getSLByShort *sql.Stmt
+	updateSLByID *sql.Stmt
+	incrAccesses *sql.Stmt
+	incrUniques  *sql.Stmt
+	insertSL     *sql.Stmt
+	deleteSLByID *sql.Stmt
+
+	getTrashed     *sql.Stmt
+	getTrashedByID *sql.Stmt
+	restoreSLByID  *sql.Stmt
+	purgeSLByID    *sql.Stmt
+	getTrashAfter  *sql.Stmt
+
+	insertRevision *sql.Stmt
+	getRevisions   *sql.Stmt
+	getRevision    *sql.Stmt
+
+	insertAccess      *sql.Stmt
+	getAccessCounts   *sql.Stmt
+	getReferrerCounts *sql.Stmt
+	getCampaignCounts *sql.Stmt
+	getCountryCounts  *sql.Stmt
+	getUniqueCounts   *sql.Stmt
+	getAccessTotal    *sql.Stmt
+	getTopSLs         *sql.Stmt
+	getIdleSLs        *sql.Stmt
+	countIdleSLs      *sql.Stmt
+
+	rollUpAccesses *sql.Stmt
+	rollUpUniques  *sql.Stmt
+	rollUpHourly   *sql.Stmt
+	deleteAccesses *sql.Stmt
+	deleteHourly   *sql.Stmt
+	deleteDaily    *sql.Stmt
+	deleteUniques  *sql.Stmt
+}
+
+// Config contains the configuration
+// for a PostgreSQL database connection.
+type Config struct {
+	Host     string `json:"host"`
+	Username string `json:"username"`
+	Password string `json:"password"`
+	Database string `json:"database"`
+	SSLMode  string `json:"ssl_mode"`
+}
+
+// Open attempts to stablishes a
+// connection to a PostgreSQL database,
+// applies pending schema migrations
+// and prepares all statements.
+func (p *Postgres) Open(cfg interface{}) error {
+	if err := p.Connect(cfg); err != nil {
+		return err
+	}
+
+	if _, err := p.migrator.Up(); err != nil {
+		return err
+	}
+
+	return p.prepStatements()
+}
+
+// Connect attempts to stablishes a
+// connection to a PostgreSQL database
+// without touching the schema.
+func (p *Postgres) Connect(cfg interface{}) error {
+	var err error
+
+	conf, ok := cfg.(*Config)
+	if !ok {
+		return errors.New("cfg is not type of postgres.Config")
+	}
+
+	sslMode := conf.SSLMode
+	if sslMode == "" {
+		sslMode = "require"
+	}
+
+	dsn := &url.URL{
+		Scheme:   "postgres",
+		User:     url.UserPassword(conf.Username, conf.Password),
+		Host:     conf.Host,
+		Path:     conf.Database,
+		RawQuery: url.Values{"sslmode": {sslMode}}.Encode(),
+	}
+
+	if p.db, err = sql.Open("postgres", dsn.String()); err != nil {
+		return err
+	}
+
+	p.migrator = migration.New(p.db, migration.PlaceholderDollar, migrations)
+
+	return nil
+}
+
+// Migrator returns the schema migrator
+// of the database connection.
+func (p *Postgres) Migrator() *migration.Migrator {
+	return p.migrator
+}
+
+// Close cleanly closes the
+// database connection.
+func (p *Postgres) Close() {
+	p.db.Close()
+}
+
+func (p *Postgres) prepStatements() error {
+	var err error
+	mErr := multierror.New(nil)
+
+	p.stmts = new(prepStmts)
+
+	p.stmts.getSLCount, err = p.db.Prepare(
+		"SELECT COUNT(id) FROM shortlinks WHERE deleted = 0;")
+	mErr.Append(err)
+
+	p.stmts.getSLByID, err = p.db.Prepare(
+		"SELECT id, rootlink, shortlink, created, accesses, uniques, edited, expires_at, max_accesses, fallback_url, redirect_type, password_hash FROM shortlinks " +
+			"WHERE deleted = 0 AND id = $1;")
+	mErr.Append(err)
+
+	p.stmts.getSLs, err = p.db.Prepare(
+		"SELECT id, rootlink, shortlink, created, accesses, uniques, edited, expires_at, max_accesses, fallback_url, redirect_type, password_hash FROM shortlinks " +
+			"WHERE deleted = 0 " +
+			"ORDER BY created DESC, id DESC " +
+			"OFFSET $1 LIMIT $2;")
+	mErr.Append(err)
+
+	p.stmts.getSLByRoot, err = p.db.Prepare(
+		"SELECT id, rootlink, shortlink, created, accesses, uniques, edited, expires_at, max_accesses, fallback_url, redirect_type, password_hash FROM shortlinks " +
+			"WHERE deleted = 0 AND rootlink = $1;")
+	mErr.Append(err)
+
+	p.stmts.getSLByShort, err = p.db.Prepare(
+		"SELECT id, rootlink, shortlink, created, accesses, uniques, edited, expires_at, max_accesses, fallback_url, redirect_type, password_hash FROM shortlinks " +
+			"WHERE deleted = 0 AND shortlink = $1;")
+	mErr.Append(err)
+
+	p.stmts.updateSLByID, err = p.db.Prepare(
+		"UPDATE shortlinks SET shortlink = $1, rootlink = $2, " +
+			"expires_at = $3, max_accesses = $4, fallback_url = $5, redirect_type = $6, " +
+			"password_hash = $7, edited = NOW() " +
+			"WHERE id = $8;")
+	mErr.Append(err)
+
+	p.stmts.incrAccesses, err = p.db.Prepare(
+		"UPDATE shortlinks SET accesses = accesses + $1 " +
+			"WHERE id = $2;")
+	mErr.Append(err)
+
+	p.stmts.incrUniques, err = p.db.Prepare(
+		"UPDATE shortlinks SET uniques = uniques + $1 " +
+			"WHERE id = $2;")
+	mErr.Append(err)
+
+	p.stmts.insertSL, err = p.db.Prepare(
+		"INSERT INTO shortlinks (rootlink, shortlink, expires_at, max_accesses, fallback_url, redirect_type, password_hash) " +
+			"VALUES ($1, $2, $3, $4, $5, $6, $7) " +
+			"RETURNING id, rootlink, shortlink, created, accesses, uniques, edited, expires_at, max_accesses, fallback_url, redirect_type, password_hash;")
+	mErr.Append(err)
+
+	p.stmts.deleteSLByID, err = p.db.Prepare(
+		"UPDATE shortlinks SET deleted = 1, deleted_at = NOW() " +
+			"WHERE deleted = 0 AND id = $1;")
+	mErr.Append(err)
+
+	p.stmts.getTrashed, err = p.db.Prepare(
+		"SELECT id, rootlink, shortlink, created, accesses, uniques, edited, expires_at, max_accesses, fallback_url, redirect_type, password_hash, deleted_at FROM shortlinks " +
+			"WHERE deleted = 1 " +
+			"ORDER BY deleted_at DESC, id DESC " +
+			"OFFSET $1 LIMIT $2;")
+	mErr.Append(err)
+
+	p.stmts.getTrashedByID, err = p.db.Prepare(
+		"SELECT id, rootlink, shortlink, created, accesses, uniques, edited, expires_at, max_accesses, fallback_url, redirect_type, password_hash, deleted_at FROM shortlinks " +
+			"WHERE deleted = 1 AND id = $1;")
+	mErr.Append(err)
+
+	p.stmts.restoreSLByID, err = p.db.Prepare(
+		"UPDATE shortlinks SET deleted = 0, deleted_at = NULL " +
+			"WHERE deleted = 1 AND id = $1;")
+	mErr.Append(err)
+
+	p.stmts.purgeSLByID, err = p.db.Prepare(
+		"DELETE FROM shortlinks WHERE deleted = 1 AND id = $1;")
+	mErr.Append(err)
+
+	// The selected rows are locked, so that they
+	// can not be restored until they are purged.
+	p.stmts.getTrashAfter, err = p.db.Prepare(
+		"SELECT id FROM shortlinks WHERE deleted = 1 AND deleted_at < NOW() - make_interval(secs => $1) FOR UPDATE;")
+	mErr.Append(err)
+
+	p.stmts.insertRevision, err = p.db.Prepare(
+		"INSERT INTO revisions " +
+			"(shortlink_id, action, old_rootlink, old_shortlink, new_rootlink, new_shortlink, actor) " +
+			"VALUES ($1, $2, $3, $4, $5, $6, $7);")
+	mErr.Append(err)
+
+	p.stmts.getRevisions, err = p.db.Prepare(
+		"SELECT id, shortlink_id, action, old_rootlink, old_shortlink, " +
+			"new_rootlink, new_shortlink, actor, created FROM revisions " +
+			"WHERE shortlink_id = $1 " +
+			"ORDER BY id DESC;")
+	mErr.Append(err)
+
+	p.stmts.getRevision, err = p.db.Prepare(
+		"SELECT id, shortlink_id, action, old_rootlink, old_shortlink, " +
+			"new_rootlink, new_shortlink, actor, created FROM revisions " +
+			"WHERE shortlink_id = $1 AND id = $2;")
+	mErr.Append(err)
+
+	p.stmts.insertAccess, err = p.db.Prepare(
+		"INSERT INTO accesses " +
+			"(shortlink_id, created, referrer, agent, bot, country, visitor, utm_source, utm_medium, utm_campaign, utm_term, utm_content) " +
+			"VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12);")
+	mErr.Append(err)
+
+	// The stats queries count the accesses and add
+	// the rollups of the same range, which are dated
+	// to the start of their hour or day.
+	p.stmts.getAccessCounts, err = p.db.Prepare(
+		"SELECT hour, SUM(n) FROM (" +
+			"SELECT date_trunc('hour', created AT TIME ZONE 'UTC') AS hour, COUNT(id) AS n FROM accesses " +
+			"WHERE shortlink_id = $1 AND created >= $2 AND created < $3 AND bot BETWEEN $4 AND $5 " +
+			"GROUP BY hour " +
+			"UNION ALL " +
+			"SELECT created AT TIME ZONE 'UTC', SUM(accesses) FROM access_rollups " +
+			"WHERE shortlink_id = $1 AND created >= $2 AND created < $3 AND bot BETWEEN $4 AND $5 " +
+			"GROUP BY created" +
+			") AS counts GROUP BY hour ORDER BY hour;")
+	mErr.Append(err)
+
+	p.stmts.getReferrerCounts, err = p.db.Prepare(
+		"SELECT referrer, SUM(n) AS total FROM (" +
+			"SELECT referrer, COUNT(id) AS n FROM accesses " +
+			"WHERE shortlink_id = $1 AND created >= $2 AND created < $3 AND bot BETWEEN $4 AND $5 " +
+			"GROUP BY referrer " +
+			"UNION ALL " +
+			"SELECT referrer, SUM(accesses) FROM access_rollups " +
+			"WHERE shortlink_id = $1 AND created >= $2 AND created < $3 AND bot BETWEEN $4 AND $5 " +
+			"GROUP BY referrer" +
+			") AS counts GROUP BY referrer ORDER BY total DESC, referrer ASC LIMIT $6;")
+	mErr.Append(err)
+
+	p.stmts.getCampaignCounts, err = p.db.Prepare(
+		"SELECT utm_source, utm_medium, utm_campaign, SUM(n) AS total FROM (" +
+			"SELECT utm_source, utm_medium, utm_campaign, COUNT(id) AS n FROM accesses " +
+			"WHERE shortlink_id = $1 AND created >= $2 AND created < $3 AND bot BETWEEN $4 AND $5 " +
+			"AND (utm_source <> '' OR utm_medium <> '' OR utm_campaign <> '') " +
+			"GROUP BY utm_source, utm_medium, utm_campaign " +
+			"UNION ALL " +
+			"SELECT utm_source, utm_medium, utm_campaign, SUM(accesses) FROM access_rollups " +
+			"WHERE shortlink_id = $1 AND created >= $2 AND created < $3 AND bot BETWEEN $4 AND $5 " +
+			"AND (utm_source <> '' OR utm_medium <> '' OR utm_campaign <> '') " +
+			"GROUP BY utm_source, utm_medium, utm_campaign" +
+			") AS counts GROUP BY utm_source, utm_medium, utm_campaign " +
+			"ORDER BY total DESC, utm_source, utm_medium, utm_campaign LIMIT $6;")
+	mErr.Append(err)
+
+	p.stmts.getCountryCounts, err = p.db.Prepare(
+		"SELECT country, SUM(n) AS total FROM (" +
+			"SELECT country, COUNT(id) AS n FROM accesses " +
+			"WHERE shortlink_id = $1 AND created >= $2 AND created < $3 AND bot BETWEEN $4 AND $5 " +
+			"GROUP BY country " +
+			"UNION ALL " +
+			"SELECT country, SUM(accesses) FROM access_rollups " +
+			"WHERE shortlink_id = $1 AND created >= $2 AND created < $3 AND bot BETWEEN $4 AND $5 " +
+			"GROUP BY country" +
+			") AS counts GROUP BY country ORDER BY total DESC, country ASC LIMIT $6;")
+	mErr.Append(err)
+
+	p.stmts.getUniqueCounts, err = p.db.Prepare(
+		"SELECT day, SUM(n) FROM (" +
+			"SELECT date_trunc('day', created AT TIME ZONE 'UTC') AS day, COUNT(DISTINCT visitor) AS n FROM accesses " +
+			"WHERE shortlink_id = $1 AND created >= $2 AND created < $3 AND bot = 0 AND visitor <> '' " +
+			"GROUP BY day " +
+			"UNION ALL " +
+			"SELECT created AT TIME ZONE 'UTC', uniques FROM unique_rollups " +
+			"WHERE shortlink_id = $1 AND created >= $2 AND created < $3" +
+			") AS counts GROUP BY day ORDER BY day;")
+	mErr.Append(err)
+
+	p.stmts.getAccessTotal, err = p.db.Prepare(
+		"SELECT COALESCE(SUM(n), 0) FROM (" +
+			"SELECT COUNT(id) AS n FROM accesses " +
+			"WHERE created >= $1 AND created < $2 AND bot BETWEEN $3 AND $4 " +
+			"AND shortlink_id IN (SELECT id FROM shortlinks WHERE deleted = 0) " +
+			"UNION ALL " +
+			"SELECT SUM(accesses) FROM access_rollups " +
+			"WHERE created >= $1 AND created < $2 AND bot BETWEEN $3 AND $4 " +
+			"AND shortlink_id IN (SELECT id FROM shortlinks WHERE deleted = 0)" +
+			") AS counts;")
+	mErr.Append(err)
+
+	p.stmts.getTopSLs, err = p.db.Prepare(
+		"SELECT s.id, s.rootlink, s.shortlink, s.created, s.accesses, s.uniques, s.edited, s.expires_at, s.max_accesses, s.fallback_url, s.redirect_type, s.password_hash, c.total FROM (" +
+			"SELECT shortlink_id, SUM(n) AS total FROM (" +
+			"SELECT shortlink_id, COUNT(id) AS n FROM accesses " +
+			"WHERE created >= $1 AND created < $2 AND bot BETWEEN $3 AND $4 " +
+			"GROUP BY shortlink_id " +
+			"UNION ALL " +
+			"SELECT shortlink_id, SUM(accesses) FROM access_rollups " +
+			"WHERE created >= $1 AND created < $2 AND bot BETWEEN $3 AND $4 " +
+			"GROUP BY shortlink_id" +
+			") AS counts GROUP BY shortlink_id" +
+			") AS c JOIN shortlinks AS s ON s.id = c.shortlink_id " +
+			"WHERE s.deleted = 0 " +
+			"ORDER BY c.total DESC, s.id ASC LIMIT $5;")
+	mErr.Append(err)
+
+	// Short links are idle if neither accesses
+	// nor rollups were recorded since the time.
+	idle := "FROM shortlinks WHERE deleted = 0 " +
+		"AND id NOT IN (SELECT shortlink_id FROM accesses WHERE created >= $1 AND bot BETWEEN $2 AND $3) " +
+		"AND id NOT IN (SELECT shortlink_id FROM access_rollups WHERE created >= $1 AND bot BETWEEN $2 AND $3) "
+
+	p.stmts.getIdleSLs, err = p.db.Prepare(
+		"SELECT id, rootlink, shortlink, created, accesses, uniques, edited, expires_at, max_accesses, fallback_url, redirect_type, password_hash " + idle +
+			"ORDER BY created ASC, id ASC LIMIT $4;")
+	mErr.Append(err)
+
+	p.stmts.countIdleSLs, err = p.db.Prepare(
+		"SELECT COUNT(id) " + idle + ";")
+	mErr.Append(err)
+
+	p.stmts.rollUpAccesses, err = p.db.Prepare(
+		"INSERT INTO access_rollups " +
+			"(shortlink_id, granularity, created, bot, referrer, country, utm_source, utm_medium, utm_campaign, accesses) " +
+			"SELECT shortlink_id, 'hour', date_trunc('hour', created AT TIME ZONE 'UTC') AT TIME ZONE 'UTC' AS hour, " +
+			"bot, referrer, country, utm_source, utm_medium, utm_campaign, COUNT(id) FROM accesses " +
+			"WHERE created < $1 " +
+			"GROUP BY shortlink_id, hour, bot, referrer, country, utm_source, utm_medium, utm_campaign;")
+	mErr.Append(err)
+
+	p.stmts.rollUpUniques, err = p.db.Prepare(
+		"INSERT INTO unique_rollups (shortlink_id, created, uniques) " +
+			"SELECT shortlink_id, date_trunc('day', created AT TIME ZONE 'UTC') AT TIME ZONE 'UTC' AS day, COUNT(DISTINCT visitor) FROM accesses " +
+			"WHERE created < $1 AND bot = 0 AND visitor <> '' " +
+			"GROUP BY shortlink_id, day;")
+	mErr.Append(err)
+
+	p.stmts.rollUpHourly, err = p.db.Prepare(
+		"INSERT INTO access_rollups " +
+			"(shortlink_id, granularity, created, bot, referrer, country, utm_source, utm_medium, utm_campaign, accesses) " +
+			"SELECT shortlink_id, 'day', date_trunc('day', created AT TIME ZONE 'UTC') AT TIME ZONE 'UTC' AS day, " +
+			"bot, referrer, country, utm_source, utm_medium, utm_campaign, SUM(accesses) FROM access_rollups " +
+			"WHERE granularity = 'hour' AND created < $1 " +
+			"GROUP BY shortlink_id, day, bot, referrer, country, utm_source, utm_medium, utm_campaign;")
+	mErr.Append(err)
+
+	p.stmts.deleteAccesses, err = p.db.Prepare(
+		"DELETE FROM accesses WHERE created < $1;")
+	mErr.Append(err)
+
+	p.stmts.deleteHourly, err = p.db.Prepare(
+		"DELETE FROM access_rollups WHERE granularity = 'hour' AND created < $1;")
+	mErr.Append(err)
+
+	p.stmts.deleteDaily, err = p.db.Prepare(
+		"DELETE FROM access_rollups WHERE granularity = 'day' AND created < $1;")
+	mErr.Append(err)
+
+	p.stmts.deleteUniques, err = p.db.Prepare(
+		"DELETE FROM unique_rollups WHERE created < $1;")
+	mErr.Append(err)
+
+	return mErr.Concat()
+}
+
+// GetShortLinkCount returns the number of short
+// link entries in the database.
+func (p *Postgres) GetShortLinkCount(ctx context.Context) (int, error) {
+	var i int
+	err := p.stmts.getSLCount.QueryRowContext(ctx).Scan(&i)
+	return i, err
+}
+
+// GetShortLink gets a short link object from database by
+// id, root link or short link, depending on which was passed
+// first (in this order).
+// If no short link was found, no error will be returned and
+// the returned short link object will be nil.
+func (p *Postgres) GetShortLink(ctx context.Context, id, root, short string) (*shortlink.ShortLink, error) {
+	switch {
+	case id != "":
+		// Other than MySQL, PostgreSQL does not cast
+		// non-numeric strings silently for integer
+		// comparison, so they are filtered out here.
+		iid, err := strconv.Atoi(id)
+		if err != nil {
+			return nil, nil
+		}
+		return p.getShortLinkWithStrategy(ctx, iid, p.stmts.getSLByID)
+	case root != "":
+		return p.getShortLinkWithStrategy(ctx, root, p.stmts.getSLByRoot)
+	case short != "":
+		return p.getShortLinkWithStrategy(ctx, short, p.stmts.getSLByShort)
+	default:
+		return nil, nil
+	}
+}
+
+// getShortLinkWithStrategy attempts to find a short link object
+// in the database by a given ident which will be passed to a
+// strategy (SQL prepared statement) defined in the arguments.
+func (p *Postgres) getShortLinkWithStrategy(ctx context.Context, ident interface{}, strategy *sql.Stmt) (*shortlink.ShortLink, error) {
+	sl, err := scanShortLink(strategy.QueryRowContext(ctx, ident))
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return sl, nil
+}
+
+// GetShortLinks returns a list of short links which
+// is ordered by created date descending between
+// from index and limit ammount.
+func (p *Postgres) GetShortLinks(ctx context.Context, from, limit int) ([]*shortlink.ShortLink, error) {
+	rows, err := p.stmts.getSLs.QueryContext(ctx, from, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	sls := make([]*shortlink.ShortLink, 0, limit)
+	for rows.Next() {
+		sl, err := scanShortLink(rows)
+		if err != nil {
+			return nil, err
+		}
+		sls = append(sls, sl)
+	}
+
+	return sls, rows.Err()
+}
+
+// QueryShortLinks returns the list of short links
+// described by the passed query and the total
+// number of short links matching its filters.
+func (p *Postgres) QueryShortLinks(ctx context.Context, q *database.Query) ([]*shortlink.ShortLink, int, error) {
+	b := sqlquery.New(q, dialect)
+
+	var total int
+	err := p.db.QueryRowContext(ctx,
+		"SELECT COUNT(id) FROM shortlinks "+b.Where()+";", b.Args()...).Scan(&total)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	rows, err := p.db.QueryContext(ctx,
+		"SELECT id, rootlink, shortlink, created, accesses, uniques, edited, expires_at, max_accesses, fallback_url, redirect_type, password_hash FROM shortlinks "+
+			b.Page(q)+";", b.Args()...)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer rows.Close()
+
+	sls := make([]*shortlink.ShortLink, 0, q.Limit)
+	for rows.Next() {
+		sl, err := scanShortLink(rows)
+		if err != nil {
+			return nil, 0, err
+		}
+		sls = append(sls, sl)
+	}
+
+	if q.Cursor != nil && q.Cursor.Backward {
+		for i, j := 0, len(sls)-1; i < j; i, j = i+1, j-1 {
+			sls[i], sls[j] = sls[j], sls[i]
+		}
+	}
+
+	return sls, total, rows.Err()
+}
+
+// UpdateShortLink updates the root and short
+// link and the settings of a short link by the
+// values contained in updated.
+func (p *Postgres) UpdateShortLink(ctx context.Context, id int, updated *shortlink.ShortLink) error {
+	return database.Transaction(ctx, p.db, func(tx *sql.Tx) error {
+		old, err := scanShortLink(tx.StmtContext(ctx, p.stmts.getSLByID).QueryRowContext(ctx, id))
+		if err == sql.ErrNoRows {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+
+		_, err = tx.StmtContext(ctx, p.stmts.updateSLByID).ExecContext(ctx,
+			updated.ShortLink, updated.RootLink, updated.ExpiresAt,
+			updated.MaxAccesses, updated.FallbackURL, updated.RedirectType, updated.PasswordHash, id)
+		if err != nil {
+			return conflictError(err, updated.ShortLink)
+		}
+
+		return p.addRevision(ctx, tx, shortlink.ActionEdit, old, updated)
+	})
+}
+
+// IncrementAccesses atomically increases the
+// access count of a short link by n.
+func (p *Postgres) IncrementAccesses(ctx context.Context, id, n int) error {
+	_, err := p.stmts.incrAccesses.ExecContext(ctx, n, id)
+	return err
+}
+
+// IncrementUniques atomically increases the
+// unique visitor count of a short link by n.
+func (p *Postgres) IncrementUniques(ctx context.Context, id, n int) error {
+	_, err := p.stmts.incrUniques.ExecContext(ctx, n, id)
+	return err
+}
+
+// CreateShortLink creates a new shortlink
+// entry in the database and returnes the
+// new shortlink object whis was created.
+func (p *Postgres) CreateShortLink(ctx context.Context, sl *shortlink.ShortLink) (*shortlink.ShortLink, error) {
+	var newSl *shortlink.ShortLink
+
+	err := database.Transaction(ctx, p.db, func(tx *sql.Tx) error {
+		var err error
+		newSl, err = scanShortLink(
+			tx.StmtContext(ctx, p.stmts.insertSL).QueryRowContext(ctx,
+				sl.RootLink, sl.ShortLink, sl.ExpiresAt, sl.MaxAccesses, sl.FallbackURL, sl.RedirectType, sl.PasswordHash))
+		if err != nil {
+			return conflictError(err, sl.ShortLink)
+		}
+
+		return p.addRevision(ctx, tx, shortlink.ActionCreate, &shortlink.ShortLink{ID: newSl.ID}, newSl)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return newSl, nil
+}
+
+// DeleteShortLink marks a short link as
+// deleted so that it will not be returned
+// by any getter anymore.
+func (p *Postgres) DeleteShortLink(ctx context.Context, id int) error {
+	return database.Transaction(ctx, p.db, func(tx *sql.Tx) error {
+		old, err := scanShortLink(tx.StmtContext(ctx, p.stmts.getSLByID).QueryRowContext(ctx, id))
+		if err == sql.ErrNoRows {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+
+		if _, err = tx.StmtContext(ctx, p.stmts.deleteSLByID).ExecContext(ctx, id); err != nil {
+			return err
+		}
+
+		return p.addRevision(ctx, tx, shortlink.ActionDelete, old, old)
+	})
+}
+
+// GetTrashedShortLinks returns a list of deleted
+// short links which is ordered by deletion date
+// descending between from index and limit ammount.
+func (p *Postgres) GetTrashedShortLinks(ctx context.Context, from, limit int) ([]*shortlink.Trashed, error) {
+	rows, err := p.stmts.getTrashed.QueryContext(ctx, from, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	sls := make([]*shortlink.Trashed, 0, limit)
+	for rows.Next() {
+		sl, err := scanTrashed(rows)
+		if err != nil {
+			return nil, err
+		}
+		sls = append(sls, sl)
+	}
+
+	return sls, rows.Err()
+}
+
+// GetTrashedShortLink returns the deleted short
+// link with the passed ID. If no deleted short
+// link was found, nil is returned.
+func (p *Postgres) GetTrashedShortLink(ctx context.Context, id int) (*shortlink.Trashed, error) {
+	sl, err := scanTrashed(p.stmts.getTrashedByID.QueryRowContext(ctx, id))
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return sl, nil
+}
+
+// RestoreShortLink makes the deleted short
+// link with the passed ID available again.
+func (p *Postgres) RestoreShortLink(ctx context.Context, id int) error {
+	return database.Transaction(ctx, p.db, func(tx *sql.Tx) error {
+		old, err := scanTrashed(tx.StmtContext(ctx, p.stmts.getTrashedByID).QueryRowContext(ctx, id))
+		if err == sql.ErrNoRows {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+
+		if _, err = tx.StmtContext(ctx, p.stmts.restoreSLByID).ExecContext(ctx, id); err != nil {
+			return conflictError(err, old.ShortLink.ShortLink)
+		}
+
+		return p.addRevision(ctx, tx, shortlink.ActionRestore, &old.ShortLink, &old.ShortLink)
+	})
+}
+
+// PurgeShortLink permanently removes the deleted
+// short link with the passed ID, its revisions
+// and its accesses from the database.
+func (p *Postgres) PurgeShortLink(ctx context.Context, id int) error {
+	return database.Transaction(ctx, p.db, func(tx *sql.Tx) error {
+		res, err := tx.StmtContext(ctx, p.stmts.purgeSLByID).ExecContext(ctx, id)
+		if err != nil {
+			return err
+		}
+		if n, err := res.RowsAffected(); err != nil || n == 0 {
+			return err
+		}
+		return sqlquery.PurgeData(ctx, tx, dialect, []int{id})
+	})
+}
+
+// PurgeTrash permanently removes all short links
+// which were deleted longer than age ago, their
+// revisions and their accesses and returns the
+// number of removed short links.
+func (p *Postgres) PurgeTrash(ctx context.Context, age time.Duration) (int, error) {
+	var ids []int
+
+	err := database.Transaction(ctx, p.db, func(tx *sql.Tx) error {
+		var err error
+		ids, err = sqlquery.QueryIDs(ctx, tx.StmtContext(ctx, p.stmts.getTrashAfter), age.Seconds())
+		if err != nil || len(ids) == 0 {
+			return err
+		}
+		return sqlquery.Purge(ctx, tx, dialect, ids)
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	return len(ids), nil
+}
+
+// GetRevisions returns all revisions of the short
+// link with the passed ID ordered descending.
+func (p *Postgres) GetRevisions(ctx context.Context, id int) ([]*shortlink.Revision, error) {
+	rows, err := p.stmts.getRevisions.QueryContext(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	revs := make([]*shortlink.Revision, 0)
+	for rows.Next() {
+		rev, err := scanRevision(rows)
+		if err != nil {
+			return nil, err
+		}
+		revs = append(revs, rev)
+	}
+
+	return revs, rows.Err()
+}
+
+// GetRevision returns the revision with the ID rev
+// of the short link with the passed ID. If no such
+// revision was found, nil is returned.
+func (p *Postgres) GetRevision(ctx context.Context, id, rev int) (*shortlink.Revision, error) {
+	r, err := scanRevision(p.stmts.getRevision.QueryRowContext(ctx, id, rev))
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	return r, err
+}
+
+// addRevision records a revision of the passed
+// action changing the short link from before to
+// after in the passed transaction. The actor is
+// taken from ctx.
+func (p *Postgres) addRevision(ctx context.Context, tx *sql.Tx, action string, before, after *shortlink.ShortLink) error {
+	_, err := tx.StmtContext(ctx, p.stmts.insertRevision).ExecContext(ctx,
+		before.ID, action, before.RootLink, before.ShortLink, after.RootLink, after.ShortLink, database.Actor(ctx))
+	return err
+}
+
+// AddAccesses records the passed accesses
+// of short links.
+func (p *Postgres) AddAccesses(ctx context.Context, accesses []*shortlink.Access) error {
+	return database.Transaction(ctx, p.db, func(tx *sql.Tx) error {
+		stmt := tx.StmtContext(ctx, p.stmts.insertAccess)
+		for _, a := range accesses {
+			bot := 0
+			if a.Bot {
+				bot = 1
+			}
+			_, err := stmt.ExecContext(ctx, a.ShortLinkID, a.Time, a.Referrer, a.Agent, bot, a.Country, a.Visitor,
+				a.Source, a.Medium, a.Name, a.Term, a.Content)
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// GetAccessCounts returns the hourly counts of
+// the accesses of the short link with the passed
+// ID selected by traffic in the range [from, to)
+// ordered ascending. Hours without accesses are
+// omitted.
+func (p *Postgres) GetAccessCounts(ctx context.Context, id int, from, to time.Time, traffic database.Traffic) ([]*shortlink.AccessCount, error) {
+	minBot, maxBot := traffic.BotRange()
+	rows, err := p.stmts.getAccessCounts.QueryContext(ctx, id, from, to, minBot, maxBot)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	counts := make([]*shortlink.AccessCount, 0)
+	for rows.Next() {
+		var hour time.Time
+		c := new(shortlink.AccessCount)
+		if err = rows.Scan(&hour, &c.Count); err != nil {
+			return nil, err
+		}
+		c.Time = hour.UTC()
+		counts = append(counts, c)
+	}
+
+	return counts, rows.Err()
+}
+
+// GetReferrerCounts returns the counts of the
+// accesses of the short link with the passed ID
+// selected by traffic in the range [from, to)
+// per referrer host. The limit referrers
+// with the most accesses are returned ordered by
+// count descending and referrer ascending.
+func (p *Postgres) GetReferrerCounts(ctx context.Context, id int, from, to time.Time, traffic database.Traffic, limit int) ([]*shortlink.ReferrerCount, error) {
+	minBot, maxBot := traffic.BotRange()
+	rows, err := p.stmts.getReferrerCounts.QueryContext(ctx, id, from, to, minBot, maxBot, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	counts := make([]*shortlink.ReferrerCount, 0, limit)
+	for rows.Next() {
+		c := new(shortlink.ReferrerCount)
+		if err = rows.Scan(&c.Referrer, &c.Count); err != nil {
+			return nil, err
+		}
+		counts = append(counts, c)
+	}
+
+	return counts, rows.Err()
+}
+
+// GetCampaignCounts returns the counts of the
+// accesses of the short link with the passed ID
+// selected by traffic in the range [from, to) per
+// UTM source, medium and campaign.
+// Accesses without these parameters are not counted.
+// The limit campaigns with the most accesses are
+// returned ordered by count descending and source,
+// medium and campaign ascending.
+func (p *Postgres) GetCampaignCounts(ctx context.Context, id int, from, to time.Time, traffic database.Traffic, limit int) ([]*shortlink.CampaignCount, error) {
+	minBot, maxBot := traffic.BotRange()
+	rows, err := p.stmts.getCampaignCounts.QueryContext(ctx, id, from, to, minBot, maxBot, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	counts := make([]*shortlink.CampaignCount, 0, limit)
+	for rows.Next() {
+		c := new(shortlink.CampaignCount)
+		if err = rows.Scan(&c.Source, &c.Medium, &c.Name, &c.Count); err != nil {
+			return nil, err
+		}
+		counts = append(counts, c)
+	}
+
+	return counts, rows.Err()
+}
+
+// GetCountryCounts returns the counts of the
+// accesses of the short link with the passed ID
+// selected by traffic in the range [from, to)
+// per country code. Accesses with unresolved
+// country are counted with an empty code. The
+// limit countries with the most accesses are
+// returned ordered by count descending and
+// country ascending.
+func (p *Postgres) GetCountryCounts(ctx context.Context, id int, from, to time.Time, traffic database.Traffic, limit int) ([]*shortlink.CountryCount, error) {
+	minBot, maxBot := traffic.BotRange()
+	rows, err := p.stmts.getCountryCounts.QueryContext(ctx, id, from, to, minBot, maxBot, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	counts := make([]*shortlink.CountryCount, 0, limit)
+	for rows.Next() {
+		c := new(shortlink.CountryCount)
+		if err = rows.Scan(&c.Country, &c.Count); err != nil {
+			return nil, err
+		}
+		counts = append(counts, c)
+	}
+
+	return counts, rows.Err()
+}
+
+// GetUniqueCounts returns the daily counts of the
+// unique human visitors of the short link with the
+// passed ID in the range [from, to) ordered
+// ascending. Days without visitors are omitted.
+func (p *Postgres) GetUniqueCounts(ctx context.Context, id int, from, to time.Time) ([]*shortlink.AccessCount, error) {
+	rows, err := p.stmts.getUniqueCounts.QueryContext(ctx, id, from, to)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	counts := make([]*shortlink.AccessCount, 0)
+	for rows.Next() {
+		var day time.Time
+		c := new(shortlink.AccessCount)
+		if err = rows.Scan(&day, &c.Count); err != nil {
+			return nil, err
+		}
+		c.Time = day.UTC()
+		counts = append(counts, c)
+	}
+
+	return counts, rows.Err()
+}
+
+// GetAccessTotal returns the number of accesses
+// of all short links selected by traffic in the
+// range [from, to). Accesses of deleted short
+// links are not counted.
+func (p *Postgres) GetAccessTotal(ctx context.Context, from, to time.Time, traffic database.Traffic) (int, error) {
+	minBot, maxBot := traffic.BotRange()
+	args := []interface{}{from, to, minBot, maxBot}
+
+	var total int
+	err := p.stmts.getAccessTotal.QueryRowContext(ctx, args...).Scan(&total)
+	return total, err
+}
+
+// GetTopShortLinks returns the limit short links
+// with the most accesses selected by traffic in
+// the range [from, to) together with their number
+// ordered by count descending and ID ascending.
+// Short links without accesses are omitted.
+func (p *Postgres) GetTopShortLinks(ctx context.Context, from, to time.Time, traffic database.Traffic, limit int) ([]*shortlink.ShortLinkCount, error) {
+	minBot, maxBot := traffic.BotRange()
+	args := []interface{}{from, to, minBot, maxBot}
+	rows, err := p.stmts.getTopSLs.QueryContext(ctx, append(args, limit)...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	counts := make([]*shortlink.ShortLinkCount, 0, limit)
+	for rows.Next() {
+		var n int
+		sl, err := scanShortLink(rows, &n)
+		if err != nil {
+			return nil, err
+		}
+		counts = append(counts, &shortlink.ShortLinkCount{ShortLink: *sl, Count: n})
+	}
+
+	return counts, rows.Err()
+}
+
+// GetIdleShortLinks returns the limit short links
+// without accesses selected by traffic since the
+// passed time ordered by created date ascending
+// and the total number of such short links.
+func (p *Postgres) GetIdleShortLinks(ctx context.Context, since time.Time, traffic database.Traffic, limit int) ([]*shortlink.ShortLink, int, error) {
+	minBot, maxBot := traffic.BotRange()
+	args := []interface{}{since, minBot, maxBot}
+
+	var total int
+	if err := p.stmts.countIdleSLs.QueryRowContext(ctx, args...).Scan(&total); err != nil {
+		return nil, 0, err
+	}
+
+	rows, err := p.stmts.getIdleSLs.QueryContext(ctx, append(args, limit)...)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer rows.Close()
+
+	sls := make([]*shortlink.ShortLink, 0, limit)
+	for rows.Next() {
+		sl, err := scanShortLink(rows)
+		if err != nil {
+			return nil, 0, err
+		}
+		sls = append(sls, sl)
+	}
+
+	return sls, total, rows.Err()
+}
+
+// RollUpAccesses aggregates the accesses recorded
+// before the passed time into hourly rollups and
+// the unique visitors into daily rollups, removes
+// the aggregated accesses and returns their number.
+// before should be the start of a day, so that the
+// visitors of a day are not split.
+func (p *Postgres) RollUpAccesses(ctx context.Context, before time.Time) (int, error) {
+	var n int64
+
+	err := database.Transaction(ctx, p.db, func(tx *sql.Tx) error {
+		if _, err := tx.StmtContext(ctx, p.stmts.rollUpAccesses).ExecContext(ctx, before); err != nil {
+			return err
+		}
+		if _, err := tx.StmtContext(ctx, p.stmts.rollUpUniques).ExecContext(ctx, before); err != nil {
+			return err
+		}
+
+		res, err := tx.StmtContext(ctx, p.stmts.deleteAccesses).ExecContext(ctx, before)
+		if err != nil {
+			return err
+		}
+		n, err = res.RowsAffected()
+		return err
+	})
+
+	return int(n), err
+}
+
+// RollUpHourlyAccesses aggregates the hourly rollups
+// before the passed time into daily rollups, removes
+// the aggregated hourly rollups and returns their
+// number.
+func (p *Postgres) RollUpHourlyAccesses(ctx context.Context, before time.Time) (int, error) {
+	var n int64
+
+	err := database.Transaction(ctx, p.db, func(tx *sql.Tx) error {
+		if _, err := tx.StmtContext(ctx, p.stmts.rollUpHourly).ExecContext(ctx, before); err != nil {
+			return err
+		}
+
+		res, err := tx.StmtContext(ctx, p.stmts.deleteHourly).ExecContext(ctx, before)
+		if err != nil {
+			return err
+		}
+		n, err = res.RowsAffected()
+		return err
+	})
+
+	return int(n), err
+}
+
+// PurgeDailyAccesses removes the daily rollups
+// before the passed time and returns their number.
+func (p *Postgres) PurgeDailyAccesses(ctx context.Context, before time.Time) (int, error) {
+	var n int64
+
+	err := database.Transaction(ctx, p.db, func(tx *sql.Tx) error {
+		res, err := tx.StmtContext(ctx, p.stmts.deleteDaily).ExecContext(ctx, before)
+		if err != nil {
+			return err
+		}
+		if n, err = res.RowsAffected(); err != nil {
+			return err
+		}
+
+		_, err = tx.StmtContext(ctx, p.stmts.deleteUniques).ExecContext(ctx, before)
+		return err
+	})
+
+	return int(n), err
+}
+
+// scanner is implemented by *sql.Row
+// and *sql.Rows.
+type scanner interface {
+	Scan(dest ...interface{}) error
+}
+
+// scanShortLink scans a short link from sc
+// and the following columns into extra.
+func scanShortLink(sc scanner, extra ...interface{}) (*shortlink.ShortLink, error) {
+	sl := new(shortlink.ShortLink)
+	err := sc.Scan(append([]interface{}{
+		&sl.ID, &sl.RootLink, &sl.ShortLink, &sl.Created, &sl.Accesses, &sl.Uniques, &sl.Edited,
+		&sl.ExpiresAt, &sl.MaxAccesses, &sl.FallbackURL, &sl.RedirectType, &sl.PasswordHash}, extra...)...)
+	if err != nil {
+		return nil, err
+	}
+	return sl, nil
+}
+
+// scanTrashed scans a short link followed
+// by its deletion date from sc.
+func scanTrashed(sc scanner) (*shortlink.Trashed, error) {
+	var deleted time.Time
+	sl, err := scanShortLink(sc, &deleted)
+	if err != nil {
+		return nil, err
+	}
+	return &shortlink.Trashed{ShortLink: *sl, Deleted: deleted}, nil
+}
+
+// scanRevision scans a revision from sc.
+func scanRevision(sc scanner) (*shortlink.Revision, error) {
+	r := new(shortlink.Revision)
+	err := sc.Scan(
+		&r.ID, &r.ShortLinkID, &r.Action, &r.OldRootLink, &r.OldShortLink,
+		&r.NewRootLink, &r.NewShortLink, &r.Actor, &r.Created)
+	if err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+// conflictError returns a database.ConflictError
+// for the passed short identifier if err is a
+// violation of the unique short link index.
+// Otherwise, err is returned.
+func conflictError(err error, short string) error {
+	if e, ok := err.(*pq.Error); ok && e.Code == uniqueViolation {
+		return &database.ConflictError{ShortLink: short}
+	}
+	return err
+}
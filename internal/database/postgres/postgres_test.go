@@ -0,0 +1,60 @@
+package postgres
+
+import (
+	"os"
+	"testing"
+	"time"
+
+	"github.com/zekroTJA/slms/internal/database"
+	"github.com/zekroTJA/slms/internal/database/dbtest"
+)
+
+// TestMiddleware runs the conformance test suite
+// against a PostgreSQL server specified by the env
+// variables SLMS_TEST_POSTGRES_HOST, _USERNAME,
+// _PASSWORD and _DATABASE.
+// Use 'make test-postgres' to run the tests against
+// a temporary PostgreSQL docker container.
+//
+// ATTENTION: All short links, revisions and
+// accesses in the specified database will be
+// deleted!
+func TestMiddleware(t *testing.T) {
+	cfg := &Config{
+		Host:     os.Getenv("SLMS_TEST_POSTGRES_HOST"),
+		Username: os.Getenv("SLMS_TEST_POSTGRES_USERNAME"),
+		Password: os.Getenv("SLMS_TEST_POSTGRES_PASSWORD"),
+		Database: os.Getenv("SLMS_TEST_POSTGRES_DATABASE"),
+		SSLMode:  "disable",
+	}
+	if cfg.Host == "" {
+		t.Skip("SLMS_TEST_POSTGRES_HOST is not set")
+	}
+
+	dbtest.Run(t, func(t *testing.T) database.Middleware {
+		db := new(Postgres)
+
+		// A freshly started container may take some
+		// time until it accepts connections.
+		var err error
+		for i := 0; i < 30; i++ {
+			if err = db.Open(cfg); err == nil {
+				break
+			}
+			time.Sleep(time.Second)
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		// Rows of the other tables are not removed with
+		// their short links and would be assigned to the
+		// short links of the next case by their IDs.
+		if _, err = db.db.Exec("TRUNCATE TABLE shortlinks, revisions, accesses, " +
+			"access_rollups, unique_rollups RESTART IDENTITY;"); err != nil {
+			t.Fatal(err)
+		}
+
+		return db
+	})
+}
@@ -0,0 +1,70 @@
+package database
+
+import "time"
+
+// Fields short link lists can be sorted by.
+// The names equal the JSON keys of
+// shortlink.ShortLink.
+const (
+	SortID        = "id"
+	SortRootLink  = "root_link"
+	SortShortLink = "short_link"
+	SortCreated   = "created"
+	SortAccesses  = "accesses"
+	SortEdited    = "edited"
+)
+
+// IsSortField returns true if short link
+// lists can be sorted by the passed field.
+func IsSortField(field string) bool {
+	switch field {
+	case SortID, SortRootLink, SortShortLink, SortCreated, SortAccesses, SortEdited:
+		return true
+	}
+	return false
+}
+
+// A Query describes the filters, the sorting and
+// the page of a list of short links. Empty values
+// do not filter.
+//
+// Search matches short links whose short or root
+// link contains the value, case-insensitively.
+// Domain matches short links whose root link points
+// to exactly this host, case-insensitively.
+// The date ranges include their start and exclude
+// their end.
+// The list is sorted by Sort, which defaults to
+// SortCreated, and by ID with equal values.
+//
+// If Cursor is set, the list is sorted by created
+// date and only contains the short links behind
+// the cursor.
+type Query struct {
+	Search      string
+	Domain      string
+	CreatedFrom time.Time
+	CreatedTo   time.Time
+	EditedFrom  time.Time
+	EditedTo    time.Time
+	MinAccesses int
+	Sort        string
+	Descending  bool
+	Cursor      *Cursor
+	From        int
+	Limit       int
+}
+
+// A Cursor is a position in a list of short
+// links sorted by created date.
+//
+// The list behind the cursor contains the short
+// links following the short link with the created
+// date and the ID of the cursor or, if Backward is
+// set, the short links preceding it. In both cases
+// the list keeps its order.
+type Cursor struct {
+	Created  time.Time
+	ID       int
+	Backward bool
+}
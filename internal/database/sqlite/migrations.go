@@ -0,0 +1,216 @@
+package sqlite
+
+import "github.com/zekroTJA/slms/internal/database/migration"
+
+// migrations contains all schema migrations
+// of the SQLite database in ascending order.
+var migrations = []*migration.Migration{
+	{
+		Version: 1,
+		Name:    "create shortlinks table",
+		Up: []string{
+			"CREATE TABLE IF NOT EXISTS `shortlinks` (" +
+				"`id` INTEGER PRIMARY KEY AUTOINCREMENT, " +
+				"`rootlink` TEXT NOT NULL, " +
+				"`shortlink` TEXT NOT NULL, " +
+				"`created` TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP, " +
+				"`accesses` INTEGER NOT NULL DEFAULT 0, " +
+				"`edited` TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP, " +
+				"`deleted` INTEGER NOT NULL DEFAULT 0);",
+			"CREATE INDEX IF NOT EXISTS `idx_shortlink` ON `shortlinks` (`shortlink`);",
+		},
+		Down: []string{
+			"DROP TABLE `shortlinks`;",
+		},
+	},
+	{
+		Version: 2,
+		Name:    "add deletion date",
+		Up: []string{
+			"ALTER TABLE `shortlinks` ADD COLUMN `deleted_at` TIMESTAMP NULL;",
+			// Short links deleted before are dated
+			// to their last modification.
+			"UPDATE `shortlinks` SET `deleted_at` = `edited` WHERE `deleted` = 1;",
+		},
+		Down: []string{
+			"ALTER TABLE `shortlinks` DROP COLUMN `deleted_at`;",
+		},
+	},
+	{
+		Version: 3,
+		Name:    "create revisions table",
+		Up: []string{
+			"CREATE TABLE IF NOT EXISTS `revisions` (" +
+				"`id` INTEGER PRIMARY KEY AUTOINCREMENT, " +
+				"`shortlink_id` INTEGER NOT NULL, " +
+				"`action` TEXT NOT NULL, " +
+				"`old_rootlink` TEXT NOT NULL, " +
+				"`old_shortlink` TEXT NOT NULL, " +
+				"`new_rootlink` TEXT NOT NULL, " +
+				"`new_shortlink` TEXT NOT NULL, " +
+				"`actor` TEXT NOT NULL, " +
+				"`created` TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP);",
+			"CREATE INDEX IF NOT EXISTS `idx_shortlink_id` ON `revisions` (`shortlink_id`);",
+			// Existing short links get a create revision
+			// so that they can be reverted to their
+			// current state.
+			"INSERT INTO `revisions` " +
+				"(`shortlink_id`, `action`, `old_rootlink`, `old_shortlink`, `new_rootlink`, `new_shortlink`, `actor`, `created`) " +
+				"SELECT `id`, 'create', '', '', `rootlink`, `shortlink`, '', `created` FROM `shortlinks`;",
+		},
+		Down: []string{
+			"DROP TABLE `revisions`;",
+		},
+	},
+	{
+		Version: 4,
+		Name:    "unique short links",
+		Up: []string{
+			// Duplicate short links created by concurrent
+			// requests are moved to the trash, so only the
+			// oldest one stays available.
+			"UPDATE `shortlinks` SET `deleted` = 1, `deleted_at` = CURRENT_TIMESTAMP " +
+				"WHERE `deleted` = 0 AND EXISTS (SELECT 1 FROM `shortlinks` AS `s` " +
+				"WHERE `s`.`deleted` = 0 AND `s`.`shortlink` = `shortlinks`.`shortlink` AND `s`.`id` < `shortlinks`.`id`);",
+			"CREATE UNIQUE INDEX IF NOT EXISTS `idx_active_shortlink` ON `shortlinks` (`shortlink`) " +
+				"WHERE `deleted` = 0;",
+		},
+		Down: []string{
+			"DROP INDEX `idx_active_shortlink`;",
+		},
+	},
+	{
+		Version: 5,
+		Name:    "create accesses table",
+		Up: []string{
+			"CREATE TABLE IF NOT EXISTS `accesses` (" +
+				"`id` INTEGER PRIMARY KEY AUTOINCREMENT, " +
+				"`shortlink_id` INTEGER NOT NULL, " +
+				"`created` TIMESTAMP NOT NULL, " +
+				"`referrer` TEXT NOT NULL, " +
+				"`agent` TEXT NOT NULL);",
+			"CREATE INDEX IF NOT EXISTS `idx_accesses_shortlink_created` ON `accesses` (`shortlink_id`, `created`);",
+		},
+		Down: []string{
+			"DROP TABLE `accesses`;",
+		},
+	},
+	{
+		Version: 6,
+		Name:    "add campaign parameters to accesses",
+		Up: []string{
+			"ALTER TABLE `accesses` ADD COLUMN `utm_source` TEXT NOT NULL DEFAULT '';",
+			"ALTER TABLE `accesses` ADD COLUMN `utm_medium` TEXT NOT NULL DEFAULT '';",
+			"ALTER TABLE `accesses` ADD COLUMN `utm_campaign` TEXT NOT NULL DEFAULT '';",
+			"ALTER TABLE `accesses` ADD COLUMN `utm_term` TEXT NOT NULL DEFAULT '';",
+			"ALTER TABLE `accesses` ADD COLUMN `utm_content` TEXT NOT NULL DEFAULT '';",
+		},
+		Down: []string{
+			"ALTER TABLE `accesses` DROP COLUMN `utm_source`;",
+			"ALTER TABLE `accesses` DROP COLUMN `utm_medium`;",
+			"ALTER TABLE `accesses` DROP COLUMN `utm_campaign`;",
+			"ALTER TABLE `accesses` DROP COLUMN `utm_term`;",
+			"ALTER TABLE `accesses` DROP COLUMN `utm_content`;",
+		},
+	},
+	{
+		Version: 7,
+		Name:    "flag bot accesses",
+		Up: []string{
+			"ALTER TABLE `accesses` ADD COLUMN `bot` INTEGER NOT NULL DEFAULT 0;",
+			"UPDATE `accesses` SET `bot` = 1 WHERE `agent` = 'bot';",
+		},
+		Down: []string{
+			"ALTER TABLE `accesses` DROP COLUMN `bot`;",
+		},
+	},
+	{
+		Version: 8,
+		Name:    "count unique visitors",
+		Up: []string{
+			"ALTER TABLE `accesses` ADD COLUMN `visitor` TEXT NOT NULL DEFAULT '';",
+			"ALTER TABLE `shortlinks` ADD COLUMN `uniques` INTEGER NOT NULL DEFAULT 0;",
+		},
+		Down: []string{
+			"ALTER TABLE `shortlinks` DROP COLUMN `uniques`;",
+			"ALTER TABLE `accesses` DROP COLUMN `visitor`;",
+		},
+	},
+	{
+		Version: 9,
+		Name:    "create rollup tables",
+		Up: []string{
+			"CREATE TABLE IF NOT EXISTS `access_rollups` (" +
+				"`id` INTEGER PRIMARY KEY AUTOINCREMENT, " +
+				"`shortlink_id` INTEGER NOT NULL, " +
+				"`granularity` TEXT NOT NULL, " +
+				"`created` TIMESTAMP NOT NULL, " +
+				"`bot` INTEGER NOT NULL, " +
+				"`referrer` TEXT NOT NULL, " +
+				"`utm_source` TEXT NOT NULL, " +
+				"`utm_medium` TEXT NOT NULL, " +
+				"`utm_campaign` TEXT NOT NULL, " +
+				"`accesses` INTEGER NOT NULL);",
+			"CREATE INDEX IF NOT EXISTS `idx_access_rollups_shortlink_created` ON `access_rollups` (`shortlink_id`, `created`);",
+			"CREATE INDEX IF NOT EXISTS `idx_access_rollups_granularity_created` ON `access_rollups` (`granularity`, `created`);",
+			"CREATE TABLE IF NOT EXISTS `unique_rollups` (" +
+				"`id` INTEGER PRIMARY KEY AUTOINCREMENT, " +
+				"`shortlink_id` INTEGER NOT NULL, " +
+				"`created` TIMESTAMP NOT NULL, " +
+				"`uniques` INTEGER NOT NULL);",
+			"CREATE INDEX IF NOT EXISTS `idx_unique_rollups_shortlink_created` ON `unique_rollups` (`shortlink_id`, `created`);",
+			"CREATE INDEX IF NOT EXISTS `idx_accesses_created` ON `accesses` (`created`);",
+		},
+		Down: []string{
+			"DROP INDEX `idx_accesses_created`;",
+			"DROP TABLE `unique_rollups`;",
+			"DROP TABLE `access_rollups`;",
+		},
+	},
+	{
+		Version: 10,
+		Name:    "add country to accesses",
+		Up: []string{
+			"ALTER TABLE `accesses` ADD COLUMN `country` TEXT NOT NULL DEFAULT '';",
+			"ALTER TABLE `access_rollups` ADD COLUMN `country` TEXT NOT NULL DEFAULT '';",
+		},
+		Down: []string{
+			"ALTER TABLE `access_rollups` DROP COLUMN `country`;",
+			"ALTER TABLE `accesses` DROP COLUMN `country`;",
+		},
+	},
+	{
+		Version: 11,
+		Name:    "add limits to shortlinks",
+		Up: []string{
+			"ALTER TABLE `shortlinks` ADD COLUMN `expires_at` TIMESTAMP NULL;",
+			"ALTER TABLE `shortlinks` ADD COLUMN `max_accesses` INTEGER NOT NULL DEFAULT 0;",
+			"ALTER TABLE `shortlinks` ADD COLUMN `fallback_url` TEXT NOT NULL DEFAULT '';",
+		},
+		Down: []string{
+			"ALTER TABLE `shortlinks` DROP COLUMN `fallback_url`;",
+			"ALTER TABLE `shortlinks` DROP COLUMN `max_accesses`;",
+			"ALTER TABLE `shortlinks` DROP COLUMN `expires_at`;",
+		},
+	},
+	{
+		Version: 12,
+		Name:    "add redirect type to shortlinks",
+		Up: []string{
+			"ALTER TABLE `shortlinks` ADD COLUMN `redirect_type` TEXT NOT NULL DEFAULT '';",
+		},
+		Down: []string{
+			"ALTER TABLE `shortlinks` DROP COLUMN `redirect_type`;",
+		},
+	},
+	{
+		Version: 13,
+		Name:    "add password hash to shortlinks",
+		Up: []string{
+			"ALTER TABLE `shortlinks` ADD COLUMN `password_hash` TEXT NOT NULL DEFAULT '';",
+		},
+		Down: []string{
+			"ALTER TABLE `shortlinks` DROP COLUMN `password_hash`;",
+		},
+	},
+}
@@ -0,0 +1,1109 @@
+package sqlite
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+
+	// SQLite driver import
+	"github.com/mattn/go-sqlite3"
+	"github.com/zekroTJA/slms/internal/database"
+	"github.com/zekroTJA/slms/internal/database/migration"
+	"github.com/zekroTJA/slms/internal/database/sqlquery"
+	"github.com/zekroTJA/slms/internal/shortlink"
+	"github.com/zekroTJA/slms/pkg/multierror"
+)
+
+// SQLite maintains the connection
+// to a SQLite database file.
+type SQLite struct {
+	db       *sql.DB
+	stmts    *prepStmts
+	migrator *migration.Migrator
+}
+
+// timeFormat is the format of times
+// stored as text in UTC.
+const timeFormat = "2006-01-02 15:04:05"
+
+// dialect is the SQL dialect of SQLite for
+// dynamically built queries. Times are stored
+// as text in UTC, so they are compared as such.
+var dialect = sqlquery.Dialect{
+	FormatTime: func(t time.Time) interface{} {
+		return t.UTC().Format(timeFormat)
+	},
+}
+
+type prepStmts struct {
+	getSLCount   *sql.Stmt
+	getSLByID    *sql.Stmt
+	getSLs       *sql.Stmt
+	getSLByRoot  *sql.Stmt
+	getSLByShort *sql.Stmt
+	updateSLByID *sql.Stmt
+	incrAccesses *sql.Stmt
+	incrUniques  *sql.Stmt
+	insertSL     *sql.Stmt
+	deleteSLByID *sql.Stmt
+
+	getTrashed     *sql.Stmt
+	getTrashedByID *sql.Stmt
+	restoreSLByID  *sql.Stmt
+	purgeSLByID    *sql.Stmt
+	getTrashAfter  *sql.Stmt
+
+	insertRevision *sql.Stmt
+	getRevisions   *sql.Stmt
+	getRevision    *sql.Stmt
+
+	insertAccess      *sql.Stmt
+	getAccessCounts   *sql.Stmt
+	getReferrerCounts *sql.Stmt
+	getCampaignCounts *sql.Stmt
+	getCountryCounts  *sql.Stmt
+	getUniqueCounts   *sql.Stmt
+	getAccessTotal    *sql.Stmt
+	getTopSLs         *sql.Stmt
+	getIdleSLs        *sql.Stmt
+	countIdleSLs      *sql.Stmt
+
+	rollUpAccesses *sql.Stmt
+	rollUpUniques  *sql.Stmt
+	rollUpHourly   *sql.Stmt
+	deleteAccesses *sql.Stmt
+	deleteHourly   *sql.Stmt
+	deleteDaily    *sql.Stmt
+	deleteUniques  *sql.Stmt
+}
+
+// Config contains the configuration
+// for a SQLite database.
+type Config struct {
+	File string `json:"file"`
+}
+
+// Open opens the SQLite database file,
+// applies pending schema migrations
+// and prepares all statements.
+func (s *SQLite) Open(cfg interface{}) error {
+	if err := s.Connect(cfg); err != nil {
+		return err
+	}
+
+	if _, err := s.migrator.Up(); err != nil {
+		return err
+	}
+
+	return s.prepStatements()
+}
+
+// Connect opens the SQLite database
+// file without touching the schema.
+func (s *SQLite) Connect(cfg interface{}) error {
+	var err error
+
+	conf, ok := cfg.(*Config)
+	if !ok {
+		return errors.New("cfg is not type of sqlite.Config")
+	}
+
+	if conf.File == "" {
+		return errors.New("database file must be specified")
+	}
+
+	if s.db, err = sql.Open("sqlite3", conf.File); err != nil {
+		return err
+	}
+
+	// SQLite does only allow one writer at a time,
+	// so concurrent connections would only result
+	// in 'database is locked' errors.
+	s.db.SetMaxOpenConns(1)
+
+	s.migrator = migration.New(s.db, migration.PlaceholderQuestion, migrations)
+
+	return nil
+}
+
+// Migrator returns the schema migrator
+// of the database connection.
+func (s *SQLite) Migrator() *migration.Migrator {
+	return s.migrator
+}
+
+// Close cleanly closes the
+// database connection.
+func (s *SQLite) Close() {
+	s.db.Close()
+}
+
+func (s *SQLite) prepStatements() error {
+	var err error
+	mErr := multierror.New(nil)
+
+	s.stmts = new(prepStmts)
+
+	s.stmts.getSLCount, err = s.db.Prepare(
+		"SELECT COUNT(`id`) FROM `shortlinks` WHERE `deleted` = 0;")
+	mErr.Append(err)
+
+	s.stmts.getSLByID, err = s.db.Prepare(
+		"SELECT `id`, `rootlink`, `shortlink`, `created`, `accesses`, `uniques`, `edited`, `expires_at`, `max_accesses`, `fallback_url`, `redirect_type`, `password_hash` FROM `shortlinks` " +
+			"WHERE `deleted` = 0 AND `id` = ?;")
+	mErr.Append(err)
+
+	s.stmts.getSLs, err = s.db.Prepare(
+		"SELECT `id`, `rootlink`, `shortlink`, `created`, `accesses`, `uniques`, `edited`, `expires_at`, `max_accesses`, `fallback_url`, `redirect_type`, `password_hash` FROM `shortlinks` " +
+			"WHERE `deleted` = 0 " +
+			"ORDER BY `created` DESC, `id` DESC " +
+			"LIMIT ?, ?;")
+	mErr.Append(err)
+
+	s.stmts.getSLByRoot, err = s.db.Prepare(
+		"SELECT `id`, `rootlink`, `shortlink`, `created`, `accesses`, `uniques`, `edited`, `expires_at`, `max_accesses`, `fallback_url`, `redirect_type`, `password_hash` FROM `shortlinks` " +
+			"WHERE `deleted` = 0 AND `rootlink` = ?;")
+	mErr.Append(err)
+
+	s.stmts.getSLByShort, err = s.db.Prepare(
+		"SELECT `id`, `rootlink`, `shortlink`, `created`, `accesses`, `uniques`, `edited`, `expires_at`, `max_accesses`, `fallback_url`, `redirect_type`, `password_hash` FROM `shortlinks` " +
+			"WHERE `deleted` = 0 AND `shortlink` = ?;")
+	mErr.Append(err)
+
+	// SQLite has no 'ON UPDATE' column attribute like
+	// MySQL, so the edited timestamp is set explicitly.
+	s.stmts.updateSLByID, err = s.db.Prepare(
+		"UPDATE `shortlinks` SET `shortlink` = ?, `rootlink` = ?, " +
+			"`expires_at` = ?, `max_accesses` = ?, `fallback_url` = ?, `redirect_type` = ?, `password_hash` = ?, " +
+			"`edited` = CURRENT_TIMESTAMP " +
+			"WHERE `id` = ?;")
+	mErr.Append(err)
+
+	s.stmts.incrAccesses, err = s.db.Prepare(
+		"UPDATE `shortlinks` SET `accesses` = `accesses` + ? " +
+			"WHERE `id` = ?;")
+	mErr.Append(err)
+
+	s.stmts.incrUniques, err = s.db.Prepare(
+		"UPDATE `shortlinks` SET `uniques` = `uniques` + ? " +
+			"WHERE `id` = ?;")
+	mErr.Append(err)
+
+	s.stmts.insertSL, err = s.db.Prepare(
+		"INSERT INTO `shortlinks` (`rootlink`, `shortlink`, `expires_at`, `max_accesses`, `fallback_url`, `redirect_type`, `password_hash`) " +
+			"VALUES (?, ?, ?, ?, ?, ?, ?);")
+	mErr.Append(err)
+
+	s.stmts.deleteSLByID, err = s.db.Prepare(
+		"UPDATE `shortlinks` SET `deleted` = 1, `deleted_at` = CURRENT_TIMESTAMP " +
+			"WHERE `deleted` = 0 AND `id` = ?;")
+	mErr.Append(err)
+
+	s.stmts.getTrashed, err = s.db.Prepare(
+		"SELECT `id`, `rootlink`, `shortlink`, `created`, `accesses`, `uniques`, `edited`, `expires_at`, `max_accesses`, `fallback_url`, `redirect_type`, `password_hash`, `deleted_at` FROM `shortlinks` " +
+			"WHERE `deleted` = 1 " +
+			"ORDER BY `deleted_at` DESC, `id` DESC " +
+			"LIMIT ?, ?;")
+	mErr.Append(err)
+
+	s.stmts.getTrashedByID, err = s.db.Prepare(
+		"SELECT `id`, `rootlink`, `shortlink`, `created`, `accesses`, `uniques`, `edited`, `expires_at`, `max_accesses`, `fallback_url`, `redirect_type`, `password_hash`, `deleted_at` FROM `shortlinks` " +
+			"WHERE `deleted` = 1 AND `id` = ?;")
+	mErr.Append(err)
+
+	s.stmts.restoreSLByID, err = s.db.Prepare(
+		"UPDATE `shortlinks` SET `deleted` = 0, `deleted_at` = NULL " +
+			"WHERE `deleted` = 1 AND `id` = ?;")
+	mErr.Append(err)
+
+	s.stmts.purgeSLByID, err = s.db.Prepare(
+		"DELETE FROM `shortlinks` WHERE `deleted` = 1 AND `id` = ?;")
+	mErr.Append(err)
+
+	// The modifier passed to datetime() has the
+	// format '-<n> seconds'.
+	s.stmts.getTrashAfter, err = s.db.Prepare(
+		"SELECT `id` FROM `shortlinks` WHERE `deleted` = 1 AND `deleted_at` < datetime('now', ?);")
+	mErr.Append(err)
+
+	s.stmts.insertRevision, err = s.db.Prepare(
+		"INSERT INTO `revisions` " +
+			"(`shortlink_id`, `action`, `old_rootlink`, `old_shortlink`, `new_rootlink`, `new_shortlink`, `actor`) " +
+			"VALUES (?, ?, ?, ?, ?, ?, ?);")
+	mErr.Append(err)
+
+	s.stmts.getRevisions, err = s.db.Prepare(
+		"SELECT `id`, `shortlink_id`, `action`, `old_rootlink`, `old_shortlink`, " +
+			"`new_rootlink`, `new_shortlink`, `actor`, `created` FROM `revisions` " +
+			"WHERE `shortlink_id` = ? " +
+			"ORDER BY `id` DESC;")
+	mErr.Append(err)
+
+	s.stmts.getRevision, err = s.db.Prepare(
+		"SELECT `id`, `shortlink_id`, `action`, `old_rootlink`, `old_shortlink`, " +
+			"`new_rootlink`, `new_shortlink`, `actor`, `created` FROM `revisions` " +
+			"WHERE `shortlink_id` = ? AND `id` = ?;")
+	mErr.Append(err)
+
+	s.stmts.insertAccess, err = s.db.Prepare(
+		"INSERT INTO `accesses` " +
+			"(`shortlink_id`, `created`, `referrer`, `agent`, `bot`, `country`, `visitor`, `utm_source`, `utm_medium`, `utm_campaign`, `utm_term`, `utm_content`) " +
+			"VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?);")
+	mErr.Append(err)
+
+	// The stats queries count the accesses and add
+	// the rollups of the same range, which are stored
+	// as text dated to the start of their hour or day.
+	s.stmts.getAccessCounts, err = s.db.Prepare(
+		"SELECT `hour`, SUM(`n`) FROM (" +
+			"SELECT strftime('%Y-%m-%d %H:00:00', `created`) AS `hour`, COUNT(`id`) AS `n` FROM `accesses` " +
+			"WHERE `shortlink_id` = ? AND `created` >= ? AND `created` < ? AND `bot` BETWEEN ? AND ? " +
+			"GROUP BY `hour` " +
+			"UNION ALL " +
+			"SELECT strftime('%Y-%m-%d %H:00:00', `created`), SUM(`accesses`) FROM `access_rollups` " +
+			"WHERE `shortlink_id` = ? AND `created` >= ? AND `created` < ? AND `bot` BETWEEN ? AND ? " +
+			"GROUP BY `created`" +
+			") AS `counts` GROUP BY `hour` ORDER BY `hour`;")
+	mErr.Append(err)
+
+	s.stmts.getReferrerCounts, err = s.db.Prepare(
+		"SELECT `referrer`, SUM(`n`) AS `total` FROM (" +
+			"SELECT `referrer`, COUNT(`id`) AS `n` FROM `accesses` " +
+			"WHERE `shortlink_id` = ? AND `created` >= ? AND `created` < ? AND `bot` BETWEEN ? AND ? " +
+			"GROUP BY `referrer` " +
+			"UNION ALL " +
+			"SELECT `referrer`, SUM(`accesses`) FROM `access_rollups` " +
+			"WHERE `shortlink_id` = ? AND `created` >= ? AND `created` < ? AND `bot` BETWEEN ? AND ? " +
+			"GROUP BY `referrer`" +
+			") AS `counts` GROUP BY `referrer` ORDER BY `total` DESC, `referrer` ASC LIMIT ?;")
+	mErr.Append(err)
+
+	s.stmts.getCampaignCounts, err = s.db.Prepare(
+		"SELECT `utm_source`, `utm_medium`, `utm_campaign`, SUM(`n`) AS `total` FROM (" +
+			"SELECT `utm_source`, `utm_medium`, `utm_campaign`, COUNT(`id`) AS `n` FROM `accesses` " +
+			"WHERE `shortlink_id` = ? AND `created` >= ? AND `created` < ? AND `bot` BETWEEN ? AND ? " +
+			"AND (`utm_source` <> '' OR `utm_medium` <> '' OR `utm_campaign` <> '') " +
+			"GROUP BY `utm_source`, `utm_medium`, `utm_campaign` " +
+			"UNION ALL " +
+			"SELECT `utm_source`, `utm_medium`, `utm_campaign`, SUM(`accesses`) FROM `access_rollups` " +
+			"WHERE `shortlink_id` = ? AND `created` >= ? AND `created` < ? AND `bot` BETWEEN ? AND ? " +
+			"AND (`utm_source` <> '' OR `utm_medium` <> '' OR `utm_campaign` <> '') " +
+			"GROUP BY `utm_source`, `utm_medium`, `utm_campaign`" +
+			") AS `counts` GROUP BY `utm_source`, `utm_medium`, `utm_campaign` " +
+			"ORDER BY `total` DESC, `utm_source`, `utm_medium`, `utm_campaign` LIMIT ?;")
+	mErr.Append(err)
+
+	s.stmts.getCountryCounts, err = s.db.Prepare(
+		"SELECT `country`, SUM(`n`) AS `total` FROM (" +
+			"SELECT `country`, COUNT(`id`) AS `n` FROM `accesses` " +
+			"WHERE `shortlink_id` = ? AND `created` >= ? AND `created` < ? AND `bot` BETWEEN ? AND ? " +
+			"GROUP BY `country` " +
+			"UNION ALL " +
+			"SELECT `country`, SUM(`accesses`) FROM `access_rollups` " +
+			"WHERE `shortlink_id` = ? AND `created` >= ? AND `created` < ? AND `bot` BETWEEN ? AND ? " +
+			"GROUP BY `country`" +
+			") AS `counts` GROUP BY `country` ORDER BY `total` DESC, `country` ASC LIMIT ?;")
+	mErr.Append(err)
+
+	s.stmts.getUniqueCounts, err = s.db.Prepare(
+		"SELECT `day`, SUM(`n`) FROM (" +
+			"SELECT strftime('%Y-%m-%d 00:00:00', `created`) AS `day`, COUNT(DISTINCT `visitor`) AS `n` FROM `accesses` " +
+			"WHERE `shortlink_id` = ? AND `created` >= ? AND `created` < ? AND `bot` = 0 AND `visitor` <> '' " +
+			"GROUP BY `day` " +
+			"UNION ALL " +
+			"SELECT strftime('%Y-%m-%d 00:00:00', `created`), `uniques` FROM `unique_rollups` " +
+			"WHERE `shortlink_id` = ? AND `created` >= ? AND `created` < ?" +
+			") AS `counts` GROUP BY `day` ORDER BY `day`;")
+	mErr.Append(err)
+
+	s.stmts.getAccessTotal, err = s.db.Prepare(
+		"SELECT COALESCE(SUM(`n`), 0) FROM (" +
+			"SELECT COUNT(`id`) AS `n` FROM `accesses` " +
+			"WHERE `created` >= ? AND `created` < ? AND `bot` BETWEEN ? AND ? " +
+			"AND `shortlink_id` IN (SELECT `id` FROM `shortlinks` WHERE `deleted` = 0) " +
+			"UNION ALL " +
+			"SELECT SUM(`accesses`) FROM `access_rollups` " +
+			"WHERE `created` >= ? AND `created` < ? AND `bot` BETWEEN ? AND ? " +
+			"AND `shortlink_id` IN (SELECT `id` FROM `shortlinks` WHERE `deleted` = 0)" +
+			") AS `counts`;")
+	mErr.Append(err)
+
+	s.stmts.getTopSLs, err = s.db.Prepare(
+		"SELECT `s`.`id`, `s`.`rootlink`, `s`.`shortlink`, `s`.`created`, `s`.`accesses`, `s`.`uniques`, `s`.`edited`, `s`.`expires_at`, `s`.`max_accesses`, `s`.`fallback_url`, `s`.`redirect_type`, `s`.`password_hash`, `c`.`total` FROM (" +
+			"SELECT `shortlink_id`, SUM(`n`) AS `total` FROM (" +
+			"SELECT `shortlink_id`, COUNT(`id`) AS `n` FROM `accesses` " +
+			"WHERE `created` >= ? AND `created` < ? AND `bot` BETWEEN ? AND ? " +
+			"GROUP BY `shortlink_id` " +
+			"UNION ALL " +
+			"SELECT `shortlink_id`, SUM(`accesses`) FROM `access_rollups` " +
+			"WHERE `created` >= ? AND `created` < ? AND `bot` BETWEEN ? AND ? " +
+			"GROUP BY `shortlink_id`" +
+			") AS `counts` GROUP BY `shortlink_id`" +
+			") AS `c` JOIN `shortlinks` AS `s` ON `s`.`id` = `c`.`shortlink_id` " +
+			"WHERE `s`.`deleted` = 0 " +
+			"ORDER BY `c`.`total` DESC, `s`.`id` ASC LIMIT ?;")
+	mErr.Append(err)
+
+	// Short links are idle if neither accesses
+	// nor rollups were recorded since the time.
+	idle := "FROM `shortlinks` WHERE `deleted` = 0 " +
+		"AND `id` NOT IN (SELECT `shortlink_id` FROM `accesses` WHERE `created` >= ? AND `bot` BETWEEN ? AND ?) " +
+		"AND `id` NOT IN (SELECT `shortlink_id` FROM `access_rollups` WHERE `created` >= ? AND `bot` BETWEEN ? AND ?) "
+
+	s.stmts.getIdleSLs, err = s.db.Prepare(
+		"SELECT `id`, `rootlink`, `shortlink`, `created`, `accesses`, `uniques`, `edited`, `expires_at`, `max_accesses`, `fallback_url`, `redirect_type`, `password_hash` " + idle +
+			"ORDER BY `created` ASC, `id` ASC LIMIT ?;")
+	mErr.Append(err)
+
+	s.stmts.countIdleSLs, err = s.db.Prepare(
+		"SELECT COUNT(`id`) " + idle + ";")
+	mErr.Append(err)
+
+	s.stmts.rollUpAccesses, err = s.db.Prepare(
+		"INSERT INTO `access_rollups` " +
+			"(`shortlink_id`, `granularity`, `created`, `bot`, `referrer`, `country`, `utm_source`, `utm_medium`, `utm_campaign`, `accesses`) " +
+			"SELECT `shortlink_id`, 'hour', strftime('%Y-%m-%d %H:00:00', `created`) AS `hour`, " +
+			"`bot`, `referrer`, `country`, `utm_source`, `utm_medium`, `utm_campaign`, COUNT(`id`) FROM `accesses` " +
+			"WHERE `created` < ? " +
+			"GROUP BY `shortlink_id`, `hour`, `bot`, `referrer`, `country`, `utm_source`, `utm_medium`, `utm_campaign`;")
+	mErr.Append(err)
+
+	s.stmts.rollUpUniques, err = s.db.Prepare(
+		"INSERT INTO `unique_rollups` (`shortlink_id`, `created`, `uniques`) " +
+			"SELECT `shortlink_id`, strftime('%Y-%m-%d 00:00:00', `created`) AS `day`, COUNT(DISTINCT `visitor`) FROM `accesses` " +
+			"WHERE `created` < ? AND `bot` = 0 AND `visitor` <> '' " +
+			"GROUP BY `shortlink_id`, `day`;")
+	mErr.Append(err)
+
+	s.stmts.rollUpHourly, err = s.db.Prepare(
+		"INSERT INTO `access_rollups` " +
+			"(`shortlink_id`, `granularity`, `created`, `bot`, `referrer`, `country`, `utm_source`, `utm_medium`, `utm_campaign`, `accesses`) " +
+			"SELECT `shortlink_id`, 'day', strftime('%Y-%m-%d 00:00:00', `created`) AS `day`, " +
+			"`bot`, `referrer`, `country`, `utm_source`, `utm_medium`, `utm_campaign`, SUM(`accesses`) FROM `access_rollups` " +
+			"WHERE `granularity` = 'hour' AND `created` < ? " +
+			"GROUP BY `shortlink_id`, `day`, `bot`, `referrer`, `country`, `utm_source`, `utm_medium`, `utm_campaign`;")
+	mErr.Append(err)
+
+	s.stmts.deleteAccesses, err = s.db.Prepare(
+		"DELETE FROM `accesses` WHERE `created` < ?;")
+	mErr.Append(err)
+
+	s.stmts.deleteHourly, err = s.db.Prepare(
+		"DELETE FROM `access_rollups` WHERE `granularity` = 'hour' AND `created` < ?;")
+	mErr.Append(err)
+
+	s.stmts.deleteDaily, err = s.db.Prepare(
+		"DELETE FROM `access_rollups` WHERE `granularity` = 'day' AND `created` < ?;")
+	mErr.Append(err)
+
+	s.stmts.deleteUniques, err = s.db.Prepare(
+		"DELETE FROM `unique_rollups` WHERE `created` < ?;")
+	mErr.Append(err)
+
+	return mErr.Concat()
+}
+
+// GetShortLinkCount returns the number of short
+// link entries in the database.
+func (s *SQLite) GetShortLinkCount(ctx context.Context) (int, error) {
+	var i int
+	err := s.stmts.getSLCount.QueryRowContext(ctx).Scan(&i)
+	return i, err
+}
+
+// GetShortLink gets a short link object from database by
+// id, root link or short link, depending on which was passed
+// first (in this order).
+// If no short link was found, no error will be returned and
+// the returned short link object will be nil.
+func (s *SQLite) GetShortLink(ctx context.Context, id, root, short string) (*shortlink.ShortLink, error) {
+	switch {
+	case id != "":
+		return s.getShortLinkWithStrategy(ctx, id, s.stmts.getSLByID)
+	case root != "":
+		return s.getShortLinkWithStrategy(ctx, root, s.stmts.getSLByRoot)
+	case short != "":
+		return s.getShortLinkWithStrategy(ctx, short, s.stmts.getSLByShort)
+	default:
+		return nil, nil
+	}
+}
+
+// getShortLinkWithStrategy attempts to find a short link object
+// in the database by a given ident which will be passed to a
+// strategy (SQL prepared statement) defined in the arguments.
+func (s *SQLite) getShortLinkWithStrategy(ctx context.Context, ident string, strategy *sql.Stmt) (*shortlink.ShortLink, error) {
+	sl, err := scanShortLink(strategy.QueryRowContext(ctx, ident))
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return sl, nil
+}
+
+// GetShortLinks returns a list of short links which
+// is ordered by created date descending between
+// from index and limit ammount.
+func (s *SQLite) GetShortLinks(ctx context.Context, from, limit int) ([]*shortlink.ShortLink, error) {
+	rows, err := s.stmts.getSLs.QueryContext(ctx, from, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	sls := make([]*shortlink.ShortLink, 0, limit)
+	for rows.Next() {
+		sl, err := scanShortLink(rows)
+		if err != nil {
+			return nil, err
+		}
+		sls = append(sls, sl)
+	}
+
+	return sls, rows.Err()
+}
+
+// QueryShortLinks returns the list of short links
+// described by the passed query and the total
+// number of short links matching its filters.
+func (s *SQLite) QueryShortLinks(ctx context.Context, q *database.Query) ([]*shortlink.ShortLink, int, error) {
+	b := sqlquery.New(q, dialect)
+
+	var total int
+	err := s.db.QueryRowContext(ctx,
+		"SELECT COUNT(id) FROM shortlinks "+b.Where()+";", b.Args()...).Scan(&total)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	rows, err := s.db.QueryContext(ctx,
+		"SELECT id, rootlink, shortlink, created, accesses, uniques, edited, expires_at, max_accesses, fallback_url, redirect_type, password_hash FROM shortlinks "+
+			b.Page(q)+";", b.Args()...)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer rows.Close()
+
+	sls := make([]*shortlink.ShortLink, 0, q.Limit)
+	for rows.Next() {
+		sl, err := scanShortLink(rows)
+		if err != nil {
+			return nil, 0, err
+		}
+		sls = append(sls, sl)
+	}
+
+	if q.Cursor != nil && q.Cursor.Backward {
+		for i, j := 0, len(sls)-1; i < j; i, j = i+1, j-1 {
+			sls[i], sls[j] = sls[j], sls[i]
+		}
+	}
+
+	return sls, total, rows.Err()
+}
+
+// UpdateShortLink updates the root and short
+// link and the settings of a short link by the
+// values contained in updated.
+func (s *SQLite) UpdateShortLink(ctx context.Context, id int, updated *shortlink.ShortLink) error {
+	return database.Transaction(ctx, s.db, func(tx *sql.Tx) error {
+		old, err := scanShortLink(tx.StmtContext(ctx, s.stmts.getSLByID).QueryRowContext(ctx, id))
+		if err == sql.ErrNoRows {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+
+		_, err = tx.StmtContext(ctx, s.stmts.updateSLByID).ExecContext(ctx,
+			updated.ShortLink, updated.RootLink, nullTime(updated.ExpiresAt),
+			updated.MaxAccesses, updated.FallbackURL, updated.RedirectType, updated.PasswordHash, id)
+		if err != nil {
+			return conflictError(err, updated.ShortLink)
+		}
+
+		return s.addRevision(ctx, tx, shortlink.ActionEdit, old, updated)
+	})
+}
+
+// IncrementAccesses atomically increases the
+// access count of a short link by n.
+func (s *SQLite) IncrementAccesses(ctx context.Context, id, n int) error {
+	_, err := s.stmts.incrAccesses.ExecContext(ctx, n, id)
+	return err
+}
+
+// IncrementUniques atomically increases the
+// unique visitor count of a short link by n.
+func (s *SQLite) IncrementUniques(ctx context.Context, id, n int) error {
+	_, err := s.stmts.incrUniques.ExecContext(ctx, n, id)
+	return err
+}
+
+// CreateShortLink creates a new shortlink
+// entry in the database and returnes the
+// new shortlink object whis was created.
+func (s *SQLite) CreateShortLink(ctx context.Context, sl *shortlink.ShortLink) (*shortlink.ShortLink, error) {
+	var newSl *shortlink.ShortLink
+
+	err := database.Transaction(ctx, s.db, func(tx *sql.Tx) error {
+		res, err := tx.StmtContext(ctx, s.stmts.insertSL).ExecContext(ctx,
+			sl.RootLink, sl.ShortLink, nullTime(sl.ExpiresAt), sl.MaxAccesses, sl.FallbackURL, sl.RedirectType, sl.PasswordHash)
+		if err != nil {
+			return conflictError(err, sl.ShortLink)
+		}
+
+		id, err := res.LastInsertId()
+		if err != nil {
+			return err
+		}
+
+		newSl, err = scanShortLink(tx.StmtContext(ctx, s.stmts.getSLByID).QueryRowContext(ctx, id))
+		if err != nil {
+			return err
+		}
+
+		return s.addRevision(ctx, tx, shortlink.ActionCreate, &shortlink.ShortLink{ID: newSl.ID}, newSl)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return newSl, nil
+}
+
+// DeleteShortLink marks a short link as
+// deleted so that it will not be returned
+// by any getter anymore.
+func (s *SQLite) DeleteShortLink(ctx context.Context, id int) error {
+	return database.Transaction(ctx, s.db, func(tx *sql.Tx) error {
+		old, err := scanShortLink(tx.StmtContext(ctx, s.stmts.getSLByID).QueryRowContext(ctx, id))
+		if err == sql.ErrNoRows {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+
+		if _, err = tx.StmtContext(ctx, s.stmts.deleteSLByID).ExecContext(ctx, id); err != nil {
+			return err
+		}
+
+		return s.addRevision(ctx, tx, shortlink.ActionDelete, old, old)
+	})
+}
+
+// GetTrashedShortLinks returns a list of deleted
+// short links which is ordered by deletion date
+// descending between from index and limit ammount.
+func (s *SQLite) GetTrashedShortLinks(ctx context.Context, from, limit int) ([]*shortlink.Trashed, error) {
+	rows, err := s.stmts.getTrashed.QueryContext(ctx, from, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	sls := make([]*shortlink.Trashed, 0, limit)
+	for rows.Next() {
+		sl, err := scanTrashed(rows)
+		if err != nil {
+			return nil, err
+		}
+		sls = append(sls, sl)
+	}
+
+	return sls, rows.Err()
+}
+
+// GetTrashedShortLink returns the deleted short
+// link with the passed ID. If no deleted short
+// link was found, nil is returned.
+func (s *SQLite) GetTrashedShortLink(ctx context.Context, id int) (*shortlink.Trashed, error) {
+	sl, err := scanTrashed(s.stmts.getTrashedByID.QueryRowContext(ctx, id))
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return sl, nil
+}
+
+// RestoreShortLink makes the deleted short
+// link with the passed ID available again.
+func (s *SQLite) RestoreShortLink(ctx context.Context, id int) error {
+	return database.Transaction(ctx, s.db, func(tx *sql.Tx) error {
+		old, err := scanTrashed(tx.StmtContext(ctx, s.stmts.getTrashedByID).QueryRowContext(ctx, id))
+		if err == sql.ErrNoRows {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+
+		if _, err = tx.StmtContext(ctx, s.stmts.restoreSLByID).ExecContext(ctx, id); err != nil {
+			return conflictError(err, old.ShortLink.ShortLink)
+		}
+
+		return s.addRevision(ctx, tx, shortlink.ActionRestore, &old.ShortLink, &old.ShortLink)
+	})
+}
+
+// PurgeShortLink permanently removes the deleted
+// short link with the passed ID, its revisions
+// and its accesses from the database.
+func (s *SQLite) PurgeShortLink(ctx context.Context, id int) error {
+	return database.Transaction(ctx, s.db, func(tx *sql.Tx) error {
+		res, err := tx.StmtContext(ctx, s.stmts.purgeSLByID).ExecContext(ctx, id)
+		if err != nil {
+			return err
+		}
+		if n, err := res.RowsAffected(); err != nil || n == 0 {
+			return err
+		}
+		return sqlquery.PurgeData(ctx, tx, dialect, []int{id})
+	})
+}
+
+// PurgeTrash permanently removes all short links
+// which were deleted longer than age ago, their
+// revisions and their accesses and returns the
+// number of removed short links.
+func (s *SQLite) PurgeTrash(ctx context.Context, age time.Duration) (int, error) {
+	var ids []int
+
+	err := database.Transaction(ctx, s.db, func(tx *sql.Tx) error {
+		var err error
+		ids, err = sqlquery.QueryIDs(ctx, tx.StmtContext(ctx, s.stmts.getTrashAfter),
+			fmt.Sprintf("-%d seconds", int64(age/time.Second)))
+		if err != nil || len(ids) == 0 {
+			return err
+		}
+		return sqlquery.Purge(ctx, tx, dialect, ids)
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	return len(ids), nil
+}
+
+// GetRevisions returns all revisions of the short
+// link with the passed ID ordered descending.
+func (s *SQLite) GetRevisions(ctx context.Context, id int) ([]*shortlink.Revision, error) {
+	rows, err := s.stmts.getRevisions.QueryContext(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	revs := make([]*shortlink.Revision, 0)
+	for rows.Next() {
+		rev, err := scanRevision(rows)
+		if err != nil {
+			return nil, err
+		}
+		revs = append(revs, rev)
+	}
+
+	return revs, rows.Err()
+}
+
+// GetRevision returns the revision with the ID rev
+// of the short link with the passed ID. If no such
+// revision was found, nil is returned.
+func (s *SQLite) GetRevision(ctx context.Context, id, rev int) (*shortlink.Revision, error) {
+	r, err := scanRevision(s.stmts.getRevision.QueryRowContext(ctx, id, rev))
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	return r, err
+}
+
+// addRevision records a revision of the passed
+// action changing the short link from before to
+// after in the passed transaction. The actor is
+// taken from ctx.
+func (s *SQLite) addRevision(ctx context.Context, tx *sql.Tx, action string, before, after *shortlink.ShortLink) error {
+	_, err := tx.StmtContext(ctx, s.stmts.insertRevision).ExecContext(ctx,
+		before.ID, action, before.RootLink, before.ShortLink, after.RootLink, after.ShortLink, database.Actor(ctx))
+	return err
+}
+
+// AddAccesses records the passed accesses
+// of short links.
+func (s *SQLite) AddAccesses(ctx context.Context, accesses []*shortlink.Access) error {
+	return database.Transaction(ctx, s.db, func(tx *sql.Tx) error {
+		stmt := tx.StmtContext(ctx, s.stmts.insertAccess)
+		for _, a := range accesses {
+			bot := 0
+			if a.Bot {
+				bot = 1
+			}
+			_, err := stmt.ExecContext(ctx, a.ShortLinkID, a.Time.UTC().Format(timeFormat), a.Referrer, a.Agent, bot, a.Country, a.Visitor,
+				a.Source, a.Medium, a.Name, a.Term, a.Content)
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// GetAccessCounts returns the hourly counts of
+// the accesses of the short link with the passed
+// ID selected by traffic in the range [from, to)
+// ordered ascending. Hours without accesses are
+// omitted.
+func (s *SQLite) GetAccessCounts(ctx context.Context, id int, from, to time.Time, traffic database.Traffic) ([]*shortlink.AccessCount, error) {
+	minBot, maxBot := traffic.BotRange()
+	args := []interface{}{id, from.UTC().Format(timeFormat), to.UTC().Format(timeFormat), minBot, maxBot}
+	rows, err := s.stmts.getAccessCounts.QueryContext(ctx, append(args, args...)...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	counts := make([]*shortlink.AccessCount, 0)
+	for rows.Next() {
+		var hour string
+		c := new(shortlink.AccessCount)
+		if err = rows.Scan(&hour, &c.Count); err != nil {
+			return nil, err
+		}
+		if c.Time, err = time.Parse(timeFormat, hour); err != nil {
+			return nil, err
+		}
+		counts = append(counts, c)
+	}
+
+	return counts, rows.Err()
+}
+
+// GetReferrerCounts returns the counts of the
+// accesses of the short link with the passed ID
+// selected by traffic in the range [from, to)
+// per referrer host. The limit referrers
+// with the most accesses are returned ordered by
+// count descending and referrer ascending.
+func (s *SQLite) GetReferrerCounts(ctx context.Context, id int, from, to time.Time, traffic database.Traffic, limit int) ([]*shortlink.ReferrerCount, error) {
+	minBot, maxBot := traffic.BotRange()
+	args := []interface{}{id, from.UTC().Format(timeFormat), to.UTC().Format(timeFormat), minBot, maxBot}
+	rows, err := s.stmts.getReferrerCounts.QueryContext(ctx, append(append(args, args...), limit)...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	counts := make([]*shortlink.ReferrerCount, 0, limit)
+	for rows.Next() {
+		c := new(shortlink.ReferrerCount)
+		if err = rows.Scan(&c.Referrer, &c.Count); err != nil {
+			return nil, err
+		}
+		counts = append(counts, c)
+	}
+
+	return counts, rows.Err()
+}
+
+// GetCampaignCounts returns the counts of the
+// accesses of the short link with the passed ID
+// selected by traffic in the range [from, to) per
+// UTM source, medium and campaign.
+// Accesses without these parameters are not counted.
+// The limit campaigns with the most accesses are
+// returned ordered by count descending and source,
+// medium and campaign ascending.
+func (s *SQLite) GetCampaignCounts(ctx context.Context, id int, from, to time.Time, traffic database.Traffic, limit int) ([]*shortlink.CampaignCount, error) {
+	minBot, maxBot := traffic.BotRange()
+	args := []interface{}{id, from.UTC().Format(timeFormat), to.UTC().Format(timeFormat), minBot, maxBot}
+	rows, err := s.stmts.getCampaignCounts.QueryContext(ctx, append(append(args, args...), limit)...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	counts := make([]*shortlink.CampaignCount, 0, limit)
+	for rows.Next() {
+		c := new(shortlink.CampaignCount)
+		if err = rows.Scan(&c.Source, &c.Medium, &c.Name, &c.Count); err != nil {
+			return nil, err
+		}
+		counts = append(counts, c)
+	}
+
+	return counts, rows.Err()
+}
+
+// GetCountryCounts returns the counts of the
+// accesses of the short link with the passed ID
+// selected by traffic in the range [from, to)
+// per country code. Accesses with unresolved
+// country are counted with an empty code. The
+// limit countries with the most accesses are
+// returned ordered by count descending and
+// country ascending.
+func (s *SQLite) GetCountryCounts(ctx context.Context, id int, from, to time.Time, traffic database.Traffic, limit int) ([]*shortlink.CountryCount, error) {
+	minBot, maxBot := traffic.BotRange()
+	args := []interface{}{id, from.UTC().Format(timeFormat), to.UTC().Format(timeFormat), minBot, maxBot}
+	rows, err := s.stmts.getCountryCounts.QueryContext(ctx, append(append(args, args...), limit)...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	counts := make([]*shortlink.CountryCount, 0, limit)
+	for rows.Next() {
+		c := new(shortlink.CountryCount)
+		if err = rows.Scan(&c.Country, &c.Count); err != nil {
+			return nil, err
+		}
+		counts = append(counts, c)
+	}
+
+	return counts, rows.Err()
+}
+
+// GetUniqueCounts returns the daily counts of the
+// unique human visitors of the short link with the
+// passed ID in the range [from, to) ordered
+// ascending. Days without visitors are omitted.
+func (s *SQLite) GetUniqueCounts(ctx context.Context, id int, from, to time.Time) ([]*shortlink.AccessCount, error) {
+	args := []interface{}{id, from.UTC().Format(timeFormat), to.UTC().Format(timeFormat)}
+	rows, err := s.stmts.getUniqueCounts.QueryContext(ctx, append(args, args...)...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	counts := make([]*shortlink.AccessCount, 0)
+	for rows.Next() {
+		var day string
+		c := new(shortlink.AccessCount)
+		if err = rows.Scan(&day, &c.Count); err != nil {
+			return nil, err
+		}
+		if c.Time, err = time.Parse(timeFormat, day); err != nil {
+			return nil, err
+		}
+		counts = append(counts, c)
+	}
+
+	return counts, rows.Err()
+}
+
+// GetAccessTotal returns the number of accesses
+// of all short links selected by traffic in the
+// range [from, to). Accesses of deleted short
+// links are not counted.
+func (s *SQLite) GetAccessTotal(ctx context.Context, from, to time.Time, traffic database.Traffic) (int, error) {
+	minBot, maxBot := traffic.BotRange()
+	args := []interface{}{from.UTC().Format(timeFormat), to.UTC().Format(timeFormat), minBot, maxBot}
+
+	var total int
+	err := s.stmts.getAccessTotal.QueryRowContext(ctx, append(args, args...)...).Scan(&total)
+	return total, err
+}
+
+// GetTopShortLinks returns the limit short links
+// with the most accesses selected by traffic in
+// the range [from, to) together with their number
+// ordered by count descending and ID ascending.
+// Short links without accesses are omitted.
+func (s *SQLite) GetTopShortLinks(ctx context.Context, from, to time.Time, traffic database.Traffic, limit int) ([]*shortlink.ShortLinkCount, error) {
+	minBot, maxBot := traffic.BotRange()
+	args := []interface{}{from.UTC().Format(timeFormat), to.UTC().Format(timeFormat), minBot, maxBot}
+	rows, err := s.stmts.getTopSLs.QueryContext(ctx, append(append(args, args...), limit)...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	counts := make([]*shortlink.ShortLinkCount, 0, limit)
+	for rows.Next() {
+		var n int
+		sl, err := scanShortLink(rows, &n)
+		if err != nil {
+			return nil, err
+		}
+		counts = append(counts, &shortlink.ShortLinkCount{ShortLink: *sl, Count: n})
+	}
+
+	return counts, rows.Err()
+}
+
+// GetIdleShortLinks returns the limit short links
+// without accesses selected by traffic since the
+// passed time ordered by created date ascending
+// and the total number of such short links.
+func (s *SQLite) GetIdleShortLinks(ctx context.Context, since time.Time, traffic database.Traffic, limit int) ([]*shortlink.ShortLink, int, error) {
+	minBot, maxBot := traffic.BotRange()
+	args := []interface{}{since.UTC().Format(timeFormat), minBot, maxBot}
+	args = append(args, args...)
+
+	var total int
+	if err := s.stmts.countIdleSLs.QueryRowContext(ctx, args...).Scan(&total); err != nil {
+		return nil, 0, err
+	}
+
+	rows, err := s.stmts.getIdleSLs.QueryContext(ctx, append(args, limit)...)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer rows.Close()
+
+	sls := make([]*shortlink.ShortLink, 0, limit)
+	for rows.Next() {
+		sl, err := scanShortLink(rows)
+		if err != nil {
+			return nil, 0, err
+		}
+		sls = append(sls, sl)
+	}
+
+	return sls, total, rows.Err()
+}
+
+// RollUpAccesses aggregates the accesses recorded
+// before the passed time into hourly rollups and
+// the unique visitors into daily rollups, removes
+// the aggregated accesses and returns their number.
+// before should be the start of a day, so that the
+// visitors of a day are not split.
+func (s *SQLite) RollUpAccesses(ctx context.Context, before time.Time) (int, error) {
+	var n int64
+
+	err := database.Transaction(ctx, s.db, func(tx *sql.Tx) error {
+		b := before.UTC().Format(timeFormat)
+		if _, err := tx.StmtContext(ctx, s.stmts.rollUpAccesses).ExecContext(ctx, b); err != nil {
+			return err
+		}
+		if _, err := tx.StmtContext(ctx, s.stmts.rollUpUniques).ExecContext(ctx, b); err != nil {
+			return err
+		}
+
+		res, err := tx.StmtContext(ctx, s.stmts.deleteAccesses).ExecContext(ctx, b)
+		if err != nil {
+			return err
+		}
+		n, err = res.RowsAffected()
+		return err
+	})
+
+	return int(n), err
+}
+
+// RollUpHourlyAccesses aggregates the hourly rollups
+// before the passed time into daily rollups, removes
+// the aggregated hourly rollups and returns their
+// number.
+func (s *SQLite) RollUpHourlyAccesses(ctx context.Context, before time.Time) (int, error) {
+	var n int64
+
+	err := database.Transaction(ctx, s.db, func(tx *sql.Tx) error {
+		b := before.UTC().Format(timeFormat)
+		if _, err := tx.StmtContext(ctx, s.stmts.rollUpHourly).ExecContext(ctx, b); err != nil {
+			return err
+		}
+
+		res, err := tx.StmtContext(ctx, s.stmts.deleteHourly).ExecContext(ctx, b)
+		if err != nil {
+			return err
+		}
+		n, err = res.RowsAffected()
+		return err
+	})
+
+	return int(n), err
+}
+
+// PurgeDailyAccesses removes the daily rollups
+// before the passed time and returns their number.
+func (s *SQLite) PurgeDailyAccesses(ctx context.Context, before time.Time) (int, error) {
+	var n int64
+
+	err := database.Transaction(ctx, s.db, func(tx *sql.Tx) error {
+		b := before.UTC().Format(timeFormat)
+		res, err := tx.StmtContext(ctx, s.stmts.deleteDaily).ExecContext(ctx, b)
+		if err != nil {
+			return err
+		}
+		if n, err = res.RowsAffected(); err != nil {
+			return err
+		}
+
+		_, err = tx.StmtContext(ctx, s.stmts.deleteUniques).ExecContext(ctx, b)
+		return err
+	})
+
+	return int(n), err
+}
+
+// scanner is implemented by *sql.Row
+// and *sql.Rows.
+type scanner interface {
+	Scan(dest ...interface{}) error
+}
+
+// scanShortLink scans a short link from sc
+// and the following columns into extra.
+func scanShortLink(sc scanner, extra ...interface{}) (*shortlink.ShortLink, error) {
+	sl := new(shortlink.ShortLink)
+	err := sc.Scan(append([]interface{}{
+		&sl.ID, &sl.RootLink, &sl.ShortLink, &sl.Created, &sl.Accesses, &sl.Uniques, &sl.Edited,
+		&sl.ExpiresAt, &sl.MaxAccesses, &sl.FallbackURL, &sl.RedirectType, &sl.PasswordHash}, extra...)...)
+	if err != nil {
+		return nil, err
+	}
+	return sl, nil
+}
+
+// scanTrashed scans a short link followed
+// by its deletion date from sc.
+func scanTrashed(sc scanner) (*shortlink.Trashed, error) {
+	var deleted time.Time
+	sl, err := scanShortLink(sc, &deleted)
+	if err != nil {
+		return nil, err
+	}
+	return &shortlink.Trashed{ShortLink: *sl, Deleted: deleted}, nil
+}
+
+// nullTime returns the passed time as text in
+// UTC or nil, if t is nil.
+func nullTime(t *time.Time) interface{} {
+	if t == nil {
+		return nil
+	}
+	return t.UTC().Format(timeFormat)
+}
+
+// scanRevision scans a revision from sc.
+func scanRevision(sc scanner) (*shortlink.Revision, error) {
+	r := new(shortlink.Revision)
+	err := sc.Scan(
+		&r.ID, &r.ShortLinkID, &r.Action, &r.OldRootLink, &r.OldShortLink,
+		&r.NewRootLink, &r.NewShortLink, &r.Actor, &r.Created)
+	if err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+// conflictError returns a database.ConflictError
+// for the passed short identifier if err is a
+// violation of the unique short link index.
+// Otherwise, err is returned.
+func conflictError(err error, short string) error {
+	if e, ok := err.(sqlite3.Error); ok && e.ExtendedCode == sqlite3.ErrConstraintUnique {
+		return &database.ConflictError{ShortLink: short}
+	}
+	return err
+}
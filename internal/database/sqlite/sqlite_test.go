@@ -0,0 +1,33 @@
+package sqlite
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/zekroTJA/slms/internal/database"
+	"github.com/zekroTJA/slms/internal/database/dbtest"
+)
+
+func TestMiddleware(t *testing.T) {
+	dir, err := ioutil.TempDir("", "slms-sqlite-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	var i int
+	dbtest.Run(t, func(t *testing.T) database.Middleware {
+		i++
+		db := new(SQLite)
+		err := db.Open(&Config{
+			File: filepath.Join(dir, fmt.Sprintf("test%d.db", i)),
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return db
+	})
+}
@@ -0,0 +1,84 @@
+package sqlquery
+
+import (
+	"context"
+	"database/sql"
+	"strings"
+)
+
+// maxPurgeIDs is the maximum number of IDs passed to
+// a single DELETE statement, which stays below the
+// parameter limit of SQLite.
+const maxPurgeIDs = 500
+
+// dataTables are the tables containing data of
+// short links referenced by their shortlink_id.
+var dataTables = []string{"revisions", "accesses", "access_rollups", "unique_rollups"}
+
+// QueryIDs returns the IDs selected by the passed
+// statement, which must select a single column.
+func QueryIDs(ctx context.Context, stmt *sql.Stmt, args ...interface{}) ([]int, error) {
+	rows, err := stmt.QueryContext(ctx, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	ids := make([]int, 0)
+	for rows.Next() {
+		var id int
+		if err = rows.Scan(&id); err != nil {
+			return nil, err
+		}
+		ids = append(ids, id)
+	}
+
+	return ids, rows.Err()
+}
+
+// Purge removes the short links with the passed IDs
+// and their revisions, accesses and rollups in the
+// passed transaction.
+func Purge(ctx context.Context, tx *sql.Tx, d Dialect, ids []int) error {
+	if err := deleteIn(ctx, tx, d, "shortlinks", "id", ids); err != nil {
+		return err
+	}
+	return PurgeData(ctx, tx, d, ids)
+}
+
+// PurgeData removes the revisions, accesses and
+// rollups of the short links with the passed IDs
+// in the passed transaction.
+func PurgeData(ctx context.Context, tx *sql.Tx, d Dialect, ids []int) error {
+	for _, table := range dataTables {
+		if err := deleteIn(ctx, tx, d, table, "shortlink_id", ids); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// deleteIn removes the rows of the passed table
+// whose column contains one of the passed IDs.
+func deleteIn(ctx context.Context, tx *sql.Tx, d Dialect, table, col string, ids []int) error {
+	for len(ids) > 0 {
+		chunk := ids
+		if len(chunk) > maxPurgeIDs {
+			chunk = chunk[:maxPurgeIDs]
+		}
+		ids = ids[len(chunk):]
+
+		b := &Builder{dialect: d}
+		placeholders := make([]string, len(chunk))
+		for i, id := range chunk {
+			placeholders[i] = b.arg(id)
+		}
+
+		_, err := tx.ExecContext(ctx, "DELETE FROM "+table+" WHERE "+col+
+			" IN ("+strings.Join(placeholders, ", ")+");", b.Args()...)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,195 @@
+// Package sqlquery builds the SQL clauses of
+// short link list queries and the statements
+// purging short links for the SQL database
+// backends.
+package sqlquery
+
+import (
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/zekroTJA/slms/internal/database"
+)
+
+// likeEscape is the escape character used
+// in LIKE patterns.
+const likeEscape = "!"
+
+// columns maps sort fields to column names.
+var columns = map[string]string{
+	database.SortID:        "id",
+	database.SortRootLink:  "rootlink",
+	database.SortShortLink: "shortlink",
+	database.SortCreated:   "created",
+	database.SortAccesses:  "accesses",
+	database.SortEdited:    "edited",
+}
+
+// Dialect describes the differences of the
+// SQL dialects of the database backends.
+type Dialect struct {
+	// Dollar enables '$n' placeholders
+	// instead of '?'.
+	Dollar bool
+	// Like is the operator used for case-
+	// insensitive pattern matching.
+	Like string
+	// FormatTime converts time values to
+	// query arguments. If nil, time values
+	// are passed as they are.
+	FormatTime func(t time.Time) interface{}
+}
+
+// Builder collects the clauses and
+// arguments of a query.
+type Builder struct {
+	dialect Dialect
+	where   []string
+	args    []interface{}
+}
+
+// New creates a Builder which builds the clauses
+// of the passed query for non-deleted short links.
+func New(q *database.Query, d Dialect) *Builder {
+	b := &Builder{
+		dialect: d,
+		where:   []string{"deleted = 0"},
+	}
+
+	if d.Like == "" {
+		b.dialect.Like = "LIKE"
+	}
+
+	if q.Search != "" {
+		p := "%" + escapeLike(q.Search) + "%"
+		b.where = append(b.where, "("+b.like("shortlink", p)+" OR "+b.like("rootlink", p)+")")
+	}
+
+	if q.Domain != "" {
+		b.where = append(b.where, b.domain(strings.ToLower(q.Domain)))
+	}
+
+	b.timeRange("created", q.CreatedFrom, q.CreatedTo)
+	b.timeRange("edited", q.EditedFrom, q.EditedTo)
+
+	if q.MinAccesses > 0 {
+		b.where = append(b.where, "accesses >= "+b.arg(q.MinAccesses))
+	}
+
+	return b
+}
+
+// Where returns the WHERE clause of the filters.
+// Until Page is called, Args returns its arguments.
+func (b *Builder) Where() string {
+	return "WHERE " + strings.Join(b.where, " AND ")
+}
+
+// Page returns the WHERE, ORDER BY and LIMIT
+// clauses selecting the page of the passed query
+// and adds their arguments.
+// If the cursor of the query is backward, the
+// order is reversed, so the rows must be reversed
+// after reading.
+func (b *Builder) Page(q *database.Query) string {
+	where := b.Where()
+	col, ok := columns[q.Sort]
+	if !ok {
+		col = columns[database.SortCreated]
+	}
+	desc := q.Descending
+
+	if c := q.Cursor; c != nil {
+		col = columns[database.SortCreated]
+		if c.Backward {
+			desc = !desc
+		}
+		op := ">"
+		if desc {
+			op = "<"
+		}
+		where += " AND (created " + op + " " + b.timeArg(c.Created) +
+			" OR created = " + b.timeArg(c.Created) + " AND id " + op + " " + b.arg(c.ID) + ")"
+	}
+
+	dir := "ASC"
+	if desc {
+		dir = "DESC"
+	}
+	order := "ORDER BY " + col + " " + dir + ", id " + dir
+	if col == "id" {
+		order = "ORDER BY id " + dir
+	}
+
+	return where + " " + order + " LIMIT " + b.arg(q.Limit) + " OFFSET " + b.arg(q.From)
+}
+
+// Args returns the arguments of all
+// clauses built so far.
+func (b *Builder) Args() []interface{} {
+	return b.args
+}
+
+// arg adds the passed argument and
+// returns its placeholder.
+func (b *Builder) arg(v interface{}) string {
+	b.args = append(b.args, v)
+	if b.dialect.Dollar {
+		return "$" + strconv.Itoa(len(b.args))
+	}
+	return "?"
+}
+
+// timeArg adds the passed time argument
+// and returns its placeholder.
+func (b *Builder) timeArg(t time.Time) string {
+	if b.dialect.FormatTime != nil {
+		return b.arg(b.dialect.FormatTime(t))
+	}
+	return b.arg(t)
+}
+
+// like returns a pattern matching condition
+// of the passed column and pattern.
+func (b *Builder) like(col, pattern string) string {
+	return col + " " + b.dialect.Like + " " + b.arg(pattern) + " ESCAPE '" + likeEscape + "'"
+}
+
+// timeRange adds the conditions of the time
+// range [from, to) of the passed column. Zero
+// times are ignored.
+func (b *Builder) timeRange(col string, from, to time.Time) {
+	if !from.IsZero() {
+		b.where = append(b.where, col+" >= "+b.timeArg(from))
+	}
+	if !to.IsZero() {
+		b.where = append(b.where, col+" < "+b.timeArg(to))
+	}
+}
+
+// domain returns a condition matching root
+// links with the passed host. The host of a
+// URL ends with the URL, a port, a path, a
+// query or a fragment.
+func (b *Builder) domain(host string) string {
+	host = escapeLike(host)
+	conds := make([]string, 0, 10)
+	for _, scheme := range []string{"http://", "https://"} {
+		conds = append(conds, b.like("rootlink", scheme+host))
+		for _, sep := range []string{":", "/", "?", "#"} {
+			conds = append(conds, b.like("rootlink", scheme+host+sep+"%"))
+		}
+	}
+	return "(" + strings.Join(conds, " OR ") + ")"
+}
+
+// escapeLike escapes wildcard characters
+// in s for the use in LIKE patterns.
+func escapeLike(s string) string {
+	return strings.NewReplacer(
+		likeEscape, likeEscape+likeEscape,
+		"%", likeEscape+"%",
+		"_", likeEscape+"_",
+	).Replace(s)
+}
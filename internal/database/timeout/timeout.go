@@ -0,0 +1,256 @@
+// Package timeout provides a database middleware
+// which limits the duration of each call to another
+// database middleware.
+package timeout
+
+import (
+	"context"
+	"time"
+
+	"github.com/zekroTJA/slms/internal/database"
+	"github.com/zekroTJA/slms/internal/shortlink"
+)
+
+// Timeout wraps a database middleware and passes
+// a context to each call which is cancelled after
+// the specified duration, if the passed context is
+// not done earlier.
+type Timeout struct {
+	database.Middleware
+
+	d time.Duration
+}
+
+// New creates a new Timeout wrapping the passed,
+// already opened database middleware which limits
+// each call to the duration d.
+func New(db database.Middleware, d time.Duration) *Timeout {
+	return &Timeout{
+		Middleware: db,
+		d:          d,
+	}
+}
+
+// GetShortLinkCount calls GetShortLinkCount of the
+// wrapped database middleware with a timeout.
+func (t *Timeout) GetShortLinkCount(ctx context.Context) (int, error) {
+	ctx, cancel := context.WithTimeout(ctx, t.d)
+	defer cancel()
+	return t.Middleware.GetShortLinkCount(ctx)
+}
+
+// GetShortLink calls GetShortLink of the
+// wrapped database middleware with a timeout.
+func (t *Timeout) GetShortLink(ctx context.Context, id, root, short string) (*shortlink.ShortLink, error) {
+	ctx, cancel := context.WithTimeout(ctx, t.d)
+	defer cancel()
+	return t.Middleware.GetShortLink(ctx, id, root, short)
+}
+
+// GetShortLinks calls GetShortLinks of the
+// wrapped database middleware with a timeout.
+func (t *Timeout) GetShortLinks(ctx context.Context, from, limit int) ([]*shortlink.ShortLink, error) {
+	ctx, cancel := context.WithTimeout(ctx, t.d)
+	defer cancel()
+	return t.Middleware.GetShortLinks(ctx, from, limit)
+}
+
+// QueryShortLinks calls QueryShortLinks of the
+// wrapped database middleware with a timeout.
+func (t *Timeout) QueryShortLinks(ctx context.Context, q *database.Query) ([]*shortlink.ShortLink, int, error) {
+	ctx, cancel := context.WithTimeout(ctx, t.d)
+	defer cancel()
+	return t.Middleware.QueryShortLinks(ctx, q)
+}
+
+// UpdateShortLink calls UpdateShortLink of the
+// wrapped database middleware with a timeout.
+func (t *Timeout) UpdateShortLink(ctx context.Context, id int, updated *shortlink.ShortLink) error {
+	ctx, cancel := context.WithTimeout(ctx, t.d)
+	defer cancel()
+	return t.Middleware.UpdateShortLink(ctx, id, updated)
+}
+
+// IncrementAccesses calls IncrementAccesses of the
+// wrapped database middleware with a timeout.
+func (t *Timeout) IncrementAccesses(ctx context.Context, id, n int) error {
+	ctx, cancel := context.WithTimeout(ctx, t.d)
+	defer cancel()
+	return t.Middleware.IncrementAccesses(ctx, id, n)
+}
+
+// IncrementUniques calls IncrementUniques of the
+// wrapped database middleware with a timeout.
+func (t *Timeout) IncrementUniques(ctx context.Context, id, n int) error {
+	ctx, cancel := context.WithTimeout(ctx, t.d)
+	defer cancel()
+	return t.Middleware.IncrementUniques(ctx, id, n)
+}
+
+// CreateShortLink calls CreateShortLink of the
+// wrapped database middleware with a timeout.
+func (t *Timeout) CreateShortLink(ctx context.Context, sl *shortlink.ShortLink) (*shortlink.ShortLink, error) {
+	ctx, cancel := context.WithTimeout(ctx, t.d)
+	defer cancel()
+	return t.Middleware.CreateShortLink(ctx, sl)
+}
+
+// DeleteShortLink calls DeleteShortLink of the
+// wrapped database middleware with a timeout.
+func (t *Timeout) DeleteShortLink(ctx context.Context, id int) error {
+	ctx, cancel := context.WithTimeout(ctx, t.d)
+	defer cancel()
+	return t.Middleware.DeleteShortLink(ctx, id)
+}
+
+// GetTrashedShortLinks calls GetTrashedShortLinks of
+// the wrapped database middleware with a timeout.
+func (t *Timeout) GetTrashedShortLinks(ctx context.Context, from, limit int) ([]*shortlink.Trashed, error) {
+	ctx, cancel := context.WithTimeout(ctx, t.d)
+	defer cancel()
+	return t.Middleware.GetTrashedShortLinks(ctx, from, limit)
+}
+
+// GetTrashedShortLink calls GetTrashedShortLink of
+// the wrapped database middleware with a timeout.
+func (t *Timeout) GetTrashedShortLink(ctx context.Context, id int) (*shortlink.Trashed, error) {
+	ctx, cancel := context.WithTimeout(ctx, t.d)
+	defer cancel()
+	return t.Middleware.GetTrashedShortLink(ctx, id)
+}
+
+// RestoreShortLink calls RestoreShortLink of the
+// wrapped database middleware with a timeout.
+func (t *Timeout) RestoreShortLink(ctx context.Context, id int) error {
+	ctx, cancel := context.WithTimeout(ctx, t.d)
+	defer cancel()
+	return t.Middleware.RestoreShortLink(ctx, id)
+}
+
+// PurgeShortLink calls PurgeShortLink of the
+// wrapped database middleware with a timeout.
+func (t *Timeout) PurgeShortLink(ctx context.Context, id int) error {
+	ctx, cancel := context.WithTimeout(ctx, t.d)
+	defer cancel()
+	return t.Middleware.PurgeShortLink(ctx, id)
+}
+
+// PurgeTrash calls PurgeTrash of the wrapped
+// database middleware with a timeout.
+func (t *Timeout) PurgeTrash(ctx context.Context, age time.Duration) (int, error) {
+	ctx, cancel := context.WithTimeout(ctx, t.d)
+	defer cancel()
+	return t.Middleware.PurgeTrash(ctx, age)
+}
+
+// GetRevisions calls GetRevisions of the wrapped
+// database middleware with a timeout.
+func (t *Timeout) GetRevisions(ctx context.Context, id int) ([]*shortlink.Revision, error) {
+	ctx, cancel := context.WithTimeout(ctx, t.d)
+	defer cancel()
+	return t.Middleware.GetRevisions(ctx, id)
+}
+
+// GetRevision calls GetRevision of the wrapped
+// database middleware with a timeout.
+func (t *Timeout) GetRevision(ctx context.Context, id, rev int) (*shortlink.Revision, error) {
+	ctx, cancel := context.WithTimeout(ctx, t.d)
+	defer cancel()
+	return t.Middleware.GetRevision(ctx, id, rev)
+}
+
+// AddAccesses calls AddAccesses of the wrapped
+// database middleware with a timeout.
+func (t *Timeout) AddAccesses(ctx context.Context, accesses []*shortlink.Access) error {
+	ctx, cancel := context.WithTimeout(ctx, t.d)
+	defer cancel()
+	return t.Middleware.AddAccesses(ctx, accesses)
+}
+
+// GetAccessCounts calls GetAccessCounts of the
+// wrapped database middleware with a timeout.
+func (t *Timeout) GetAccessCounts(ctx context.Context, id int, from, to time.Time, traffic database.Traffic) ([]*shortlink.AccessCount, error) {
+	ctx, cancel := context.WithTimeout(ctx, t.d)
+	defer cancel()
+	return t.Middleware.GetAccessCounts(ctx, id, from, to, traffic)
+}
+
+// GetReferrerCounts calls GetReferrerCounts of the
+// wrapped database middleware with a timeout.
+func (t *Timeout) GetReferrerCounts(ctx context.Context, id int, from, to time.Time, traffic database.Traffic, limit int) ([]*shortlink.ReferrerCount, error) {
+	ctx, cancel := context.WithTimeout(ctx, t.d)
+	defer cancel()
+	return t.Middleware.GetReferrerCounts(ctx, id, from, to, traffic, limit)
+}
+
+// GetCampaignCounts calls GetCampaignCounts of the
+// wrapped database middleware with a timeout.
+func (t *Timeout) GetCampaignCounts(ctx context.Context, id int, from, to time.Time, traffic database.Traffic, limit int) ([]*shortlink.CampaignCount, error) {
+	ctx, cancel := context.WithTimeout(ctx, t.d)
+	defer cancel()
+	return t.Middleware.GetCampaignCounts(ctx, id, from, to, traffic, limit)
+}
+
+// GetCountryCounts calls GetCountryCounts of the
+// wrapped database middleware with a timeout.
+func (t *Timeout) GetCountryCounts(ctx context.Context, id int, from, to time.Time, traffic database.Traffic, limit int) ([]*shortlink.CountryCount, error) {
+	ctx, cancel := context.WithTimeout(ctx, t.d)
+	defer cancel()
+	return t.Middleware.GetCountryCounts(ctx, id, from, to, traffic, limit)
+}
+
+// GetUniqueCounts calls GetUniqueCounts of the
+// wrapped database middleware with a timeout.
+func (t *Timeout) GetUniqueCounts(ctx context.Context, id int, from, to time.Time) ([]*shortlink.AccessCount, error) {
+	ctx, cancel := context.WithTimeout(ctx, t.d)
+	defer cancel()
+	return t.Middleware.GetUniqueCounts(ctx, id, from, to)
+}
+
+// GetAccessTotal calls GetAccessTotal of the
+// wrapped database middleware with a timeout.
+func (t *Timeout) GetAccessTotal(ctx context.Context, from, to time.Time, traffic database.Traffic) (int, error) {
+	ctx, cancel := context.WithTimeout(ctx, t.d)
+	defer cancel()
+	return t.Middleware.GetAccessTotal(ctx, from, to, traffic)
+}
+
+// GetTopShortLinks calls GetTopShortLinks of the
+// wrapped database middleware with a timeout.
+func (t *Timeout) GetTopShortLinks(ctx context.Context, from, to time.Time, traffic database.Traffic, limit int) ([]*shortlink.ShortLinkCount, error) {
+	ctx, cancel := context.WithTimeout(ctx, t.d)
+	defer cancel()
+	return t.Middleware.GetTopShortLinks(ctx, from, to, traffic, limit)
+}
+
+// GetIdleShortLinks calls GetIdleShortLinks of the
+// wrapped database middleware with a timeout.
+func (t *Timeout) GetIdleShortLinks(ctx context.Context, since time.Time, traffic database.Traffic, limit int) ([]*shortlink.ShortLink, int, error) {
+	ctx, cancel := context.WithTimeout(ctx, t.d)
+	defer cancel()
+	return t.Middleware.GetIdleShortLinks(ctx, since, traffic, limit)
+}
+
+// RollUpAccesses calls RollUpAccesses of the
+// wrapped database middleware with a timeout.
+func (t *Timeout) RollUpAccesses(ctx context.Context, before time.Time) (int, error) {
+	ctx, cancel := context.WithTimeout(ctx, t.d)
+	defer cancel()
+	return t.Middleware.RollUpAccesses(ctx, before)
+}
+
+// RollUpHourlyAccesses calls RollUpHourlyAccesses of the
+// wrapped database middleware with a timeout.
+func (t *Timeout) RollUpHourlyAccesses(ctx context.Context, before time.Time) (int, error) {
+	ctx, cancel := context.WithTimeout(ctx, t.d)
+	defer cancel()
+	return t.Middleware.RollUpHourlyAccesses(ctx, before)
+}
+
+// PurgeDailyAccesses calls PurgeDailyAccesses of the
+// wrapped database middleware with a timeout.
+func (t *Timeout) PurgeDailyAccesses(ctx context.Context, before time.Time) (int, error) {
+	ctx, cancel := context.WithTimeout(ctx, t.d)
+	defer cancel()
+	return t.Middleware.PurgeDailyAccesses(ctx, before)
+}
@@ -0,0 +1,20 @@
+package timeout
+
+import (
+	"testing"
+	"time"
+
+	"github.com/zekroTJA/slms/internal/database"
+	"github.com/zekroTJA/slms/internal/database/dbtest"
+	"github.com/zekroTJA/slms/internal/database/memory"
+)
+
+func TestMiddleware(t *testing.T) {
+	dbtest.Run(t, func(t *testing.T) database.Middleware {
+		db := new(memory.Memory)
+		if err := db.Open(new(memory.Config)); err != nil {
+			t.Fatal(err)
+		}
+		return New(db, 5*time.Second)
+	})
+}
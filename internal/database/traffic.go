@@ -0,0 +1,48 @@
+package database
+
+// Traffic selects the accesses counted by
+// access statistics by their origin. The
+// zero value selects TrafficHuman.
+type Traffic string
+
+// Origins of accesses.
+const (
+	TrafficHuman Traffic = "human"
+	TrafficBot   Traffic = "bot"
+	TrafficAll   Traffic = "all"
+)
+
+// IsTraffic returns true if the passed
+// value names a traffic selection.
+func IsTraffic(v string) bool {
+	switch Traffic(v) {
+	case TrafficHuman, TrafficBot, TrafficAll:
+		return true
+	}
+	return false
+}
+
+// BotRange returns the range of the bot
+// flags of the selected accesses stored
+// as 0 for humans and 1 for bots.
+func (t Traffic) BotRange() (min, max int) {
+	switch t {
+	case TrafficBot:
+		return 1, 1
+	case TrafficAll:
+		return 0, 1
+	default:
+		return 0, 0
+	}
+}
+
+// Matches returns true if an access with
+// the passed bot flag is selected.
+func (t Traffic) Matches(bot bool) bool {
+	min, max := t.BotRange()
+	v := 0
+	if bot {
+		v = 1
+	}
+	return v >= min && v <= max
+}
@@ -0,0 +1,24 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+)
+
+// Transaction executes fn in a transaction on
+// the passed database. The transaction is
+// committed if fn returns nil and rolled back
+// otherwise.
+func Transaction(ctx context.Context, db *sql.DB, fn func(tx *sql.Tx) error) error {
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+
+	if err = fn(tx); err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	return tx.Commit()
+}
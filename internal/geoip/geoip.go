@@ -0,0 +1,67 @@
+// Package geoip resolves the countries of IP
+// addresses from a local MaxMind DB file.
+//
+// Autonomous system numbers are not resolved.
+// They are only contained in separate ASN
+// databases, and as nothing but the country
+// code of visitors is recorded, they would be
+// discarded right after the lookup.
+package geoip
+
+import (
+	"net"
+
+	"github.com/oschwald/maxminddb-golang"
+)
+
+// record contains the fields of a MaxMind DB
+// record which are required to resolve the
+// country of an IP address.
+type record struct {
+	Country struct {
+		ISOCode string `maxminddb:"iso_code"`
+	} `maxminddb:"country"`
+	RegisteredCountry struct {
+		ISOCode string `maxminddb:"iso_code"`
+	} `maxminddb:"registered_country"`
+}
+
+// Resolver resolves the countries of IP
+// addresses from a MaxMind DB file like
+// GeoLite2 Country or GeoLite2 City.
+type Resolver struct {
+	reader *maxminddb.Reader
+}
+
+// Open opens the MaxMind DB file at the
+// passed location.
+func Open(file string) (*Resolver, error) {
+	reader, err := maxminddb.Open(file)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Resolver{reader}, nil
+}
+
+// Country returns the ISO 3166-1 alpha-2 code of
+// the country the passed IP address is located
+// in, or of the country it is registered in if
+// the location is unknown. An empty string is
+// returned if the country can not be resolved.
+func (r *Resolver) Country(ip net.IP) string {
+	var rec record
+	if err := r.reader.Lookup(ip, &rec); err != nil {
+		return ""
+	}
+
+	if rec.Country.ISOCode != "" {
+		return rec.Country.ISOCode
+	}
+	return rec.RegisteredCountry.ISOCode
+}
+
+// Close closes the MaxMind DB file.
+func (r *Resolver) Close() error {
+	return r.reader.Close()
+}
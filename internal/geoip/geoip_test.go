@@ -0,0 +1,153 @@
+package geoip
+
+import (
+	"bytes"
+	"io/ioutil"
+	"net"
+	"os"
+	"testing"
+)
+
+// fixture is a network of the test database
+// and the country code stored in field.
+type fixture struct {
+	cidr  string
+	field string
+	code  string
+}
+
+func TestCountry(t *testing.T) {
+	file := writeDatabase(t, []fixture{
+		{"81.2.69.0/24", "country", "GB"},
+		{"89.160.20.0/24", "country", "SE"},
+		{"2.125.160.0/24", "registered_country", "JP"},
+	})
+	defer os.Remove(file)
+
+	r, err := Open(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+
+	cases := map[string]string{
+		"81.2.69.142":   "GB",
+		"89.160.20.112": "SE",
+		"2.125.160.216": "JP",
+		"192.0.2.1":     "",
+		"2001:db8::1":   "",
+	}
+	for ip, exp := range cases {
+		if got := r.Country(net.ParseIP(ip)); got != exp {
+			t.Errorf("country of %s should be '%s' but was '%s'", ip, exp, got)
+		}
+	}
+}
+
+func TestOpenMissing(t *testing.T) {
+	if _, err := Open("./missing.mmdb"); err == nil {
+		t.Error("opening a missing file should fail")
+	}
+}
+
+// writeDatabase writes an IPv4 MaxMind DB file with
+// a 24 bit search tree containing the passed networks
+// to a temporary file and returns its location.
+func writeDatabase(t *testing.T, networks []fixture) string {
+	// Records >= 0 point to nodes, -1 is empty
+	// and -2 - i points to the data of network i.
+	nodes := [][2]int{{-1, -1}}
+	var data [][]byte
+
+	for i, n := range networks {
+		_, ipNet, err := net.ParseCIDR(n.cidr)
+		if err != nil {
+			t.Fatal(err)
+		}
+		ip := ipNet.IP.To4()
+		ones, _ := ipNet.Mask.Size()
+
+		node := 0
+		for b := 0; b < ones; b++ {
+			bit := int(ip[b/8]>>(7-uint(b%8))) & 1
+			if b == ones-1 {
+				nodes[node][bit] = -2 - i
+				break
+			}
+			if nodes[node][bit] == -1 {
+				nodes = append(nodes, [2]int{-1, -1})
+				nodes[node][bit] = len(nodes) - 1
+			}
+			node = nodes[node][bit]
+		}
+
+		data = append(data, encMap(encString(n.field), encMap(encString("iso_code"), encString(n.code))))
+	}
+
+	offsets := make([]int, len(data))
+	for i := 1; i < len(data); i++ {
+		offsets[i] = offsets[i-1] + len(data[i-1])
+	}
+
+	var buf bytes.Buffer
+	for _, n := range nodes {
+		for _, rec := range n {
+			v := rec
+			switch {
+			case rec == -1:
+				v = len(nodes)
+			case rec < -1:
+				v = len(nodes) + 16 + offsets[-2-rec]
+			}
+			buf.Write([]byte{byte(v >> 16), byte(v >> 8), byte(v)})
+		}
+	}
+	buf.Write(make([]byte, 16))
+	for _, d := range data {
+		buf.Write(d)
+	}
+
+	buf.WriteString("\xab\xcd\xefMaxMind.com")
+	buf.Write(encMap(
+		encString("node_count"), encUint32(uint32(len(nodes))),
+		encString("record_size"), encUint16(24),
+		encString("ip_version"), encUint16(4),
+		encString("database_type"), encString("SLMS-Test"),
+		encString("binary_format_major_version"), encUint16(2),
+		encString("binary_format_minor_version"), encUint16(0),
+	))
+
+	f, err := ioutil.TempFile("", "slms-geoip-*.mmdb")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	if _, err = f.Write(buf.Bytes()); err != nil {
+		t.Fatal(err)
+	}
+
+	return f.Name()
+}
+
+func encString(s string) []byte {
+	return append([]byte{2<<5 | byte(len(s))}, s...)
+}
+
+func encUint16(v uint16) []byte {
+	return []byte{5<<5 | 2, byte(v >> 8), byte(v)}
+}
+
+func encUint32(v uint32) []byte {
+	return []byte{6<<5 | 4, byte(v >> 24), byte(v >> 16), byte(v >> 8), byte(v)}
+}
+
+// encMap encodes a map of the passed
+// encoded keys and values in turn.
+func encMap(kv ...[]byte) []byte {
+	b := []byte{7<<5 | byte(len(kv)/2)}
+	for _, e := range kv {
+		b = append(b, e...)
+	}
+	return b
+}
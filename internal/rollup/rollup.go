@@ -0,0 +1,132 @@
+// Package rollup provides a background job which
+// aggregates recorded accesses into hourly and
+// daily rollups and removes them after their
+// retention periods.
+package rollup
+
+import (
+	"context"
+	"time"
+
+	"github.com/zekroTJA/slms/internal/database"
+	"github.com/zekroTJA/slms/internal/logger"
+	"github.com/zekroTJA/slms/internal/stats"
+)
+
+// DefaultInterval is the interval in which
+// expired accesses are rolled up.
+const DefaultInterval = 1 * time.Hour
+
+// Config contains the retention periods in days
+// of each access granularity. Raw accesses are
+// rolled up into hourly rollups after Raw days,
+// hourly rollups into daily rollups after Hourly
+// days and daily rollups are removed after Daily
+// days. 0 keeps the granularity forever.
+type Config struct {
+	Raw    int `json:"raw"`
+	Hourly int `json:"hourly"`
+	Daily  int `json:"daily"`
+}
+
+// Roller rolls up the accesses of a database
+// by the configured retention periods in the
+// specified interval.
+type Roller struct {
+	db  database.Middleware
+	cfg Config
+
+	ticker *time.Ticker
+	stop   chan struct{}
+	done   chan struct{}
+}
+
+// New creates a new Roller which rolls up the
+// accesses of the passed database by cfg. The
+// accesses are rolled up once on creation and
+// then in the passed interval.
+// If interval is <= 0, DefaultInterval is used.
+func New(db database.Middleware, cfg Config, interval time.Duration) *Roller {
+	if interval <= 0 {
+		interval = DefaultInterval
+	}
+
+	r := &Roller{
+		db:     db,
+		cfg:    cfg,
+		ticker: time.NewTicker(interval),
+		stop:   make(chan struct{}),
+		done:   make(chan struct{}),
+	}
+
+	go r.loop()
+
+	return r
+}
+
+// RollUp rolls up the raw accesses and hourly
+// rollups and removes the daily rollups which
+// exceeded their retention period at now.
+// The cutoffs are aligned to the start of the
+// day. The returned numbers are the rolled up
+// accesses, the rolled up hourly rollups and
+// the removed daily rollups.
+func (r *Roller) RollUp(ctx context.Context, now time.Time) (raw, hourly, daily int, err error) {
+	if r.cfg.Raw > 0 {
+		if raw, err = r.db.RollUpAccesses(ctx, cutoff(now, r.cfg.Raw)); err != nil {
+			return
+		}
+	}
+	if r.cfg.Hourly > 0 {
+		if hourly, err = r.db.RollUpHourlyAccesses(ctx, cutoff(now, r.cfg.Hourly)); err != nil {
+			return
+		}
+	}
+	if r.cfg.Daily > 0 {
+		daily, err = r.db.PurgeDailyAccesses(ctx, cutoff(now, r.cfg.Daily))
+	}
+	return
+}
+
+// Close stops the rollup loop.
+func (r *Roller) Close() {
+	r.ticker.Stop()
+	close(r.stop)
+	<-r.done
+}
+
+// loop rolls up the accesses initially and
+// on each tick until the roller is closed.
+func (r *Roller) loop() {
+	defer close(r.done)
+
+	r.rollUp()
+
+	for {
+		select {
+		case <-r.ticker.C:
+			r.rollUp()
+		case <-r.stop:
+			return
+		}
+	}
+}
+
+// rollUp rolls up the accesses and logs the result.
+func (r *Roller) rollUp() {
+	raw, hourly, daily, err := r.RollUp(context.Background(), time.Now())
+	if err != nil {
+		logger.Error("ROLLUP :: failed rolling up accesses: %s", err.Error())
+		return
+	}
+	if raw > 0 || hourly > 0 || daily > 0 {
+		logger.Info("ROLLUP :: rolled up %d access(es) and %d hourly rollup(s), removed %d daily rollup(s)",
+			raw, hourly, daily)
+	}
+}
+
+// cutoff returns the start of the day
+// the passed number of days before now.
+func cutoff(now time.Time, days int) time.Time {
+	return stats.IntervalDay.Start(now).AddDate(0, 0, -days)
+}
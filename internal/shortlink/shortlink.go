@@ -3,13 +3,167 @@ package shortlink
 import "time"
 
 // A ShortLink contains the ID, root link,
-// short string, created date, access count
-// and edited date of a short link.
+// short string, created date, access count,
+// the sum of the daily unique visitors and
+// edited date of a short link.
+// A short link stops redirecting to its root
+// link after ExpiresAt, if set, and after
+// MaxAccesses accesses, if greater than 0.
+// Requests to an expired short link are
+// redirected to FallbackURL, if set.
+// RedirectType is the kind of redirect to the
+// root link. If empty, the default of the
+// web server is used.
+// If PasswordHash is set, visitors must enter
+// the password to be redirected. The hash is
+// never contained in JSON representations.
+// Expired and Protected are not stored but
+// set by the web server in responses.
 type ShortLink struct {
-	ID        int       `json:"id"`
-	RootLink  string    `json:"root_link"`
-	ShortLink string    `json:"short_link"`
-	Created   time.Time `json:"created"`
-	Accesses  int       `json:"accesses"`
-	Edited    time.Time `json:"edited"`
+	ID           int        `json:"id"`
+	RootLink     string     `json:"root_link"`
+	ShortLink    string     `json:"short_link"`
+	Created      time.Time  `json:"created"`
+	Accesses     int        `json:"accesses"`
+	Uniques      int        `json:"uniques"`
+	Edited       time.Time  `json:"edited"`
+	ExpiresAt    *time.Time `json:"expires_at"`
+	MaxAccesses  int        `json:"max_accesses"`
+	FallbackURL  string     `json:"fallback_url"`
+	RedirectType string     `json:"redirect_type"`
+	PasswordHash string     `json:"-"`
+	Expired      bool       `json:"expired"`
+	Protected    bool       `json:"protected"`
+}
+
+// Redirect types of short links. Redirects by
+// status code are named by their code, pages
+// redirecting by HTML meta refresh by 'meta'.
+const (
+	RedirectMovedPermanently = "301"
+	RedirectFound            = "302"
+	RedirectTemporary        = "307"
+	RedirectPermanent        = "308"
+	RedirectMetaRefresh      = "meta"
+)
+
+// IsExpired returns true if the short link
+// expired at the passed time or if its access
+// count plus the passed number of accesses not
+// yet counted reaches its access limit.
+func (sl *ShortLink) IsExpired(now time.Time, pending int) bool {
+	if sl.ExpiresAt != nil && !now.Before(*sl.ExpiresAt) {
+		return true
+	}
+	return sl.MaxAccesses > 0 && sl.Accesses+pending >= sl.MaxAccesses
+}
+
+// A Trashed short link is a deleted short
+// link together with its deletion date.
+type Trashed struct {
+	ShortLink
+	Deleted time.Time `json:"deleted"`
+}
+
+// Revision actions.
+const (
+	ActionCreate  = "create"
+	ActionEdit    = "edit"
+	ActionDelete  = "delete"
+	ActionRestore = "restore"
+)
+
+// A Revision records a change of a short
+// link with the root and short link before
+// and after the change, the change date and
+// the actor who initiated it.
+type Revision struct {
+	ID           int       `json:"id"`
+	ShortLinkID  int       `json:"short_link_id"`
+	Action       string    `json:"action"`
+	OldRootLink  string    `json:"old_root_link"`
+	OldShortLink string    `json:"old_short_link"`
+	NewRootLink  string    `json:"new_root_link"`
+	NewShortLink string    `json:"new_short_link"`
+	Actor        string    `json:"actor"`
+	Created      time.Time `json:"created"`
+}
+
+// User agent classes of accesses.
+const (
+	AgentBrowser = "browser"
+	AgentMobile  = "mobile"
+	AgentBot     = "bot"
+	AgentOther   = "other"
+)
+
+// An Access records a redirect of a short
+// link with its date, the host of the
+// referring page, the class of the user
+// agent and the campaign parameters of the
+// short URL. Bot is set if the access was
+// made by an automated client. Country is
+// the ISO 3166-1 alpha-2 code of the country
+// the visitor was located in, if resolved.
+// Visitor is a hash which is equal for
+// accesses by the same visitor on the same
+// day only. Accesses contain no data which
+// identifies the visitor.
+type Access struct {
+	ShortLinkID int       `json:"short_link_id"`
+	Time        time.Time `json:"time"`
+	Referrer    string    `json:"referrer"`
+	Agent       string    `json:"agent"`
+	Bot         bool      `json:"bot"`
+	Country     string    `json:"country"`
+	Visitor     string    `json:"visitor"`
+	Campaign
+}
+
+// A Campaign contains the UTM parameters
+// of the short URL of an access.
+type Campaign struct {
+	Source  string `json:"utm_source"`
+	Medium  string `json:"utm_medium"`
+	Name    string `json:"utm_campaign"`
+	Term    string `json:"utm_term"`
+	Content string `json:"utm_content"`
+}
+
+// An AccessCount is the number of accesses
+// in the period starting at Time.
+type AccessCount struct {
+	Time  time.Time `json:"time"`
+	Count int       `json:"count"`
+}
+
+// A ShortLinkCount is a short link together
+// with the number of its accesses in a period.
+type ShortLinkCount struct {
+	ShortLink
+	Count int `json:"count"`
+}
+
+// A ReferrerCount is the number of accesses
+// referred by pages of the host Referrer.
+type ReferrerCount struct {
+	Referrer string `json:"referrer"`
+	Count    int    `json:"count"`
+}
+
+// A CountryCount is the number of accesses
+// from the country with the ISO 3166-1 alpha-2
+// code Country.
+type CountryCount struct {
+	Country string `json:"country"`
+	Count   int    `json:"count"`
+}
+
+// A CampaignCount is the number of accesses
+// with the UTM source, medium and campaign.
+type CampaignCount struct {
+	Source string `json:"utm_source"`
+	Medium string `json:"utm_medium"`
+	Name   string `json:"utm_campaign"`
+	Count  int    `json:"count"`
 }
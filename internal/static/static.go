@@ -4,4 +4,8 @@ const (
 	// RandShortLen is the character length
 	// of random short identifiers.
 	RandShortLen = 8
+	// RandShortTries is the maximum number of
+	// random short identifiers generated for a
+	// short link if they are already used.
+	RandShortTries = 5
 )
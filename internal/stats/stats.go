@@ -0,0 +1,85 @@
+// Package stats groups the hourly access counts
+// of short links into time series of intervals.
+package stats
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/zekroTJA/slms/internal/shortlink"
+)
+
+// Interval is the length of the buckets
+// of a time series.
+type Interval string
+
+// Supported intervals. Days and weeks start at
+// midnight UTC, weeks on monday.
+const (
+	IntervalHour Interval = "hour"
+	IntervalDay  Interval = "day"
+	IntervalWeek Interval = "week"
+)
+
+// ParseInterval returns the Interval of the
+// passed name or an error if it is not
+// supported.
+func ParseInterval(s string) (Interval, error) {
+	switch i := Interval(s); i {
+	case IntervalHour, IntervalDay, IntervalWeek:
+		return i, nil
+	}
+	return "", fmt.Errorf("interval must be '%s', '%s' or '%s'",
+		IntervalHour, IntervalDay, IntervalWeek)
+}
+
+// Duration returns the length of the interval.
+func (i Interval) Duration() time.Duration {
+	switch i {
+	case IntervalWeek:
+		return 7 * 24 * time.Hour
+	case IntervalDay:
+		return 24 * time.Hour
+	default:
+		return time.Hour
+	}
+}
+
+// Start returns the start of the interval
+// containing t.
+func (i Interval) Start(t time.Time) time.Time {
+	t = t.UTC()
+	switch i {
+	case IntervalWeek:
+		day := time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, time.UTC)
+		return day.AddDate(0, 0, -(int(day.Weekday())+6)%7)
+	case IntervalDay:
+		return time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, time.UTC)
+	default:
+		return t.Truncate(time.Hour)
+	}
+}
+
+// Buckets groups the passed hourly access counts
+// into the intervals covering the range [from, to)
+// ordered ascending. Each bucket is dated to the
+// start of its interval. Intervals without accesses
+// are contained with a count of 0.
+func Buckets(counts []*shortlink.AccessCount, from, to time.Time, i Interval) []*shortlink.AccessCount {
+	buckets := make([]*shortlink.AccessCount, 0)
+	index := make(map[time.Time]*shortlink.AccessCount)
+
+	for t := i.Start(from); t.Before(to); t = t.Add(i.Duration()) {
+		b := &shortlink.AccessCount{Time: t}
+		buckets = append(buckets, b)
+		index[t] = b
+	}
+
+	for _, c := range counts {
+		if b, ok := index[i.Start(c.Time)]; ok {
+			b.Count += c.Count
+		}
+	}
+
+	return buckets
+}
@@ -0,0 +1,69 @@
+package stats
+
+import (
+	"testing"
+	"time"
+
+	"github.com/zekroTJA/slms/internal/shortlink"
+)
+
+func date(s string) time.Time {
+	t, err := time.Parse(time.RFC3339, s)
+	if err != nil {
+		panic(err)
+	}
+	return t
+}
+
+func TestParseInterval(t *testing.T) {
+	for _, s := range []string{"hour", "day", "week"} {
+		if i, err := ParseInterval(s); err != nil || string(i) != s {
+			t.Errorf("'%s' should parse but returned %q (%v)", s, i, err)
+		}
+	}
+	if _, err := ParseInterval("month"); err == nil {
+		t.Error("'month' should not parse")
+	}
+}
+
+func TestStart(t *testing.T) {
+	// 2019-04-03 is a wednesday.
+	tm := date("2019-04-03T20:16:49+02:00")
+
+	cases := map[Interval]string{
+		IntervalHour: "2019-04-03T18:00:00Z",
+		IntervalDay:  "2019-04-03T00:00:00Z",
+		IntervalWeek: "2019-04-01T00:00:00Z",
+	}
+	for i, exp := range cases {
+		if got := i.Start(tm); !got.Equal(date(exp)) {
+			t.Errorf("start of %s should be %s but was %s", i, exp, got)
+		}
+	}
+
+	monday := date("2019-04-01T00:00:00Z")
+	if got := IntervalWeek.Start(monday); !got.Equal(monday) {
+		t.Errorf("start of week of monday should be %s but was %s", monday, got)
+	}
+}
+
+func TestBuckets(t *testing.T) {
+	counts := []*shortlink.AccessCount{
+		{Time: date("2019-04-01T10:00:00Z"), Count: 2},
+		{Time: date("2019-04-01T23:00:00Z"), Count: 1},
+		{Time: date("2019-04-03T00:00:00Z"), Count: 4},
+	}
+
+	buckets := Buckets(counts, date("2019-04-01T12:00:00Z"), date("2019-04-04T00:00:00Z"), IntervalDay)
+
+	exp := []int{3, 0, 4}
+	if len(buckets) != len(exp) {
+		t.Fatalf("should return %d buckets but returned %d", len(exp), len(buckets))
+	}
+	for i, b := range buckets {
+		day := date("2019-04-01T00:00:00Z").AddDate(0, 0, i)
+		if !b.Time.Equal(day) || b.Count != exp[i] {
+			t.Errorf("bucket %d should be %d at %s but was %d at %s", i, exp[i], day, b.Count, b.Time)
+		}
+	}
+}
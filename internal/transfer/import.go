@@ -0,0 +1,272 @@
+package transfer
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/zekroTJA/slms/internal/database"
+	"github.com/zekroTJA/slms/internal/shortlink"
+	"github.com/zekroTJA/slms/internal/static"
+	"github.com/zekroTJA/slms/internal/util"
+)
+
+// Strategy defines how imported short links are
+// handled whose short identifier already exists.
+type Strategy string
+
+// Supported conflict strategies.
+const (
+	// StrategySkip keeps the existing short link
+	// and drops the imported one.
+	StrategySkip Strategy = "skip"
+	// StrategyOverwrite sets the root link, limits,
+	// redirect type and password hash of the
+	// existing short link to the imported ones.
+	// Its access counts are kept.
+	StrategyOverwrite Strategy = "overwrite"
+	// StrategyRename imports the short link with
+	// a numeric suffix appended to its short
+	// identifier.
+	StrategyRename Strategy = "rename"
+)
+
+// ParseStrategy returns the Strategy of the passed
+// name or an error if the strategy is not supported.
+// An empty name results in StrategySkip.
+func ParseStrategy(name string) (Strategy, error) {
+	switch s := Strategy(name); s {
+	case "":
+		return StrategySkip, nil
+	case StrategySkip, StrategyOverwrite, StrategyRename:
+		return s, nil
+	default:
+		return "", fmt.Errorf("unsupported strategy '%s'", name)
+	}
+}
+
+// A Validator checks an imported short link
+// before it is written to the database.
+type Validator func(sl *shortlink.ShortLink) error
+
+// Rename describes an imported short link
+// which was stored under another short
+// identifier.
+type Rename struct {
+	From string `json:"from"`
+	To   string `json:"to"`
+}
+
+// Failure describes an imported short link
+// which was rejected by the Validator.
+type Failure struct {
+	ShortLink string `json:"short_link"`
+	RootLink  string `json:"root_link"`
+	Error     string `json:"error"`
+}
+
+// Result contains the outcome of an import.
+type Result struct {
+	Created     int       `json:"created"`
+	Overwritten int       `json:"overwritten"`
+	Skipped     int       `json:"skipped"`
+	Renamed     []Rename  `json:"renamed"`
+	Failed      []Failure `json:"failed"`
+}
+
+// fail adds the passed short link to the
+// failed short links of the result.
+func (r *Result) fail(sl *shortlink.ShortLink, err error) {
+	r.Failed = append(r.Failed, Failure{
+		ShortLink: sl.ShortLink,
+		RootLink:  sl.RootLink,
+		Error:     err.Error(),
+	})
+}
+
+// Import writes the passed short links to the
+// database middleware after checking each of
+// them with validate.
+// Short links without short identifier get a
+// random one. Conflicting short identifiers are
+// handled as defined by strategy before the
+// short links are validated, so that skipped
+// short links are not validated.
+// IDs and timestamps of imported short links are
+// assigned by the database. Access counts are
+// restored for newly created short links.
+// Short links rejected by validate or by short
+// identifiers used in the meantime are collected
+// in the returned Result. Database errors and the
+// cancellation of ctx abort the import and are
+// returned together with the Result of the short
+// links imported so far.
+func Import(ctx context.Context, db database.Middleware, sls []*shortlink.ShortLink,
+	strategy Strategy, validate Validator) (*Result, error) {
+
+	res := &Result{
+		Renamed: make([]Rename, 0),
+		Failed:  make([]Failure, 0),
+	}
+
+	for _, sl := range sls {
+		if sl == nil {
+			continue
+		}
+
+		if err := ctx.Err(); err != nil {
+			return res, err
+		}
+
+		if sl.ShortLink == "" {
+			sl.ShortLink = util.GetRandString(static.RandShortLen)
+			if err := validate(sl); err != nil {
+				res.fail(sl, err)
+			} else if err = createRandom(ctx, db, sl); database.IsConflict(err) {
+				res.fail(sl, err)
+			} else if err != nil {
+				return res, err
+			} else {
+				res.Created++
+			}
+			continue
+		}
+
+		exSl, err := db.GetShortLink(ctx, "", "", sl.ShortLink)
+		if err != nil {
+			return res, err
+		}
+
+		// Existing short links are skipped unless
+		// they are overwritten or renamed.
+		if exSl != nil && strategy != StrategyOverwrite && strategy != StrategyRename {
+			res.Skipped++
+			continue
+		}
+
+		if err = validate(sl); err != nil {
+			res.fail(sl, err)
+			continue
+		}
+
+		if exSl == nil {
+			// The short identifier may have been
+			// used in the meantime by another client.
+			if err = create(ctx, db, sl); database.IsConflict(err) {
+				res.fail(sl, err)
+			} else if err != nil {
+				return res, err
+			} else {
+				res.Created++
+			}
+			continue
+		}
+
+		if strategy == StrategyOverwrite {
+			if overwrite(exSl, sl) {
+				if err = db.UpdateShortLink(ctx, exSl.ID, exSl); err != nil {
+					return res, err
+				}
+			}
+			res.Overwritten++
+			continue
+		}
+
+		short, err := freeShort(ctx, db, sl.ShortLink)
+		if err != nil {
+			return res, err
+		}
+		from := sl.ShortLink
+		sl.ShortLink = short
+		if err = create(ctx, db, sl); database.IsConflict(err) {
+			res.fail(sl, err)
+			continue
+		} else if err != nil {
+			return res, err
+		}
+		res.Renamed = append(res.Renamed, Rename{From: from, To: short})
+	}
+
+	return res, nil
+}
+
+// create creates the passed short link and
+// restores its access and unique visitor count.
+func create(ctx context.Context, db database.Middleware, sl *shortlink.ShortLink) error {
+	newSl, err := db.CreateShortLink(ctx, sl)
+	if err != nil || newSl == nil {
+		return err
+	}
+
+	if sl.Accesses > 0 {
+		if err = db.IncrementAccesses(ctx, newSl.ID, sl.Accesses); err != nil {
+			return err
+		}
+	}
+
+	if sl.Uniques > 0 {
+		return db.IncrementUniques(ctx, newSl.ID, sl.Uniques)
+	}
+
+	return nil
+}
+
+// overwrite sets the exported values of the existing
+// short link ex, except of its access counts, to
+// the ones of the imported short link sl and
+// returns true if any of them changed.
+func overwrite(ex, sl *shortlink.ShortLink) bool {
+	changed := ex.RootLink != sl.RootLink ||
+		!equalTime(ex.ExpiresAt, sl.ExpiresAt) ||
+		ex.MaxAccesses != sl.MaxAccesses ||
+		ex.FallbackURL != sl.FallbackURL ||
+		ex.RedirectType != sl.RedirectType ||
+		ex.PasswordHash != sl.PasswordHash
+
+	ex.RootLink = sl.RootLink
+	ex.ExpiresAt = sl.ExpiresAt
+	ex.MaxAccesses = sl.MaxAccesses
+	ex.FallbackURL = sl.FallbackURL
+	ex.RedirectType = sl.RedirectType
+	ex.PasswordHash = sl.PasswordHash
+
+	return changed
+}
+
+// equalTime returns true if both passed times
+// are nil or the same instant.
+func equalTime(a, b *time.Time) bool {
+	if a == nil || b == nil {
+		return a == b
+	}
+	return a.Equal(*b)
+}
+
+// createRandom creates the passed short link
+// like create. If its random short identifier is
+// already used, new ones are generated.
+func createRandom(ctx context.Context, db database.Middleware, sl *shortlink.ShortLink) error {
+	err := create(ctx, db, sl)
+	for i := 1; database.IsConflict(err) && i < static.RandShortTries; i++ {
+		sl.ShortLink = util.GetRandString(static.RandShortLen)
+		err = create(ctx, db, sl)
+	}
+	return err
+}
+
+// freeShort returns the first short identifier
+// of the form '<short>-<n>' which is not used
+// yet, starting with n = 1.
+func freeShort(ctx context.Context, db database.Middleware, short string) (string, error) {
+	for n := 1; ; n++ {
+		candidate := short + "-" + strconv.Itoa(n)
+		sl, err := db.GetShortLink(ctx, "", "", candidate)
+		if err != nil {
+			return "", err
+		}
+		if sl == nil {
+			return candidate, nil
+		}
+	}
+}
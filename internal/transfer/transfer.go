@@ -0,0 +1,264 @@
+// Package transfer provides exporting and importing
+// of all short links of a database middleware as
+// JSON or CSV.
+package transfer
+
+import (
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/zekroTJA/slms/internal/database"
+	"github.com/zekroTJA/slms/internal/shortlink"
+)
+
+// Supported export and import formats.
+const (
+	FormatJSON = "json"
+	FormatCSV  = "csv"
+)
+
+// pageSize is the number of short links
+// fetched from the database at once
+// while exporting.
+const pageSize = 1000
+
+// csvHeader contains the column names of
+// CSV exports which equal the JSON keys
+// of shortlink.ShortLink.
+var csvHeader = []string{"id", "root_link", "short_link", "created", "accesses", "uniques", "edited",
+	"expires_at", "max_accesses", "fallback_url", "redirect_type", "password_hash"}
+
+// record is the JSON representation of exported
+// short links, which contains the password hash
+// of protected short links unlike the one of
+// shortlink.ShortLink.
+type record struct {
+	*shortlink.ShortLink
+	PasswordHash string `json:"password_hash,omitempty"`
+}
+
+// CheckFormat returns an error if the
+// passed format is not supported.
+func CheckFormat(format string) error {
+	if format != FormatJSON && format != FormatCSV {
+		return fmt.Errorf("unsupported format '%s'", format)
+	}
+	return nil
+}
+
+// Export writes all short links of the passed
+// database middleware in the specified format
+// to w, ordered by creation date ascending.
+func Export(ctx context.Context, db database.Middleware, w io.Writer, format string) error {
+	if err := CheckFormat(format); err != nil {
+		return err
+	}
+
+	sls, err := fetchAll(ctx, db)
+	if err != nil {
+		return err
+	}
+
+	// Short links are exported oldest first so
+	// that importing them keeps their order.
+	for i, j := 0, len(sls)-1; i < j; i, j = i+1, j-1 {
+		sls[i], sls[j] = sls[j], sls[i]
+	}
+
+	now := time.Now()
+	for _, sl := range sls {
+		sl.Expired = sl.IsExpired(now, 0)
+		sl.Protected = sl.PasswordHash != ""
+	}
+
+	if format == FormatCSV {
+		return encodeCSV(w, sls)
+	}
+
+	recs := make([]record, len(sls))
+	for i, sl := range sls {
+		recs[i] = record{ShortLink: sl, PasswordHash: sl.PasswordHash}
+	}
+
+	data, err := json.MarshalIndent(recs, "", "  ")
+	if err != nil {
+		return err
+	}
+	_, err = w.Write(data)
+	return err
+}
+
+// Decode reads short links in the specified
+// format from r.
+// CSV input must contain a header row. Only
+// the columns 'root_link' and 'short_link'
+// are required, the order of the columns
+// does not matter.
+func Decode(r io.Reader, format string) ([]*shortlink.ShortLink, error) {
+	if err := CheckFormat(format); err != nil {
+		return nil, err
+	}
+
+	if format == FormatCSV {
+		return decodeCSV(r)
+	}
+
+	var recs []record
+	if err := json.NewDecoder(r).Decode(&recs); err != nil {
+		return nil, err
+	}
+
+	sls := make([]*shortlink.ShortLink, len(recs))
+	for i, rec := range recs {
+		if rec.ShortLink == nil {
+			rec.ShortLink = new(shortlink.ShortLink)
+		}
+		rec.ShortLink.PasswordHash = rec.PasswordHash
+		sls[i] = rec.ShortLink
+	}
+	return sls, nil
+}
+
+// fetchAll collects all short links of the
+// database middleware page by page.
+func fetchAll(ctx context.Context, db database.Middleware) ([]*shortlink.ShortLink, error) {
+	sls := make([]*shortlink.ShortLink, 0)
+
+	for from := 0; ; from += pageSize {
+		page, err := db.GetShortLinks(ctx, from, pageSize)
+		if err != nil {
+			return nil, err
+		}
+		sls = append(sls, page...)
+		if len(page) < pageSize {
+			return sls, nil
+		}
+	}
+}
+
+func encodeCSV(w io.Writer, sls []*shortlink.ShortLink) error {
+	cw := csv.NewWriter(w)
+
+	if err := cw.Write(csvHeader); err != nil {
+		return err
+	}
+
+	for _, sl := range sls {
+		var expires string
+		if sl.ExpiresAt != nil {
+			expires = sl.ExpiresAt.Format(time.RFC3339)
+		}
+
+		err := cw.Write([]string{
+			strconv.Itoa(sl.ID),
+			sl.RootLink,
+			sl.ShortLink,
+			sl.Created.Format(time.RFC3339),
+			strconv.Itoa(sl.Accesses),
+			strconv.Itoa(sl.Uniques),
+			sl.Edited.Format(time.RFC3339),
+			expires,
+			strconv.Itoa(sl.MaxAccesses),
+			sl.FallbackURL,
+			sl.RedirectType,
+			sl.PasswordHash,
+		})
+		if err != nil {
+			return err
+		}
+	}
+
+	cw.Flush()
+	return cw.Error()
+}
+
+func decodeCSV(r io.Reader) ([]*shortlink.ShortLink, error) {
+	records, err := csv.NewReader(r).ReadAll()
+	if err != nil {
+		return nil, err
+	}
+	if len(records) == 0 {
+		return nil, fmt.Errorf("missing CSV header")
+	}
+
+	cols := make(map[string]int)
+	for i, name := range records[0] {
+		cols[strings.TrimSpace(name)] = i
+	}
+
+	for _, name := range []string{"root_link", "short_link"} {
+		if _, ok := cols[name]; !ok {
+			return nil, fmt.Errorf("missing CSV column '%s'", name)
+		}
+	}
+
+	sls := make([]*shortlink.ShortLink, len(records)-1)
+	for i, rec := range records[1:] {
+		// Line numbers start at 1 and the
+		// first line is the header.
+		line := i + 2
+
+		field := func(name string) string {
+			if c, ok := cols[name]; ok {
+				return rec[c]
+			}
+			return ""
+		}
+
+		sl := &shortlink.ShortLink{
+			RootLink:     field("root_link"),
+			ShortLink:    field("short_link"),
+			FallbackURL:  field("fallback_url"),
+			RedirectType: field("redirect_type"),
+			PasswordHash: field("password_hash"),
+		}
+
+		if v := field("id"); v != "" {
+			if sl.ID, err = strconv.Atoi(v); err != nil {
+				return nil, fmt.Errorf("line %d: invalid id: %s", line, err.Error())
+			}
+		}
+		if v := field("accesses"); v != "" {
+			if sl.Accesses, err = strconv.Atoi(v); err != nil {
+				return nil, fmt.Errorf("line %d: invalid accesses: %s", line, err.Error())
+			}
+		}
+		if v := field("uniques"); v != "" {
+			if sl.Uniques, err = strconv.Atoi(v); err != nil {
+				return nil, fmt.Errorf("line %d: invalid uniques: %s", line, err.Error())
+			}
+		}
+		if v := field("created"); v != "" {
+			if sl.Created, err = time.Parse(time.RFC3339, v); err != nil {
+				return nil, fmt.Errorf("line %d: invalid created: %s", line, err.Error())
+			}
+		}
+		if v := field("edited"); v != "" {
+			if sl.Edited, err = time.Parse(time.RFC3339, v); err != nil {
+				return nil, fmt.Errorf("line %d: invalid edited: %s", line, err.Error())
+			}
+		}
+		if v := field("expires_at"); v != "" {
+			t, err := time.Parse(time.RFC3339, v)
+			if err != nil {
+				return nil, fmt.Errorf("line %d: invalid expires_at: %s", line, err.Error())
+			}
+			sl.ExpiresAt = &t
+		}
+		if v := field("max_accesses"); v != "" {
+			if sl.MaxAccesses, err = strconv.Atoi(v); err != nil {
+				return nil, fmt.Errorf("line %d: invalid max_accesses: %s", line, err.Error())
+			}
+		}
+
+		sls[i] = sl
+	}
+
+	return sls, nil
+}
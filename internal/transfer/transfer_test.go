@@ -0,0 +1,250 @@
+package transfer
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/zekroTJA/slms/internal/database"
+	"github.com/zekroTJA/slms/internal/database/memory"
+	"github.com/zekroTJA/slms/internal/shortlink"
+)
+
+var ctx = context.Background()
+
+func noValidation(sl *shortlink.ShortLink) error {
+	return nil
+}
+
+func newDB(t *testing.T) database.Middleware {
+	db := new(memory.Memory)
+	if err := db.Open(new(memory.Config)); err != nil {
+		t.Fatal(err)
+	}
+	return db
+}
+
+func mustCreate(t *testing.T, db database.Middleware, root, short string, accesses int) {
+	sl, err := db.CreateShortLink(ctx, &shortlink.ShortLink{RootLink: root, ShortLink: short})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if accesses > 0 {
+		if err = db.IncrementAccesses(ctx, sl.ID, accesses); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestRoundTrip(t *testing.T) {
+	for _, format := range []string{FormatJSON, FormatCSV} {
+		t.Run(format, func(t *testing.T) {
+			src := newDB(t)
+			mustCreate(t, src, "https://example.com/a", "a", 3)
+			mustCreate(t, src, "https://example.com/b?x=1,2", "b", 0)
+
+			expires := time.Date(2030, 1, 2, 3, 4, 5, 0, time.UTC)
+			_, err := src.CreateShortLink(ctx, &shortlink.ShortLink{
+				RootLink:     "https://example.com/c",
+				ShortLink:    "c",
+				ExpiresAt:    &expires,
+				MaxAccesses:  5,
+				FallbackURL:  "https://example.com",
+				PasswordHash: "$2a$04$hash",
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			var buf bytes.Buffer
+			if err := Export(ctx, src, &buf, format); err != nil {
+				t.Fatal(err)
+			}
+
+			sls, err := Decode(&buf, format)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(sls) != 3 {
+				t.Fatalf("decoded %d short links, expected 3", len(sls))
+			}
+			if sls[2].PasswordHash != "$2a$04$hash" {
+				t.Fatalf("decoded password hash '%s', expected '$2a$04$hash'", sls[2].PasswordHash)
+			}
+
+			dst := newDB(t)
+			res, err := Import(ctx, dst, sls, StrategySkip, noValidation)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if res.Created != 3 {
+				t.Fatalf("created %d short links, expected 3", res.Created)
+			}
+
+			for _, exp := range sls {
+				sl, err := dst.GetShortLink(ctx, "", "", exp.ShortLink)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if sl == nil {
+					t.Fatalf("short link '%s' was not imported", exp.ShortLink)
+				}
+				if sl.RootLink != exp.RootLink || sl.Accesses != exp.Accesses {
+					t.Fatalf("imported %+v, expected %+v", sl, exp)
+				}
+				if (sl.ExpiresAt == nil) != (exp.ExpiresAt == nil) ||
+					sl.ExpiresAt != nil && !sl.ExpiresAt.Equal(*exp.ExpiresAt) ||
+					sl.MaxAccesses != exp.MaxAccesses || sl.FallbackURL != exp.FallbackURL ||
+					sl.PasswordHash != exp.PasswordHash {
+					t.Fatalf("imported limits %+v, expected %+v", sl, exp)
+				}
+			}
+		})
+	}
+}
+
+func TestImportStrategies(t *testing.T) {
+	imported := func() []*shortlink.ShortLink {
+		return []*shortlink.ShortLink{{RootLink: "https://example.com/new", ShortLink: "a"}}
+	}
+
+	t.Run("skip", func(t *testing.T) {
+		db := newDB(t)
+		mustCreate(t, db, "https://example.com/old", "a", 0)
+
+		res, err := Import(ctx, db, imported(), StrategySkip, noValidation)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if res.Skipped != 1 {
+			t.Fatalf("skipped %d short links, expected 1", res.Skipped)
+		}
+		sl, _ := db.GetShortLink(ctx, "", "", "a")
+		if sl.RootLink != "https://example.com/old" {
+			t.Fatalf("root link was changed to '%s'", sl.RootLink)
+		}
+	})
+
+	t.Run("overwrite", func(t *testing.T) {
+		db := newDB(t)
+		mustCreate(t, db, "https://example.com/old", "a", 3)
+
+		expires := time.Date(2030, 1, 2, 3, 4, 5, 0, time.UTC)
+		exp := &shortlink.ShortLink{
+			RootLink:     "https://example.com/new",
+			ShortLink:    "a",
+			ExpiresAt:    &expires,
+			MaxAccesses:  10,
+			FallbackURL:  "https://example.com/gone",
+			RedirectType: shortlink.RedirectFound,
+			PasswordHash: "$2a$04$hash",
+		}
+
+		res, err := Import(ctx, db, []*shortlink.ShortLink{exp}, StrategyOverwrite, noValidation)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if res.Overwritten != 1 {
+			t.Fatalf("overwrote %d short links, expected 1", res.Overwritten)
+		}
+		sl, _ := db.GetShortLink(ctx, "", "", "a")
+		if sl.RootLink != exp.RootLink || sl.ExpiresAt == nil || !sl.ExpiresAt.Equal(expires) ||
+			sl.MaxAccesses != exp.MaxAccesses || sl.FallbackURL != exp.FallbackURL ||
+			sl.RedirectType != exp.RedirectType || sl.PasswordHash != exp.PasswordHash {
+			t.Fatalf("short link %+v was not overwritten with %+v", sl, exp)
+		}
+		if sl.Accesses != 3 {
+			t.Fatalf("accesses were changed to %d, expected 3", sl.Accesses)
+		}
+	})
+
+	t.Run("rename", func(t *testing.T) {
+		db := newDB(t)
+		mustCreate(t, db, "https://example.com/old", "a", 0)
+		mustCreate(t, db, "https://example.com/old", "a-1", 0)
+
+		res, err := Import(ctx, db, imported(), StrategyRename, noValidation)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(res.Renamed) != 1 || res.Renamed[0].To != "a-2" {
+			t.Fatalf("unexpected renames: %+v", res.Renamed)
+		}
+		sl, _ := db.GetShortLink(ctx, "", "", "a-2")
+		if sl == nil || sl.RootLink != "https://example.com/new" {
+			t.Fatalf("renamed short link was not imported: %+v", sl)
+		}
+	})
+}
+
+func TestImportValidation(t *testing.T) {
+	db := newDB(t)
+	errInvalid := errors.New("invalid")
+
+	res, err := Import(ctx, db, []*shortlink.ShortLink{{RootLink: "nope", ShortLink: "a"}}, StrategySkip,
+		func(sl *shortlink.ShortLink) error {
+			return errInvalid
+		})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.Created != 0 || len(res.Failed) != 1 || res.Failed[0].Error != errInvalid.Error() {
+		t.Fatalf("unexpected result: %+v", res)
+	}
+	if n, _ := db.GetShortLinkCount(ctx); n != 0 {
+		t.Fatalf("%d short links were created, expected 0", n)
+	}
+}
+
+func TestDecodeCSVMissingColumn(t *testing.T) {
+	_, err := Decode(bytes.NewBufferString("short_link\na\n"), FormatCSV)
+	if err == nil {
+		t.Fatal("expected error for missing root_link column")
+	}
+}
+
+func TestImportSkipBeforeValidation(t *testing.T) {
+	db := newDB(t)
+	mustCreate(t, db, "https://example.com/old", "a", 0)
+
+	validated := 0
+	res, err := Import(ctx, db, []*shortlink.ShortLink{
+		{RootLink: "https://example.com/new", ShortLink: "a"},
+		{RootLink: "https://example.com/new", ShortLink: "b"},
+	}, StrategySkip, func(sl *shortlink.ShortLink) error {
+		validated++
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.Skipped != 1 || res.Created != 1 {
+		t.Fatalf("unexpected result: %+v", res)
+	}
+	if validated != 1 {
+		t.Fatalf("validated %d short links, expected 1", validated)
+	}
+}
+
+func TestImportCancelled(t *testing.T) {
+	db := newDB(t)
+	cctx, cancel := context.WithCancel(ctx)
+
+	res, err := Import(cctx, db, []*shortlink.ShortLink{
+		{RootLink: "https://example.com/a", ShortLink: "a"},
+		{RootLink: "https://example.com/b", ShortLink: "b"},
+	}, StrategySkip, func(sl *shortlink.ShortLink) error {
+		if sl.ShortLink == "b" {
+			cancel()
+		}
+		return nil
+	})
+	if err != context.Canceled {
+		t.Fatalf("import should be cancelled but returned %v", err)
+	}
+	if res == nil || res.Created != 1 {
+		t.Fatalf("result should contain the imported short link: %+v", res)
+	}
+}
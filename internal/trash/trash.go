@@ -0,0 +1,94 @@
+// Package trash provides a background job which
+// permanently removes deleted short links after
+// a retention period.
+package trash
+
+import (
+	"context"
+	"time"
+
+	"github.com/zekroTJA/slms/internal/database"
+	"github.com/zekroTJA/slms/internal/logger"
+)
+
+// DefaultInterval is the interval in which
+// the trash is checked for expired short links.
+const DefaultInterval = 1 * time.Hour
+
+// Purger permanently removes short links which
+// were deleted longer than the retention period
+// ago in the specified interval.
+type Purger struct {
+	db        database.Middleware
+	retention time.Duration
+
+	ticker *time.Ticker
+	stop   chan struct{}
+	done   chan struct{}
+}
+
+// New creates a new Purger which removes short
+// links from the trash of the passed database
+// after retention. The trash is purged once on
+// creation and then in the passed interval.
+// If interval is <= 0, DefaultInterval is used.
+func New(db database.Middleware, retention, interval time.Duration) *Purger {
+	if interval <= 0 {
+		interval = DefaultInterval
+	}
+
+	p := &Purger{
+		db:        db,
+		retention: retention,
+		ticker:    time.NewTicker(interval),
+		stop:      make(chan struct{}),
+		done:      make(chan struct{}),
+	}
+
+	go p.loop()
+
+	return p
+}
+
+// Purge removes all short links from the trash
+// which were deleted longer than the retention
+// period ago and returns their number.
+func (p *Purger) Purge(ctx context.Context) (int, error) {
+	return p.db.PurgeTrash(ctx, p.retention)
+}
+
+// Close stops the purge loop.
+func (p *Purger) Close() {
+	p.ticker.Stop()
+	close(p.stop)
+	<-p.done
+}
+
+// loop purges the trash initially and on
+// each tick until the purger is closed.
+func (p *Purger) loop() {
+	defer close(p.done)
+
+	p.purge()
+
+	for {
+		select {
+		case <-p.ticker.C:
+			p.purge()
+		case <-p.stop:
+			return
+		}
+	}
+}
+
+// purge purges the trash and logs the result.
+func (p *Purger) purge() {
+	n, err := p.Purge(context.Background())
+	if err != nil {
+		logger.Error("TRASH :: failed purging trash: %s", err.Error())
+		return
+	}
+	if n > 0 {
+		logger.Info("TRASH :: purged %d short link(s)", n)
+	}
+}
@@ -0,0 +1,149 @@
+// Package useragent classifies HTTP clients
+// by their user agent string.
+package useragent
+
+import (
+	"io/ioutil"
+	"strings"
+	"sync"
+
+	"github.com/ghodss/yaml"
+	"github.com/zekroTJA/slms/internal/shortlink"
+)
+
+// Patterns contains the substrings of user
+// agents identifying the client classes.
+// Matching is case-insensitive.
+type Patterns struct {
+	Bot     []string `json:"bot"`
+	Mobile  []string `json:"mobile"`
+	Browser []string `json:"browser"`
+}
+
+// DefaultPatterns returns the built-in
+// patterns of the client classes.
+func DefaultPatterns() *Patterns {
+	return &Patterns{
+		Bot: []string{
+			"bot", "crawl", "spider", "slurp", "preview",
+			"facebookexternalhit", "embedly", "curl", "wget",
+			"python-requests", "go-http-client",
+		},
+		Mobile: []string{
+			"mobi", "android", "iphone", "ipad",
+		},
+		Browser: []string{
+			"mozilla/", "opera",
+		},
+	}
+}
+
+// LoadPatterns reads patterns from the passed
+// YAML or JSON file. Classes which are not
+// specified in the file keep their built-in
+// patterns.
+func LoadPatterns(file string) (*Patterns, error) {
+	data, err := ioutil.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+
+	loaded := new(Patterns)
+	if err = yaml.Unmarshal(data, loaded); err != nil {
+		return nil, err
+	}
+
+	p := DefaultPatterns()
+	if loaded.Bot != nil {
+		p.Bot = loaded.Bot
+	}
+	if loaded.Mobile != nil {
+		p.Mobile = loaded.Mobile
+	}
+	if loaded.Browser != nil {
+		p.Browser = loaded.Browser
+	}
+
+	return p, nil
+}
+
+// A Classifier classifies user agents by
+// patterns which can be replaced while
+// the Classifier is in use.
+type Classifier struct {
+	mtx      sync.RWMutex
+	patterns *Patterns
+}
+
+// New creates a new Classifier using the
+// passed patterns. If p is nil, the built-in
+// patterns are used.
+func New(p *Patterns) *Classifier {
+	c := new(Classifier)
+	c.SetPatterns(p)
+	return c
+}
+
+// SetPatterns replaces the patterns of the
+// Classifier. If p is nil, the built-in
+// patterns are used.
+func (c *Classifier) SetPatterns(p *Patterns) {
+	if p == nil {
+		p = DefaultPatterns()
+	}
+
+	lower := &Patterns{
+		Bot:     toLower(p.Bot),
+		Mobile:  toLower(p.Mobile),
+		Browser: toLower(p.Browser),
+	}
+
+	c.mtx.Lock()
+	c.patterns = lower
+	c.mtx.Unlock()
+}
+
+// Classify returns the client class of the passed
+// user agent which is one of shortlink.AgentBot,
+// shortlink.AgentMobile, shortlink.AgentBrowser
+// and shortlink.AgentOther.
+func (c *Classifier) Classify(ua string) string {
+	ua = strings.ToLower(ua)
+
+	c.mtx.RLock()
+	p := c.patterns
+	c.mtx.RUnlock()
+
+	switch {
+	case containsAny(ua, p.Bot):
+		return shortlink.AgentBot
+	case containsAny(ua, p.Mobile):
+		return shortlink.AgentMobile
+	case containsAny(ua, p.Browser):
+		return shortlink.AgentBrowser
+	default:
+		return shortlink.AgentOther
+	}
+}
+
+// containsAny returns true if s contains
+// at least one of the passed non-empty
+// substrings.
+func containsAny(s string, substrs []string) bool {
+	for _, sub := range substrs {
+		if sub != "" && strings.Contains(s, sub) {
+			return true
+		}
+	}
+	return false
+}
+
+// toLower returns the lower case
+// copies of the passed strings.
+func toLower(s []string) []string {
+	res := make([]string, len(s))
+	for i, v := range s {
+		res[i] = strings.ToLower(v)
+	}
+	return res
+}
@@ -0,0 +1,65 @@
+package useragent
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/zekroTJA/slms/internal/shortlink"
+)
+
+func TestClassify(t *testing.T) {
+	c := New(nil)
+
+	cases := map[string]string{
+		"Mozilla/5.0 (X11; Linux x86_64) Firefox/66.0":                  shortlink.AgentBrowser,
+		"Mozilla/5.0 (iPhone; CPU iPhone OS 12_2 like Mac OS X) Mobile": shortlink.AgentMobile,
+		"Mozilla/5.0 (compatible; Googlebot/2.1)":                       shortlink.AgentBot,
+		"Slackbot-LinkExpanding 1.0":                                    shortlink.AgentBot,
+		"":                                                              shortlink.AgentOther,
+	}
+	for ua, exp := range cases {
+		if got := c.Classify(ua); got != exp {
+			t.Errorf("'%s' should be classified as %s but was %s", ua, exp, got)
+		}
+	}
+}
+
+func TestLoadPatterns(t *testing.T) {
+	dir, err := ioutil.TempDir("", "slms-useragent")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	file := filepath.Join(dir, "patterns.yml")
+	if err = ioutil.WriteFile(file, []byte("bot:\n  - MyMonitor\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	p, err := LoadPatterns(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(p.Mobile) == 0 || len(p.Browser) == 0 {
+		t.Error("unspecified classes should keep the built-in patterns")
+	}
+
+	c := New(nil)
+	if got := c.Classify("mymonitor/1.0"); got != shortlink.AgentOther {
+		t.Errorf("unknown agent should be classified as other but was %s", got)
+	}
+
+	c.SetPatterns(p)
+	if got := c.Classify("mymonitor/1.0"); got != shortlink.AgentBot {
+		t.Errorf("loaded pattern should classify as bot but was %s", got)
+	}
+	if got := c.Classify("Googlebot/2.1"); got != shortlink.AgentOther {
+		t.Errorf("replaced bot patterns should not match but was %s", got)
+	}
+
+	if _, err = LoadPatterns(filepath.Join(dir, "missing.yml")); err == nil {
+		t.Error("loading a missing file should fail")
+	}
+}
@@ -5,14 +5,23 @@ import (
 	"net/http"
 	"regexp"
 	"strings"
+	"time"
 )
 
+// linkClient is the HTTP client used to check
+// links, which limits the duration of each
+// request.
+var linkClient = &http.Client{
+	Timeout: 10 * time.Second,
+}
+
 // CheckIfValidLink checks an URL if it is
 // a valid link. So first, it checks if it
 // starts with 'http'. If httpsOnly is set,
 // it will be checked if the link starts with
 // https. Then, a http request is executed
-// to the link. If this fails or responds
+// to the link, which is aborted after 10
+// seconds. If this fails or responds
 // with an status code >= 400, the link is
 // invalid and an error will be returned.
 // The link is qualified as valid if the
@@ -26,10 +35,11 @@ func CheckIfValidLink(url string, httpsOnly bool) error {
 		return fmt.Errorf("URL must be https")
 	}
 
-	res, err := http.DefaultClient.Get(url)
+	res, err := linkClient.Get(url)
 	if err != nil || res == nil {
 		return fmt.Errorf("request to URL failed")
 	}
+	defer res.Body.Close()
 
 	if res.StatusCode >= 400 {
 		return fmt.Errorf("ULR request failed with status code %d", res.StatusCode)
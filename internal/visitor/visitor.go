@@ -0,0 +1,76 @@
+// Package visitor identifies the visitors of
+// short links by hashes which can not be traced
+// back to them.
+package visitor
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"sync"
+	"time"
+)
+
+// saltLen is the length of the random
+// salts in bytes.
+const saltLen = 32
+
+// hashLen is the length of the visitor
+// hashes in bytes before hex encoding.
+const hashLen = 16
+
+// A Hasher hashes the IP address and the user
+// agent of visitors with a random salt which
+// is replaced at the start of each UTC day.
+// Salts are only kept in memory, so the hash of
+// a visitor changes daily and, after the day is
+// over, can not be linked to the visitor anymore.
+type Hasher struct {
+	mtx  sync.Mutex
+	day  time.Time
+	salt []byte
+}
+
+// New creates a new Hasher.
+func New() *Hasher {
+	return new(Hasher)
+}
+
+// Hash returns the hex encoded hash of the
+// visitor with the passed IP address and user
+// agent for the UTC day of t.
+func (h *Hasher) Hash(t time.Time, ip, ua string) string {
+	salt := h.saltOf(t)
+
+	sum := sha256.New()
+	sum.Write(salt)
+	sum.Write([]byte(ip))
+	sum.Write([]byte{0})
+	sum.Write([]byte(ua))
+
+	return hex.EncodeToString(sum.Sum(nil)[:hashLen])
+}
+
+// saltOf returns the salt of the UTC day of t
+// and creates a new one if the day has changed.
+func (h *Hasher) saltOf(t time.Time) []byte {
+	t = t.UTC()
+	day := time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, time.UTC)
+
+	h.mtx.Lock()
+	defer h.mtx.Unlock()
+
+	if h.salt == nil || !h.day.Equal(day) {
+		salt := make([]byte, saltLen)
+		if _, err := rand.Read(salt); err != nil {
+			// The system's secure random source must
+			// be available, otherwise visitors could
+			// be traced by a predictable salt.
+			panic(err)
+		}
+		h.day = day
+		h.salt = salt
+	}
+
+	return h.salt
+}
@@ -0,0 +1,31 @@
+package visitor
+
+import (
+	"testing"
+	"time"
+)
+
+func TestHash(t *testing.T) {
+	h := New()
+	day := time.Date(2019, 4, 3, 10, 0, 0, 0, time.UTC)
+
+	a := h.Hash(day, "192.0.2.1", "Mozilla/5.0")
+	if len(a) != 2*hashLen {
+		t.Errorf("hash should have %d characters but had %d", 2*hashLen, len(a))
+	}
+	if b := h.Hash(day.Add(13*time.Hour), "192.0.2.1", "Mozilla/5.0"); b != a {
+		t.Error("hash should be equal on the same day")
+	}
+	if b := h.Hash(day, "192.0.2.2", "Mozilla/5.0"); b == a {
+		t.Error("hash should differ for another IP")
+	}
+	if b := h.Hash(day, "192.0.2.1", "curl/7.64"); b == a {
+		t.Error("hash should differ for another user agent")
+	}
+	if b := h.Hash(day.Add(24*time.Hour), "192.0.2.1", "Mozilla/5.0"); b == a {
+		t.Error("hash should differ on the next day")
+	}
+	if b := New().Hash(day, "192.0.2.1", "Mozilla/5.0"); b == a {
+		t.Error("hash should differ for another hasher")
+	}
+}
@@ -0,0 +1,94 @@
+package webserver
+
+import (
+	"net/url"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/qiangxue/fasthttp-routing"
+	"github.com/zekroTJA/slms/internal/shortlink"
+)
+
+// maxValueLen is the maximum length of
+// recorded referrer hosts and UTM parameters.
+const maxValueLen = 255
+
+// newAccess returns the access event of a redirect
+// of the passed request to the passed short link.
+// The visitor's IP address is not recorded, but
+// only a daily changing hash of it and the user
+// agent to count unique visitors and the
+// country it is located in, if a GeoIP
+// database is configured. Behind trusted proxies,
+// the forwarded client IP address is used.
+func (ws *WebServer) newAccess(ctx *routing.Context, sl *shortlink.ShortLink) *shortlink.Access {
+	query := ctx.QueryArgs()
+	utm := func(key string) string {
+		return truncate(string(query.Peek("utm_" + key)))
+	}
+
+	ua := string(ctx.Request.Header.UserAgent())
+	agent := ws.agents.Classify(ua)
+
+	ip := ws.proxies.clientIP(ctx)
+
+	var country string
+	if ws.countries != nil {
+		country = ws.countries.Country(ip)
+	}
+
+	return &shortlink.Access{
+		ShortLinkID: sl.ID,
+		Time:        ctx.Time(),
+		Referrer:    referrerHost(string(ctx.Request.Header.Referer())),
+		Agent:       agent,
+		Bot:         isBot(ctx, agent),
+		Country:     country,
+		Visitor:     ws.visitors.Hash(ctx.Time(), ip.String(), ua),
+		Campaign: shortlink.Campaign{
+			Source:  utm("source"),
+			Medium:  utm("medium"),
+			Name:    utm("campaign"),
+			Term:    utm("term"),
+			Content: utm("content"),
+		},
+	}
+}
+
+// isBot returns true if the request was made
+// by a bot. Besides bot user agents, link
+// previews and monitors often only request the
+// headers of a page or do not send an Accept
+// header, which browsers always do.
+func isBot(ctx *routing.Context, agent string) bool {
+	return agent == shortlink.AgentBot ||
+		ctx.IsHead() ||
+		len(ctx.Request.Header.Peek("Accept")) == 0
+}
+
+// referrerHost returns the lower case host of the
+// passed referrer URL or an empty string if it is
+// not a valid absolute URL.
+func referrerHost(ref string) string {
+	u, err := url.Parse(ref)
+	if err != nil {
+		return ""
+	}
+
+	return truncate(strings.ToLower(u.Hostname()))
+}
+
+// truncate cuts s to maxValueLen bytes without
+// splitting UTF-8 encoded characters.
+func truncate(s string) string {
+	if len(s) <= maxValueLen {
+		return s
+	}
+
+	n := maxValueLen
+	for n > 0 && !utf8.RuneStart(s[n]) {
+		n--
+	}
+
+	return s[:n]
+}
@@ -0,0 +1,91 @@
+package webserver
+
+import (
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/zekroTJA/slms/internal/database"
+	"github.com/zekroTJA/slms/internal/shortlink"
+)
+
+var errInvalidCursor = errors.New("invalid cursor")
+
+// encodeCursor returns the opaque cursor string
+// pointing to the passed short link. If backward
+// is true, the cursor selects the page preceding
+// the short link.
+func encodeCursor(sl *shortlink.ShortLink, backward bool) string {
+	dir := "n"
+	if backward {
+		dir = "p"
+	}
+	return base64.RawURLEncoding.EncodeToString(
+		[]byte(fmt.Sprintf("%s:%d:%d", dir, sl.Created.UnixNano(), sl.ID)))
+}
+
+// decodeCursor parses a cursor string created
+// by encodeCursor.
+func decodeCursor(s string) (*database.Cursor, error) {
+	data, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return nil, errInvalidCursor
+	}
+
+	parts := strings.Split(string(data), ":")
+	if len(parts) != 3 || parts[0] != "n" && parts[0] != "p" {
+		return nil, errInvalidCursor
+	}
+
+	created, err := strconv.ParseInt(parts[1], 10, 64)
+	if err != nil {
+		return nil, errInvalidCursor
+	}
+	id, err := strconv.Atoi(parts[2])
+	if err != nil {
+		return nil, errInvalidCursor
+	}
+
+	return &database.Cursor{
+		Created:  time.Unix(0, created).UTC(),
+		ID:       id,
+		Backward: parts[0] == "p",
+	}, nil
+}
+
+// paginate trims the passed short links, which were
+// queried by q with one entry more than size, to the
+// page of size entries. When sorting by creation date,
+// the cursors of the following and the preceding page
+// are returned, which are empty if there is no such
+// page.
+func paginate(sls []*shortlink.ShortLink, q *database.Query, size int) (
+	page []*shortlink.ShortLink, next, prev string) {
+
+	backward := q.Cursor != nil && q.Cursor.Backward
+	more := len(sls) > size
+	if more && backward {
+		sls = sls[1:]
+	} else if more {
+		sls = sls[:size]
+	}
+
+	if len(sls) == 0 || q.Sort != database.SortCreated {
+		return sls, "", ""
+	}
+
+	// A backward page always has a following page
+	// and a forward page always has a preceding
+	// page, unless it is the first page.
+	if more || backward {
+		next = encodeCursor(sls[len(sls)-1], false)
+	}
+	if more && backward || q.Cursor != nil && !backward || q.Cursor == nil && q.From > 0 {
+		prev = encodeCursor(sls[0], true)
+	}
+
+	return sls, next, prev
+}
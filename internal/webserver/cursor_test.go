@@ -0,0 +1,153 @@
+package webserver
+
+import (
+	"context"
+	"encoding/base64"
+	"testing"
+	"time"
+
+	"github.com/zekroTJA/slms/internal/database"
+	"github.com/zekroTJA/slms/internal/database/memory"
+	"github.com/zekroTJA/slms/internal/shortlink"
+)
+
+func TestCursorRoundTrip(t *testing.T) {
+	created := time.Date(2019, 4, 2, 20, 16, 49, 123456789, time.UTC)
+	sl := &shortlink.ShortLink{ID: 42, Created: created}
+
+	for _, backward := range []bool{false, true} {
+		c, err := decodeCursor(encodeCursor(sl, backward))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !c.Created.Equal(created) || c.ID != 42 || c.Backward != backward {
+			t.Errorf("decoded cursor %+v does not match the short link (backward: %t)", c, backward)
+		}
+	}
+}
+
+func TestDecodeInvalidCursor(t *testing.T) {
+	enc := func(s string) string {
+		return base64.RawURLEncoding.EncodeToString([]byte(s))
+	}
+
+	for _, s := range []string{
+		"",
+		"not base64!",
+		enc("x:1:2"),
+		enc("n:1"),
+		enc("n:1:2:3"),
+		enc("n:a:2"),
+		enc("p:1:b"),
+	} {
+		if _, err := decodeCursor(s); err != errInvalidCursor {
+			t.Errorf("cursor %q should be invalid but returned %v", s, err)
+		}
+	}
+}
+
+func TestPaginate(t *testing.T) {
+	const size = 3
+	ctx := context.Background()
+
+	db := new(memory.Memory)
+	if err := db.Open(new(memory.Config)); err != nil {
+		t.Fatal(err)
+	}
+	for _, short := range []string{"a", "b", "c", "d", "e", "f", "g"} {
+		if _, err := db.CreateShortLink(ctx, &shortlink.ShortLink{RootLink: "https://example.com", ShortLink: short}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// Each step requests the page of the cursor of the
+	// previous step selected by use, or the page at
+	// from without cursor if use is empty.
+	var next, prev string
+	for _, step := range []struct {
+		name    string
+		use     string
+		from    int
+		ids     []int
+		hasNext bool
+		hasPrev bool
+	}{
+		{"first", "", 0, []int{7, 6, 5}, true, false},
+		{"middle forward", "next", 0, []int{4, 3, 2}, true, true},
+		{"last forward", "next", 0, []int{1}, false, true},
+		{"middle backward", "prev", 0, []int{4, 3, 2}, true, true},
+		{"first backward", "prev", 0, []int{7, 6, 5}, true, false},
+		{"offset", "", 3, []int{4, 3, 2}, true, true},
+		{"empty", "", 7, []int{}, false, false},
+	} {
+		q := &database.Query{
+			Sort:       database.SortCreated,
+			Descending: true,
+			From:       step.from,
+			Limit:      size + 1,
+		}
+
+		var cursor string
+		switch step.use {
+		case "next":
+			cursor = next
+		case "prev":
+			cursor = prev
+		}
+		if cursor != "" {
+			c, err := decodeCursor(cursor)
+			if err != nil {
+				t.Fatalf("%s: %v", step.name, err)
+			}
+			q.Cursor = c
+		} else if step.use != "" {
+			t.Fatalf("%s: previous step returned no %s cursor", step.name, step.use)
+		}
+
+		sls, _, err := db.QueryShortLinks(ctx, q)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		var page []*shortlink.ShortLink
+		page, next, prev = paginate(sls, q, size)
+
+		ids := make([]int, len(page))
+		for i, sl := range page {
+			ids[i] = sl.ID
+		}
+		if !equalInts(ids, step.ids) {
+			t.Errorf("%s: page should be %v but was %v", step.name, step.ids, ids)
+		}
+		if (next != "") != step.hasNext {
+			t.Errorf("%s: next cursor should be set: %t", step.name, step.hasNext)
+		}
+		if (prev != "") != step.hasPrev {
+			t.Errorf("%s: prev cursor should be set: %t", step.name, step.hasPrev)
+		}
+	}
+}
+
+func TestPaginateOtherSort(t *testing.T) {
+	sls := []*shortlink.ShortLink{{ID: 3}, {ID: 2}, {ID: 1}}
+
+	page, next, prev := paginate(sls, &database.Query{Sort: database.SortID, From: 2}, 2)
+	if len(page) != 2 || page[0].ID != 3 {
+		t.Errorf("page should contain the first 2 short links but was %+v", page)
+	}
+	if next != "" || prev != "" {
+		t.Error("cursors should only be set when sorting by created")
+	}
+}
+
+func equalInts(a, b []int) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
@@ -1,27 +1,75 @@
 package webserver
 
 import (
+	"bytes"
+	"context"
 	"encoding/json"
 	"errors"
 	"fmt"
 	"regexp"
 	"strconv"
+	"time"
 
 	"github.com/qiangxue/fasthttp-routing"
 	"github.com/valyala/fasthttp"
 	"github.com/zekroTJA/slms/internal/auth"
+	"github.com/zekroTJA/slms/internal/database"
 	"github.com/zekroTJA/slms/internal/logger"
 	"github.com/zekroTJA/slms/internal/shortlink"
 	"github.com/zekroTJA/slms/internal/static"
+	"github.com/zekroTJA/slms/internal/stats"
+	"github.com/zekroTJA/slms/internal/transfer"
 	"github.com/zekroTJA/slms/internal/util"
 )
 
+// Default and maximum number of intervals
+// of stats time series and of entries of
+// stats top lists.
+const (
+	defaultStatsBuckets = 30
+	maxStatsBuckets     = 1000
+	defaultTopLimit     = 10
+	maxTopLimit         = 100
+)
+
+// Default and maximum number of days without
+// accesses after which short links are listed
+// as idle by the stats summary.
+const (
+	defaultIdleDays = 30
+	maxIdleDays     = 3650
+)
+
+// Maximum number of short links and maximum
+// duration of an import via the API.
+const (
+	maxImportEntries = 10000
+	importTimeout    = 5 * time.Minute
+)
+
+// shortLinkBody is the request body of creating
+// and modifying short links, which may contain
+// the password of the short link in plain text.
+type shortLinkBody struct {
+	shortlink.ShortLink
+	Password string `json:"password"`
+}
+
+// importError is the response body of an import
+// which was aborted by an error.
+type importError struct {
+	Code    int              `json:"code"`
+	Message string           `json:"message"`
+	Result  *transfer.Result `json:"result"`
+}
+
 // Error Objects
 var (
-	errNotFound           = errors.New("not found")
-	errUpdatedBoth        = errors.New("you can not update short and root link at once")
-	errShortAlreadyExists = errors.New("the set short identifyer already exists")
-	errInvalidArguments   = errors.New("invalid arguments")
+	errNotFound         = errors.New("not found")
+	errUpdatedBoth      = errors.New("you can not update short and root link at once")
+	errInvalidArguments = errors.New("invalid arguments")
+	errNegativeLimit    = errors.New("max_accesses must not be negative")
+	errTooManyEntries   = fmt.Errorf("imports must not contain more than %d short links", maxImportEntries)
 )
 
 // Static File Handlers
@@ -37,10 +85,72 @@ var (
 
 var allowedRx = regexp.MustCompile(`[\w_\-]+`)
 
-const reservedWords = "manage count"
+// actorKey is the key of the request actor
+// in the routing context.
+const actorKey = "actor"
+
+const reservedWords = "manage count metrics"
 
 // --- HELPER FUNCTIONS AND HANDLERS -------------------------------------
 
+// ValidateShortLink checks the short and root link,
+// the limits and the redirect type of the passed
+// short link object the same way as on creating
+// short links via the API. A password hash, which
+// is only set on imports, must be a bcrypt hash.
+// If httpsOnly is set, the root link must be https.
+func ValidateShortLink(sl *shortlink.ShortLink, httpsOnly bool) error {
+	if sl.RootLink == "" {
+		return errInvalidArguments
+	}
+
+	if err := util.CheckIfValidShort(sl.ShortLink, reservedWords, allowedRx); err != nil {
+		return err
+	}
+
+	if err := util.CheckIfValidLink(sl.RootLink, httpsOnly); err != nil {
+		return err
+	}
+
+	if err := validateRedirectType(sl.RedirectType); err != nil {
+		return err
+	}
+
+	if sl.PasswordHash != "" && !auth.IsHash(sl.PasswordHash) {
+		return errInvalidPasswordHash
+	}
+
+	return validateLimits(sl, httpsOnly)
+}
+
+// validateLimits checks the access limit and
+// the fallback URL of the passed short link.
+// If httpsOnly is set, the fallback URL must
+// be https.
+func validateLimits(sl *shortlink.ShortLink, httpsOnly bool) error {
+	if sl.MaxAccesses < 0 {
+		return errNegativeLimit
+	}
+
+	if sl.FallbackURL != "" {
+		return util.CheckIfValidLink(sl.FallbackURL, httpsOnly)
+	}
+
+	return nil
+}
+
+// annotate sets the expired and protected flags
+// of the passed short links, taking the accesses
+// into account which are not yet written to the
+// database.
+func (ws *WebServer) annotate(sls ...*shortlink.ShortLink) {
+	now := time.Now()
+	for _, sl := range sls {
+		sl.Expired = sl.IsExpired(now, ws.counter.Pending(sl.ID))
+		sl.Protected = sl.PasswordHash != ""
+	}
+}
+
 // jsonError writes the error message of err and the
 // passed status to response context and aborts the
 // execution of following registered handlers ONLY IF
@@ -77,6 +187,56 @@ func jsonResponse(ctx *routing.Context, v interface{}, status int) error {
 	return jsonError(ctx, err, fasthttp.StatusInternalServerError)
 }
 
+// dbError writes the error message of an error returned
+// by a database call with the context rctx as jsonError
+// to the response context.
+// Conflicts of short identifiers result in status 409,
+// exceeded timeouts in status 504 and cancelled calls in
+// status 503. All other errors result in status 500.
+// This function always returns a nil error.
+func dbError(ctx *routing.Context, rctx context.Context, err error) error {
+	return jsonError(ctx, err, dbErrorStatus(rctx, err))
+}
+
+// dbErrorStatus returns the HTTP status code for an
+// error returned by a database call with the context
+// rctx. As the database drivers return their own
+// errors on cancellation, the error of rctx is
+// checked as well.
+func dbErrorStatus(rctx context.Context, err error) int {
+	if database.IsConflict(err) {
+		return fasthttp.StatusConflict
+	}
+
+	switch {
+	case errors.Is(err, context.DeadlineExceeded), errors.Is(rctx.Err(), context.DeadlineExceeded):
+		return fasthttp.StatusGatewayTimeout
+	case errors.Is(err, context.Canceled), errors.Is(rctx.Err(), context.Canceled):
+		return fasthttp.StatusServiceUnavailable
+	default:
+		return fasthttp.StatusInternalServerError
+	}
+}
+
+// requestContext returns the context for database calls
+// of the passed request which is cancelled when the
+// configured request timeout is exceeded, measured from
+// the start of the request. The context carries the
+// actor of the request.
+// The returned cancel function must be called after
+// the request was handled.
+func (ws *WebServer) requestContext(ctx *routing.Context) (context.Context, context.CancelFunc) {
+	return context.WithDeadline(actorContext(ctx), ctx.Time().Add(ws.requestTimeout))
+}
+
+// actorContext returns a background context carrying
+// the actor which was set for the passed request by
+// handlerAuth.
+func actorContext(ctx *routing.Context) context.Context {
+	actor, _ := ctx.Get(actorKey).(string)
+	return database.WithActor(context.Background(), actor)
+}
+
 // parseJSONBody tries to parse a requests JSON
 // body to the passed object pointer. If the
 // parsing fails, this will result in a jsonError
@@ -113,18 +273,21 @@ func (ws *WebServer) getShortLink(ctx *routing.Context, onlyByShort bool) (*shor
 	var err error
 	id := ctx.Param("id")
 
+	rctx, cancel := ws.requestContext(ctx)
+	defer cancel()
+
 	if !onlyByShort {
-		sl, err = ws.db.GetShortLink(id, "", "")
+		sl, err = ws.db.GetShortLink(rctx, id, "", "")
 		if err != nil {
-			jsonError(ctx, err, fasthttp.StatusInternalServerError)
+			dbError(ctx, rctx, err)
 			return nil, false
 		}
 	}
 
 	if sl == nil {
-		sl, err = ws.db.GetShortLink("", "", id)
+		sl, err = ws.db.GetShortLink(rctx, "", "", id)
 		if err != nil {
-			jsonError(ctx, err, fasthttp.StatusInternalServerError)
+			dbError(ctx, rctx, err)
 			return nil, false
 		}
 		if sl == nil {
@@ -136,6 +299,300 @@ func (ws *WebServer) getShortLink(ctx *routing.Context, onlyByShort bool) (*shor
 	return sl, true
 }
 
+// getPaging parses the query parameters 'page' and
+// 'size' of the request. If they are not set, page
+// defaults to 0 and size to 100.
+// If the parsing fails, this results in a jsonError
+// response with status 400 and false is returned.
+func getPaging(ctx *routing.Context) (int, int, bool) {
+	var err error
+	page, size := 0, 100
+
+	query := ctx.QueryArgs()
+
+	if query.Has("page") {
+		page, err = strconv.Atoi(string(query.Peek("page")))
+		if err != nil {
+			jsonError(ctx, err, fasthttp.StatusBadRequest)
+			return 0, 0, false
+		}
+		if page < 0 {
+			jsonError(ctx, errors.New("page must be at leats 0"), fasthttp.StatusBadRequest)
+			return 0, 0, false
+		}
+	}
+
+	if query.Has("size") {
+		size, err = strconv.Atoi(string(query.Peek("size")))
+		if err != nil {
+			jsonError(ctx, err, fasthttp.StatusBadRequest)
+			return 0, 0, false
+		}
+		if size < 1 || size > 1000 {
+			jsonError(ctx, errors.New("size must be in range (0, 1000]"), fasthttp.StatusBadRequest)
+			return 0, 0, false
+		}
+	}
+
+	return page, size, true
+}
+
+// getQuery parses the paging, filter and sort
+// parameters of the request to a database.Query.
+// The list is sorted by 'sort', which defaults to
+// created, in the direction 'order', which defaults
+// to desc. If 'cursor' is set, it replaces 'page'.
+// If the parsing fails, this results in a jsonError
+// response with status 400 and false is returned.
+func getQuery(ctx *routing.Context) (*database.Query, bool) {
+	page, size, ok := getPaging(ctx)
+	if !ok {
+		return nil, false
+	}
+
+	query := ctx.QueryArgs()
+	q := &database.Query{
+		Search:     string(query.Peek("search")),
+		Domain:     string(query.Peek("domain")),
+		Sort:       database.SortCreated,
+		Descending: true,
+		From:       page * size,
+		Limit:      size,
+	}
+
+	times := map[string]*time.Time{
+		"created_from": &q.CreatedFrom,
+		"created_to":   &q.CreatedTo,
+		"edited_from":  &q.EditedFrom,
+		"edited_to":    &q.EditedTo,
+	}
+	for key, t := range times {
+		if !query.Has(key) {
+			continue
+		}
+		v, err := parseTime(string(query.Peek(key)))
+		if err != nil {
+			jsonError(ctx, fmt.Errorf("%s must be a RFC3339 time or date", key), fasthttp.StatusBadRequest)
+			return nil, false
+		}
+		*t = v
+	}
+
+	if query.Has("min_accesses") {
+		n, err := strconv.Atoi(string(query.Peek("min_accesses")))
+		if err != nil {
+			jsonError(ctx, err, fasthttp.StatusBadRequest)
+			return nil, false
+		}
+		q.MinAccesses = n
+	}
+
+	if query.Has("sort") {
+		q.Sort = string(query.Peek("sort"))
+		if !database.IsSortField(q.Sort) {
+			jsonError(ctx, fmt.Errorf("can not sort by '%s'", q.Sort), fasthttp.StatusBadRequest)
+			return nil, false
+		}
+	}
+
+	switch order := string(query.Peek("order")); order {
+	case "", "desc":
+	case "asc":
+		q.Descending = false
+	default:
+		jsonError(ctx, errors.New("order must be 'asc' or 'desc'"), fasthttp.StatusBadRequest)
+		return nil, false
+	}
+
+	if query.Has("cursor") {
+		if q.Sort != database.SortCreated {
+			jsonError(ctx, errors.New("cursor can only be used when sorting by created"), fasthttp.StatusBadRequest)
+			return nil, false
+		}
+		c, err := decodeCursor(string(query.Peek("cursor")))
+		if err != nil {
+			jsonError(ctx, err, fasthttp.StatusBadRequest)
+			return nil, false
+		}
+		q.Cursor = c
+		q.From = 0
+	}
+
+	return q, true
+}
+
+// getStatsRange parses the query parameters
+// 'interval', 'from' and 'to' of the request.
+// 'interval' defaults to day and the range to
+// the 30 intervals before the request.
+// If the parsing fails or the range contains more
+// than maxStatsBuckets intervals, this results in a
+// jsonError response with status 400 and false is
+// returned.
+func getStatsRange(ctx *routing.Context) (time.Time, time.Time, stats.Interval, bool) {
+	interval := stats.IntervalDay
+	if query := ctx.QueryArgs(); query.Has("interval") {
+		var err error
+		if interval, err = stats.ParseInterval(string(query.Peek("interval"))); err != nil {
+			jsonError(ctx, err, fasthttp.StatusBadRequest)
+			return time.Time{}, time.Time{}, "", false
+		}
+	}
+
+	from, to, ok := getTimeRange(ctx, defaultStatsBuckets*interval.Duration())
+	if !ok {
+		return time.Time{}, time.Time{}, "", false
+	}
+
+	if to.Sub(from) > maxStatsBuckets*interval.Duration() {
+		jsonError(ctx, fmt.Errorf("the range must not contain more than %d intervals", maxStatsBuckets),
+			fasthttp.StatusBadRequest)
+		return time.Time{}, time.Time{}, "", false
+	}
+
+	return from, to, interval, true
+}
+
+// getTimeRange parses the query parameters 'from'
+// and 'to' of the request. 'to' defaults to the
+// request time and 'from' to span before 'to'.
+// If the parsing fails, this results in a jsonError
+// response with status 400 and false is returned.
+func getTimeRange(ctx *routing.Context, span time.Duration) (time.Time, time.Time, bool) {
+	var err error
+	query := ctx.QueryArgs()
+
+	to := ctx.Time().UTC()
+	if query.Has("to") {
+		if to, err = parseTime(string(query.Peek("to"))); err != nil {
+			jsonError(ctx, errors.New("to must be a RFC3339 time or date"), fasthttp.StatusBadRequest)
+			return time.Time{}, time.Time{}, false
+		}
+	}
+
+	from := to.Add(-span)
+	if query.Has("from") {
+		if from, err = parseTime(string(query.Peek("from"))); err != nil {
+			jsonError(ctx, errors.New("from must be a RFC3339 time or date"), fasthttp.StatusBadRequest)
+			return time.Time{}, time.Time{}, false
+		}
+	}
+
+	if !from.Before(to) {
+		jsonError(ctx, errors.New("from must be before to"), fasthttp.StatusBadRequest)
+		return time.Time{}, time.Time{}, false
+	}
+
+	return from, to, true
+}
+
+// getLimit parses the query parameter 'limit' of
+// the request which defaults to 10 and must be in
+// range [1, 100].
+// If the parsing fails, this results in a jsonError
+// response with status 400 and false is returned.
+func getLimit(ctx *routing.Context) (int, bool) {
+	query := ctx.QueryArgs()
+	if !query.Has("limit") {
+		return defaultTopLimit, true
+	}
+
+	limit, err := strconv.Atoi(string(query.Peek("limit")))
+	if err != nil {
+		jsonError(ctx, err, fasthttp.StatusBadRequest)
+		return 0, false
+	}
+	if limit < 1 || limit > maxTopLimit {
+		jsonError(ctx, fmt.Errorf("limit must be in range [1, %d]", maxTopLimit), fasthttp.StatusBadRequest)
+		return 0, false
+	}
+
+	return limit, true
+}
+
+// getIdleDays parses the query parameter 'idle' of
+// the request which defaults to 30 and must be in
+// range [1, 3650].
+// If the parsing fails, this results in a jsonError
+// response with status 400 and false is returned.
+func getIdleDays(ctx *routing.Context) (int, bool) {
+	query := ctx.QueryArgs()
+	if !query.Has("idle") {
+		return defaultIdleDays, true
+	}
+
+	days, err := strconv.Atoi(string(query.Peek("idle")))
+	if err != nil {
+		jsonError(ctx, err, fasthttp.StatusBadRequest)
+		return 0, false
+	}
+	if days < 1 || days > maxIdleDays {
+		jsonError(ctx, fmt.Errorf("idle must be in range [1, %d]", maxIdleDays), fasthttp.StatusBadRequest)
+		return 0, false
+	}
+
+	return days, true
+}
+
+// getTraffic parses the query parameter 'traffic'
+// of the request which selects the counted accesses
+// and defaults to human accesses.
+// If the parsing fails, this results in a jsonError
+// response with status 400 and false is returned.
+func getTraffic(ctx *routing.Context) (database.Traffic, bool) {
+	query := ctx.QueryArgs()
+	if !query.Has("traffic") {
+		return database.TrafficHuman, true
+	}
+
+	traffic := string(query.Peek("traffic"))
+	if !database.IsTraffic(traffic) {
+		jsonError(ctx, fmt.Errorf("invalid traffic '%s'", traffic), fasthttp.StatusBadRequest)
+		return "", false
+	}
+
+	return database.Traffic(traffic), true
+}
+
+// parseTime parses s as RFC3339 time or,
+// if this fails, as date in UTC.
+func parseTime(s string) (time.Time, error) {
+	t, err := time.Parse(time.RFC3339, s)
+	if err != nil {
+		t, err = time.Parse("2006-01-02", s)
+	}
+	return t, err
+}
+
+// getTrashedShortLink tries to get the ID from the path
+// parameter <id> and attempts to find the corresponding
+// deleted short link.
+// If the attempt fails, this results in a jsonError response
+// with wether status code 404 if no deleted link was found or
+// a database error status if the search attempt failed.
+func (ws *WebServer) getTrashedShortLink(ctx *routing.Context) (*shortlink.Trashed, bool) {
+	id, err := strconv.Atoi(ctx.Param("id"))
+	if err != nil {
+		jsonError(ctx, errNotFound, fasthttp.StatusNotFound)
+		return nil, false
+	}
+
+	rctx, cancel := ws.requestContext(ctx)
+	defer cancel()
+
+	sl, err := ws.db.GetTrashedShortLink(rctx, id)
+	if err != nil {
+		dbError(ctx, rctx, err)
+		return nil, false
+	}
+	if sl == nil {
+		jsonError(ctx, errNotFound, fasthttp.StatusNotFound)
+		return nil, false
+	}
+
+	return sl, true
+}
+
 // checkRequestAuth first checks for a Basic auth
 // token as Authorization header. If the header
 // has no value or the value does not match with
@@ -143,21 +600,23 @@ func (ws *WebServer) getShortLink(ctx *routing.Context, onlyByShort bool) (*shor
 // decode a session from the passed cookie header.
 // If both fails, the auhtorization fails and false
 // will be returned.
-func (ws *WebServer) checkRequestAuth(ctx *routing.Context) bool {
+// On success, the used authentication method
+// ('token' or 'session') is returned.
+func (ws *WebServer) checkRequestAuth(ctx *routing.Context) (string, bool) {
 	_, err := ws.auth.Authenticate(ctx)
 	if err != nil {
 		s, err := ws.sessions.Get(ctx.RequestCtx, "session")
 		if err != nil {
 			logger.Debug("WEBSERVER :: AUTH :: %s", err.Error())
-			return false
+			return "", false
 		}
 		if s.IsNew {
 			logger.Debug("WEBSERVER :: AUTH :: is new")
-			return false
+			return "", false
 		}
-		return true
+		return "session", true
 	}
-	return err == nil
+	return "token", true
 }
 
 // --- GENERAL HANDLERS --------------------------------------------------
@@ -193,10 +652,15 @@ func (ws *WebServer) handlerFileServer(ctx *routing.Context) error {
 // handlerAuth manages general authorization for
 // API endpoints resulting in a jsonError on
 // unauthorized request.
+// The actor of authorized requests, which is
+// recorded in revisions, consists of the
+// authentication method and the client address.
 func (ws *WebServer) handlerAuth(ctx *routing.Context) error {
-	if !ws.checkRequestAuth(ctx) {
+	method, ok := ws.checkRequestAuth(ctx)
+	if !ok {
 		return jsonError(ctx, auth.ErrUnauthorized, fasthttp.StatusUnauthorized)
 	}
+	ctx.Set(actorKey, method+"@"+ws.proxies.clientIP(ctx).String())
 	return nil
 }
 
@@ -205,21 +669,25 @@ func (ws *WebServer) handlerAuth(ctx *routing.Context) error {
 func (ws *WebServer) handlerShort(ctx *routing.Context) error {
 	short := ctx.Param("short")
 	if short == "" {
-		ctx.SetStatusCode(ws.redirectStatus)
-		ctx.Response.Header.Set("Location", ws.config.RootRedirect)
+		ws.redirect(ctx, ws.redirectType, ws.config.RootRedirect)
 		ctx.Abort()
 		return nil
 	}
 
 	ctx.Response.Header.SetContentType("text/html")
 
-	sl, err := ws.db.GetShortLink("", "", short)
+	rctx, cancel := ws.requestContext(ctx)
+	defer cancel()
+
+	sl, err := ws.db.GetShortLink(rctx, "", "", short)
 	if err != nil {
-		ctx.SetStatusCode(fasthttp.StatusInternalServerError)
+		ws.metrics.redirects.Inc(redirectError)
+		status := dbErrorStatus(rctx, err)
+		ctx.SetStatusCode(status)
 		ctx.SetBodyString(
 			"<html>" +
 				"<body>" +
-				"<h1>500 - Internal Error</h1><br/>" +
+				"<h1>" + strconv.Itoa(status) + " - " + fasthttp.StatusMessage(status) + "</h1><br/>" +
 				"<p>Something went wrong getting the short link data:</p><br/>" +
 				"<code>" + err.Error() + "</code>" +
 				"</body>" +
@@ -229,28 +697,43 @@ func (ws *WebServer) handlerShort(ctx *routing.Context) error {
 	}
 
 	if sl == nil {
-		ctx.SetStatusCode(fasthttp.StatusNotFound)
+		ws.metrics.redirects.Inc(redirectMiss)
+		// SendFile sets the status code, so it
+		// must be overwritten afterwards.
 		ctx.SendFile("./web/dist/invalid.html")
+		ctx.SetStatusCode(fasthttp.StatusNotFound)
 		ctx.Abort()
 		return nil
 	}
 
-	ctx.SetStatusCode(ws.redirectStatus)
-	ctx.Response.Header.Set("Location", sl.RootLink)
-	ctx.SetBodyString(
-		"<html>" +
-			"<head>" +
-			"<title>Short Link Management System</title>" +
-			"</head>" +
-			"<body>" +
-			"</body>" +
-			"<a href=\"" + sl.RootLink + "\">moved here</a>" +
-			"</html>")
+	// Expired short links are neither redirected
+	// to their root link nor counted.
+	if sl.IsExpired(time.Now(), ws.counter.Pending(sl.ID)) {
+		ws.metrics.redirects.Inc(redirectExpired)
+		if sl.FallbackURL == "" {
+			ctx.SendFile("./web/dist/expired.html")
+			ctx.SetStatusCode(fasthttp.StatusGone)
+			ctx.Response.Header.Set("Cache-Control", "no-store")
+			ctx.Abort()
+			return nil
+		}
+		ws.redirect(ctx, shortlink.RedirectTemporary, sl.FallbackURL)
+		return nil
+	}
+
+	if sl.PasswordHash == "" {
+		ws.redirect(ctx, ws.redirectTypeOf(sl), sl.RootLink)
+	} else if ws.checkLinkPassword(ctx, sl) {
+		// Form submissions are redirected by 303, so
+		// that browsers follow them by a GET request.
+		ctx.Response.Header.Set("Cache-Control", "no-store")
+		setRedirect(ctx, fasthttp.StatusSeeOther, sl.RootLink)
+	} else {
+		return nil
+	}
 
-	go func() {
-		sl.Accesses++
-		ws.db.UpdateShortLink(sl.ID, sl)
-	}()
+	ws.metrics.redirects.Inc(redirectHit)
+	ws.counter.Record(ws.newAccess(ctx, sl))
 
 	return nil
 }
@@ -272,56 +755,56 @@ func (ws *WebServer) handlerLogin(ctx *routing.Context) error {
 
 // GET /api/shortlinks/count
 func (ws *WebServer) handlerGetShortLinkCount(ctx *routing.Context) error {
-	i, err := ws.db.GetShortLinkCount()
+	rctx, cancel := ws.requestContext(ctx)
+	defer cancel()
+
+	i, err := ws.db.GetShortLinkCount(rctx)
 	if err != nil {
-		return jsonError(ctx, err, fasthttp.StatusInternalServerError)
+		return dbError(ctx, rctx, err)
 	}
 	return jsonResponse(ctx, map[string]int{"count": i}, fasthttp.StatusOK)
 }
 
 // GET /api/shortlinks
 func (ws *WebServer) handlerGetShortLinks(ctx *routing.Context) error {
-	var err error
-	page, size := 0, 100
-
-	query := ctx.QueryArgs()
-
-	if query.Has("page") {
-		page, err = strconv.Atoi(string(query.Peek("page")))
-		if err != nil {
-			return jsonError(ctx, err, fasthttp.StatusBadRequest)
-		}
-		if page < 0 {
-			return jsonError(ctx, errors.New("page must be at leats 0"), fasthttp.StatusBadRequest)
-		}
+	q, ok := getQuery(ctx)
+	if !ok {
+		return nil
 	}
 
-	if query.Has("size") {
-		size, err = strconv.Atoi(string(query.Peek("size")))
-		if err != nil {
-			return jsonError(ctx, err, fasthttp.StatusBadRequest)
-		}
-		if size < 1 || size > 1000 {
-			return jsonError(ctx, errors.New("size must be in range (0, 1000]"), fasthttp.StatusBadRequest)
-		}
-	}
+	rctx, cancel := ws.requestContext(ctx)
+	defer cancel()
+
+	// One more entry is requested to find
+	// out if there is a following page.
+	size := q.Limit
+	q.Limit++
 
-	sls, err := ws.db.GetShortLinks(page*size, size)
+	sls, total, err := ws.db.QueryShortLinks(rctx, q)
 	if err != nil {
-		return jsonError(ctx, err, fasthttp.StatusInternalServerError)
+		return dbError(ctx, rctx, err)
 	}
 
+	sls, next, prev := paginate(sls, q, size)
+
+	ws.annotate(sls...)
+
 	res := map[string]interface{}{
-		"n":       len(sls),
-		"results": sls,
+		"n":           len(sls),
+		"results":     sls,
+		"next_cursor": nil,
+		"prev_cursor": nil,
 	}
 
-	if query.Has("total_entries") {
-		i, err := ws.db.GetShortLinkCount()
-		if err != nil {
-			return jsonError(ctx, err, fasthttp.StatusInternalServerError)
-		}
-		res["total_entries"] = i
+	if next != "" {
+		res["next_cursor"] = next
+	}
+	if prev != "" {
+		res["prev_cursor"] = prev
+	}
+
+	if ctx.QueryArgs().Has("total_entries") {
+		res["total_entries"] = total
 	}
 
 	return jsonResponse(ctx, res, fasthttp.StatusOK)
@@ -329,17 +812,19 @@ func (ws *WebServer) handlerGetShortLinks(ctx *routing.Context) error {
 
 // POST /api/shortlinks
 func (ws *WebServer) handlerCreateShortLink(ctx *routing.Context) error {
-	newSl := new(shortlink.ShortLink)
-	err := parseJSONBody(ctx, newSl)
+	body := new(shortLinkBody)
+	err := parseJSONBody(ctx, body)
 	if err != nil {
 		return jsonError(ctx, err, fasthttp.StatusBadRequest)
 	}
+	newSl := &body.ShortLink
 
 	if newSl.RootLink == "" {
 		return jsonError(ctx, errInvalidArguments, fasthttp.StatusBadRequest)
 	}
 
-	if newSl.ShortLink == "" {
+	random := newSl.ShortLink == ""
+	if random {
 		newSl.ShortLink = util.GetRandString(static.RandShortLen)
 	}
 
@@ -351,19 +836,34 @@ func (ws *WebServer) handlerCreateShortLink(ctx *routing.Context) error {
 		return jsonError(ctx, err, fasthttp.StatusBadRequest)
 	}
 
-	exSl, err := ws.db.GetShortLink("", "", newSl.ShortLink)
-	if err != nil {
-		return jsonError(ctx, err, fasthttp.StatusInternalServerError)
+	if err = validateLimits(newSl, ws.config.OnlyHTTPSRootLink); err != nil {
+		return jsonError(ctx, err, fasthttp.StatusBadRequest)
 	}
-	if exSl != nil {
-		return jsonError(ctx, errShortAlreadyExists, fasthttp.StatusBadRequest)
+
+	if err = validateRedirectType(newSl.RedirectType); err != nil {
+		return jsonError(ctx, err, fasthttp.StatusBadRequest)
 	}
 
-	resSl, err := ws.db.CreateShortLink(newSl)
+	if err = setPassword(newSl, body.Password); err != nil {
+		return jsonError(ctx, err, fasthttp.StatusBadRequest)
+	}
+
+	rctx, cancel := ws.requestContext(ctx)
+	defer cancel()
+
+	resSl, err := ws.db.CreateShortLink(rctx, newSl)
+	// Random short identifiers are generated
+	// again if they are already used.
+	for i := 1; random && database.IsConflict(err) && i < static.RandShortTries; i++ {
+		newSl.ShortLink = util.GetRandString(static.RandShortLen)
+		resSl, err = ws.db.CreateShortLink(rctx, newSl)
+	}
 	if err != nil {
-		return jsonError(ctx, err, fasthttp.StatusInternalServerError)
+		return dbError(ctx, rctx, err)
 	}
 
+	ws.annotate(resSl)
+
 	return jsonResponse(ctx, resSl, fasthttp.StatusOK)
 }
 
@@ -374,22 +874,37 @@ func (ws *WebServer) handlerGetShortLink(ctx *routing.Context) error {
 		return nil
 	}
 
+	ws.annotate(sl)
+
 	return jsonResponse(ctx, sl, fasthttp.StatusOK)
 }
 
 // POST /api/shortlinks/:ID
 func (ws *WebServer) handlerEditShortLink(ctx *routing.Context) error {
-	slUpdated := new(shortlink.ShortLink)
-	err := parseJSONBody(ctx, slUpdated)
+	body := new(shortLinkBody)
+	err := parseJSONBody(ctx, body)
 	if err != nil {
 		return jsonError(ctx, err, fasthttp.StatusBadRequest)
 	}
+	slUpdated := &body.ShortLink
+
+	// The limits, the redirect type and the password
+	// are only updated if they are contained in the
+	// body, so that they can be removed by passing
+	// null, 0 or "".
+	var fields map[string]json.RawMessage
+	if err = json.Unmarshal(ctx.PostBody(), &fields); err != nil {
+		return jsonError(ctx, err, fasthttp.StatusBadRequest)
+	}
 
 	sl, ok := ws.getShortLink(ctx, false)
 	if !ok {
 		return nil
 	}
 
+	rctx, cancel := ws.requestContext(ctx)
+	defer cancel()
+
 	shortLinkUpdated := slUpdated.ShortLink != "" && sl.ShortLink != slUpdated.ShortLink
 	rootLinkUpdated := slUpdated.RootLink != "" && sl.RootLink != slUpdated.RootLink
 
@@ -401,11 +916,6 @@ func (ws *WebServer) handlerEditShortLink(ctx *routing.Context) error {
 		if err := util.CheckIfValidShort(slUpdated.ShortLink, reservedWords, allowedRx); err != nil {
 			return jsonError(ctx, err, fasthttp.StatusBadRequest)
 		}
-		if dsl, err := ws.db.GetShortLink("", "", slUpdated.ShortLink); err != nil {
-			return jsonError(ctx, err, fasthttp.StatusInternalServerError)
-		} else if dsl != nil {
-			return jsonError(ctx, errShortAlreadyExists, fasthttp.StatusBadRequest)
-		}
 	}
 
 	if shortLinkUpdated {
@@ -419,10 +929,39 @@ func (ws *WebServer) handlerEditShortLink(ctx *routing.Context) error {
 		sl.RootLink = slUpdated.RootLink
 	}
 
-	if err := ws.db.UpdateShortLink(sl.ID, sl); err != nil {
-		return jsonError(ctx, err, fasthttp.StatusInternalServerError)
+	if _, ok := fields["expires_at"]; ok {
+		sl.ExpiresAt = slUpdated.ExpiresAt
+	}
+	if _, ok := fields["max_accesses"]; ok {
+		sl.MaxAccesses = slUpdated.MaxAccesses
+	}
+	if _, ok := fields["fallback_url"]; ok {
+		sl.FallbackURL = slUpdated.FallbackURL
+	}
+	if _, ok := fields["redirect_type"]; ok {
+		sl.RedirectType = slUpdated.RedirectType
+	}
+
+	if err := validateLimits(sl, ws.config.OnlyHTTPSRootLink); err != nil {
+		return jsonError(ctx, err, fasthttp.StatusBadRequest)
+	}
+
+	if err := validateRedirectType(sl.RedirectType); err != nil {
+		return jsonError(ctx, err, fasthttp.StatusBadRequest)
 	}
 
+	if _, ok := fields["password"]; ok {
+		if err := setPassword(sl, body.Password); err != nil {
+			return jsonError(ctx, err, fasthttp.StatusBadRequest)
+		}
+	}
+
+	if err := ws.db.UpdateShortLink(rctx, sl.ID, sl); err != nil {
+		return dbError(ctx, rctx, err)
+	}
+
+	ws.annotate(sl)
+
 	return jsonResponse(ctx, sl, fasthttp.StatusOK)
 }
 
@@ -433,9 +972,460 @@ func (ws *WebServer) handlerDeleteShortLink(ctx *routing.Context) error {
 		return nil
 	}
 
-	err := ws.db.DeleteShortLink(sl.ID)
+	rctx, cancel := ws.requestContext(ctx)
+	defer cancel()
+
+	err := ws.db.DeleteShortLink(rctx, sl.ID)
 	if err != nil {
-		return jsonError(ctx, err, fasthttp.StatusInternalServerError)
+		return dbError(ctx, rctx, err)
+	}
+
+	ctx.SetStatusCode(fasthttp.StatusOK)
+	return nil
+}
+
+// GET /api/export
+func (ws *WebServer) handlerExport(ctx *routing.Context) error {
+	format := string(ctx.QueryArgs().Peek("format"))
+	if format == "" {
+		format = transfer.FormatJSON
+	}
+	if err := transfer.CheckFormat(format); err != nil {
+		return jsonError(ctx, err, fasthttp.StatusBadRequest)
+	}
+
+	rctx, cancel := ws.requestContext(ctx)
+	defer cancel()
+
+	var buf bytes.Buffer
+	if err := transfer.Export(rctx, ws.db, &buf, format); err != nil {
+		return dbError(ctx, rctx, err)
+	}
+
+	if format == transfer.FormatCSV {
+		ctx.Response.Header.SetContentType("text/csv")
+	} else {
+		ctx.Response.Header.SetContentType("application/json")
+	}
+	ctx.Response.Header.Set("Content-Disposition",
+		fmt.Sprintf("attachment; filename=\"slms-export.%s\"", format))
+	ctx.SetStatusCode(fasthttp.StatusOK)
+	ctx.SetBody(buf.Bytes())
+
+	return nil
+}
+
+// POST /api/import
+func (ws *WebServer) handlerImport(ctx *routing.Context) error {
+	query := ctx.QueryArgs()
+
+	format := string(query.Peek("format"))
+	if format == "" {
+		format = transfer.FormatJSON
+	}
+	if err := transfer.CheckFormat(format); err != nil {
+		return jsonError(ctx, err, fasthttp.StatusBadRequest)
+	}
+
+	strategy, err := transfer.ParseStrategy(string(query.Peek("strategy")))
+	if err != nil {
+		return jsonError(ctx, err, fasthttp.StatusBadRequest)
+	}
+
+	sls, err := transfer.Decode(bytes.NewReader(ctx.PostBody()), format)
+	if err != nil {
+		return jsonError(ctx, err, fasthttp.StatusBadRequest)
+	}
+
+	if len(sls) > maxImportEntries {
+		return jsonError(ctx, errTooManyEntries, fasthttp.StatusRequestEntityTooLarge)
+	}
+
+	// Validating root links requests each of them,
+	// so the import is not bound to the request
+	// timeout but to the longer import timeout.
+	ictx, cancel := context.WithTimeout(actorContext(ctx), importTimeout)
+	defer cancel()
+
+	res, err := transfer.Import(ictx, ws.db, sls, strategy,
+		func(sl *shortlink.ShortLink) error {
+			return ValidateShortLink(sl, ws.config.OnlyHTTPSRootLink)
+		})
+	if err != nil {
+		// The result contains the short links which
+		// were imported before the import was aborted.
+		status := dbErrorStatus(ictx, err)
+		return jsonResponse(ctx, &importError{
+			Code:    status,
+			Message: err.Error(),
+			Result:  res,
+		}, status)
+	}
+
+	return jsonResponse(ctx, res, fasthttp.StatusOK)
+}
+
+// GET /api/shortlinks/:ID/history
+func (ws *WebServer) handlerGetHistory(ctx *routing.Context) error {
+	sl, ok := ws.getShortLink(ctx, false)
+	if !ok {
+		return nil
+	}
+
+	rctx, cancel := ws.requestContext(ctx)
+	defer cancel()
+
+	revs, err := ws.db.GetRevisions(rctx, sl.ID)
+	if err != nil {
+		return dbError(ctx, rctx, err)
+	}
+
+	return jsonResponse(ctx, map[string]interface{}{
+		"n":       len(revs),
+		"results": revs,
+	}, fasthttp.StatusOK)
+}
+
+// GET /api/shortlinks/:ID/stats
+func (ws *WebServer) handlerGetStats(ctx *routing.Context) error {
+	sl, ok := ws.getShortLink(ctx, false)
+	if !ok {
+		return nil
+	}
+
+	from, to, interval, ok := getStatsRange(ctx)
+	if !ok {
+		return nil
+	}
+
+	traffic, ok := getTraffic(ctx)
+	if !ok {
+		return nil
+	}
+
+	rctx, cancel := ws.requestContext(ctx)
+	defer cancel()
+
+	counts, err := ws.db.GetAccessCounts(rctx, sl.ID, from, to, traffic)
+	if err != nil {
+		return dbError(ctx, rctx, err)
+	}
+
+	// Visitors can only be distinguished within
+	// a day, so uniques are counted per whole day.
+	uniques, err := ws.db.GetUniqueCounts(rctx, sl.ID, stats.IntervalDay.Start(from), to)
+	if err != nil {
+		return dbError(ctx, rctx, err)
+	}
+
+	var total int
+	for _, c := range counts {
+		total += c.Count
+	}
+
+	return jsonResponse(ctx, map[string]interface{}{
+		"from":     from,
+		"to":       to,
+		"interval": interval,
+		"traffic":  traffic,
+		"total":    total,
+		"results":  stats.Buckets(counts, from, to, interval),
+		"uniques":  stats.Buckets(uniques, from, to, stats.IntervalDay),
+	}, fasthttp.StatusOK)
+}
+
+// GET /api/shortlinks/:ID/stats/referrers
+func (ws *WebServer) handlerGetReferrerStats(ctx *routing.Context) error {
+	sl, ok := ws.getShortLink(ctx, false)
+	if !ok {
+		return nil
+	}
+
+	from, to, ok := getTimeRange(ctx, defaultStatsBuckets*stats.IntervalDay.Duration())
+	if !ok {
+		return nil
+	}
+
+	limit, ok := getLimit(ctx)
+	if !ok {
+		return nil
+	}
+
+	traffic, ok := getTraffic(ctx)
+	if !ok {
+		return nil
+	}
+
+	rctx, cancel := ws.requestContext(ctx)
+	defer cancel()
+
+	counts, err := ws.db.GetReferrerCounts(rctx, sl.ID, from, to, traffic, limit)
+	if err != nil {
+		return dbError(ctx, rctx, err)
+	}
+
+	return jsonResponse(ctx, map[string]interface{}{
+		"from":    from,
+		"to":      to,
+		"traffic": traffic,
+		"n":       len(counts),
+		"results": counts,
+	}, fasthttp.StatusOK)
+}
+
+// GET /api/shortlinks/:ID/stats/campaigns
+func (ws *WebServer) handlerGetCampaignStats(ctx *routing.Context) error {
+	sl, ok := ws.getShortLink(ctx, false)
+	if !ok {
+		return nil
+	}
+
+	from, to, ok := getTimeRange(ctx, defaultStatsBuckets*stats.IntervalDay.Duration())
+	if !ok {
+		return nil
+	}
+
+	limit, ok := getLimit(ctx)
+	if !ok {
+		return nil
+	}
+
+	traffic, ok := getTraffic(ctx)
+	if !ok {
+		return nil
+	}
+
+	rctx, cancel := ws.requestContext(ctx)
+	defer cancel()
+
+	counts, err := ws.db.GetCampaignCounts(rctx, sl.ID, from, to, traffic, limit)
+	if err != nil {
+		return dbError(ctx, rctx, err)
+	}
+
+	return jsonResponse(ctx, map[string]interface{}{
+		"from":    from,
+		"to":      to,
+		"traffic": traffic,
+		"n":       len(counts),
+		"results": counts,
+	}, fasthttp.StatusOK)
+}
+
+// GET /api/shortlinks/:ID/stats/countries
+func (ws *WebServer) handlerGetCountryStats(ctx *routing.Context) error {
+	sl, ok := ws.getShortLink(ctx, false)
+	if !ok {
+		return nil
+	}
+
+	from, to, ok := getTimeRange(ctx, defaultStatsBuckets*stats.IntervalDay.Duration())
+	if !ok {
+		return nil
+	}
+
+	limit, ok := getLimit(ctx)
+	if !ok {
+		return nil
+	}
+
+	traffic, ok := getTraffic(ctx)
+	if !ok {
+		return nil
+	}
+
+	rctx, cancel := ws.requestContext(ctx)
+	defer cancel()
+
+	counts, err := ws.db.GetCountryCounts(rctx, sl.ID, from, to, traffic, limit)
+	if err != nil {
+		return dbError(ctx, rctx, err)
+	}
+
+	return jsonResponse(ctx, map[string]interface{}{
+		"from":    from,
+		"to":      to,
+		"traffic": traffic,
+		"n":       len(counts),
+		"results": counts,
+	}, fasthttp.StatusOK)
+}
+
+// GET /api/stats/summary
+func (ws *WebServer) handlerGetSummary(ctx *routing.Context) error {
+	from, to, ok := getTimeRange(ctx, defaultStatsBuckets*stats.IntervalDay.Duration())
+	if !ok {
+		return nil
+	}
+
+	limit, ok := getLimit(ctx)
+	if !ok {
+		return nil
+	}
+
+	traffic, ok := getTraffic(ctx)
+	if !ok {
+		return nil
+	}
+
+	idleDays, ok := getIdleDays(ctx)
+	if !ok {
+		return nil
+	}
+
+	rctx, cancel := ws.requestContext(ctx)
+	defer cancel()
+
+	links, err := ws.db.GetShortLinkCount(rctx)
+	if err != nil {
+		return dbError(ctx, rctx, err)
+	}
+
+	accesses, err := ws.db.GetAccessTotal(rctx, from, to, traffic)
+	if err != nil {
+		return dbError(ctx, rctx, err)
+	}
+
+	top, err := ws.db.GetTopShortLinks(rctx, from, to, traffic, limit)
+	if err != nil {
+		return dbError(ctx, rctx, err)
+	}
+
+	recent, created, err := ws.db.QueryShortLinks(rctx, &database.Query{
+		CreatedFrom: from,
+		CreatedTo:   to,
+		Sort:        database.SortCreated,
+		Descending:  true,
+		Limit:       limit,
+	})
+	if err != nil {
+		return dbError(ctx, rctx, err)
+	}
+
+	since := time.Now().Add(-time.Duration(idleDays) * 24 * time.Hour)
+	idle, idleTotal, err := ws.db.GetIdleShortLinks(rctx, since, traffic, limit)
+	if err != nil {
+		return dbError(ctx, rctx, err)
+	}
+
+	for _, sl := range top {
+		ws.annotate(&sl.ShortLink)
+	}
+	ws.annotate(recent...)
+	ws.annotate(idle...)
+
+	return jsonResponse(ctx, map[string]interface{}{
+		"from":     from,
+		"to":       to,
+		"traffic":  traffic,
+		"links":    links,
+		"accesses": accesses,
+		"top":      top,
+		"recent": map[string]interface{}{
+			"total":   created,
+			"results": recent,
+		},
+		"idle": map[string]interface{}{
+			"since":   since,
+			"total":   idleTotal,
+			"results": idle,
+		},
+	}, fasthttp.StatusOK)
+}
+
+// POST /api/shortlinks/:ID/revert/:REV
+func (ws *WebServer) handlerRevertShortLink(ctx *routing.Context) error {
+	revID, err := strconv.Atoi(ctx.Param("rev"))
+	if err != nil {
+		return jsonError(ctx, errNotFound, fasthttp.StatusNotFound)
+	}
+
+	sl, ok := ws.getShortLink(ctx, false)
+	if !ok {
+		return nil
+	}
+
+	rctx, cancel := ws.requestContext(ctx)
+	defer cancel()
+
+	rev, err := ws.db.GetRevision(rctx, sl.ID, revID)
+	if err != nil {
+		return dbError(ctx, rctx, err)
+	}
+	if rev == nil {
+		return jsonError(ctx, errNotFound, fasthttp.StatusNotFound)
+	}
+
+	if sl.RootLink != rev.NewRootLink || sl.ShortLink != rev.NewShortLink {
+		sl.RootLink = rev.NewRootLink
+		sl.ShortLink = rev.NewShortLink
+
+		if err := ws.db.UpdateShortLink(rctx, sl.ID, sl); err != nil {
+			return dbError(ctx, rctx, err)
+		}
+	}
+
+	ws.annotate(sl)
+
+	return jsonResponse(ctx, sl, fasthttp.StatusOK)
+}
+
+// GET /api/trash
+func (ws *WebServer) handlerGetTrash(ctx *routing.Context) error {
+	page, size, ok := getPaging(ctx)
+	if !ok {
+		return nil
+	}
+
+	rctx, cancel := ws.requestContext(ctx)
+	defer cancel()
+
+	sls, err := ws.db.GetTrashedShortLinks(rctx, page*size, size)
+	if err != nil {
+		return dbError(ctx, rctx, err)
+	}
+
+	for _, sl := range sls {
+		ws.annotate(&sl.ShortLink)
+	}
+
+	return jsonResponse(ctx, map[string]interface{}{
+		"n":       len(sls),
+		"results": sls,
+	}, fasthttp.StatusOK)
+}
+
+// POST /api/trash/:ID/restore
+func (ws *WebServer) handlerRestoreShortLink(ctx *routing.Context) error {
+	sl, ok := ws.getTrashedShortLink(ctx)
+	if !ok {
+		return nil
+	}
+
+	rctx, cancel := ws.requestContext(ctx)
+	defer cancel()
+
+	if err := ws.db.RestoreShortLink(rctx, sl.ID); err != nil {
+		return dbError(ctx, rctx, err)
+	}
+
+	ws.annotate(&sl.ShortLink)
+
+	return jsonResponse(ctx, sl.ShortLink, fasthttp.StatusOK)
+}
+
+// DELETE /api/trash/:ID
+func (ws *WebServer) handlerPurgeShortLink(ctx *routing.Context) error {
+	sl, ok := ws.getTrashedShortLink(ctx)
+	if !ok {
+		return nil
+	}
+
+	rctx, cancel := ws.requestContext(ctx)
+	defer cancel()
+
+	if err := ws.db.PurgeShortLink(rctx, sl.ID); err != nil {
+		return dbError(ctx, rctx, err)
 	}
 
 	ctx.SetStatusCode(fasthttp.StatusOK)
@@ -0,0 +1,94 @@
+package webserver
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/valyala/fasthttp"
+	"github.com/zekroTJA/slms/internal/auth"
+	"github.com/zekroTJA/slms/internal/shortlink"
+)
+
+func TestAnnotatedResponses(t *testing.T) {
+	ctx := context.Background()
+	ws, protected := newTestServer(t)
+	defer ws.counter.Close()
+
+	hash, err := auth.CreateHash("testtoken", 4)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ws.auth = auth.NewTokenAuthProvider(hash)
+
+	expires := time.Now().Add(-time.Hour)
+	for _, short := range []string{"expired", "trashed"} {
+		sl, err := ws.db.CreateShortLink(ctx, &shortlink.ShortLink{
+			RootLink:  "https://example.com",
+			ShortLink: short,
+			ExpiresAt: &expires,
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if short == "trashed" {
+			if err = ws.db.DeleteShortLink(ctx, sl.ID); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+
+	revs, err := ws.db.GetRevisions(ctx, protected.ID)
+	if err != nil || len(revs) == 0 {
+		t.Fatalf("revisions of the protected short link not found: %v", err)
+	}
+
+	client, ln := serve(ws)
+	defer ln.Close()
+
+	request := func(method, path string, v interface{}) {
+		req := newRequest(method, "http://slms"+path, "")
+		req.Header.Set("Authorization", "Basic testtoken")
+		res := new(fasthttp.Response)
+		if err := client.Do(req, res); err != nil {
+			t.Fatal(err)
+		}
+		if res.StatusCode() != fasthttp.StatusOK {
+			t.Fatalf("%s %s: status should be 200 but was %d", method, path, res.StatusCode())
+		}
+		if err := json.Unmarshal(res.Body(), v); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	var reverted shortlink.ShortLink
+	request("POST", fmt.Sprintf("/api/shortlinks/%d/revert/%d", protected.ID, revs[0].ID), &reverted)
+	if !reverted.Protected {
+		t.Error("reverted short link should be protected")
+	}
+
+	var trash struct {
+		Results []*shortlink.Trashed `json:"results"`
+	}
+	request("GET", "/api/trash", &trash)
+	if len(trash.Results) != 1 || !trash.Results[0].Expired {
+		t.Errorf("trashed short link should be expired: %+v", trash.Results)
+	}
+
+	var summary struct {
+		Recent struct {
+			Results []*shortlink.ShortLink `json:"results"`
+		} `json:"recent"`
+	}
+	request("GET", "/api/stats/summary", &summary)
+	for _, sl := range summary.Recent.Results {
+		if sl.Expired != (sl.ShortLink == "expired") || sl.Protected != (sl.ShortLink == "protected") {
+			t.Errorf("recent short link %s is not annotated: %+v", sl.ShortLink, sl)
+		}
+	}
+	if len(summary.Recent.Results) != 2 {
+		t.Errorf("summary should contain 2 recent short links but contained %d", len(summary.Recent.Results))
+	}
+}
@@ -0,0 +1,83 @@
+package webserver
+
+import (
+	"strconv"
+	"time"
+
+	routing "github.com/qiangxue/fasthttp-routing"
+	"github.com/valyala/fasthttp"
+	"github.com/zekroTJA/slms/internal/auth"
+	"github.com/zekroTJA/slms/pkg/metrics"
+)
+
+// Results of short link redirect requests
+// counted by the redirects metric.
+const (
+	redirectHit       = "hit"
+	redirectMiss      = "miss"
+	redirectExpired   = "expired"
+	redirectProtected = "protected"
+	redirectDenied    = "denied"
+	redirectError     = "error"
+)
+
+// webServerMetrics contains the metrics
+// recorded by the web server handlers.
+type webServerMetrics struct {
+	redirects *metrics.Counter
+	requests  *metrics.Counter
+	durations *metrics.Histogram
+}
+
+// newWebServerMetrics registers the metrics of
+// the web server and of the passed rate limit
+// manager in reg.
+func newWebServerMetrics(reg *metrics.Registry, rlm *RateLimitManager) *webServerMetrics {
+	reg.CounterFunc("slms_ratelimit_rejections_total",
+		"Number of requests rejected by rate limiting.",
+		func() float64 { return float64(rlm.Rejected()) })
+	reg.GaugeFunc("slms_ratelimit_entries",
+		"Number of rate limiters currently maintained.",
+		func() float64 { return float64(rlm.Size()) })
+
+	return &webServerMetrics{
+		redirects: reg.Counter("slms_redirects_total",
+			"Number of short link redirect requests by result.", "result"),
+		requests: reg.Counter("slms_api_requests_total",
+			"Number of API requests by route and status.", "method", "route", "status"),
+		durations: reg.Histogram("slms_api_request_duration_seconds",
+			"Duration of API requests in seconds by route.", nil, "method", "route"),
+	}
+}
+
+// observe returns a handler which counts and times
+// the request by the passed route path and the
+// request method. It must precede the other handlers
+// of a route as it executes all following handlers.
+func (ws *WebServer) observe(route string) routing.Handler {
+	return func(ctx *routing.Context) error {
+		start := time.Now()
+		err := ctx.Next()
+
+		method := string(ctx.Method())
+		ws.metrics.durations.Observe(time.Since(start).Seconds(), method, route)
+		ws.metrics.requests.Inc(method, route, strconv.Itoa(ctx.Response.StatusCode()))
+
+		return err
+	}
+}
+
+// GET /metrics
+// Unless the metrics are configured public, the
+// request must be authorized like API requests.
+func (ws *WebServer) handlerMetrics(ctx *routing.Context) error {
+	if !ws.config.Metrics.Public {
+		if _, ok := ws.checkRequestAuth(ctx); !ok {
+			return jsonError(ctx, auth.ErrUnauthorized, fasthttp.StatusUnauthorized)
+		}
+	}
+
+	ctx.Response.Header.SetContentType(metrics.ContentType)
+	_, err := ws.registry.WriteTo(ctx)
+	return err
+}
@@ -0,0 +1,97 @@
+package webserver
+
+import (
+	"errors"
+
+	routing "github.com/qiangxue/fasthttp-routing"
+	"github.com/valyala/fasthttp"
+	"github.com/zekroTJA/slms/internal/auth"
+	"github.com/zekroTJA/slms/internal/shortlink"
+)
+
+// passwordHashRounds is the number of bcrypt
+// rounds used to hash short link passwords.
+const passwordHashRounds = 12
+
+// maxPasswordLen is the maximum length of short
+// link passwords in bytes, as bcrypt ignores
+// all following bytes.
+const maxPasswordLen = 72
+
+var (
+	errPasswordTooLong     = errors.New("password must not be longer than 72 bytes")
+	errInvalidPasswordHash = errors.New("password hash must be a bcrypt hash")
+)
+
+// setPassword sets the password hash of the passed
+// short link to the hash of password or removes
+// it, if password is empty.
+func setPassword(sl *shortlink.ShortLink, password string) error {
+	if password == "" {
+		sl.PasswordHash = ""
+		return nil
+	}
+
+	if len(password) > maxPasswordLen {
+		return errPasswordTooLong
+	}
+
+	hash, err := auth.CreateHash(password, passwordHashRounds)
+	if err != nil {
+		return err
+	}
+
+	sl.PasswordHash = hash
+	return nil
+}
+
+// checkLinkPassword returns true if the request
+// contains the password of the passed protected
+// short link. Otherwise, the response is set to
+// the password form, which is submitted by a
+// POST request to the short link.
+func (ws *WebServer) checkLinkPassword(ctx *routing.Context, sl *shortlink.ShortLink) bool {
+	if !ctx.IsPost() {
+		ws.metrics.redirects.Inc(redirectProtected)
+		setPasswordForm(ctx, fasthttp.StatusOK, "")
+		return false
+	}
+
+	if !auth.CheckHash(string(ctx.FormValue("password")), sl.PasswordHash) {
+		ws.metrics.redirects.Inc(redirectDenied)
+		setPasswordForm(ctx, fasthttp.StatusForbidden, "The entered password is wrong.")
+		return false
+	}
+
+	return true
+}
+
+// setPasswordForm sets the response to the password
+// form of protected short links with the passed
+// status code and message.
+func setPasswordForm(ctx *routing.Context, status int, message string) {
+	ctx.SetStatusCode(status)
+	ctx.Response.Header.Set("Cache-Control", "no-store")
+	ctx.SetBodyString(
+		"<!DOCTYPE html>" +
+			"<html>" +
+			"<head>" +
+			"<meta charset=\"utf-8\" />" +
+			"<title>SLMS - PROTECTED SHORTLINK</title>" +
+			"<meta name=\"viewport\" content=\"width=device-width, initial-scale=1\">" +
+			"<style>" +
+			"body { font-family: 'Avenir', Helvetica, Arial, sans-serif; background-color: #263238; " +
+			"color: white; text-align: center; margin-top: 10%; }" +
+			"</style>" +
+			"</head>" +
+			"<body>" +
+			"<h1>PROTECTED SHORTLINK</h1>" +
+			"<p>Please enter the password of this shortlink.</p>" +
+			"<p>" + message + "</p>" +
+			"<form method=\"post\">" +
+			"<input type=\"password\" name=\"password\" autofocus required> " +
+			"<button type=\"submit\">Open</button>" +
+			"</form>" +
+			"</body>" +
+			"</html>")
+}
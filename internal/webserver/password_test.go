@@ -0,0 +1,178 @@
+package webserver
+
+import (
+	"context"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	routing "github.com/qiangxue/fasthttp-routing"
+	"github.com/valyala/fasthttp"
+	"github.com/valyala/fasthttp/fasthttputil"
+	"github.com/zekroTJA/slms/internal/auth"
+	"github.com/zekroTJA/slms/internal/counter"
+	"github.com/zekroTJA/slms/internal/database/memory"
+	"github.com/zekroTJA/slms/internal/shortlink"
+)
+
+const testPassword = "correct horse"
+
+// newTestServer returns a web server on a memory
+// database containing the short link 'protected'
+// with the password testPassword.
+func newTestServer(t *testing.T) (*WebServer, *shortlink.ShortLink) {
+	db := new(memory.Memory)
+	if err := db.Open(new(memory.Config)); err != nil {
+		t.Fatal(err)
+	}
+
+	hash, err := auth.CreateHash(testPassword, 4)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sl, err := db.CreateShortLink(context.Background(), &shortlink.ShortLink{
+		RootLink:     "https://example.com",
+		ShortLink:    "protected",
+		PasswordHash: hash,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ws, err := NewWebServer(&Config{APITokenHash: "testtokenhash"}, db, counter.New(db, time.Hour), nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return ws, sl
+}
+
+// newRequest returns a request of a browser with
+// the passed method, URI and form body.
+func newRequest(method, uri, form string) *fasthttp.Request {
+	req := new(fasthttp.Request)
+	req.Header.SetMethod(method)
+	req.SetRequestURI(uri)
+	req.Header.Set("Accept", "text/html")
+	if form != "" {
+		req.Header.SetContentType("application/x-www-form-urlencoded")
+		req.SetBodyString(form)
+	}
+	return req
+}
+
+// serve serves the passed web server on an in-memory
+// listener and returns a client connecting to it.
+// Requests are served by the server, which sets
+// the request time the deadlines are based on.
+func serve(ws *WebServer) (*fasthttp.Client, net.Listener) {
+	ln := fasthttputil.NewInmemoryListener()
+	go ws.server.Serve(ln)
+
+	client := &fasthttp.Client{
+		Dial: func(addr string) (net.Conn, error) {
+			return ln.Dial()
+		},
+	}
+
+	return client, ln
+}
+
+func TestSetPassword(t *testing.T) {
+	sl := &shortlink.ShortLink{PasswordHash: "old"}
+
+	if err := setPassword(sl, strings.Repeat("a", maxPasswordLen+1)); err != errPasswordTooLong {
+		t.Errorf("too long password should be rejected but returned %v", err)
+	}
+	if sl.PasswordHash != "old" {
+		t.Error("password hash should be kept on error")
+	}
+
+	if err := setPassword(sl, testPassword); err != nil {
+		t.Fatal(err)
+	}
+	if !auth.IsHash(sl.PasswordHash) || !auth.CheckHash(testPassword, sl.PasswordHash) {
+		t.Errorf("password hash '%s' should match the password", sl.PasswordHash)
+	}
+
+	if err := setPassword(sl, ""); err != nil {
+		t.Fatal(err)
+	}
+	if sl.PasswordHash != "" {
+		t.Error("empty password should remove the password hash")
+	}
+}
+
+func TestCheckLinkPassword(t *testing.T) {
+	ws, sl := newTestServer(t)
+	defer ws.counter.Close()
+
+	for _, c := range []struct {
+		name   string
+		method string
+		form   string
+		ok     bool
+		status int
+	}{
+		{"form", "GET", "", false, fasthttp.StatusOK},
+		{"missing", "POST", "", false, fasthttp.StatusForbidden},
+		{"wrong", "POST", "password=wrong", false, fasthttp.StatusForbidden},
+		{"correct", "POST", "password=correct+horse", true, fasthttp.StatusOK},
+	} {
+		ctx := &routing.Context{RequestCtx: new(fasthttp.RequestCtx)}
+		ctx.Init(newRequest(c.method, "/protected", c.form), nil, nil)
+
+		if ok := ws.checkLinkPassword(ctx, sl); ok != c.ok {
+			t.Errorf("%s: password check should return %t", c.name, c.ok)
+		}
+		if status := ctx.Response.StatusCode(); status != c.status {
+			t.Errorf("%s: status should be %d but was %d", c.name, c.status, status)
+		}
+		if !c.ok && !strings.Contains(string(ctx.Response.Body()), "<form method=\"post\">") {
+			t.Errorf("%s: response should contain the password form", c.name)
+		}
+	}
+}
+
+func TestProtectedRedirect(t *testing.T) {
+	ws, sl := newTestServer(t)
+	defer ws.counter.Close()
+
+	client, ln := serve(ws)
+	defer ln.Close()
+
+	// Password submissions are limited to a burst
+	// of 3, so the last one is rejected although
+	// the password is correct.
+	for _, c := range []struct {
+		name   string
+		method string
+		form   string
+		status int
+	}{
+		{"form", "GET", "", fasthttp.StatusOK},
+		{"wrong", "POST", "password=wrong", fasthttp.StatusForbidden},
+		{"correct", "POST", "password=correct+horse", fasthttp.StatusSeeOther},
+		{"again", "POST", "password=correct+horse", fasthttp.StatusSeeOther},
+		{"rate limited", "POST", "password=correct+horse", fasthttp.StatusTooManyRequests},
+	} {
+		res := new(fasthttp.Response)
+		if err := client.Do(newRequest(c.method, "http://slms/protected", c.form), res); err != nil {
+			t.Fatal(err)
+		}
+
+		if status := res.StatusCode(); status != c.status {
+			t.Errorf("%s: status should be %d but was %d", c.name, c.status, status)
+		}
+		if c.status == fasthttp.StatusSeeOther {
+			if loc := string(res.Header.Peek("Location")); loc != sl.RootLink {
+				t.Errorf("%s: location should be '%s' but was '%s'", c.name, sl.RootLink, loc)
+			}
+		}
+	}
+
+	if n := ws.counter.Pending(sl.ID); n != 2 {
+		t.Errorf("2 accesses should be counted but were %d", n)
+	}
+}
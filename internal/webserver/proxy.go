@@ -0,0 +1,83 @@
+package webserver
+
+import (
+	"fmt"
+	"net"
+	"strings"
+
+	routing "github.com/qiangxue/fasthttp-routing"
+)
+
+// trustedProxies contains the networks of
+// reverse proxies whose X-Forwarded-For
+// headers are trusted.
+type trustedProxies []*net.IPNet
+
+// parseTrustedProxies parses the passed list of
+// IP addresses and CIDR networks.
+func parseTrustedProxies(proxies []string) (trustedProxies, error) {
+	nets := make(trustedProxies, 0, len(proxies))
+
+	for _, p := range proxies {
+		if !strings.Contains(p, "/") {
+			ip := net.ParseIP(p)
+			if ip == nil {
+				return nil, fmt.Errorf("invalid trusted proxy '%s'", p)
+			}
+			bits := 8 * net.IPv4len
+			if ip.To4() == nil {
+				bits = 8 * net.IPv6len
+			}
+			p = fmt.Sprintf("%s/%d", p, bits)
+		}
+
+		_, ipNet, err := net.ParseCIDR(p)
+		if err != nil {
+			return nil, fmt.Errorf("invalid trusted proxy '%s'", p)
+		}
+		nets = append(nets, ipNet)
+	}
+
+	return nets, nil
+}
+
+// contains returns true if the passed IP address
+// is located in one of the trusted networks.
+func (p trustedProxies) contains(ip net.IP) bool {
+	for _, n := range p {
+		if n.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// clientIP returns the IP address of the client of
+// the passed request.
+// If the request was sent by a trusted proxy, the
+// addresses of the X-Forwarded-For header are
+// walked from right to left, as only the entries
+// appended by trusted proxies can be relied on. The
+// first address not belonging to a trusted proxy is
+// the client. Otherwise, the remote address of the
+// connection is returned.
+func (p trustedProxies) clientIP(ctx *routing.Context) net.IP {
+	ip := ctx.RemoteIP()
+	if !p.contains(ip) {
+		return ip
+	}
+
+	forwarded := strings.Split(string(ctx.Request.Header.Peek("X-Forwarded-For")), ",")
+	for i := len(forwarded) - 1; i >= 0; i-- {
+		fip := net.ParseIP(strings.TrimSpace(forwarded[i]))
+		if fip == nil {
+			break
+		}
+		ip = fip
+		if !p.contains(ip) {
+			break
+		}
+	}
+
+	return ip
+}
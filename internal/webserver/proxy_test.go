@@ -0,0 +1,69 @@
+package webserver
+
+import (
+	"net"
+	"testing"
+
+	routing "github.com/qiangxue/fasthttp-routing"
+	"github.com/valyala/fasthttp"
+)
+
+func TestParseTrustedProxies(t *testing.T) {
+	p, err := parseTrustedProxies([]string{"10.0.0.1", "192.168.0.0/16", "::1"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cases := map[string]bool{
+		"10.0.0.1":    true,
+		"10.0.0.2":    false,
+		"192.168.4.2": true,
+		"::1":         true,
+		"::2":         false,
+	}
+	for ip, exp := range cases {
+		if got := p.contains(net.ParseIP(ip)); got != exp {
+			t.Errorf("%s should be trusted: %t", ip, exp)
+		}
+	}
+
+	for _, invalid := range []string{"localhost", "10.0.0.0/33", ""} {
+		if _, err := parseTrustedProxies([]string{invalid}); err == nil {
+			t.Errorf("'%s' should be an invalid trusted proxy", invalid)
+		}
+	}
+}
+
+func TestClientIP(t *testing.T) {
+	p, err := parseTrustedProxies([]string{"10.0.0.0/8"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, c := range []struct {
+		name      string
+		remote    string
+		forwarded string
+		exp       string
+	}{
+		{"direct", "203.0.113.7", "", "203.0.113.7"},
+		{"untrusted proxy", "203.0.113.7", "198.51.100.1", "203.0.113.7"},
+		{"trusted proxy", "10.0.0.1", "198.51.100.1", "198.51.100.1"},
+		{"spoofed entry", "10.0.0.1", "192.0.2.1, 198.51.100.1", "198.51.100.1"},
+		{"proxy chain", "10.0.0.1", "198.51.100.1, 10.0.0.2", "198.51.100.1"},
+		{"only proxies", "10.0.0.1", "10.0.0.3, 10.0.0.2", "10.0.0.3"},
+		{"invalid entry", "10.0.0.1", "198.51.100.1, garbage, 10.0.0.2", "10.0.0.2"},
+		{"missing header", "10.0.0.1", "", "10.0.0.1"},
+	} {
+		req := new(fasthttp.Request)
+		if c.forwarded != "" {
+			req.Header.Set("X-Forwarded-For", c.forwarded)
+		}
+		fctx := new(fasthttp.RequestCtx)
+		fctx.Init(req, &net.TCPAddr{IP: net.ParseIP(c.remote)}, nil)
+
+		if ip := p.clientIP(&routing.Context{RequestCtx: fctx}); ip.String() != c.exp {
+			t.Errorf("%s: client IP should be %s but was %s", c.name, c.exp, ip)
+		}
+	}
+}
@@ -2,6 +2,8 @@ package webserver
 
 import (
 	"fmt"
+	"net"
+	"sync/atomic"
 	"time"
 
 	routing "github.com/qiangxue/fasthttp-routing"
@@ -17,8 +19,10 @@ const (
 // A RateLimitManager maintains all
 // rate limiters for each connection.
 type RateLimitManager struct {
-	limits  *timedmap.TimedMap
-	handler []*rateLimitHandler
+	rejected uint64
+	limits   *timedmap.TimedMap
+	handler  []*rateLimitHandler
+	clientIP func(ctx *routing.Context) net.IP
 }
 
 type rateLimitHandler struct {
@@ -27,11 +31,14 @@ type rateLimitHandler struct {
 }
 
 // NewRateLimitManager creates a new instance
-// of RateLimitManager.
-func NewRateLimitManager() *RateLimitManager {
+// of RateLimitManager. Connections are
+// identified by the IP addresses returned
+// by clientIP.
+func NewRateLimitManager(clientIP func(ctx *routing.Context) net.IP) *RateLimitManager {
 	return &RateLimitManager{
-		limits:  timedmap.New(cleanupInterval),
-		handler: make([]*rateLimitHandler, 0),
+		limits:   timedmap.New(cleanupInterval),
+		handler:  make([]*rateLimitHandler, 0),
+		clientIP: clientIP,
 	}
 }
 
@@ -52,7 +59,7 @@ func (rlm *RateLimitManager) GetHandler(limit time.Duration, burst int) routing.
 
 	rlh.handler = func(ctx *routing.Context) error {
 		limiterID := fmt.Sprintf("%d#%s",
-			rlh.id, ctx.RemoteIP().String())
+			rlh.id, rlm.clientIP(ctx).String())
 		ok, res := rlm.getLimiter(limiterID, limit, burst).Reserve()
 
 		ctx.Response.Header.Set("X-RateLimit-Limit", fmt.Sprintf("%d", res.Burst))
@@ -60,6 +67,7 @@ func (rlm *RateLimitManager) GetHandler(limit time.Duration, burst int) routing.
 		ctx.Response.Header.Set("X-RateLimit-Reset", fmt.Sprintf("%d", res.Reset.Unix()))
 
 		if !ok {
+			atomic.AddUint64(&rlm.rejected, 1)
 			ctx.Abort()
 			ctx.Response.Header.SetContentType("application/json")
 			ctx.SetStatusCode(429)
@@ -75,6 +83,18 @@ func (rlm *RateLimitManager) GetHandler(limit time.Duration, burst int) routing.
 	return rlh.handler
 }
 
+// Rejected returns the number of requests
+// rejected by all rate limit handlers.
+func (rlm *RateLimitManager) Rejected() uint64 {
+	return atomic.LoadUint64(&rlm.rejected)
+}
+
+// Size returns the number of rate limiters
+// currently maintained.
+func (rlm *RateLimitManager) Size() int {
+	return rlm.limits.Size()
+}
+
 // getLimiter tries to get an existent limiter
 // from the limiter map. If there is no limiter
 // existent for this address, a new limiter
@@ -0,0 +1,112 @@
+package webserver
+
+import (
+	"errors"
+	"html"
+	"strconv"
+
+	routing "github.com/qiangxue/fasthttp-routing"
+	"github.com/valyala/fasthttp"
+	"github.com/zekroTJA/slms/internal/shortlink"
+)
+
+// defaultRedirectMaxAge is the time in seconds
+// browsers may cache permanent redirects if
+// not configured.
+const defaultRedirectMaxAge = 24 * 60 * 60
+
+var errInvalidRedirectType = errors.New("redirect_type must be 301, 302, 307, 308 or meta")
+
+// redirectStatuses maps the redirect types of
+// short links to the status codes of their
+// responses.
+var redirectStatuses = map[string]int{
+	shortlink.RedirectMovedPermanently: fasthttp.StatusMovedPermanently,
+	shortlink.RedirectFound:            fasthttp.StatusFound,
+	shortlink.RedirectTemporary:        fasthttp.StatusTemporaryRedirect,
+	shortlink.RedirectPermanent:        fasthttp.StatusPermanentRedirect,
+	shortlink.RedirectMetaRefresh:      fasthttp.StatusOK,
+}
+
+// temporaryTypes maps the permanent redirect
+// types to the temporary ones keeping the
+// request method the same way.
+var temporaryTypes = map[string]string{
+	shortlink.RedirectMovedPermanently: shortlink.RedirectFound,
+	shortlink.RedirectPermanent:        shortlink.RedirectTemporary,
+}
+
+// validateRedirectType returns an error if the
+// passed redirect type is neither empty nor
+// one of the supported types.
+func validateRedirectType(typ string) error {
+	if _, ok := redirectStatuses[typ]; typ != "" && !ok {
+		return errInvalidRedirectType
+	}
+	return nil
+}
+
+// redirectTypeOf returns the redirect type used
+// for redirects to the root link of the passed
+// short link.
+func (ws *WebServer) redirectTypeOf(sl *shortlink.ShortLink) string {
+	typ := sl.RedirectType
+	if typ == "" {
+		typ = ws.redirectType
+	}
+
+	// Permanent redirects are cached by browsers,
+	// which would bypass the limits of the short link.
+	if sl.ExpiresAt != nil || sl.MaxAccesses > 0 {
+		if t, ok := temporaryTypes[typ]; ok {
+			typ = t
+		}
+	}
+
+	return typ
+}
+
+// redirect sets the response to a redirect to
+// location by the passed redirect type. Browsers
+// may cache permanent redirects for the configured
+// max age, other redirects are not cached.
+func (ws *WebServer) redirect(ctx *routing.Context, typ, location string) {
+	if _, ok := temporaryTypes[typ]; ok {
+		ctx.Response.Header.Set("Cache-Control", "public, max-age="+strconv.Itoa(ws.redirectMaxAge))
+	} else {
+		ctx.Response.Header.Set("Cache-Control", "no-store")
+	}
+
+	if typ == shortlink.RedirectMetaRefresh {
+		ctx.SetStatusCode(fasthttp.StatusOK)
+		ctx.SetBodyString(
+			"<html>" +
+				"<head>" +
+				"<title>Short Link Management System</title>" +
+				"<meta http-equiv=\"refresh\" content=\"0; url=" + html.EscapeString(location) + "\">" +
+				"</head>" +
+				"<body>" +
+				"<a href=\"" + html.EscapeString(location) + "\">moved here</a>" +
+				"</body>" +
+				"</html>")
+		return
+	}
+
+	setRedirect(ctx, redirectStatuses[typ], location)
+}
+
+// setRedirect sets the response to a redirect
+// to location with the passed status code.
+func setRedirect(ctx *routing.Context, status int, location string) {
+	ctx.SetStatusCode(status)
+	ctx.Response.Header.Set("Location", location)
+	ctx.SetBodyString(
+		"<html>" +
+			"<head>" +
+			"<title>Short Link Management System</title>" +
+			"</head>" +
+			"<body>" +
+			"</body>" +
+			"<a href=\"" + html.EscapeString(location) + "\">moved here</a>" +
+			"</html>")
+}
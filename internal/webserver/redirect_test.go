@@ -0,0 +1,80 @@
+package webserver
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/valyala/fasthttp"
+	"github.com/zekroTJA/slms/internal/shortlink"
+)
+
+func TestRedirect(t *testing.T) {
+	ws, _ := newTestServer(t)
+	defer ws.counter.Close()
+	ws.redirectType = shortlink.RedirectPermanent
+	ws.redirectMaxAge = 60
+
+	const root = "https://example.com/target"
+	expires := time.Now().Add(time.Hour)
+
+	cases := []struct {
+		sl     shortlink.ShortLink
+		status int
+		cache  string
+	}{
+		{shortlink.ShortLink{ShortLink: "default"},
+			fasthttp.StatusPermanentRedirect, "public, max-age=60"},
+		{shortlink.ShortLink{ShortLink: "moved", RedirectType: shortlink.RedirectMovedPermanently},
+			fasthttp.StatusMovedPermanently, "public, max-age=60"},
+		{shortlink.ShortLink{ShortLink: "found", RedirectType: shortlink.RedirectFound},
+			fasthttp.StatusFound, "no-store"},
+		{shortlink.ShortLink{ShortLink: "temporary", RedirectType: shortlink.RedirectTemporary},
+			fasthttp.StatusTemporaryRedirect, "no-store"},
+		{shortlink.ShortLink{ShortLink: "permanent", RedirectType: shortlink.RedirectPermanent},
+			fasthttp.StatusPermanentRedirect, "public, max-age=60"},
+		{shortlink.ShortLink{ShortLink: "meta", RedirectType: shortlink.RedirectMetaRefresh},
+			fasthttp.StatusOK, "no-store"},
+		// Permanent redirects of short links with
+		// limits are downgraded to temporary ones.
+		{shortlink.ShortLink{ShortLink: "limited", MaxAccesses: 10},
+			fasthttp.StatusTemporaryRedirect, "no-store"},
+		{shortlink.ShortLink{ShortLink: "expiring", RedirectType: shortlink.RedirectMovedPermanently, ExpiresAt: &expires},
+			fasthttp.StatusFound, "no-store"},
+	}
+
+	for _, c := range cases {
+		sl := c.sl
+		sl.RootLink = root
+		if _, err := ws.db.CreateShortLink(context.Background(), &sl); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	client, ln := serve(ws)
+	defer ln.Close()
+
+	for _, c := range cases {
+		name := c.sl.ShortLink
+		res := new(fasthttp.Response)
+		if err := client.Do(newRequest("GET", "http://slms/"+name, ""), res); err != nil {
+			t.Fatal(err)
+		}
+
+		if status := res.StatusCode(); status != c.status {
+			t.Errorf("%s: status should be %d but was %d", name, c.status, status)
+		}
+		if cache := string(res.Header.Peek("Cache-Control")); cache != c.cache {
+			t.Errorf("%s: cache control should be '%s' but was '%s'", name, c.cache, cache)
+		}
+
+		if c.sl.RedirectType == shortlink.RedirectMetaRefresh {
+			if !strings.Contains(string(res.Body()), "url="+root) {
+				t.Errorf("%s: body should refresh to the root link: %s", name, res.Body())
+			}
+		} else if loc := string(res.Header.Peek("Location")); loc != root {
+			t.Errorf("%s: location should be '%s' but was '%s'", name, root, loc)
+		}
+	}
+}
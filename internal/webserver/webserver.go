@@ -2,38 +2,87 @@ package webserver
 
 import (
 	"errors"
+	"net"
+	"sync"
 	"time"
 
 	"github.com/go-gem/sessions"
 	routing "github.com/qiangxue/fasthttp-routing"
 	"github.com/valyala/fasthttp"
 	"github.com/zekroTJA/slms/internal/auth"
+	"github.com/zekroTJA/slms/internal/counter"
 	"github.com/zekroTJA/slms/internal/database"
+	"github.com/zekroTJA/slms/internal/geoip"
+	"github.com/zekroTJA/slms/internal/shortlink"
+	"github.com/zekroTJA/slms/internal/useragent"
+	"github.com/zekroTJA/slms/internal/visitor"
+	"github.com/zekroTJA/slms/pkg/metrics"
 )
 
+const defaultRequestTimeout = 10 * time.Second
+
 // A WebServer handles the REST API
 // connections.
 type WebServer struct {
 	db             database.Middleware
+	counter        *counter.Counter
 	auth           auth.Provider
 	sessions       sessions.Store
 	config         *Config
 	server         *fasthttp.Server
 	router         *routing.Router
 	limitManager   *RateLimitManager
-	redirectStatus int
+	agents         *useragent.Classifier
+	visitors       *visitor.Hasher
+	countries      *geoip.Resolver
+	proxies        trustedProxies
+	registry       *metrics.Registry
+	metrics        *webServerMetrics
+	redirectType   string
+	redirectMaxAge int
+	requestTimeout time.Duration
+
+	mtx      sync.RWMutex
+	listener net.Listener
+	closed   bool
 }
 
 // Config contains the configuration
 // values for the WebServer.
+// RequestTimeout is the time in seconds
+// after which database calls of a request
+// are aborted.
+// UserAgentPatterns is the optional path of
+// a file replacing the built-in user agent
+// patterns used to detect bots.
+// PermanentRedirect sets the default redirect
+// type of short links to 308 instead of 307.
+// RedirectMaxAge is the time in seconds
+// browsers may cache permanent redirects.
+// GeoIPDatabase is the optional path of a
+// MaxMind DB file used to resolve the countries
+// of visitors. Without it, no countries are
+// recorded.
+// TrustedProxies contains the IP addresses and
+// CIDR networks of reverse proxies whose
+// X-Forwarded-For headers are used to determine
+// the client IP addresses of requests.
+// Metrics configures the optional Prometheus
+// metrics endpoint.
 type Config struct {
-	Address           string     `json:"address"`
-	RootRedirect      string     `json:"root_redirect"`
-	OnlyHTTPSRootLink bool       `json:"only_https_rootlink"`
-	PermanentRedirect bool       `json:"permanent_redirect"`
-	APITokenHash      string     `json:"api_token_hash"`
-	SessionStoreKey   string     `json:"session_store_key"`
-	TLS               *ConfigTLS `json:"tls"`
+	Address           string         `json:"address"`
+	RequestTimeout    int            `json:"request_timeout"`
+	RootRedirect      string         `json:"root_redirect"`
+	OnlyHTTPSRootLink bool           `json:"only_https_rootlink"`
+	PermanentRedirect bool           `json:"permanent_redirect"`
+	RedirectMaxAge    int            `json:"redirect_max_age"`
+	APITokenHash      string         `json:"api_token_hash"`
+	SessionStoreKey   string         `json:"session_store_key"`
+	UserAgentPatterns string         `json:"user_agent_patterns"`
+	GeoIPDatabase     string         `json:"geoip_database"`
+	TrustedProxies    []string       `json:"trusted_proxies"`
+	TLS               *ConfigTLS     `json:"tls"`
+	Metrics           *ConfigMetrics `json:"metrics,omitempty"`
 }
 
 // ConfigTLS contains the configuration
@@ -45,14 +94,33 @@ type ConfigTLS struct {
 	KeyFile  string `json:"key_file"`
 }
 
+// ConfigMetrics contains the configuration
+// values for the metrics endpoint of the
+// WebServer. If Public is set, the endpoint
+// can be accessed without authorization.
+type ConfigMetrics struct {
+	Enabled bool `json:"enabled"`
+	Public  bool `json:"public"`
+}
+
 // NewWebServer creates a new instance
 // of WebServer and registers all set
 // request handlers.
-func NewWebServer(conf *Config, db database.Middleware, authProvider auth.Provider) (*WebServer, error) {
+// Short link accesses are recorded to
+// the passed access counter.
+// If metrics are enabled in the config, they
+// are registered in and exposed from reg.
+func NewWebServer(conf *Config, db database.Middleware, accessCounter *counter.Counter,
+	authProvider auth.Provider, reg *metrics.Registry) (*WebServer, error) {
 	if len(conf.APITokenHash) < 8 {
 		return nil, errors.New("api_token must have at least 8 characters")
 	}
 
+	proxies, err := parseTrustedProxies(conf.TrustedProxies)
+	if err != nil {
+		return nil, err
+	}
+
 	router := routing.New()
 
 	cookieStore := sessions.NewCookieStore([]byte(conf.SessionStoreKey))
@@ -62,18 +130,51 @@ func NewWebServer(conf *Config, db database.Middleware, authProvider auth.Provid
 		auth:         authProvider,
 		sessions:     cookieStore,
 		db:           db,
+		counter:      accessCounter,
 		config:       conf,
 		router:       router,
-		limitManager: NewRateLimitManager(),
-		server: &fasthttp.Server{
-			Handler: sessions.ClearHandler(router.HandleRequest),
-		},
+		limitManager: NewRateLimitManager(proxies.clientIP),
+		proxies:      proxies,
+		visitors:     visitor.New(),
+		registry:     reg,
+	}
+
+	ws.server = &fasthttp.Server{
+		Handler: sessions.ClearHandler(ws.handleRequest),
+	}
+
+	ws.requestTimeout = defaultRequestTimeout
+	if ws.config.RequestTimeout > 0 {
+		ws.requestTimeout = time.Duration(ws.config.RequestTimeout) * time.Second
+	}
+
+	// Without a registry the metrics are still
+	// recorded but never exposed.
+	if ws.registry == nil {
+		ws.registry = metrics.NewRegistry()
+	}
+	ws.metrics = newWebServerMetrics(ws.registry, ws.limitManager)
+
+	ws.agents = useragent.New(nil)
+	if err := ws.ReloadUserAgentPatterns(); err != nil {
+		return nil, err
+	}
+
+	if ws.config.GeoIPDatabase != "" {
+		if ws.countries, err = geoip.Open(ws.config.GeoIPDatabase); err != nil {
+			return nil, err
+		}
 	}
 
 	if ws.config.PermanentRedirect {
-		ws.redirectStatus = fasthttp.StatusPermanentRedirect
+		ws.redirectType = shortlink.RedirectPermanent
 	} else {
-		ws.redirectStatus = fasthttp.StatusTemporaryRedirect
+		ws.redirectType = shortlink.RedirectTemporary
+	}
+
+	ws.redirectMaxAge = defaultRedirectMaxAge
+	if ws.config.RedirectMaxAge > 0 {
+		ws.redirectMaxAge = ws.config.RedirectMaxAge
 	}
 
 	ws.registerHandlers()
@@ -84,57 +185,234 @@ func NewWebServer(conf *Config, db database.Middleware, authProvider auth.Provid
 func (ws *WebServer) registerHandlers() {
 	ws.router.Use(ws.handlerHeaderServer, ws.handlerFileServer)
 
-	// GET /:SHORT
-	ws.router.Get("/<short>", ws.handlerShort)
+	// GET /metrics
+	if ws.config.Metrics != nil && ws.config.Metrics.Enabled {
+		ws.router.Get("/metrics", ws.handlerMetrics)
+	}
+
+	// GET, HEAD /:SHORT
+	ws.router.To("GET,HEAD", "/<short>", ws.handlerShort)
+	// POST /:SHORT
+	// Password form submissions of protected short links.
+	ws.router.Post("/<short>",
+		ws.limitManager.GetHandler(10*time.Second, 3),
+		ws.handlerShort)
 
 	// GROUP # /api
+	// API requests are observed before they are authorized,
+	// so that rejected requests are counted and timed as well.
 	api := ws.router.Group("/api")
-	api.Use(ws.handlerAuth)
 
 	// POST /api/login
 	api.Post("/login",
+		ws.observe("/api/login"),
+		ws.handlerAuth,
 		ws.limitManager.GetHandler(10*time.Second, 3),
 		ws.handlerLogin)
 
 	// GET /api/shortlinks/count
 	api.Get("/shortlinks/count",
+		ws.observe("/api/shortlinks/count"),
+		ws.handlerAuth,
 		ws.limitManager.GetHandler(1*time.Second, 10),
 		ws.handlerGetShortLinkCount)
 	// GET /api/shortlinks
 	shortLinks := api.Get("/shortlinks",
+		ws.observe("/api/shortlinks"),
+		ws.handlerAuth,
 		ws.limitManager.GetHandler(1*time.Second, 10),
 		ws.handlerGetShortLinks)
 	// POST /api/shortlinks
 	shortLinks.Post(
+		ws.observe("/api/shortlinks"),
+		ws.handlerAuth,
 		ws.limitManager.GetHandler(3*time.Second, 3),
 		ws.handlerCreateShortLink)
 
 	// GET /api/shortlinks/:ID
 	shortLinksID := api.Get("/shortlinks/<id>",
+		ws.observe("/api/shortlinks/<id>"),
+		ws.handlerAuth,
 		ws.limitManager.GetHandler(1*time.Second, 5),
 		ws.handlerGetShortLink)
 	// POST /api/shortlinks/:ID
 	shortLinksID.Post(
+		ws.observe("/api/shortlinks/<id>"),
+		ws.handlerAuth,
 		ws.limitManager.GetHandler(2*time.Second, 3),
 		ws.handlerEditShortLink)
 	// DELETE /api/shortlinks/:ID
 	shortLinksID.Delete(
+		ws.observe("/api/shortlinks/<id>"),
+		ws.handlerAuth,
 		ws.limitManager.GetHandler(2*time.Second, 5),
 		ws.handlerDeleteShortLink)
+
+	// GET /api/shortlinks/:ID/history
+	api.Get("/shortlinks/<id>/history",
+		ws.observe("/api/shortlinks/<id>/history"),
+		ws.handlerAuth,
+		ws.limitManager.GetHandler(1*time.Second, 5),
+		ws.handlerGetHistory)
+	// POST /api/shortlinks/:ID/revert/:REV
+	api.Post("/shortlinks/<id>/revert/<rev>",
+		ws.observe("/api/shortlinks/<id>/revert/<rev>"),
+		ws.handlerAuth,
+		ws.limitManager.GetHandler(2*time.Second, 3),
+		ws.handlerRevertShortLink)
+	// GET /api/shortlinks/:ID/stats
+	api.Get("/shortlinks/<id>/stats",
+		ws.observe("/api/shortlinks/<id>/stats"),
+		ws.handlerAuth,
+		ws.limitManager.GetHandler(1*time.Second, 5),
+		ws.handlerGetStats)
+	// GET /api/shortlinks/:ID/stats/referrers
+	api.Get("/shortlinks/<id>/stats/referrers",
+		ws.observe("/api/shortlinks/<id>/stats/referrers"),
+		ws.handlerAuth,
+		ws.limitManager.GetHandler(1*time.Second, 5),
+		ws.handlerGetReferrerStats)
+	// GET /api/shortlinks/:ID/stats/campaigns
+	api.Get("/shortlinks/<id>/stats/campaigns",
+		ws.observe("/api/shortlinks/<id>/stats/campaigns"),
+		ws.handlerAuth,
+		ws.limitManager.GetHandler(1*time.Second, 5),
+		ws.handlerGetCampaignStats)
+
+	// GET /api/shortlinks/:ID/stats/countries
+	api.Get("/shortlinks/<id>/stats/countries",
+		ws.observe("/api/shortlinks/<id>/stats/countries"),
+		ws.handlerAuth,
+		ws.limitManager.GetHandler(1*time.Second, 5),
+		ws.handlerGetCountryStats)
+	// GET /api/stats/summary
+	api.Get("/stats/summary",
+		ws.observe("/api/stats/summary"),
+		ws.handlerAuth,
+		ws.limitManager.GetHandler(1*time.Second, 5),
+		ws.handlerGetSummary)
+
+	// GET /api/trash
+	api.Get("/trash",
+		ws.observe("/api/trash"),
+		ws.handlerAuth,
+		ws.limitManager.GetHandler(1*time.Second, 10),
+		ws.handlerGetTrash)
+	// POST /api/trash/:ID/restore
+	api.Post("/trash/<id>/restore",
+		ws.observe("/api/trash/<id>/restore"),
+		ws.handlerAuth,
+		ws.limitManager.GetHandler(2*time.Second, 3),
+		ws.handlerRestoreShortLink)
+	// DELETE /api/trash/:ID
+	api.Delete("/trash/<id>",
+		ws.observe("/api/trash/<id>"),
+		ws.handlerAuth,
+		ws.limitManager.GetHandler(2*time.Second, 5),
+		ws.handlerPurgeShortLink)
+
+	// GET /api/export
+	api.Get("/export",
+		ws.observe("/api/export"),
+		ws.handlerAuth,
+		ws.limitManager.GetHandler(10*time.Second, 2),
+		ws.handlerExport)
+	// POST /api/import
+	api.Post("/import",
+		ws.observe("/api/import"),
+		ws.handlerAuth,
+		ws.limitManager.GetHandler(30*time.Second, 1),
+		ws.handlerImport)
 }
 
 // ListenAndServeBlocking starts listening for HTTP requests
 // and serving responses to the specified address in the config.
 // The server will run in TLS mode when set in the config.
-// The startet event loop will block the current go routine.
+// The startet event loop will block the current go routine
+// until the web server is closed.
 func (ws *WebServer) ListenAndServeBlocking() error {
-	if ws.config.TLS != nil && ws.config.TLS.Use {
-		if ws.config.TLS.CertFile == "" || ws.config.TLS.KeyFile == "" {
-			return errors.New("cert file and key file must be specified")
-		}
-		return ws.server.ListenAndServeTLS(
-			ws.config.Address, ws.config.TLS.CertFile, ws.config.TLS.KeyFile)
+	useTLS := ws.config.TLS != nil && ws.config.TLS.Use
+	if useTLS && (ws.config.TLS.CertFile == "" || ws.config.TLS.KeyFile == "") {
+		return errors.New("cert file and key file must be specified")
+	}
+
+	ln, err := net.Listen("tcp4", ws.config.Address)
+	if err != nil {
+		return err
+	}
+
+	ws.mtx.Lock()
+	ws.listener = ln
+	closed := ws.closed
+	ws.mtx.Unlock()
+	if closed {
+		return ln.Close()
+	}
+
+	if useTLS {
+		err = ws.server.ServeTLS(ln, ws.config.TLS.CertFile, ws.config.TLS.KeyFile)
+	} else {
+		err = ws.server.Serve(ln)
+	}
+
+	// Closing the listener stops serving
+	// with an error, which is expected.
+	ws.mtx.RLock()
+	if ws.closed {
+		err = nil
+	}
+	ws.mtx.RUnlock()
+
+	return err
+}
+
+// Close stops accepting connections and waits
+// until all requests in progress are handled.
+// Requests on open connections received
+// afterwards are rejected with status 503.
+func (ws *WebServer) Close() error {
+	ws.mtx.Lock()
+	defer ws.mtx.Unlock()
+
+	ws.closed = true
+	if ws.listener != nil {
+		return ws.listener.Close()
+	}
+
+	return nil
+}
+
+// handleRequest passes the request to the router
+// unless the web server is closed.
+func (ws *WebServer) handleRequest(ctx *fasthttp.RequestCtx) {
+	ws.mtx.RLock()
+	defer ws.mtx.RUnlock()
+
+	if ws.closed {
+		ctx.SetConnectionClose()
+		ctx.Error(fasthttp.StatusMessage(fasthttp.StatusServiceUnavailable),
+			fasthttp.StatusServiceUnavailable)
+		return
+	}
+
+	ws.router.HandleRequest(ctx)
+}
+
+// ReloadUserAgentPatterns reads the user agent
+// patterns file set in the config and replaces
+// the patterns used to detect bots. If no file
+// is set, the built-in patterns are used.
+func (ws *WebServer) ReloadUserAgentPatterns() error {
+	if ws.config.UserAgentPatterns == "" {
+		ws.agents.SetPatterns(nil)
+		return nil
+	}
+
+	p, err := useragent.LoadPatterns(ws.config.UserAgentPatterns)
+	if err != nil {
+		return err
 	}
 
-	return ws.server.ListenAndServe(ws.config.Address)
+	ws.agents.SetPatterns(p)
+	return nil
 }
@@ -0,0 +1,68 @@
+package webserver
+
+import (
+	"testing"
+	"time"
+
+	"github.com/valyala/fasthttp"
+)
+
+func TestCloseStopsServing(t *testing.T) {
+	ws, _ := newTestServer(t)
+	defer ws.counter.Close()
+	ws.config.Address = "127.0.0.1:0"
+
+	served := make(chan error, 1)
+	go func() {
+		served <- ws.ListenAndServeBlocking()
+	}()
+
+	for i := 0; ; i++ {
+		ws.mtx.RLock()
+		listening := ws.listener != nil
+		ws.mtx.RUnlock()
+		if listening {
+			break
+		}
+		if i == 100 {
+			t.Fatal("web server did not start listening")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	if err := ws.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	select {
+	case err := <-served:
+		if err != nil {
+			t.Errorf("closed web server should stop serving without error but returned %v", err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("web server did not stop serving")
+	}
+}
+
+func TestCloseRejectsRequests(t *testing.T) {
+	ws, sl := newTestServer(t)
+	defer ws.counter.Close()
+
+	client, ln := serve(ws)
+	defer ln.Close()
+
+	if err := ws.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	res := new(fasthttp.Response)
+	if err := client.Do(newRequest("POST", "http://slms/protected", "password=correct+horse"), res); err != nil {
+		t.Fatal(err)
+	}
+	if res.StatusCode() != fasthttp.StatusServiceUnavailable {
+		t.Errorf("status should be 503 but was %d", res.StatusCode())
+	}
+	if n := ws.counter.Pending(sl.ID); n != 0 {
+		t.Errorf("no accesses should be counted after closing but were %d", n)
+	}
+}
@@ -0,0 +1,353 @@
+// Package metrics provides counters, gauges and
+// histograms which are exposed in the Prometheus
+// text exposition format.
+package metrics
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// ContentType is the content type of the
+// Prometheus text exposition format.
+const ContentType = "text/plain; version=0.0.4; charset=utf-8"
+
+// DefBuckets are the default upper bounds of
+// histogram buckets in seconds, suitable for
+// request and query latencies.
+var DefBuckets = []float64{.001, .005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5, 10}
+
+// labelSep separates label values in
+// the keys of series.
+const labelSep = "\xff"
+
+// metric is a named collection of series
+// which writes itself in the text format.
+type metric interface {
+	name() string
+	write(w *bufio.Writer)
+}
+
+// A Registry contains metrics and writes
+// them in the text exposition format.
+type Registry struct {
+	mtx     sync.Mutex
+	metrics map[string]metric
+}
+
+// NewRegistry creates a new, empty Registry.
+func NewRegistry() *Registry {
+	return &Registry{
+		metrics: make(map[string]metric),
+	}
+}
+
+// Counter registers and returns a new counter
+// with the passed name, help text and label names.
+// Registering a metric name twice panics.
+func (r *Registry) Counter(name, help string, labels ...string) *Counter {
+	c := &Counter{
+		desc:   newDesc(name, help, labels),
+		series: make(map[string]*counterSeries),
+	}
+	r.register(c)
+	return c
+}
+
+// CounterFunc registers a counter without labels
+// whose value is returned by f on each write.
+func (r *Registry) CounterFunc(name, help string, f func() float64) {
+	r.register(&valueFunc{desc: newDesc(name, help, nil), typ: "counter", f: f})
+}
+
+// GaugeFunc registers a gauge without labels
+// whose value is returned by f on each write.
+func (r *Registry) GaugeFunc(name, help string, f func() float64) {
+	r.register(&valueFunc{desc: newDesc(name, help, nil), typ: "gauge", f: f})
+}
+
+// Histogram registers and returns a new histogram
+// with the passed name, help text, bucket upper
+// bounds and label names. If buckets is nil,
+// DefBuckets is used.
+func (r *Registry) Histogram(name, help string, buckets []float64, labels ...string) *Histogram {
+	if buckets == nil {
+		buckets = DefBuckets
+	}
+	buckets = append([]float64(nil), buckets...)
+	sort.Float64s(buckets)
+
+	h := &Histogram{
+		desc:    newDesc(name, help, labels),
+		buckets: buckets,
+		series:  make(map[string]*histogramSeries),
+	}
+	r.register(h)
+	return h
+}
+
+// WriteTo writes all registered metrics ordered
+// by name in the text exposition format to w.
+func (r *Registry) WriteTo(w io.Writer) (int64, error) {
+	r.mtx.Lock()
+	metrics := make([]metric, 0, len(r.metrics))
+	for _, m := range r.metrics {
+		metrics = append(metrics, m)
+	}
+	r.mtx.Unlock()
+
+	sort.Slice(metrics, func(i, j int) bool {
+		return metrics[i].name() < metrics[j].name()
+	})
+
+	cw := &countWriter{w: w}
+	bw := bufio.NewWriter(cw)
+	for _, m := range metrics {
+		m.write(bw)
+	}
+	err := bw.Flush()
+
+	return cw.n, err
+}
+
+// register adds m to the registry and panics
+// if its name is already registered.
+func (r *Registry) register(m metric) {
+	r.mtx.Lock()
+	defer r.mtx.Unlock()
+
+	if _, ok := r.metrics[m.name()]; ok {
+		panic(fmt.Sprintf("metrics: duplicate metric '%s'", m.name()))
+	}
+	r.metrics[m.name()] = m
+}
+
+// desc describes a metric by its name,
+// help text and label names.
+type desc struct {
+	n      string
+	help   string
+	labels []string
+}
+
+func newDesc(name, help string, labels []string) desc {
+	return desc{n: name, help: help, labels: labels}
+}
+
+func (d desc) name() string {
+	return d.n
+}
+
+// key returns the series key of the passed label
+// values and panics if their number does not
+// match the label names.
+func (d desc) key(values []string) string {
+	if len(values) != len(d.labels) {
+		panic(fmt.Sprintf("metrics: '%s' has %d labels but got %d values",
+			d.n, len(d.labels), len(values)))
+	}
+	return strings.Join(values, labelSep)
+}
+
+// writeHeader writes the HELP and TYPE
+// lines of the metric.
+func (d desc) writeHeader(w *bufio.Writer, typ string) {
+	fmt.Fprintf(w, "# HELP %s %s\n", d.n, escapeHelp(d.help))
+	fmt.Fprintf(w, "# TYPE %s %s\n", d.n, typ)
+}
+
+// writeSample writes a sample of the metric with the
+// passed name suffix, label values and extra label.
+func (d desc) writeSample(w *bufio.Writer, suffix string, values []string, extra string, v float64) {
+	w.WriteString(d.n + suffix)
+
+	pairs := make([]string, 0, len(values)+1)
+	for i, val := range values {
+		pairs = append(pairs, d.labels[i]+`="`+escapeLabel(val)+`"`)
+	}
+	if extra != "" {
+		pairs = append(pairs, extra)
+	}
+	if len(pairs) > 0 {
+		w.WriteString("{" + strings.Join(pairs, ",") + "}")
+	}
+
+	w.WriteString(" " + formatFloat(v) + "\n")
+}
+
+// A Counter is a monotonically increasing
+// value per combination of label values.
+type Counter struct {
+	desc
+
+	mtx    sync.Mutex
+	series map[string]*counterSeries
+}
+
+type counterSeries struct {
+	values []string
+	v      float64
+}
+
+// Inc increases the counter of the passed
+// label values by 1.
+func (c *Counter) Inc(values ...string) {
+	c.Add(1, values...)
+}
+
+// Add increases the counter of the passed label
+// values by v, which must not be negative.
+func (c *Counter) Add(v float64, values ...string) {
+	if v < 0 {
+		panic("metrics: counters can not decrease")
+	}
+
+	key := c.key(values)
+
+	c.mtx.Lock()
+	s, ok := c.series[key]
+	if !ok {
+		s = &counterSeries{values: append([]string(nil), values...)}
+		c.series[key] = s
+	}
+	s.v += v
+	c.mtx.Unlock()
+}
+
+func (c *Counter) write(w *bufio.Writer) {
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+
+	keys := make([]string, 0, len(c.series))
+	for k := range c.series {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	c.writeHeader(w, "counter")
+	for _, key := range keys {
+		s := c.series[key]
+		c.writeSample(w, "", s.values, "", s.v)
+	}
+}
+
+// A Histogram counts observed values in buckets
+// per combination of label values.
+type Histogram struct {
+	desc
+
+	buckets []float64
+	mtx     sync.Mutex
+	series  map[string]*histogramSeries
+}
+
+type histogramSeries struct {
+	values []string
+	counts []uint64
+	count  uint64
+	sum    float64
+}
+
+// Observe adds the value v to the histogram
+// of the passed label values.
+func (h *Histogram) Observe(v float64, values ...string) {
+	key := h.key(values)
+
+	h.mtx.Lock()
+	s, ok := h.series[key]
+	if !ok {
+		s = &histogramSeries{
+			values: append([]string(nil), values...),
+			counts: make([]uint64, len(h.buckets)),
+		}
+		h.series[key] = s
+	}
+	for i, b := range h.buckets {
+		if v <= b {
+			s.counts[i]++
+		}
+	}
+	s.count++
+	s.sum += v
+	h.mtx.Unlock()
+}
+
+func (h *Histogram) write(w *bufio.Writer) {
+	h.mtx.Lock()
+	defer h.mtx.Unlock()
+
+	keys := make([]string, 0, len(h.series))
+	for k := range h.series {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	h.writeHeader(w, "histogram")
+	for _, key := range keys {
+		s := h.series[key]
+		for i, b := range h.buckets {
+			h.writeSample(w, "_bucket", s.values, `le="`+formatFloat(b)+`"`, float64(s.counts[i]))
+		}
+		h.writeSample(w, "_bucket", s.values, `le="+Inf"`, float64(s.count))
+		h.writeSample(w, "_sum", s.values, "", s.sum)
+		h.writeSample(w, "_count", s.values, "", float64(s.count))
+	}
+}
+
+// valueFunc is a counter or gauge without
+// labels whose value is read on write.
+type valueFunc struct {
+	desc
+
+	typ string
+	f   func() float64
+}
+
+func (v *valueFunc) write(w *bufio.Writer) {
+	v.writeHeader(w, v.typ)
+	v.writeSample(w, "", nil, "", v.f())
+}
+
+// countWriter counts the bytes
+// written to w.
+type countWriter struct {
+	w io.Writer
+	n int64
+}
+
+func (cw *countWriter) Write(p []byte) (int, error) {
+	n, err := cw.w.Write(p)
+	cw.n += int64(n)
+	return n, err
+}
+
+// formatFloat formats v as sample value.
+func formatFloat(v float64) string {
+	switch {
+	case math.IsInf(v, 1):
+		return "+Inf"
+	case math.IsInf(v, -1):
+		return "-Inf"
+	case math.IsNaN(v):
+		return "NaN"
+	}
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
+
+// escapeHelp escapes backslashes and
+// line feeds of help texts.
+func escapeHelp(s string) string {
+	return strings.NewReplacer(`\`, `\\`, "\n", `\n`).Replace(s)
+}
+
+// escapeLabel escapes backslashes, double
+// quotes and line feeds of label values.
+func escapeLabel(s string) string {
+	return strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`).Replace(s)
+}
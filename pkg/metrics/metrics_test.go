@@ -0,0 +1,86 @@
+package metrics
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestWriteTo(t *testing.T) {
+	r := NewRegistry()
+
+	c := r.Counter("test_requests_total", "Number of requests.", "route", "status")
+	c.Inc("/b", "200")
+	c.Inc("/a", "200")
+	c.Add(2, "/a", "200")
+	c.Inc("/a", "404")
+
+	h := r.Histogram("test_duration_seconds", "Request duration.", []float64{1, 0.1}, "route")
+	h.Observe(0.05, "/a")
+	h.Observe(0.5, "/a")
+	h.Observe(5, "/a")
+
+	r.GaugeFunc("test_size", "Size with \"quotes\"\nand lines.", func() float64 { return 42 })
+	r.CounterFunc("test_rejections_total", "Rejections.", func() float64 { return 1.5 })
+
+	lc := r.Counter("test_labels_total", "Escaped labels.", "value")
+	lc.Inc("a\"b\\c\nd")
+
+	var buf bytes.Buffer
+	n, err := r.WriteTo(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != int64(buf.Len()) {
+		t.Errorf("should return %d written bytes but returned %d", buf.Len(), n)
+	}
+
+	exp := `# HELP test_duration_seconds Request duration.
+# TYPE test_duration_seconds histogram
+test_duration_seconds_bucket{route="/a",le="0.1"} 1
+test_duration_seconds_bucket{route="/a",le="1"} 2
+test_duration_seconds_bucket{route="/a",le="+Inf"} 3
+test_duration_seconds_sum{route="/a"} 5.55
+test_duration_seconds_count{route="/a"} 3
+# HELP test_labels_total Escaped labels.
+# TYPE test_labels_total counter
+test_labels_total{value="a\"b\\c\nd"} 1
+# HELP test_rejections_total Rejections.
+# TYPE test_rejections_total counter
+test_rejections_total 1.5
+# HELP test_requests_total Number of requests.
+# TYPE test_requests_total counter
+test_requests_total{route="/a",status="200"} 3
+test_requests_total{route="/a",status="404"} 1
+test_requests_total{route="/b",status="200"} 1
+# HELP test_size Size with "quotes"\nand lines.
+# TYPE test_size gauge
+test_size 42
+`
+	if got := buf.String(); got != exp {
+		t.Errorf("output should be\n%s\nbut was\n%s", exp, got)
+	}
+}
+
+func TestRegisterDuplicate(t *testing.T) {
+	r := NewRegistry()
+	r.Counter("test_total", "Test.")
+
+	defer func() {
+		if recover() == nil {
+			t.Error("registering a duplicate name should panic")
+		}
+	}()
+	r.GaugeFunc("test_total", "Test.", func() float64 { return 0 })
+}
+
+func TestLabelCount(t *testing.T) {
+	r := NewRegistry()
+	c := r.Counter("test_total", "Test.", "a", "b")
+
+	defer func() {
+		if recover() == nil {
+			t.Error("passing the wrong number of label values should panic")
+		}
+	}()
+	c.Inc("a")
+}
@@ -45,7 +45,7 @@ func New(cleanupTickTime time.Duration) *TimedMap {
 			case <-tm.cleaner.C:
 				tm.cleanUp()
 			case <-tm.cleanerStopChan:
-				break
+				return
 			}
 		}
 	}()
@@ -54,44 +54,66 @@ func New(cleanupTickTime time.Duration) *TimedMap {
 }
 
 // expireElement removes the specified key-value element
-// from the map and executes all defined callback functions
+// from the map and executes all defined callback functions.
+// If the key was set again in the meantime, the new
+// element is kept and no callbacks are executed.
 func (tm *TimedMap) expireElement(k interface{}, v *element) {
+	tm.mtx.Lock()
+	current := tm.container[k] == v
+	if current {
+		delete(tm.container, k)
+	}
+	tm.mtx.Unlock()
+
+	if !current {
+		return
+	}
+
 	for _, cb := range v.cbs {
 		cb(v.value)
 	}
-
-	tm.mtx.Lock()
-	delete(tm.container, k)
-	tm.mtx.Unlock()
 }
 
 // cleanUp iterates trhough the map and expires all key-value
 // pairs which expire time after the current time
 func (tm *TimedMap) cleanUp() {
 	now := time.Now()
+	expired := make(map[interface{}]*element)
+
+	tm.mtx.Lock()
 	for k, v := range tm.container {
 		if now.After(v.expires) {
-			tm.expireElement(k, v)
+			expired[k] = v
 		}
 	}
+	tm.mtx.Unlock()
+
+	for k, v := range expired {
+		tm.expireElement(k, v)
+	}
 }
 
-// get returns an element object by key
-func (tm *TimedMap) get(key interface{}) *element {
+// get returns an element object by key and its
+// expire time
+func (tm *TimedMap) get(key interface{}) (*element, time.Time) {
 	tm.mtx.Lock()
 	v, ok := tm.container[key]
+	var expires time.Time
+	if ok {
+		expires = v.expires
+	}
 	tm.mtx.Unlock()
 
 	if !ok {
-		return nil
+		return nil, expires
 	}
 
-	if time.Now().After(v.expires) {
+	if time.Now().After(expires) {
 		tm.expireElement(key, v)
-		return nil
+		return nil, expires
 	}
 
-	return v
+	return v, expires
 }
 
 // Set appends a key-value pair to the mao ir sets the value of
@@ -111,7 +133,7 @@ func (tm *TimedMap) Set(key, value interface{}, expiresAfter time.Duration, cb .
 // map. The returned value is nil if there is no value to the
 // passed key or if the value was expired.
 func (tm *TimedMap) GetValue(key interface{}) interface{} {
-	v := tm.get(key)
+	v, _ := tm.get(key)
 	if v == nil {
 		return nil
 	}
@@ -122,18 +144,19 @@ func (tm *TimedMap) GetValue(key interface{}) interface{} {
 // If the key-value pair does not exist in the map or
 // was expired, this will return an error object.
 func (tm *TimedMap) GetExpires(key interface{}) (time.Time, error) {
-	v := tm.get(key)
+	v, expires := tm.get(key)
 	if v == nil {
 		return time.Time{}, errors.New("key not found")
 	}
-	return v.expires, nil
+	return expires, nil
 }
 
 // Contains returns true, if the key exists in the map.
 // false will be returned, if there is no value to the
 // key or if the key-value pair was expired.
 func (tm *TimedMap) Contains(key interface{}) bool {
-	return tm.get(key) != nil
+	v, _ := tm.get(key)
+	return v != nil
 }
 
 // Remove deletes a key-value pair in the map.
@@ -147,8 +170,11 @@ func (tm *TimedMap) Remove(key interface{}) {
 // about the passed duration. If there is no value to
 // the key passed, this will return an error object.
 func (tm *TimedMap) Refresh(key interface{}, d time.Duration) error {
-	v := tm.get(key)
-	if v == nil {
+	tm.mtx.Lock()
+	defer tm.mtx.Unlock()
+
+	v, ok := tm.container[key]
+	if !ok || time.Now().After(v.expires) {
 		return errors.New("key not found")
 	}
 	v.expires = v.expires.Add(d)
@@ -157,12 +183,16 @@ func (tm *TimedMap) Refresh(key interface{}, d time.Duration) error {
 
 // Flush deletes all key-value pairs of the map.
 func (tm *TimedMap) Flush() {
+	tm.mtx.Lock()
 	tm.container = make(map[interface{}]*element)
+	tm.mtx.Unlock()
 }
 
 // Size returns the current number of key-value pairs
 // existent in the map.
 func (tm *TimedMap) Size() int {
+	tm.mtx.Lock()
+	defer tm.mtx.Unlock()
 	return len(tm.container)
 }
 
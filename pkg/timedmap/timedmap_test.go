@@ -1,6 +1,7 @@
 package timedmap
 
 import (
+	"sync/atomic"
 	"testing"
 	"time"
 )
@@ -11,6 +12,15 @@ const (
 
 var tm *TimedMap
 
+// lookup returns the element of the passed key
+// without expiring it.
+func (tm *TimedMap) lookup(key interface{}) (*element, bool) {
+	tm.mtx.Lock()
+	defer tm.mtx.Unlock()
+	v, ok := tm.container[key]
+	return v, ok
+}
+
 func TestMain(m *testing.M) {
 	tm = New(dCleanupTick)
 	m.Run()
@@ -20,20 +30,17 @@ func TestNew(t *testing.T) {
 	if tm == nil {
 		t.Fatal("TimedMap was nil")
 	}
-	if s := len(tm.container); s != 0 {
+	if s := tm.Size(); s != 0 {
 		t.Fatalf("map size was %d != 0", s)
 	}
 }
 
 func TestFlush(t *testing.T) {
 	for i := 0; i < 10; i++ {
-		tm.container[i] = &element{
-			value:   1,
-			expires: time.Now().Add(time.Hour),
-		}
+		tm.Set(i, 1, time.Hour)
 	}
 	tm.Flush()
-	if s := len(tm.container); s > 0 {
+	if s := tm.Size(); s > 0 {
 		t.Fatalf("size was %d > 0", s)
 	}
 }
@@ -43,13 +50,13 @@ func TestSet(t *testing.T) {
 	val := "tValSet"
 
 	tm.Set(key, val, 20*time.Millisecond)
-	if v, ok := tm.container[key]; !ok {
+	if v, ok := tm.lookup(key); !ok {
 		t.Fatal("key was not set")
 	} else if v.value.(string) != val {
 		t.Fatal("value was not like set")
 	}
 	time.Sleep(40 * time.Millisecond)
-	if _, ok := tm.container[key]; ok {
+	if _, ok := tm.lookup(key); ok {
 		t.Fatal("key was not deleted after expire")
 	}
 
@@ -139,7 +146,7 @@ func TestRemove(t *testing.T) {
 	tm.Set(key, 1, time.Hour)
 	tm.Remove(key)
 
-	if _, ok := tm.container[key]; ok {
+	if _, ok := tm.lookup(key); ok {
 		t.Fatal("key still exists after remove")
 	}
 
@@ -159,12 +166,12 @@ func TestRefresh(t *testing.T) {
 	}
 
 	time.Sleep(30 * time.Millisecond)
-	if _, ok := tm.container[key]; !ok {
+	if _, ok := tm.lookup(key); !ok {
 		t.Fatal("key was not refreshed")
 	}
 
 	time.Sleep(100 * time.Millisecond)
-	if _, ok := tm.container[key]; ok {
+	if _, ok := tm.lookup(key); ok {
 		t.Fatal("key was not deleted after refreshed time")
 	}
 
@@ -183,20 +190,37 @@ func TestSize(t *testing.T) {
 }
 
 func TestCallback(t *testing.T) {
-	var cbCalled bool
+	var cbCalled int32
 	tm.Set(1, 3, 25*time.Millisecond, func(v interface{}) {
-		cbCalled = true
+		atomic.StoreInt32(&cbCalled, 1)
 	})
 
 	time.Sleep(50 * time.Millisecond)
-	if !cbCalled {
+	if atomic.LoadInt32(&cbCalled) == 0 {
 		t.Fatal("callback has not been called")
 	}
-	if _, ok := tm.container[1]; ok {
+	if _, ok := tm.lookup(1); ok {
 		t.Fatal("key was not deleted after expire time")
 	}
 }
 
+func TestExpireReplaced(t *testing.T) {
+	key := "tKeyReplaced"
+
+	tm.Set(key, 1, time.Hour)
+	v, _ := tm.lookup(key)
+	tm.Set(key, 2, time.Hour)
+
+	// Expiring the replaced element must not
+	// remove the new one.
+	tm.expireElement(key, v)
+	if tm.GetValue(key) != 2 {
+		t.Fatal("replaced key was deleted")
+	}
+
+	tm.Flush()
+}
+
 func TestStopCleaner(t *testing.T) {
 	tm.StopCleaner()
 	time.Sleep(10 * time.Millisecond)